@@ -3,11 +3,14 @@ package main
 import (
 	"context"
 	"net/http"
+	"os"
+	"slices"
+	"strings"
 
 	httpadapter "github.com/andreychano/compressor-golang/internal/adapter/inbound/http"
-	"github.com/andreychano/compressor-golang/internal/adapter/outbound/processor/bimg"
 	"github.com/andreychano/compressor-golang/internal/adapter/outbound/repository/local"
 	"github.com/andreychano/compressor-golang/internal/config"
+	"github.com/andreychano/compressor-golang/internal/core/port"
 	"github.com/andreychano/compressor-golang/internal/core/service"
 	applogger "github.com/andreychano/compressor-golang/internal/logger"
 )
@@ -23,8 +26,24 @@ func main() {
 	// Это покажет нам, что реально загрузилось
 
 	storage := local.NewLocalFileStorage(cfg.Storage.Path)
-	processor := bimg.NewProcessor()
-	svc := service.NewCompressionService(storage, *cfg, processor)
+	processor, processors := newProcessors(cfg)
+	cfg.Image.AllowFormats = slices.DeleteFunc(cfg.Image.AllowFormats, func(format string) bool {
+		if processor.SupportsOutput(format) {
+			return false
+		}
+		applogger.Log.Warn().
+			Str("format", format).
+			Msg("processor cannot encode format, disabling it")
+		return true
+	})
+	if !processor.SupportsOutput(cfg.Image.DefaultFormat) {
+		applogger.Log.Error().
+			Str("format", cfg.Image.DefaultFormat).
+			Msg("default format is not supported by the processor")
+		os.Exit(1)
+	}
+
+	svc := service.NewCompressionService(storage, *cfg, processors...)
 
 	mux := http.NewServeMux()
 	h := httpadapter.NewHandler(svc)
@@ -33,6 +52,11 @@ func main() {
 	maxBytes := cfg.HTTP.MaxUploadSizeBytes()
 	handler := httpadapter.MaxUploadSize(maxBytes, mux)
 
+	applogger.Log.Info().
+		Str("inputs", strings.Join(processor.SupportedInputs(), ",")).
+		Str("outputs", strings.Join(cfg.Image.AllowFormats, ",")).
+		Msg("image formats")
+
 	applogger.Log.Info().
 		Str("address", cfg.HTTP.Address).
 		Int64("max_bytes", maxBytes).
@@ -45,3 +69,12 @@ func main() {
 			Msg("failed to start server")
 	}
 }
+
+// rasterProcessor encodes images, for its own inputs and for processors that
+// render other documents through it. Builds with cgo use libvips, others a
+// pure-Go implementation.
+type rasterProcessor interface {
+	port.Processor
+	SupportsOutput(format string) bool
+	SupportedInputs() []string
+}
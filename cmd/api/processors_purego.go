@@ -0,0 +1,19 @@
+//go:build !cgo
+
+package main
+
+import (
+	"github.com/andreychano/compressor-golang/internal/adapter/outbound/processor/goimage"
+	"github.com/andreychano/compressor-golang/internal/config"
+	"github.com/andreychano/compressor-golang/internal/core/port"
+	applogger "github.com/andreychano/compressor-golang/internal/logger"
+)
+
+// newProcessors creates the pure-Go processor of builds without cgo. It has
+// no PDF, SVG, HEIC or TIFF inputs, no WebP or AVIF output and ignores the
+// colour settings.
+func newProcessors(cfg *config.Config) (rasterProcessor, []port.Processor) {
+	applogger.Log.Warn().Msg("built without cgo, using the pure-Go processor")
+	processor := goimage.NewProcessor(cfg.Image.MaxPixels)
+	return processor, []port.Processor{processor}
+}
@@ -0,0 +1,33 @@
+//go:build cgo
+
+package main
+
+import (
+	"os"
+
+	"github.com/andreychano/compressor-golang/internal/adapter/outbound/processor/bimg"
+	"github.com/andreychano/compressor-golang/internal/adapter/outbound/processor/pdf"
+	"github.com/andreychano/compressor-golang/internal/config"
+	"github.com/andreychano/compressor-golang/internal/core/port"
+	applogger "github.com/andreychano/compressor-golang/internal/logger"
+)
+
+// newProcessors creates the libvips processors: raster images, and PDF pages
+// rendered through them.
+func newProcessors(cfg *config.Config) (rasterProcessor, []port.Processor) {
+	processor := bimg.NewProcessor(cfg.Color)
+	if err := processor.CheckColorProfile(); err != nil {
+		applogger.Log.Error().
+			Str("profile", cfg.Color.Profile).
+			Err(err).
+			Msg("color profile is not usable")
+		os.Exit(1)
+	}
+
+	pdfProcessor := pdf.NewProcessor(cfg.PDF, processor)
+	if !pdfProcessor.Supports("application/pdf") {
+		applogger.Log.Warn().Msg("libvips has no PDF loader, PDF inputs are disabled")
+	}
+
+	return processor, []port.Processor{processor, pdfProcessor}
+}
@@ -4,27 +4,221 @@ import (
 	"bytes"
 	"fmt"
 	"io"
-	"net/http"
+	"time"
 
-	"github.com/andreychano/compressor-golang/internal/adapter/outbound/processor/bimg"
 	"github.com/andreychano/compressor-golang/internal/adapter/outbound/repository/local"
 	"github.com/andreychano/compressor-golang/internal/config"
 	"github.com/andreychano/compressor-golang/internal/core/domain"
+	"github.com/andreychano/compressor-golang/internal/core/port"
 	"github.com/andreychano/compressor-golang/internal/core/service"
+	"github.com/andreychano/compressor-golang/internal/mimetype"
 )
 
 // Options describes compression settings exposed to library users.
 type Options struct {
-	Format    string // "jpeg", "png", "webp"
-	Quality   int    // 1–100
+	Format    string // "jpeg", "png", "webp", "avif", "gif"; "svg" minifies SVG inputs
+	Quality   int    // 0–100, 0 = default
 	MaxWidth  int    // 0 = no limit (использовать дефолт из cfg.Image или без ограничения)
 	MaxHeight int    // 0 = no limit
+	// Pipeline lists the steps explicitly, in the compact form such as
+	// "rotate:90/resize:800:600/encode:webp:80" or as a JSON array. It
+	// replaces Format, Quality, MaxWidth, MaxHeight, Operations, Crop,
+	// Filters, Watermarks, the targets and the encoder options.
+	Pipeline string
+	// Operations transform the upright image in the order given, before
+	// Crop and resizing.
+	Operations []Operation
+	// Crop cuts the output to exactly Crop.Width x Crop.Height instead of
+	// fitting it within MaxWidth x MaxHeight.
+	Crop CropOptions
+	// Filters run in order on the resized image, before Watermarks.
+	Filters []Filter
+	// Watermarks are drawn in order over the resized image.
+	Watermarks []Watermark
+	// TargetBytes, when > 0, searches for the highest quality (up to Quality)
+	// and, if needed, smaller dimensions that keep the output within this size.
+	TargetBytes int64
+	// TargetSSIM, when > 0, picks the smallest encoding whose SSIM against
+	// the source is at least this value (0..1).
+	TargetSSIM float64
+	// Report requests a quality report in Result.Report.
+	Report bool
+	// Placeholders requests a BlurHash and a ThumbHash in Result.Placeholders.
+	Placeholders bool
+	// Analyze requests the dominant colour and palette in Result.Analysis.
+	Analyze bool
+	// Page selects the page of a multi-page input such as TIFF or PDF, from 0.
+	Page int
+	// NoAutoRotate skips applying the EXIF orientation. The tag survives
+	// only when Metadata keeps EXIF.
+	NoAutoRotate bool
+	// Metadata selects the metadata kept: "strip" (default), "icc",
+	// "copyright" or "no_private" (all but location and serial numbers).
+	Metadata string
+	// Background is the hex colour, e.g. "#ffffff", transparent pixels are
+	// flattened onto when the output has no alpha channel; empty = white.
+	Background string
+	// Alpha is "flatten" (default), "reject" to fail on transparent images
+	// the format cannot keep, or "auto" to write PNG for them instead.
+	Alpha string
+	// JPEG, PNG, WebP and AVIF tune the encoder of their output format.
+	JPEG JPEGOptions
+	PNG  PNGOptions
+	WebP WebPOptions
+	AVIF AVIFOptions
+	// FirstFrame turns animated inputs into a still image of their first frame.
+	// Otherwise animations are kept when Format is "webp" or "gif".
+	FirstFrame bool
+	// MaxFrames keeps at most this many frames of an animation, 0 = all.
+	MaxFrames int
+	// SVGWidth and SVGDPI control how SVG inputs are rasterized; zero values
+	// render at the document's own size and 72 DPI.
+	SVGWidth int
+	SVGDPI   float64
+	// PDFDPI is the density PDF pages are rendered at, 0 = 72.
+	PDFDPI float64
+}
+
+// AVIFOptions tunes AVIF encoding.
+type AVIFOptions struct {
+	Effort    int    // 0–9, 0 = encoder default; higher is slower and smaller
+	Subsample string // "", "auto", "420" or "444"
+	Lossless  bool
+}
+
+// Operation is one geometric transform: "rotate" by Angle degrees
+// clockwise, "flip" top to bottom, "flop" left to right, "crop" to the Left,
+// Top, Width, Height rectangle, "trim" uniform borders or "pad" to the Ratio
+// aspect ratio.
+type Operation struct {
+	Op                       string
+	Angle                    float64
+	Left, Top, Width, Height int
+	Threshold                float64 // Trim tolerance 0–255, 0 = 10
+	Ratio                    float64 // Pad target width / height
+	Background               string  // Fill of rotations and pads, "" = Options.Background
+}
+
+// Filter is one image filter: "blur" by Sigma, "sharpen" with an unsharp
+// mask of Sigma and Amount, "grayscale", "adjust" Brightness, Contrast and
+// Saturation, "gamma" or "tint" towards Color by Amount.
+type Filter struct {
+	Op                               string
+	Sigma                            float64 // Blur radius in pixels 0.3–100; sharpen 0 = 1
+	Amount                           float64 // Sharpen 0–10, 0 = 1; tint 0–1, 0 = 0.5
+	Brightness, Contrast, Saturation float64 // Factors 0–4, 0 = 1
+	Gamma                            float64 // 0.1–10, above 1 brightens
+	Color                            string  // Hex colour of tint
+}
+
+// Watermark overlays an image or a line of text.
+type Watermark struct {
+	Image     string  // Storage path of a PNG, JPEG, GIF or WebP
+	ImageData []byte  // Used instead of reading Image from storage; Image then only names it
+	Text      string  // Drawn instead of an image
+	Font      string  // "sans" (default), "sans-bold", "sans-italic", "mono" or "mono-bold"
+	Color     string  // Hex colour of Text, "" = white
+	Opacity   float64 // 0–1, 0 = opaque
+	Gravity   string  // "se" (default), "center", "n", "ne", "e", "s", "sw", "w" or "nw"
+	Margin    int     // Pixels from the edges, and between tiles
+	Scale     float64 // Width as a fraction of the image width, 0 = image size or a quarter for text
+	Tile      bool    // Repeat over the whole image
+}
+
+// CropOptions scales the image to cover a box and cuts off the overflow.
+// Images smaller than the box are cut to its aspect ratio, not enlarged.
+type CropOptions struct {
+	Width, Height int
+	Gravity       string // "center" (default), "n", "ne", "e", "se", "s", "sw", "w", "nw" or "smart"
+	Strategy      string // With "smart" gravity: "attention" (default) or "entropy"
+	Focus         *FocalPoint
+}
+
+// FocalPoint is a point the crop centres on, in fractions of the image width
+// and height from the top left. It excludes Gravity.
+type FocalPoint struct {
+	X, Y float64
+}
+
+// JPEGOptions tunes JPEG encoding.
+type JPEGOptions struct {
+	Progressive bool
+	Subsample   string // "", "auto", "420" or "444"
+	Trellis     bool   // Needs libvips built with mozjpeg, ignored otherwise
+}
+
+// WebPOptions tunes still WebP encoding.
+type WebPOptions struct {
+	Method         *int // 0–6, nil = 4; higher is slower and smaller
+	NearLossless   bool // Quality sets the preprocessing strength
+	Lossless       bool
+	AlphaQuality   int // 0–100, 0 = 100
+	SmartSubsample bool
+}
+
+// PNGOptions tunes PNG encoding. Palette, MaxColors and BitDepth below 8
+// quantise the image and need libvips.
+type PNGOptions struct {
+	Compression int      // zlib level 0–9, 0 = 6
+	Filter      string   // "", "adaptive" or "none"
+	Palette     bool     // Write an indexed image
+	MaxColors   int      // 2–256, implies Palette
+	Dither      *float64 // 0–1, nil = 1
+	BitDepth    int      // 1, 2, 4 or 8, 0 = picked from MaxColors
+	Lossless    bool     // Keep every pixel; excludes quantisation
 }
 
 // Result contains metadata about the compressed image.
 type Result struct {
 	MimeType string
 	Size     int64
+	Outcome  string // "compressed", "original" or "transcoded"
+	Quality  int    // Quality the output was encoded with
+	Width    int
+	Height   int
+	SSIM     float64 // Achieved SSIM, set in TargetSSIM mode
+	Report   *Report // Set when Options.Report is true
+	// Placeholders is set when Options.Placeholders is true.
+	Placeholders *Placeholders
+	// Analysis is set when Options.Analyze is true.
+	Analysis *Analysis
+	// RemovedMetadata lists the input metadata fields the output lacks,
+	// e.g. "exif:GPSLatitude".
+	RemovedMetadata []string
+}
+
+// Report describes how much a compression changed the image.
+type Report struct {
+	PSNR         float64 // dB, higher is better
+	SSIM         float64 // 1 = identical
+	InputSize    int64
+	OutputSize   int64
+	SavedBytes   int64 // Negative when the output grew
+	SavedPercent float64
+	InputWidth   int
+	InputHeight  int
+	OutputWidth  int
+	OutputHeight int
+	EncodeTime   time.Duration
+}
+
+// Placeholders are tiny encodings of the output to show, blurred, while it
+// loads.
+type Placeholders struct {
+	BlurHash  string
+	ThumbHash string // Base64
+}
+
+// Analysis describes the colours of the output.
+type Analysis struct {
+	Dominant string   // Hex colour of the largest swatch
+	Palette  []Swatch // Most common first
+}
+
+// Swatch is one palette colour and its share of the opaque pixels.
+type Swatch struct {
+	Color      string // "#rrggbb"
+	Proportion float64
 }
 
 // Compressor is a high-level façade for image compression.
@@ -46,7 +240,7 @@ func (c *Compressor) Compress(r io.Reader, opts Options) ([]byte, Result, error)
 	}
 
 	// Определяем MIME по содержимому.
-	mimeType := http.DetectContentType(buf.Bytes())
+	mimeType := mimetype.Detect(buf.Bytes())
 
 	file := domain.File{
 		Content:  bytes.NewReader(buf.Bytes()),
@@ -55,10 +249,112 @@ func (c *Compressor) Compress(r io.Reader, opts Options) ([]byte, Result, error)
 	}
 
 	domainOpts := domain.Options{
-		Format:    opts.Format,
-		Quality:   opts.Quality,
-		MaxWidth:  opts.MaxWidth,
-		MaxHeight: opts.MaxHeight,
+		Format:   opts.Format,
+		Quality:  opts.Quality,
+		MaxWidth: opts.MaxWidth,
+		Crop: domain.CropOptions{
+			Width:    opts.Crop.Width,
+			Height:   opts.Crop.Height,
+			Gravity:  domain.Gravity(opts.Crop.Gravity),
+			Strategy: opts.Crop.Strategy,
+		},
+		MaxHeight:    opts.MaxHeight,
+		TargetBytes:  opts.TargetBytes,
+		TargetSSIM:   opts.TargetSSIM,
+		Report:       opts.Report,
+		Placeholders: opts.Placeholders,
+		Analyze:      opts.Analyze,
+		Page:         opts.Page,
+		NoAutoRotate: opts.NoAutoRotate,
+		Metadata:     domain.MetadataPolicy(opts.Metadata),
+		Background:   opts.Background,
+		Alpha:        domain.AlphaPolicy(opts.Alpha),
+		AVIF: domain.AVIFOptions{
+			Effort:    opts.AVIF.Effort,
+			Subsample: opts.AVIF.Subsample,
+			Lossless:  opts.AVIF.Lossless,
+		},
+		JPEG: domain.JPEGOptions{
+			Progressive: opts.JPEG.Progressive,
+			Subsample:   opts.JPEG.Subsample,
+			Trellis:     opts.JPEG.Trellis,
+		},
+		WebP: domain.WebPOptions{
+			Method:         opts.WebP.Method,
+			NearLossless:   opts.WebP.NearLossless,
+			Lossless:       opts.WebP.Lossless,
+			AlphaQuality:   opts.WebP.AlphaQuality,
+			SmartSubsample: opts.WebP.SmartSubsample,
+		},
+		PNG: domain.PNGOptions{
+			Compression: opts.PNG.Compression,
+			Filter:      opts.PNG.Filter,
+			Palette:     opts.PNG.Palette,
+			MaxColors:   opts.PNG.MaxColors,
+			Dither:      opts.PNG.Dither,
+			BitDepth:    opts.PNG.BitDepth,
+			Lossless:    opts.PNG.Lossless,
+		},
+		SVG: domain.SVGOptions{
+			Width: opts.SVGWidth,
+			DPI:   opts.SVGDPI,
+		},
+		PDF: domain.PDFOptions{
+			DPI: opts.PDFDPI,
+		},
+		Animation: domain.AnimationOptions{
+			FirstFrame: opts.FirstFrame,
+			MaxFrames:  opts.MaxFrames,
+		},
+	}
+	for _, op := range opts.Operations {
+		domainOpts.Operations = append(domainOpts.Operations, domain.Operation{
+			Type:       domain.OperationType(op.Op),
+			Angle:      op.Angle,
+			Left:       op.Left,
+			Top:        op.Top,
+			Width:      op.Width,
+			Height:     op.Height,
+			Threshold:  op.Threshold,
+			Ratio:      op.Ratio,
+			Background: op.Background,
+		})
+	}
+	for _, f := range opts.Filters {
+		domainOpts.Filters = append(domainOpts.Filters, domain.Filter{
+			Type:       domain.OperationType(f.Op),
+			Sigma:      f.Sigma,
+			Amount:     f.Amount,
+			Brightness: f.Brightness,
+			Contrast:   f.Contrast,
+			Saturation: f.Saturation,
+			Gamma:      f.Gamma,
+			Color:      f.Color,
+		})
+	}
+	for _, w := range opts.Watermarks {
+		domainOpts.Watermarks = append(domainOpts.Watermarks, domain.WatermarkStep{
+			Image:     w.Image,
+			Text:      w.Text,
+			Font:      w.Font,
+			Color:     w.Color,
+			Opacity:   w.Opacity,
+			Gravity:   domain.Gravity(w.Gravity),
+			Margin:    w.Margin,
+			Scale:     w.Scale,
+			Tile:      w.Tile,
+			ImageData: w.ImageData,
+		})
+	}
+	if opts.Crop.Focus != nil {
+		domainOpts.Crop.Focus = &domain.FocalPoint{X: opts.Crop.Focus.X, Y: opts.Crop.Focus.Y}
+	}
+	if opts.Pipeline != "" {
+		pipeline, err := domain.ParsePipeline(opts.Pipeline)
+		if err != nil {
+			return nil, Result{}, err
+		}
+		domainOpts.Pipeline = pipeline
 	}
 
 	outFile, err := c.svc.Process(file, domainOpts)
@@ -71,16 +367,57 @@ func (c *Compressor) Compress(r io.Reader, opts Options) ([]byte, Result, error)
 		return nil, Result{}, fmt.Errorf("failed to read processed file: %w", err)
 	}
 
+	var report *Report
+	if rep := outFile.Report; rep != nil {
+		report = &Report{
+			PSNR:         rep.PSNR,
+			SSIM:         rep.SSIM,
+			InputSize:    rep.InputSize,
+			OutputSize:   rep.OutputSize,
+			SavedBytes:   rep.SavedBytes,
+			SavedPercent: rep.SavedPercent,
+			InputWidth:   rep.InputWidth,
+			InputHeight:  rep.InputHeight,
+			OutputWidth:  rep.OutputWidth,
+			OutputHeight: rep.OutputHeight,
+			EncodeTime:   time.Duration(rep.EncodeMS) * time.Millisecond,
+		}
+	}
+
+	var placeholders *Placeholders
+	if p := outFile.Placeholders; p != nil {
+		placeholders = &Placeholders{BlurHash: p.BlurHash, ThumbHash: p.ThumbHash}
+	}
+
+	var analysis *Analysis
+	if a := outFile.Analysis; a != nil {
+		analysis = &Analysis{Dominant: a.Dominant}
+		for _, s := range a.Palette {
+			analysis.Palette = append(analysis.Palette, Swatch{Color: s.Color, Proportion: s.Proportion})
+		}
+	}
+
 	return outBuf.Bytes(), Result{
 		MimeType: outFile.MimeType,
 		Size:     outFile.Size,
+		Outcome:  string(outFile.Outcome),
+		Quality:  outFile.Quality,
+		Width:    outFile.Width,
+		Height:   outFile.Height,
+		SSIM:     outFile.SSIM,
+		Report:   report,
+
+		Placeholders:    placeholders,
+		Analysis:        analysis,
+		RemovedMetadata: outFile.RemovedMetadata,
 	}, nil
 }
 
-// NewDefault creates a Compressor with default bimg processor
-// and local filesystem storage under the given base path.
+// NewDefault creates a Compressor with the default processors and local
+// filesystem storage under the given base path. Builds with cgo use libvips;
+// others a pure-Go processor reading JPEG, PNG, GIF and WebP and writing
+// JPEG, PNG and GIF.
 func NewDefault(basePath string) *Compressor {
-	proc := bimg.NewProcessor()
 	repo := local.NewLocalFileStorage(basePath)
 
 	cfg := config.Config{
@@ -94,11 +431,35 @@ func NewDefault(basePath string) *Compressor {
 			DefaultQuality: 80,
 			MaxWidth:       3840,
 			MaxHeight:      2160,
-			AllowFormats:   []string{"jpeg", "png", "webp"},
+			AllowFormats:   []string{"jpeg", "png"},
+			Metadata:       string(domain.MetadataStrip),
 		},
+		PDF: config.PDF{
+			DefaultDPI:    72,
+			MaxDPI:        300,
+			MaxPages:      500,
+			RenderTimeout: 10 * time.Second,
+		},
+		Color: config.Color{
+			Profile: "srgb",
+		},
+	}
+	raster, processors := defaultProcessors(cfg)
+
+	for _, format := range []string{"webp", "avif", "gif"} {
+		if raster.SupportsOutput(format) {
+			cfg.Image.AllowFormats = append(cfg.Image.AllowFormats, format)
+		}
 	}
 
-	svc := service.NewCompressionService(repo, cfg, proc)
+	svc := service.NewCompressionService(repo, cfg, processors...)
 
 	return &Compressor{svc: svc}
 }
+
+// rasterProcessor is the processor that encodes images; it reports the
+// output formats the build can write.
+type rasterProcessor interface {
+	port.Processor
+	SupportsOutput(format string) bool
+}
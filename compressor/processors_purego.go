@@ -0,0 +1,15 @@
+//go:build !cgo
+
+package compressor
+
+import (
+	"github.com/andreychano/compressor-golang/internal/adapter/outbound/processor/goimage"
+	"github.com/andreychano/compressor-golang/internal/config"
+	"github.com/andreychano/compressor-golang/internal/core/port"
+)
+
+// defaultProcessors creates the pure-Go processor of builds without cgo.
+func defaultProcessors(cfg config.Config) (rasterProcessor, []port.Processor) {
+	proc := goimage.NewProcessor(cfg.Image.MaxPixels)
+	return proc, []port.Processor{proc}
+}
@@ -0,0 +1,17 @@
+//go:build cgo
+
+package compressor
+
+import (
+	"github.com/andreychano/compressor-golang/internal/adapter/outbound/processor/bimg"
+	"github.com/andreychano/compressor-golang/internal/adapter/outbound/processor/pdf"
+	"github.com/andreychano/compressor-golang/internal/config"
+	"github.com/andreychano/compressor-golang/internal/core/port"
+)
+
+// defaultProcessors creates the libvips processors: raster images, and PDF
+// pages rendered through them.
+func defaultProcessors(cfg config.Config) (rasterProcessor, []port.Processor) {
+	proc := bimg.NewProcessor(cfg.Color)
+	return proc, []port.Processor{proc, pdf.NewProcessor(cfg.PDF, proc)}
+}
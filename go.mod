@@ -9,6 +9,7 @@ require (
 	github.com/golang/mock v1.6.0
 	github.com/h2non/bimg v1.1.9
 	github.com/shanth1/gotools v1.12.0
+	golang.org/x/image v0.34.0
 )
 
 require (
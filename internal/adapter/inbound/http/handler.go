@@ -1,6 +1,7 @@
 package http
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/andreychano/compressor-golang/internal/adapter/outbound/repository/local/pathvalidator"
@@ -25,7 +26,18 @@ func NewHandler(svc *service.CompressionService) *Handler {
 func (h *Handler) RegisterRoutes(mux *http.ServeMux) {
 	mux.HandleFunc("/upload", h.upload)
 	mux.HandleFunc("/process", h.process)
+	mux.HandleFunc("/placeholder", h.placeholder)
+	mux.HandleFunc("/analyze", h.analyze)
 	mux.HandleFunc("/file", h.getFile)
+	mux.HandleFunc("GET /files/{id}/meta", h.getFileMeta)
+}
+
+type uploadResponse struct {
+	Status         string          `json:"status"`
+	CompressedPath string          `json:"compressed_path"`
+	Message        string          `json:"message"`
+	Meta           domain.FileMeta `json:"meta"`
+	Report         *domain.Report  `json:"report,omitempty"`
 }
 
 func (h *Handler) upload(w http.ResponseWriter, r *http.Request) {
@@ -36,7 +48,7 @@ func (h *Handler) upload(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	dFile, dOptions, err := parseParamsStd(w, r)
+	dFile, dOptions, err := h.parseParamsStd(w, r)
 	if err != nil {
 		return
 	}
@@ -53,7 +65,7 @@ func (h *Handler) upload(w http.ResponseWriter, r *http.Request) {
 		applogger.Log.Error().
 			Err(err).
 			Msg("upload failed")
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), processErrorStatus(err))
 		return
 	}
 
@@ -64,24 +76,24 @@ func (h *Handler) upload(w http.ResponseWriter, r *http.Request) {
 	compMiB := float64(saved.CompressedSize) / float64(mib)
 	compMiBStr := fmt.Sprintf("%.2f", compMiB)
 
+	encode := dOptions.Compile().Encode()
 	applogger.Log.Info().
 		Str("path", saved.Path).
-		Int("quality", dOptions.Quality).
-		Str("format", dOptions.Format).
+		Int("quality", encode.Quality).
+		Str("format", encode.Format).
 		Str("orig_size_mib", origMiBStr).
 		Str("compressed_size_mib", compMiBStr).
+		Str("outcome", string(saved.Meta.Outcome)).
 		Str("remote_addr", r.RemoteAddr).
 		Msg("upload succeeded")
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	if _, err := fmt.Fprintf(
-		w,
-		`{"status":"success","compressed_path":"%s","message":"File saved successfully"}`,
-		saved.Path,
-	); err != nil {
-		applogger.Log.Error().Err(err).Msg("failed to write success response")
-	}
+	writeJSON(w, http.StatusOK, uploadResponse{
+		Status:         "success",
+		CompressedPath: saved.Path,
+		Message:        "File saved successfully",
+		Meta:           saved.Meta,
+		Report:         saved.Report,
+	})
 }
 
 func (h *Handler) process(w http.ResponseWriter, r *http.Request) {
@@ -90,7 +102,7 @@ func (h *Handler) process(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	dFile, dOptions, err := parseParamsStd(w, r)
+	dFile, dOptions, err := h.parseParamsStd(w, r)
 	if err != nil {
 		return
 	}
@@ -107,7 +119,7 @@ func (h *Handler) process(w http.ResponseWriter, r *http.Request) {
 		applogger.Log.Error().
 			Err(err).
 			Msg("process failed")
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), processErrorStatus(err))
 		return
 	}
 
@@ -119,20 +131,42 @@ func (h *Handler) process(w http.ResponseWriter, r *http.Request) {
 	outputMiB := float64(resultFile.Size) / float64(mib)
 	outputMiBStr := fmt.Sprintf("%.2f", outputMiB) // 0.72 [web:852]
 
+	encode := dOptions.Compile().Encode()
 	applogger.Log.Info().
-		Int("quality", dOptions.Quality).
-		Str("format", dOptions.Format).
+		Int("quality", encode.Quality).
+		Str("format", encode.Format).
 		//Int64("input_size", dFile.Size).
 		//Int64("output_size", resultFile.Size).
 		Str("input_size_mib", inputMiBStr).
 		Str("output_size_mib", outputMiBStr).
+		Str("outcome", string(resultFile.Outcome)).
 		Str("remote_addr", r.RemoteAddr).
 		Msg("process succeeded")
 
-	filename := fmt.Sprintf("processed.%s", dOptions.Format)
+	filename := fmt.Sprintf("processed.%s", resultFile.Extension(encode.Format))
 	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
 	w.Header().Set("Content-Type", resultFile.MimeType)
 	w.Header().Set("Content-Length", strconv.FormatInt(resultFile.Size, 10))
+	w.Header().Set("X-Compression-Outcome", string(resultFile.Outcome))
+	w.Header().Set("X-Compression-Quality", strconv.Itoa(resultFile.Quality))
+	w.Header().Set("X-Compression-Width", strconv.Itoa(resultFile.Width))
+	w.Header().Set("X-Compression-Height", strconv.Itoa(resultFile.Height))
+	if resultFile.SSIM > 0 {
+		w.Header().Set("X-Compression-SSIM", strconv.FormatFloat(resultFile.SSIM, 'f', 4, 64))
+	}
+	if len(resultFile.RemovedMetadata) > 0 {
+		w.Header().Set("X-Metadata-Removed", strings.Join(resultFile.RemovedMetadata, ","))
+	}
+	if report := resultFile.Report; report != nil {
+		setReportHeaders(w.Header(), report)
+	}
+	if p := resultFile.Placeholders; p != nil {
+		w.Header().Set("X-Placeholder-BlurHash", p.BlurHash)
+		w.Header().Set("X-Placeholder-ThumbHash", p.ThumbHash)
+	}
+	if analysis := resultFile.Analysis; analysis != nil {
+		setAnalysisHeaders(w.Header(), analysis)
+	}
 
 	if _, err := io.Copy(w, resultFile.Content); err != nil {
 		applogger.Log.Error().
@@ -141,6 +175,70 @@ func (h *Handler) process(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// placeholder returns the BlurHash and ThumbHash of an uploaded image after
+// the requested operations, without encoding the full image.
+func (h *Handler) placeholder(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	dFile, dOptions, err := h.parseParamsStd(w, r)
+	if err != nil {
+		return
+	}
+	if closer, ok := dFile.Content.(io.Closer); ok {
+		defer func() {
+			if err := closer.Close(); err != nil {
+				applogger.Log.Error().Err(err).Msg("failed to close file in placeholder")
+			}
+		}()
+	}
+
+	placeholders, err := h.svc.Placeholders(r.Context(), dFile, dOptions)
+	if err != nil {
+		applogger.Log.Error().
+			Err(err).
+			Msg("placeholder failed")
+		http.Error(w, err.Error(), processErrorStatus(err))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, placeholders)
+}
+
+// analyze returns the dominant colour and palette of an uploaded image after
+// the requested operations, without encoding the full image.
+func (h *Handler) analyze(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	dFile, dOptions, err := h.parseParamsStd(w, r)
+	if err != nil {
+		return
+	}
+	if closer, ok := dFile.Content.(io.Closer); ok {
+		defer func() {
+			if err := closer.Close(); err != nil {
+				applogger.Log.Error().Err(err).Msg("failed to close file in analyze")
+			}
+		}()
+	}
+
+	analysis, err := h.svc.Analyze(r.Context(), dFile, dOptions)
+	if err != nil {
+		applogger.Log.Error().
+			Err(err).
+			Msg("analyze failed")
+		http.Error(w, err.Error(), processErrorStatus(err))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, analysis)
+}
+
 func (h *Handler) getFile(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
@@ -192,46 +290,74 @@ func (h *Handler) getFile(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func parseParamsStd(w http.ResponseWriter, r *http.Request) (domain.File, domain.Options, error) {
-	file, header, err := r.FormFile("file")
+func (h *Handler) getFileMeta(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	meta, err := h.svc.FileMeta(r.Context(), id)
 	if err != nil {
-		if strings.Contains(err.Error(), "http: request body too large") {
+		var vErr *pathvalidator.ValidationError
+		if errors.Is(err, domain.ErrInvalidFileID) || errors.As(err, &vErr) {
 			applogger.Log.Warn().
+				Str("id", id).
+				Str("remote_addr", r.RemoteAddr).
 				Err(err).
-				Msg("request body too large")
-			http.Error(w, "file too large", http.StatusRequestEntityTooLarge)
-			return domain.File{}, domain.Options{}, fmt.Errorf("request body too large: %w", err)
+				Msg("invalid file id")
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
 		}
 
 		applogger.Log.Error().
+			Str("id", id).
+			Str("remote_addr", r.RemoteAddr).
 			Err(err).
-			Msg("FormFile error")
-		http.Error(w, "no file uploaded", http.StatusBadRequest)
-		return domain.File{}, domain.Options{}, fmt.Errorf("no file uploaded: %w", err)
+			Msg("get file meta failed")
+
+		http.Error(w, "File not found or access denied", http.StatusNotFound)
+		return
 	}
 
-	outputFormat := r.FormValue("format")
-	if outputFormat == "" {
-		outputFormat = "jpeg"
+	writeJSON(w, http.StatusOK, meta)
+}
+
+// setReportHeaders exposes a quality report as X-Compression-* headers.
+func setReportHeaders(header http.Header, report *domain.Report) {
+	header.Set("X-Compression-PSNR", strconv.FormatFloat(report.PSNR, 'f', 2, 64))
+	header.Set("X-Compression-SSIM", strconv.FormatFloat(report.SSIM, 'f', 4, 64))
+	header.Set("X-Compression-Input-Size", strconv.FormatInt(report.InputSize, 10))
+	header.Set("X-Compression-Output-Size", strconv.FormatInt(report.OutputSize, 10))
+	header.Set("X-Compression-Saved-Bytes", strconv.FormatInt(report.SavedBytes, 10))
+	header.Set("X-Compression-Saved-Percent", strconv.FormatFloat(report.SavedPercent, 'f', 2, 64))
+	header.Set("X-Compression-Input-Width", strconv.Itoa(report.InputWidth))
+	header.Set("X-Compression-Input-Height", strconv.Itoa(report.InputHeight))
+	header.Set("X-Compression-Encode-Ms", strconv.FormatInt(report.EncodeMS, 10))
+}
+
+// setAnalysisHeaders exposes a colour analysis as X-Analysis-* headers; the
+// palette is a list of colour:proportion pairs.
+func setAnalysisHeaders(header http.Header, analysis *domain.Analysis) {
+	swatches := make([]string, len(analysis.Palette))
+	for i, s := range analysis.Palette {
+		swatches[i] = s.Color + ":" + strconv.FormatFloat(s.Proportion, 'f', 3, 64)
 	}
+	header.Set("X-Analysis-Dominant", analysis.Dominant)
+	header.Set("X-Analysis-Palette", strings.Join(swatches, ","))
+}
 
-	qualityStr := r.FormValue("quality")
-	if qualityStr == "" {
-		qualityStr = "80"
+// processErrorStatus maps compression errors to HTTP status codes.
+func processErrorStatus(err error) int {
+	if errors.Is(err, domain.ErrInvalidOptions) || errors.Is(err, domain.ErrInvalidImage) {
+		return http.StatusBadRequest
 	}
-	quality, err := strconv.Atoi(qualityStr)
-	if err != nil || quality == 0 {
-		quality = 80
+	if errors.Is(err, domain.ErrTargetUnreachable) || errors.Is(err, domain.ErrTimeout) {
+		return http.StatusUnprocessableEntity
 	}
+	return http.StatusInternalServerError
+}
 
-	return domain.File{
-			Content:  file,
-			MimeType: header.Header.Get("Content-Type"),
-			Size:     header.Size,
-		},
-		domain.Options{
-			Format:  outputFormat,
-			Quality: quality,
-		},
-		nil
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		applogger.Log.Error().Err(err).Msg("failed to write json response")
+	}
 }
@@ -0,0 +1,94 @@
+package http
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+
+	"github.com/andreychano/compressor-golang/internal/config"
+	"github.com/andreychano/compressor-golang/internal/core/domain"
+	portmocks "github.com/andreychano/compressor-golang/internal/core/port/mocks"
+	"github.com/andreychano/compressor-golang/internal/core/service"
+	applogger "github.com/andreychano/compressor-golang/internal/logger"
+)
+
+// processRequest builds a /process upload of a PNG with the given form fields.
+func processRequest(t *testing.T, fields map[string]string, accept string) *http.Request {
+	t.Helper()
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreatePart(textproto.MIMEHeader{
+		"Content-Disposition": {`form-data; name="file"; filename="in.png"`},
+		"Content-Type":        {"image/png"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(make([]byte, 1000))
+	for name, value := range fields {
+		form.WriteField(name, value)
+	}
+	form.Close()
+
+	r := httptest.NewRequest(http.MethodPost, "/process", &body)
+	r.Header.Set("Content-Type", form.FormDataContentType())
+	r.Header.Set("Accept", accept)
+	return r
+}
+
+func TestProcess_AutoFormatPipeline(t *testing.T) {
+	applogger.Init(config.LoggerConfig{Level: "error"}.ToGotoolsConfig())
+	cfg := config.Config{Image: config.Image{AllowFormats: []string{"jpeg", "png", "webp"}}}
+
+	tests := []struct {
+		name     string
+		fields   map[string]string
+		want     string
+		wantVary bool
+	}{
+		{"format auto", map[string]string{"pipeline": "resize:100:100", "format": "auto"}, "webp", true},
+		{"encode step auto", map[string]string{"pipeline": "resize:100:100/encode:auto:70"}, "webp", true},
+		// The pipeline's own format wins.
+		{"explicit encode format", map[string]string{"pipeline": "resize:100:100/encode:png", "format": "auto"}, "png", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			processorMock := portmocks.NewMockProcessor(ctrl)
+			svc := service.NewCompressionService(portmocks.NewMockFileRepository(ctrl), cfg, processorMock)
+
+			processorMock.EXPECT().Supports("image/png").Return(true)
+			processorMock.EXPECT().SupportsStep(gomock.Any()).Return(true).AnyTimes()
+			processorMock.EXPECT().
+				Process(gomock.Any(), gomock.Any()).
+				DoAndReturn(func(file domain.File, opts domain.Options) (domain.ProcessedFile, error) {
+					if got := opts.Compile().Encode().Format; got != tt.want {
+						t.Errorf("encode format = %q, want %q", got, tt.want)
+					}
+					return domain.ProcessedFile{
+						File:   domain.File{Content: bytes.NewReader(make([]byte, 10)), MimeType: "image/" + tt.want, Size: 10},
+						Width:  100,
+						Height: 100,
+					}, nil
+				})
+
+			w := httptest.NewRecorder()
+			NewHandler(svc).process(w, processRequest(t, tt.fields, "image/webp,*/*"))
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("status %d: %s", w.Code, w.Body)
+			}
+			if vary := w.Header().Get("Vary") == "Accept"; vary != tt.wantVary {
+				t.Errorf("Vary: Accept set = %v, want %v", vary, tt.wantVary)
+			}
+		})
+	}
+}
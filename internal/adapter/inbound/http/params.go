@@ -0,0 +1,391 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/andreychano/compressor-golang/internal/core/domain"
+	applogger "github.com/andreychano/compressor-golang/internal/logger"
+	"github.com/andreychano/compressor-golang/internal/mimetype"
+)
+
+// formatAuto asks the handler to pick the output format from the Accept header.
+const formatAuto = "auto"
+
+// negotiationOrder lists output formats from most to least preferred when
+// negotiating.
+var negotiationOrder = []string{"avif", "webp", "jpeg", "png", "gif"}
+
+// explicitFormats must be listed by name: browsers send image/* and */*
+// whether or not they decode them.
+var explicitFormats = []string{"avif", "webp"}
+
+func (h *Handler) parseParamsStd(w http.ResponseWriter, r *http.Request) (domain.File, domain.Options, error) {
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		if strings.Contains(err.Error(), "http: request body too large") {
+			applogger.Log.Warn().
+				Err(err).
+				Msg("request body too large")
+			http.Error(w, "file too large", http.StatusRequestEntityTooLarge)
+			return domain.File{}, domain.Options{}, fmt.Errorf("request body too large: %w", err)
+		}
+
+		applogger.Log.Error().
+			Err(err).
+			Msg("FormFile error")
+		http.Error(w, "no file uploaded", http.StatusBadRequest)
+		return domain.File{}, domain.Options{}, fmt.Errorf("no file uploaded: %w", err)
+	}
+
+	opts, err := parseOptions(r)
+	if err != nil {
+		_ = file.Close()
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return domain.File{}, domain.Options{}, err
+	}
+
+	switch {
+	case opts.Format == formatAuto:
+		opts.Format = h.negotiate(w, r)
+	case opts.Pipeline.Encode().Format == formatAuto:
+		enc := opts.Pipeline.Encode()
+		enc.Format = h.negotiate(w, r)
+		last := len(opts.Pipeline) - 1
+		opts.Pipeline = append(opts.Pipeline[:last:last], enc)
+	}
+
+	mimeType, err := fileMimeType(file, header)
+	if err != nil {
+		_ = file.Close()
+		http.Error(w, "cannot read uploaded file", http.StatusBadRequest)
+		return domain.File{}, domain.Options{}, err
+	}
+
+	return domain.File{
+			Content:  file,
+			MimeType: mimeType,
+			Size:     header.Size,
+			Name:     header.Filename,
+		},
+		opts,
+		nil
+}
+
+// fileMimeType returns the declared type of an uploaded file, sniffing the
+// content when the client sent none. Clients often label HEIC photos as
+// application/octet-stream because they don't know the extension.
+func fileMimeType(file multipart.File, header *multipart.FileHeader) (string, error) {
+	declared := header.Header.Get("Content-Type")
+	if declared != "" && declared != "application/octet-stream" {
+		return declared, nil
+	}
+
+	head := make([]byte, mimetype.SniffLen)
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", fmt.Errorf("failed to read file head: %w", err)
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return "", fmt.Errorf("failed to seek file content: %w", err)
+	}
+
+	return mimetype.Detect(head[:n]), nil
+}
+
+// parseOptions reads compression options from form values. Format and quality
+// fall back to jpeg at 80 unless a preset supplies them.
+func parseOptions(r *http.Request) (domain.Options, error) {
+	preset := r.FormValue("preset")
+	pipeline, err := parsePipeline(r.FormValue("pipeline"))
+	if err != nil {
+		return domain.Options{}, err
+	}
+	defaultFormat, defaultQuality := "jpeg", 80
+	if preset != "" {
+		defaultFormat, defaultQuality = "", 0
+	}
+	if len(pipeline) > 0 {
+		// The pipeline's encode step takes the defaults instead, and
+		// format=auto unless it names a format itself.
+		if r.FormValue("format") == formatAuto {
+			defaultFormat = formatAuto
+		}
+		pipeline = pipeline.WithEncodeDefaults(defaultFormat, defaultQuality)
+		defaultFormat, defaultQuality = "", 0
+	}
+
+	quality, err := strconv.Atoi(r.FormValue("quality"))
+	if err != nil || quality == 0 {
+		quality = defaultQuality
+	}
+
+	f := formParser{r: r}
+
+	var operations []domain.Operation
+	f.json("operations", &operations)
+	var filters []domain.Filter
+	f.json("filters", &filters)
+	var watermarks []domain.WatermarkStep
+	f.json("watermarks", &watermarks)
+
+	opts := domain.Options{
+		Format:     f.string("format", defaultFormat),
+		Quality:    quality,
+		Pipeline:   pipeline,
+		Operations: operations,
+		Crop: domain.CropOptions{
+			Width:    f.int("crop_width"),
+			Height:   f.int("crop_height"),
+			Gravity:  domain.Gravity(f.string("gravity", "")),
+			Strategy: f.string("crop_strategy", ""),
+			Focus:    focalPoint(f.optionalFloat("fx"), f.optionalFloat("fy")),
+		},
+		Filters:      filters,
+		Watermarks:   watermarks,
+		TargetBytes:  f.int64("target_bytes"),
+		TargetSSIM:   f.float("target_ssim"),
+		Report:       f.bool("report"),
+		Placeholders: f.bool("placeholders"),
+		Analyze:      f.bool("analyze"),
+		Page:         f.int("page"),
+		NoAutoRotate: f.bool("no_auto_rotate"),
+		Preset:       preset,
+		Metadata:     domain.MetadataPolicy(f.string("metadata", "")),
+		Background:   f.string("background", ""),
+		Alpha:        domain.AlphaPolicy(f.string("alpha", "")),
+		AVIF: domain.AVIFOptions{
+			Effort:    f.int("avif_effort"),
+			Subsample: f.string("avif_subsample", ""),
+			Lossless:  f.bool("avif_lossless"),
+		},
+		JPEG: domain.JPEGOptions{
+			Progressive: f.bool("jpeg_progressive"),
+			Subsample:   f.string("jpeg_subsample", ""),
+			Trellis:     f.bool("jpeg_trellis"),
+		},
+		PNG: domain.PNGOptions{
+			Compression: f.int("png_compression"),
+			Filter:      f.string("png_filter", ""),
+			Palette:     f.bool("png_palette"),
+			MaxColors:   f.int("png_max_colors"),
+			Dither:      f.optionalFloat("png_dither"),
+			BitDepth:    f.int("png_bit_depth"),
+			Lossless:    f.bool("png_lossless"),
+		},
+		WebP: domain.WebPOptions{
+			Method:         f.optionalInt("webp_method"),
+			NearLossless:   f.bool("webp_near_lossless"),
+			Lossless:       f.bool("webp_lossless"),
+			AlphaQuality:   f.int("webp_alpha_quality"),
+			SmartSubsample: f.bool("webp_smart_subsample"),
+		},
+		SVG: domain.SVGOptions{
+			Width: f.int("svg_width"),
+			DPI:   f.float("svg_dpi"),
+		},
+		PDF: domain.PDFOptions{
+			DPI: f.float("pdf_dpi"),
+		},
+		Animation: domain.AnimationOptions{
+			FirstFrame: f.bool("first_frame"),
+			MaxFrames:  f.int("max_frames"),
+		},
+	}
+
+	if len(pipeline) > 0 && opts.Format == formatAuto {
+		// Negotiated for the encode step instead.
+		opts.Format = ""
+	}
+	return opts, f.err
+}
+
+// parsePipeline reads the pipeline field, a JSON array or the compact form.
+func parsePipeline(value string) (domain.Pipeline, error) {
+	if value == "" {
+		return nil, nil
+	}
+	pipeline, err := domain.ParsePipeline(value)
+	if err != nil {
+		return nil, fmt.Errorf("pipeline: %w", err)
+	}
+	return pipeline, nil
+}
+
+// focalPoint builds a crop focus from optional fx and fy values; a missing
+// coordinate is centred.
+func focalPoint(fx, fy *float64) *domain.FocalPoint {
+	if fx == nil && fy == nil {
+		return nil
+	}
+	focus := domain.FocalPoint{X: 0.5, Y: 0.5}
+	if fx != nil {
+		focus.X = *fx
+	}
+	if fy != nil {
+		focus.Y = *fy
+	}
+	return &focus
+}
+
+// negotiate picks the output format from the Accept header for format=auto,
+// marking the response as varying with it.
+func (h *Handler) negotiate(w http.ResponseWriter, r *http.Request) string {
+	w.Header().Add("Vary", "Accept")
+	return negotiateFormat(r.Header.Get("Accept"), h.svc.AllowedFormats())
+}
+
+// negotiateFormat picks the most preferred allowed format the client accepts.
+// When it accepts none, the most preferred allowed format every client
+// decodes is used, else the first allowed one.
+func negotiateFormat(accept string, allowed []string) string {
+	for _, format := range negotiationOrder {
+		if slices.Contains(allowed, format) && accepts(accept, "image/"+format, !slices.Contains(explicitFormats, format)) {
+			return format
+		}
+	}
+	for _, format := range negotiationOrder {
+		if slices.Contains(allowed, format) && !slices.Contains(explicitFormats, format) {
+			return format
+		}
+	}
+	if len(allowed) > 0 {
+		return allowed[0]
+	}
+	return "jpeg"
+}
+
+// accepts reports whether the most specific range of an Accept header that
+// matches mimeType has a non-zero q. Without wildcards only the exact type
+// counts. An empty header accepts anything.
+func accepts(accept, mimeType string, wildcards bool) bool {
+	if strings.TrimSpace(accept) == "" {
+		return wildcards
+	}
+	typ, _, _ := strings.Cut(mimeType, "/")
+
+	best, ok := 0, false // Specificity of the match: 3 exact, 2 type/*, 1 */*
+	for _, part := range strings.Split(accept, ",") {
+		fields := strings.Split(part, ";")
+		specificity := 0
+		switch r := strings.ToLower(strings.TrimSpace(fields[0])); {
+		case r == mimeType:
+			specificity = 3
+		case wildcards && r == typ+"/*":
+			specificity = 2
+		case wildcards && r == "*/*":
+			specificity = 1
+		}
+		if specificity <= best {
+			continue
+		}
+		best, ok = specificity, true
+		for _, param := range fields[1:] {
+			if q, found := strings.CutPrefix(strings.TrimSpace(param), "q="); found {
+				if v, err := strconv.ParseFloat(q, 64); err == nil && v == 0 {
+					ok = false
+				}
+			}
+		}
+	}
+	return ok
+}
+
+// formParser reads optional typed form values and keeps the first parse error.
+type formParser struct {
+	r   *http.Request
+	err error
+}
+
+func (f *formParser) string(name, def string) string {
+	if v := f.r.FormValue(name); v != "" {
+		return v
+	}
+	return def
+}
+
+func (f *formParser) int(name string) int {
+	v := f.r.FormValue(name)
+	if v == "" || f.err != nil {
+		return 0
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		f.err = fmt.Errorf("%s must be an integer", name)
+	}
+	return n
+}
+
+func (f *formParser) int64(name string) int64 {
+	v := f.r.FormValue(name)
+	if v == "" || f.err != nil {
+		return 0
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		f.err = fmt.Errorf("%s must be an integer", name)
+	}
+	return n
+}
+
+func (f *formParser) float(name string) float64 {
+	v := f.r.FormValue(name)
+	if v == "" || f.err != nil {
+		return 0
+	}
+	n, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		f.err = fmt.Errorf("%s must be a number", name)
+	}
+	return n
+}
+
+// optionalInt is int for values whose zero is meaningful; it returns nil
+// when the field is absent.
+func (f *formParser) optionalInt(name string) *int {
+	if f.r.FormValue(name) == "" {
+		return nil
+	}
+	n := f.int(name)
+	return &n
+}
+
+// optionalFloat is float for values whose zero is meaningful; it returns nil
+// when the field is absent.
+func (f *formParser) optionalFloat(name string) *float64 {
+	if f.r.FormValue(name) == "" {
+		return nil
+	}
+	n := f.float(name)
+	return &n
+}
+
+// json decodes a JSON form value into v, leaving v alone when the field is
+// absent.
+func (f *formParser) json(name string, v any) {
+	data := f.r.FormValue(name)
+	if data == "" || f.err != nil {
+		return
+	}
+	if err := json.Unmarshal([]byte(data), v); err != nil {
+		f.err = fmt.Errorf("%s must be valid JSON: %v", name, err)
+	}
+}
+
+func (f *formParser) bool(name string) bool {
+	v := f.r.FormValue(name)
+	if v == "" || f.err != nil {
+		return false
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		f.err = fmt.Errorf("%s must be a boolean", name)
+	}
+	return b
+}
@@ -0,0 +1,39 @@
+package http
+
+import "testing"
+
+func TestNegotiateFormat(t *testing.T) {
+	all := []string{"jpeg", "png", "webp", "avif", "gif"}
+
+	tests := []struct {
+		name    string
+		accept  string
+		allowed []string
+		want    string
+	}{
+		{"chrome", "image/avif,image/webp,image/apng,image/svg+xml,image/*,*/*;q=0.8", all, "avif"},
+		{"webp only", "image/webp,*/*", all, "webp"},
+		{"avif not allowed", "image/avif,image/webp,*/*", []string{"jpeg", "webp"}, "webp"},
+		{"avif refused", "image/avif;q=0,image/webp", all, "webp"},
+		{"image wildcard", "image/png,image/*;q=0.8", all, "jpeg"},
+		// Browsers send wildcards without decoding AVIF or WebP.
+		{"wildcards only", "image/*,*/*;q=0.5", all, "jpeg"},
+		{"any", "*/*", all, "jpeg"},
+		{"empty header", "", all, "jpeg"},
+		{"jpeg refused", "image/jpeg;q=0,image/*", all, "png"},
+		{"exact beats wildcard", "image/*;q=0,image/png", all, "png"},
+		{"jpeg not allowed", "*/*", []string{"png", "webp"}, "png"},
+		{"case insensitive", "Image/WebP", all, "webp"},
+		// Nothing acceptable is allowed: a format every client decodes wins.
+		{"no match", "image/png", []string{"webp", "jpeg"}, "jpeg"},
+		{"only explicit formats allowed", "image/png", []string{"webp", "avif"}, "webp"},
+		{"nothing allowed", "image/png", nil, "jpeg"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := negotiateFormat(tt.accept, tt.allowed); got != tt.want {
+				t.Errorf("negotiateFormat(%q, %v) = %q, want %q", tt.accept, tt.allowed, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,56 @@
+//go:build cgo
+
+package bimg
+
+import (
+	"cmp"
+	"fmt"
+
+	"github.com/andreychano/compressor-golang/internal/core/domain"
+	"github.com/h2non/bimg"
+)
+
+// applyAlpha enforces opts.Alpha on an image with an alpha channel and
+// returns the output type to encode. A non-nil buffer replaces buf: a PNG
+// without alpha, upright unless opts.NoAutoRotate.
+func applyAlpha(buf []byte, inputType, imageType bimg.ImageType, opts domain.Options) ([]byte, bimg.ImageType, error) {
+	output := cmp.Or(imageType, inputType)
+	policy := cmp.Or(opts.Alpha, domain.AlphaFlatten)
+	if keepsAlpha(output) && policy != domain.AlphaAuto {
+		return nil, imageType, nil
+	}
+
+	isOpaque := false
+	if policy != domain.AlphaFlatten {
+		var err error
+		if isOpaque, err = opaque(buf); err != nil {
+			return nil, imageType, fmt.Errorf("failed to read alpha channel: %w", err)
+		}
+	}
+
+	switch {
+	case policy == domain.AlphaFlatten || isOpaque:
+		// An opaque alpha channel carries nothing; dropping it makes smaller
+		// files.
+		background, err := opts.BackgroundColor()
+		if err != nil {
+			return nil, imageType, err
+		}
+		flat, err := flatten(buf, background, !opts.NoAutoRotate)
+		if err != nil {
+			return nil, imageType, fmt.Errorf("failed to flatten image: %w", err)
+		}
+		return flat, output, nil
+	case policy == domain.AlphaReject:
+		return nil, imageType, fmt.Errorf("%w: image is transparent and %s has no alpha channel", domain.ErrInvalidOptions, bimg.ImageTypeName(output))
+	case keepsAlpha(output):
+		return nil, imageType, nil
+	default:
+		return nil, bimg.PNG, nil
+	}
+}
+
+// keepsAlpha reports whether an output type has an alpha channel.
+func keepsAlpha(t bimg.ImageType) bool {
+	return t != bimg.JPEG
+}
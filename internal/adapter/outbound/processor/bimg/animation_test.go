@@ -0,0 +1,101 @@
+//go:build cgo
+
+package bimg
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/gif"
+	"testing"
+
+	"github.com/andreychano/compressor-golang/internal/config"
+	"github.com/andreychano/compressor-golang/internal/core/domain"
+	"github.com/h2non/bimg"
+)
+
+func TestAnimationFrames(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  bimg.ImageType
+		output bimg.ImageType
+		pages  int
+		opts   domain.AnimationOptions
+		want   int
+	}{
+		{"all frames", bimg.GIF, bimg.UNKNOWN, 3, domain.AnimationOptions{}, -1},
+		{"to webp", bimg.GIF, bimg.WEBP, 3, domain.AnimationOptions{}, -1},
+		{"max frames", bimg.GIF, bimg.GIF, 3, domain.AnimationOptions{MaxFrames: 2}, 2},
+		{"max frames past the end", bimg.GIF, bimg.GIF, 3, domain.AnimationOptions{MaxFrames: 10}, -1},
+		{"max frames at the end", bimg.WEBP, bimg.WEBP, 3, domain.AnimationOptions{MaxFrames: 3}, -1},
+		{"single frame", bimg.GIF, bimg.GIF, 1, domain.AnimationOptions{MaxFrames: 10}, 0},
+		{"first frame", bimg.GIF, bimg.GIF, 3, domain.AnimationOptions{FirstFrame: true}, 0},
+		{"still output", bimg.GIF, bimg.JPEG, 3, domain.AnimationOptions{}, 0},
+		{"still input", bimg.PNG, bimg.GIF, 1, domain.AnimationOptions{}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := animationFrames(tt.input, tt.output, tt.pages, tt.opts); got != tt.want {
+				t.Errorf("animationFrames = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+// animatedGIF encodes n solid 16x16 frames of different colours.
+func animatedGIF(t *testing.T, n int) []byte {
+	t.Helper()
+
+	palette := color.Palette{color.RGBA{R: 255, A: 255}, color.RGBA{G: 255, A: 255}, color.RGBA{B: 255, A: 255}}
+	anim := &gif.GIF{}
+	for i := range n {
+		frame := image.NewPaletted(image.Rect(0, 0, 16, 16), palette)
+		for j := range frame.Pix {
+			frame.Pix[j] = uint8(i % len(palette))
+		}
+		anim.Image = append(anim.Image, frame)
+		anim.Delay = append(anim.Delay, 10)
+	}
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, anim); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestProcess_MaxFrames(t *testing.T) {
+	if !bimg.IsTypeSupportedSave(bimg.GIF) {
+		t.Skip("libvips cannot encode GIF")
+	}
+
+	tests := []struct {
+		name      string
+		frames    int
+		maxFrames int
+		want      int
+	}{
+		{"fewer than the input", 3, 2, 2},
+		{"more than the input", 3, 10, 3},
+		{"single frame", 1, 10, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := animatedGIF(t, tt.frames)
+			result, err := NewProcessor(config.Color{}).Process(domain.File{
+				Content:  bytes.NewReader(input),
+				MimeType: "image/gif",
+				Size:     int64(len(input)),
+			}, domain.Options{Format: "gif", Animation: domain.AnimationOptions{MaxFrames: tt.maxFrames}})
+			if err != nil {
+				t.Fatalf("Process: %v", err)
+			}
+			out, err := gif.DecodeAll(result.Content)
+			if err != nil {
+				t.Fatalf("decode output: %v", err)
+			}
+			if len(out.Image) != tt.want {
+				t.Errorf("got %d frames, want %d", len(out.Image), tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,44 @@
+//go:build cgo
+
+package bimg
+
+import (
+	"cmp"
+	"fmt"
+	"os"
+)
+
+// Profiles built into libvips; anything else names an ICC file.
+const (
+	srgbProfile = "srgb"
+	p3Profile   = "p3"
+)
+
+// cmykSpace is the bimg name of the CMYK interpretation.
+const cmykSpace = "cmyk"
+
+// targetProfile returns the profile output pixels are converted to and
+// whether it is attached to the output. Outputs that cannot carry a profile
+// get sRGB, the space viewers assume for untagged images; any other target is
+// meaningless without the profile, so it is always attached.
+func (p *Processor) targetProfile(taggable bool) (profile string, embed bool) {
+	if !taggable {
+		return srgbProfile, false
+	}
+	profile = cmp.Or(p.color.Profile, srgbProfile)
+	return profile, p.color.EmbedProfile || profile != srgbProfile
+}
+
+// CheckColorProfile reports whether the configured target profile is usable:
+// built into libvips or a readable file.
+func (p *Processor) CheckColorProfile() error {
+	switch cmp.Or(p.color.Profile, srgbProfile) {
+	case srgbProfile, p3Profile:
+		return nil
+	}
+	f, err := os.Open(p.color.Profile)
+	if err != nil {
+		return fmt.Errorf("cannot read color profile: %w", err)
+	}
+	return f.Close()
+}
@@ -0,0 +1,43 @@
+//go:build cgo
+
+package bimg
+
+import (
+	"testing"
+
+	"github.com/andreychano/compressor-golang/internal/config"
+)
+
+func TestTargetProfile(t *testing.T) {
+	tests := []struct {
+		name        string
+		color       config.Color
+		taggable    bool
+		wantProfile string
+		wantEmbed   bool
+	}{
+		{"default", config.Color{}, true, srgbProfile, false},
+		{"embed srgb", config.Color{Profile: srgbProfile, EmbedProfile: true}, true, srgbProfile, true},
+		{"p3 always embedded", config.Color{Profile: p3Profile}, true, p3Profile, true},
+		{"untaggable output", config.Color{Profile: p3Profile, EmbedProfile: true}, false, srgbProfile, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &Processor{color: tt.color}
+			profile, embed := p.targetProfile(tt.taggable)
+			if profile != tt.wantProfile || embed != tt.wantEmbed {
+				t.Errorf("targetProfile(%v) = %q, %v; want %q, %v", tt.taggable, profile, embed, tt.wantProfile, tt.wantEmbed)
+			}
+		})
+	}
+}
+
+func TestCheckColorProfile(t *testing.T) {
+	if err := (&Processor{color: config.Color{Profile: p3Profile}}).CheckColorProfile(); err != nil {
+		t.Errorf("built-in profile: %v", err)
+	}
+	if err := (&Processor{color: config.Color{Profile: "/nonexistent.icc"}}).CheckColorProfile(); err == nil {
+		t.Error("missing profile file: expected an error")
+	}
+}
@@ -0,0 +1,14 @@
+//go:build cgo
+
+package bimg
+
+import (
+	"testing"
+
+	"github.com/andreychano/compressor-golang/internal/adapter/outbound/processor/conformance"
+	"github.com/andreychano/compressor-golang/internal/config"
+)
+
+func TestConformance(t *testing.T) {
+	conformance.Run(t, NewProcessor(config.Color{}))
+}
@@ -0,0 +1,71 @@
+//go:build cgo
+
+package bimg
+
+import (
+	"slices"
+
+	"github.com/h2non/bimg"
+)
+
+// inputLoaders maps input MIME types to the libvips loader that reads them.
+// Whether each loader is compiled in is checked once, in NewProcessor.
+var inputLoaders = map[string]string{
+	"image/jpeg":               "jpegload_buffer",
+	"image/png":                "pngload_buffer",
+	"image/webp":               "webpload_buffer",
+	"image/gif":                "gifload_buffer",
+	"image/avif":               "heifload_buffer",
+	"image/heic":               "heifload_buffer", // Primary image of multi-image containers
+	"image/heif":               "heifload_buffer",
+	"image/heic-sequence":      "heifload_buffer",
+	"image/heif-sequence":      "heifload_buffer",
+	"image/svg+xml":            "svgload_buffer", // Sanitized, then rasterized or minified
+	"image/tiff":               "tiffload_buffer",
+	"image/jp2":                "jp2kload_buffer",
+	"image/bmp":                "magickload_buffer",
+	"image/x-ms-bmp":           "magickload_buffer",
+	"image/x-icon":             "magickload_buffer",
+	"image/vnd.microsoft.icon": "magickload_buffer",
+}
+
+// loadableInputs returns the MIME types whose loader the linked libvips has.
+func loadableInputs() map[string]bool {
+	bimg.Initialize()
+
+	inputs := make(map[string]bool, len(inputLoaders))
+	for mimeType, loader := range inputLoaders {
+		if vipsHasOperation(loader) {
+			inputs[mimeType] = true
+		}
+	}
+	return inputs
+}
+
+// SupportedInputs lists the input MIME types the linked libvips can load.
+func (p *Processor) SupportedInputs() []string {
+	inputs := make([]string, 0, len(p.inputs))
+	for mimeType := range p.inputs {
+		inputs = append(inputs, mimeType)
+	}
+	slices.Sort(inputs)
+	return inputs
+}
+
+// bimgLoads reports whether bimg reads the type itself. Anything else, such as
+// JPEG 2000 or ImageMagick formats, is converted to PNG first.
+func bimgLoads(t bimg.ImageType) bool {
+	switch t {
+	case bimg.JPEG, bimg.PNG, bimg.WEBP, bimg.GIF, bimg.HEIF, bimg.AVIF, bimg.TIFF:
+		return true
+	default:
+		return false
+	}
+}
+
+// needsNormalize reports whether the input may have to go through normalize:
+// types bimg cannot read, page selection, and types that can hold more than
+// 8 bits per channel.
+func needsNormalize(t bimg.ImageType, page int) bool {
+	return page > 0 || !bimgLoads(t) || t == bimg.PNG || t == bimg.TIFF
+}
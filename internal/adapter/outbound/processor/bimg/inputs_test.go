@@ -0,0 +1,58 @@
+//go:build cgo
+
+package bimg
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/png"
+	"testing"
+
+	"github.com/andreychano/compressor-golang/internal/config"
+	"github.com/andreychano/compressor-golang/internal/core/domain"
+)
+
+// logoSVG references an external image that must never be fetched.
+const logoSVG = `<svg xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink" width="16" height="16">` +
+	`<image xlink:href="http://127.0.0.1:1/secret.png" width="16" height="16"/>` +
+	`<rect width="8" height="8" fill="red"/></svg>`
+
+func TestProcess_DeclaredType(t *testing.T) {
+	var graphic bytes.Buffer
+	if err := png.Encode(&graphic, image.NewNRGBA(image.Rect(0, 0, 16, 16))); err != nil {
+		t.Fatal(err)
+	}
+
+	p := NewProcessor(config.Color{})
+	tests := []struct {
+		name     string
+		input    []byte
+		mimeType string
+		wantErr  error
+	}{
+		{"png", graphic.Bytes(), "image/png", nil},
+		{"png declared as jpeg", graphic.Bytes(), "image/jpeg", domain.ErrInvalidImage},
+		{"svg", []byte(logoSVG), svgMimeType, nil},
+		// Rendered unsanitised if the declared type decided.
+		{"svg declared as png", []byte(logoSVG), "image/png", domain.ErrInvalidImage},
+		// Rasterised without the page, DPI and time limits of the PDF
+		// processor if the declared type decided.
+		{"pdf declared as jpeg", []byte("%PDF-1.4\n%%EOF\n"), "image/jpeg", domain.ErrInvalidImage},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if vipsLoader(tt.input) == "" {
+				t.Skip("libvips cannot load the input")
+			}
+			_, err := p.Process(domain.File{
+				Content:  bytes.NewReader(tt.input),
+				MimeType: tt.mimeType,
+				Size:     int64(len(tt.input)),
+			}, domain.Options{Format: "png"})
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("got %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
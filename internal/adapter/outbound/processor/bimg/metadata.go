@@ -0,0 +1,40 @@
+//go:build cgo
+
+package bimg
+
+import (
+	"fmt"
+
+	"github.com/andreychano/compressor-golang/internal/core/domain"
+	"github.com/andreychano/compressor-golang/internal/metadata"
+	"github.com/h2non/bimg"
+)
+
+// filterPolicy maps a domain policy to the one metadata.Filter applies. ok is
+// false when everything goes, which libvips does on its own.
+func filterPolicy(p domain.MetadataPolicy) (policy metadata.Policy, ok bool) {
+	policy = metadata.PolicyOf(p)
+	return policy, policy != metadata.StripAll
+}
+
+// canFilter reports whether metadata can be kept selectively in outputType.
+// libvips writes AVIF and animations without metadata.
+func canFilter(outputType bimg.ImageType, frames int) bool {
+	if frames != 0 {
+		return false
+	}
+	return outputType == bimg.JPEG || outputType == bimg.PNG || outputType == bimg.WEBP
+}
+
+// removedMetadata lists the metadata fields of input that output lacks.
+func removedMetadata(input, output []byte) ([]string, error) {
+	before, err := readMetadata(input)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read input metadata: %w", err)
+	}
+	after, err := readMetadata(output)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read output metadata: %w", err)
+	}
+	return metadata.Removed(before, after), nil
+}
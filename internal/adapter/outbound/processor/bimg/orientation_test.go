@@ -0,0 +1,188 @@
+//go:build cgo
+
+package bimg
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+
+	"github.com/andreychano/compressor-golang/internal/config"
+	"github.com/andreychano/compressor-golang/internal/core/domain"
+)
+
+// The golden image is upright: four solid quadrants, wider than tall, so
+// every flip and rotation is distinguishable.
+const (
+	goldenWidth  = 64
+	goldenHeight = 32
+)
+
+var goldenQuadrants = [2][2]color.RGBA{
+	{{R: 255, A: 255}, {G: 255, A: 255}},                 // Top: red, green
+	{{B: 255, A: 255}, {R: 255, G: 255, B: 255, A: 255}}, // Bottom: blue, white
+}
+
+func golden() *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, goldenWidth, goldenHeight))
+	for y := range goldenHeight {
+		for x := range goldenWidth {
+			img.SetRGBA(x, y, goldenQuadrants[y*2/goldenHeight][x*2/goldenWidth])
+		}
+	}
+	return img
+}
+
+// storedPixel maps an upright (display) pixel to where a camera writing the
+// given EXIF orientation stores it; sw and sh are the stored dimensions.
+func storedPixel(orientation, dx, dy, sw, sh int) (int, int) {
+	switch orientation {
+	case 2: // Mirrored horizontally
+		return sw - 1 - dx, dy
+	case 3: // Rotated 180
+		return sw - 1 - dx, sh - 1 - dy
+	case 4: // Mirrored vertically
+		return dx, sh - 1 - dy
+	case 5: // Transposed
+		return dy, dx
+	case 6: // Needs 90 clockwise
+		return dy, sh - 1 - dx
+	case 7: // Transversed
+		return sw - 1 - dy, sh - 1 - dx
+	case 8: // Needs 90 counter-clockwise
+		return sw - 1 - dy, dx
+	default:
+		return dx, dy
+	}
+}
+
+// orientedJPEG stores the golden image the way a camera would for the
+// orientation and tags it with EXIF.
+func orientedJPEG(t *testing.T, orientation int) []byte {
+	t.Helper()
+
+	upright := golden()
+	sw, sh := goldenWidth, goldenHeight
+	if swapsAxes(orientation) {
+		sw, sh = sh, sw
+	}
+
+	stored := image.NewRGBA(image.Rect(0, 0, sw, sh))
+	for dy := range goldenHeight {
+		for dx := range goldenWidth {
+			sx, sy := storedPixel(orientation, dx, dy, sw, sh)
+			stored.SetRGBA(sx, sy, upright.RGBAAt(dx, dy))
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, stored, &jpeg.Options{Quality: 95}); err != nil {
+		t.Fatalf("encode fixture: %v", err)
+	}
+	return withOrientation(buf.Bytes(), orientation)
+}
+
+// withOrientation inserts an APP1 EXIF segment holding only the orientation
+// tag right after the JPEG SOI marker.
+func withOrientation(jpg []byte, orientation int) []byte {
+	var tiff bytes.Buffer
+	tiff.WriteString("MM\x00\x2a")                            // Big-endian TIFF header
+	_ = binary.Write(&tiff, binary.BigEndian, uint32(8))      // Offset of IFD0
+	_ = binary.Write(&tiff, binary.BigEndian, uint16(1))      // One entry
+	_ = binary.Write(&tiff, binary.BigEndian, uint16(0x0112)) // Orientation
+	_ = binary.Write(&tiff, binary.BigEndian, uint16(3))      // SHORT
+	_ = binary.Write(&tiff, binary.BigEndian, uint32(1))      // One value
+	_ = binary.Write(&tiff, binary.BigEndian, uint16(orientation))
+	_ = binary.Write(&tiff, binary.BigEndian, uint16(0)) // Padding
+	_ = binary.Write(&tiff, binary.BigEndian, uint32(0)) // No next IFD
+
+	payload := append([]byte("Exif\x00\x00"), tiff.Bytes()...)
+
+	var out bytes.Buffer
+	out.Write(jpg[:2]) // SOI
+	out.Write([]byte{0xff, 0xe1})
+	_ = binary.Write(&out, binary.BigEndian, uint16(len(payload)+2))
+	out.Write(payload)
+	out.Write(jpg[2:])
+	return out.Bytes()
+}
+
+func process(t *testing.T, input []byte, opts domain.Options) image.Image {
+	t.Helper()
+
+	result, err := NewProcessor(config.Color{}).Process(domain.File{
+		Content:  bytes.NewReader(input),
+		MimeType: "image/jpeg",
+		Size:     int64(len(input)),
+	}, opts)
+	if err != nil {
+		t.Fatalf("Process: %v", err)
+	}
+
+	img, err := jpeg.Decode(result.Content)
+	if err != nil {
+		t.Fatalf("decode output: %v", err)
+	}
+	if b := img.Bounds(); b.Dx() != result.Width || b.Dy() != result.Height {
+		t.Fatalf("reported %dx%d, decoded %dx%d", result.Width, result.Height, b.Dx(), b.Dy())
+	}
+	return img
+}
+
+// assertGolden samples the centre of every quadrant, allowing for JPEG loss.
+func assertGolden(t *testing.T, img image.Image, width, height int) {
+	t.Helper()
+
+	b := img.Bounds()
+	if b.Dx() != width || b.Dy() != height {
+		t.Fatalf("got %dx%d, want %dx%d", b.Dx(), b.Dy(), width, height)
+	}
+
+	const tolerance = 48
+	for qy := range 2 {
+		for qx := range 2 {
+			x := b.Min.X + (2*qx+1)*width/4
+			y := b.Min.Y + (2*qy+1)*height/4
+			got := color.RGBAModel.Convert(img.At(x, y)).(color.RGBA)
+			want := goldenQuadrants[qy][qx]
+			if diff(got.R, want.R) > tolerance || diff(got.G, want.G) > tolerance || diff(got.B, want.B) > tolerance {
+				t.Errorf("quadrant (%d,%d): got %v, want %v", qx, qy, got, want)
+			}
+		}
+	}
+}
+
+func diff(a, b uint8) int {
+	if a > b {
+		return int(a - b)
+	}
+	return int(b - a)
+}
+
+func TestProcess_AutoOrient(t *testing.T) {
+	for orientation := 1; orientation <= 8; orientation++ {
+		t.Run(fmt.Sprintf("orientation %d", orientation), func(t *testing.T) {
+			img := process(t, orientedJPEG(t, orientation), domain.Options{Format: "jpeg", Quality: 95})
+			assertGolden(t, img, goldenWidth, goldenHeight)
+		})
+	}
+}
+
+func TestProcess_AutoOrientWithinLimits(t *testing.T) {
+	// The limits apply to the upright image: 32 wide means half size.
+	opts := domain.Options{Format: "jpeg", Quality: 95, MaxWidth: goldenWidth / 2, MaxHeight: goldenWidth / 2}
+	img := process(t, orientedJPEG(t, 6), opts)
+	assertGolden(t, img, goldenWidth/2, goldenHeight/2)
+}
+
+func TestProcess_NoAutoRotate(t *testing.T) {
+	img := process(t, orientedJPEG(t, 6), domain.Options{Format: "jpeg", Quality: 95, NoAutoRotate: true})
+
+	if b := img.Bounds(); b.Dx() != goldenHeight || b.Dy() != goldenWidth {
+		t.Fatalf("got %dx%d, want stored %dx%d", b.Dx(), b.Dy(), goldenHeight, goldenWidth)
+	}
+}
@@ -1,58 +1,493 @@
+//go:build cgo
+
 package bimg
 
 import (
 	"bytes"
+	"cmp"
+	"encoding/base64"
+	"errors"
 	"fmt"
+	"image"
 	"io"
+	"time"
 
+	"github.com/andreychano/compressor-golang/internal/adapter/outbound/processor/describe"
+	"github.com/andreychano/compressor-golang/internal/config"
 	"github.com/andreychano/compressor-golang/internal/core/domain"
+	"github.com/andreychano/compressor-golang/internal/metadata"
+	"github.com/andreychano/compressor-golang/internal/placeholder"
 	"github.com/h2non/bimg"
 )
 
 // Processor implements image compression using bimg/libvips.
-type Processor struct{}
+type Processor struct {
+	inputs map[string]bool // Loadable input MIME types
+	color  config.Color
+}
 
-// NewProcessor creates a new bimg-based processor.
-func NewProcessor() *Processor {
-	return &Processor{}
+// NewProcessor creates a new bimg-based processor converting output to the
+// configured colour profile. It asks libvips which input formats the linked
+// build can load.
+func NewProcessor(color config.Color) *Processor {
+	return &Processor{inputs: loadableInputs(), color: color}
 }
 
 // Supports reports whether the given MIME type is supported.
 func (p *Processor) Supports(mimeType string) bool {
-	switch mimeType {
-	case "image/jpeg", "image/png", "image/webp":
+	return p.inputs[mimeType]
+}
+
+// SupportsOutput reports whether the linked libvips can encode the given format.
+func (p *Processor) SupportsOutput(format string) bool {
+	if format == formatSVG {
+		return p.inputs[svgMimeType]
+	}
+	imageType, err := outputType(format)
+	return err == nil && imageType != bimg.UNKNOWN && bimg.IsTypeSupportedSave(imageType)
+}
+
+// SupportsStep reports whether the processor can run a pipeline step.
+func (p *Processor) SupportsStep(step domain.Step) bool {
+	switch s := step.(type) {
+	case domain.Operation, domain.ResizeStep, domain.Filter, domain.WatermarkStep:
 		return true
+	case domain.EncodeStep:
+		return s.Format == "" || p.SupportsOutput(s.Format)
 	default:
 		return false
 	}
 }
 
 // Process compresses the input file according to the provided options.
-func (p *Processor) Process(inputFile domain.File, opts domain.Options) (domain.File, error) {
+func (p *Processor) Process(inputFile domain.File, opts domain.Options) (domain.ProcessedFile, error) {
 	if _, err := inputFile.Content.Seek(0, 0); err != nil {
-		return domain.File{}, fmt.Errorf("failed to seek file content: %w", err)
+		return domain.ProcessedFile{}, fmt.Errorf("failed to seek file content: %w", err)
 	}
 
 	buffer, err := io.ReadAll(inputFile.Content)
 	if err != nil {
-		return domain.File{}, fmt.Errorf("cannot read: %w", err)
+		return domain.ProcessedFile{}, fmt.Errorf("cannot read: %w", err)
+	}
+
+	// The declared type picks the processor, but libvips loads whatever the
+	// content is: an SVG declared as PNG would skip sanitising, a PDF declared
+	// as JPEG the page and render limits of the PDF processor.
+	loader := vipsLoader(buffer)
+	if loader == "" || loader != inputLoaders[inputFile.MimeType] {
+		return domain.ProcessedFile{}, fmt.Errorf("%w: content does not match the declared type %s", domain.ErrInvalidImage, inputFile.MimeType)
+	}
+
+	inputType := bimg.DetermineImageType(buffer)
+	steps, fit, encode := opts.Compile().Stages()
+	format := encode.Format
+
+	if loader == inputLoaders[svgMimeType] {
+		if buffer, err = sanitizeSVG(buffer); err != nil {
+			return domain.ProcessedFile{}, err
+		}
+		if format == formatSVG {
+			if len(steps) > 0 {
+				return domain.ProcessedFile{}, fmt.Errorf("%w: svg output takes no pipeline steps", domain.ErrInvalidOptions)
+			}
+			return minifySVG(buffer, encode)
+		}
+		buffer, err = svgRender(buffer, opts.SVG.DPI, opts.SVG.Width)
+		if errors.Is(err, errCanvas) {
+			return domain.ProcessedFile{}, fmt.Errorf("%w: %v", domain.ErrInvalidOptions, err)
+		}
+		if err != nil {
+			return domain.ProcessedFile{}, fmt.Errorf("failed to render svg: %w", err)
+		}
+		inputType = bimg.PNG
+		if format == "" {
+			format = "png"
+		}
+	} else if format == formatSVG {
+		return domain.ProcessedFile{}, fmt.Errorf("%w: svg output needs an svg input", domain.ErrInvalidOptions)
+	}
+
+	// Metadata removal is reported against this buffer; unsanitized SVG never
+	// reaches libvips.
+	original := buffer
+
+	if format == "" && inputType == bimg.HEIF {
+		// Few libvips builds can encode HEVC, so HEIC without an explicit
+		// format becomes JPEG.
+		format = "jpeg"
+	}
+
+	if needsNormalize(inputType, opts.Page) {
+		normalized, err := normalize(buffer, opts.Page, !bimgLoads(inputType), !opts.NoAutoRotate)
+		if errors.Is(err, errPageOutOfRange) {
+			return domain.ProcessedFile{}, fmt.Errorf("%w: page %d does not exist", domain.ErrInvalidOptions, opts.Page)
+		}
+		if err != nil {
+			return domain.ProcessedFile{}, fmt.Errorf("failed to normalize image: %w", err)
+		}
+		if normalized != nil {
+			buffer, inputType = normalized, bimg.PNG
+			if format == "" {
+				format = "png"
+			}
+		}
 	}
 
 	img := bimg.NewImage(buffer)
 
-	processOptions := bimg.Options{
-		Quality:       opts.Quality,
-		StripMetadata: true,
+	info, err := img.Metadata()
+	if err != nil {
+		return domain.ProcessedFile{}, fmt.Errorf("failed to read image metadata: %w", err)
+	}
+	if info.Space == cmykSpace {
+		// bimg converts CMYK to sRGB without the profile and then applies
+		// the CMYK profile to the result, which garbles print files.
+		if buffer, err = normalize(buffer, 0, true, !opts.NoAutoRotate); err != nil {
+			return domain.ProcessedFile{}, fmt.Errorf("failed to convert cmyk image: %w", err)
+		}
+		if format == "" {
+			format = "png"
+			if inputType == bimg.JPEG {
+				format = "jpeg"
+			}
+		}
+		inputType = bimg.PNG
+		img = bimg.NewImage(buffer)
+		if info, err = img.Metadata(); err != nil {
+			return domain.ProcessedFile{}, fmt.Errorf("failed to read image metadata: %w", err)
+		}
 	}
 
-	processedBuffer, err := img.Process(processOptions)
+	// One-frame GIFs and WebPs take the still path, which converts colour
+	// and applies the orientation.
+	pages := 1
+	if canAnimate(inputType) {
+		if pages, err = vipsPages(buffer); err != nil {
+			return domain.ProcessedFile{}, fmt.Errorf("failed to read image frames: %w", err)
+		}
+	}
+
+	imageType, err := outputType(format)
 	if err != nil {
-		return domain.File{}, fmt.Errorf("failed to process image: %w", err)
+		return domain.ProcessedFile{}, err
+	}
+	if imageType != bimg.UNKNOWN && !bimg.IsTypeSupportedSave(imageType) {
+		return domain.ProcessedFile{}, fmt.Errorf("%w: libvips cannot encode %s", domain.ErrInvalidOptions, format)
+	}
+	if info.Alpha && animationFrames(inputType, imageType, pages, opts.Animation) == 0 {
+		// Animations only go to GIF and WebP, which keep alpha.
+		var flat []byte
+		if flat, imageType, err = applyAlpha(buffer, inputType, imageType, opts); err != nil {
+			return domain.ProcessedFile{}, err
+		}
+		if flat != nil {
+			buffer, inputType = flat, bimg.PNG
+			img = bimg.NewImage(buffer)
+			if info, err = img.Metadata(); err != nil {
+				return domain.ProcessedFile{}, fmt.Errorf("failed to read image metadata: %w", err)
+			}
+		}
+	}
+
+	sourceSize := info.Size
+	if !opts.NoAutoRotate && swapsAxes(info.Orientation) {
+		// bimg rotates before resizing, so limits apply to the upright image.
+		sourceSize.Width, sourceSize.Height = sourceSize.Height, sourceSize.Width
+	}
+
+	// encodeSize is the size of what the encoder resizes: the upright input
+	// after the pipeline steps before it.
+	encodeSize := sourceSize
+	if len(steps) > 0 && animationFrames(inputType, imageType, pages, opts.Animation) != 0 {
+		return domain.ProcessedFile{}, fmt.Errorf("%w: operations, crops, filters and watermarks of an animation need first_frame", domain.ErrInvalidOptions)
+	}
+	if len(steps) > 0 {
+		buffer, encodeSize, err = transform(buffer, encodeSize, steps, opts)
+		if errors.Is(err, errOutOfBounds) || errors.Is(err, errCanvas) {
+			return domain.ProcessedFile{}, fmt.Errorf("%w: %v", domain.ErrInvalidOptions, err)
+		}
+		if err != nil {
+			return domain.ProcessedFile{}, fmt.Errorf("failed to transform image: %w", err)
+		}
+		imageType, inputType = cmp.Or(imageType, inputType), bimg.PNG
+	}
+
+	width, height := fitWithin(encodeSize.Width, encodeSize.Height, fit.Width, fit.Height)
+	enc := encoder{
+		buffer:       buffer,
+		imageType:    imageType,
+		source:       encodeSize,
+		jpeg:         encode.JPEG,
+		png:          encode.PNG,
+		webp:         encode.WebP,
+		avif:         encode.AVIF,
+		noAutoRotate: opts.NoAutoRotate,
+	}
+	if imageType == bimg.UNKNOWN && (inputType == bimg.JPEG || inputType == bimg.PNG || inputType == bimg.WEBP) {
+		// Format options also apply when the input keeps its format.
+		enc.imageType = inputType
+	}
+	if frames := animationFrames(inputType, imageType, pages, opts.Animation); frames != 0 {
+		enc.frames = frames
+		enc.imageType = cmp.Or(imageType, inputType)
+	}
+	taggable := canFilter(cmp.Or(enc.imageType, inputType), enc.frames)
+	if policy, ok := filterPolicy(opts.Metadata); ok && taggable {
+		enc.policy, enc.retain = policy, true
+	}
+	profile, embed := p.targetProfile(taggable)
+	if embed && !enc.retain {
+		enc.policy, enc.retain = metadata.KeepICC, true
+	}
+	switch {
+	case info.Profile:
+		// Converting the pixels keeps colours right whatever happens to the
+		// profile; without this Display-P3 photos from iPhones look washed
+		// out once it is stripped. libvips attaches the target profile.
+		enc.outputICC = profile
+	case embed:
+		// Untagged pixels are sRGB by convention; converting them attaches
+		// the target profile.
+		enc.inputICC, enc.outputICC = srgbProfile, profile
 	}
 
-	return domain.File{
-		Content:  bytes.NewReader(processedBuffer),
-		MimeType: "image/" + opts.Format,
-		Size:     int64(len(processedBuffer)),
+	var (
+		processedBuffer []byte
+		quality         = encode.Quality
+		ssim            float64
+		start           = time.Now()
+	)
+	switch {
+	case encode.TargetBytes > 0:
+		// The search may scale the output down further.
+		var encoded image.Point
+		processedBuffer, quality, encoded, err = enc.searchTarget(encode.TargetBytes, encode.Quality, width, height)
+		width, height = encoded.X, encoded.Y
+	case encode.TargetSSIM > 0:
+		processedBuffer, quality, ssim, err = enc.searchSSIM(encode.TargetSSIM, encode.Quality, width, height)
+	default:
+		processedBuffer, err = enc.encode(quality, width, height)
+	}
+	if err != nil {
+		return domain.ProcessedFile{}, err
+	}
+	encodeTime := time.Since(start)
+
+	removed, err := removedMetadata(original, processedBuffer)
+	if err != nil {
+		return domain.ProcessedFile{}, err
+	}
+
+	size, err := bimg.Size(processedBuffer)
+	if err != nil {
+		return domain.ProcessedFile{}, fmt.Errorf("failed to read processed image size: %w", err)
+	}
+
+	var report *domain.Report
+	if opts.Report {
+		report, err = enc.report(processedBuffer, width, height)
+		if err != nil {
+			return domain.ProcessedFile{}, err
+		}
+		report.EncodeMS = encodeTime.Milliseconds()
+	}
+
+	var (
+		placeholders *domain.Placeholders
+		analysis     *domain.Analysis
+	)
+	if opts.Placeholders || opts.Analyze {
+		decoded, err := decode(processedBuffer)
+		if err != nil {
+			return domain.ProcessedFile{}, err
+		}
+		if opts.Placeholders {
+			blurHash, thumbHash := placeholder.Hashes(decoded)
+			placeholders = &domain.Placeholders{BlurHash: blurHash, ThumbHash: base64.StdEncoding.EncodeToString(thumbHash)}
+		}
+		if opts.Analyze {
+			analysis = describe.Palette(decoded)
+		}
+	}
+
+	mimeType := inputFile.MimeType
+	if imageType != bimg.UNKNOWN {
+		mimeType = "image/" + bimg.ImageTypeName(imageType)
+	}
+
+	return domain.ProcessedFile{
+		File: domain.File{
+			Content:  bytes.NewReader(processedBuffer),
+			MimeType: mimeType,
+			Size:     int64(len(processedBuffer)),
+		},
+		Width:           size.Width,
+		Height:          size.Height,
+		SourceWidth:     sourceSize.Width,
+		SourceHeight:    sourceSize.Height,
+		Quality:         quality,
+		SSIM:            ssim,
+		Report:          report,
+		Placeholders:    placeholders,
+		Analysis:        analysis,
+		RemovedMetadata: removed,
 	}, nil
 }
+
+// encoder runs libvips over one source buffer with varying parameters.
+type encoder struct {
+	buffer    []byte
+	imageType bimg.ImageType
+	source    bimg.ImageSize
+	jpeg      domain.JPEGOptions
+	png       domain.PNGOptions
+	webp      domain.WebPOptions
+	avif      domain.AVIFOptions
+	outputICC string // Profile to convert to before encoding, empty to keep the pixels as is
+	inputICC  string // Profile of untagged pixels, set to convert them to outputICC
+	frames    int    // Frames to keep of an animation, -1 for all, 0 for a still image
+	// noAutoRotate keeps the stored pixel order. The EXIF orientation then
+	// survives only when the policy retains EXIF.
+	noAutoRotate bool
+	// retain keeps the metadata policy allows instead of stripping it all.
+	retain bool
+	policy metadata.Policy
+}
+
+// encode produces the output at the given quality; zero width and height keep
+// the source dimensions.
+func (e encoder) encode(quality, width, height int) ([]byte, error) {
+	if e.frames != 0 {
+		processedBuffer, err := animSave(e.buffer, e.frames, width, height, e.imageType, quality)
+		if err != nil {
+			return nil, fmt.Errorf("failed to process animation: %w", err)
+		}
+		return processedBuffer, nil
+	}
+
+	processOptions := bimg.Options{
+		Type:          e.imageType,
+		Quality:       quality,
+		Width:         width,
+		Height:        height,
+		StripMetadata: !e.retain,
+		NoAutoRotate:  e.noAutoRotate,
+		OutputICC:     e.outputICC,
+		InputICC:      e.inputICC,
+	}
+	tunedJPEG := e.imageType == bimg.JPEG && (e.jpeg.Subsample != "" || e.jpeg.Trellis)
+	tunedPNG := e.imageType == bimg.PNG && e.png != domain.PNGOptions{}
+	tunedWebP := e.imageType == bimg.WEBP && e.webp != domain.WebPOptions{Lossless: e.webp.Lossless}
+	if e.imageType == bimg.AVIF || tunedJPEG || tunedPNG || tunedWebP {
+		// Resize through bimg into a lossless intermediate, then encode with
+		// the settings bimg cannot pass.
+		processOptions.Type = bimg.PNG
+		processOptions.Compression = 1
+	} else {
+		processOptions.Interlace = e.imageType == bimg.JPEG && e.jpeg.Progressive
+		processOptions.Lossless = e.imageType == bimg.WEBP && e.webp.Lossless
+	}
+
+	processedBuffer, err := bimg.NewImage(e.buffer).Process(processOptions)
+	if err != nil {
+		return nil, fmt.Errorf("failed to process image: %w", err)
+	}
+
+	switch {
+	case e.imageType == bimg.AVIF:
+		processedBuffer, err = avifSave(processedBuffer, quality, e.avif)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode avif: %w", err)
+		}
+	case tunedJPEG:
+		processedBuffer, err = jpegSave(processedBuffer, quality, e.jpeg)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode jpeg: %w", err)
+		}
+	case tunedWebP:
+		processedBuffer, err = webpSave(processedBuffer, quality, e.webp)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode webp: %w", err)
+		}
+	case tunedPNG:
+		processedBuffer, err = pngSave(processedBuffer, quality, e.png)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode png: %w", err)
+		}
+	}
+
+	if e.retain {
+		// libvips keeps all metadata or none; drop the rest here so size
+		// searches see the final bytes.
+		processedBuffer, err = metadata.Filter(processedBuffer, e.policy, e.noAutoRotate)
+		if err != nil {
+			return nil, fmt.Errorf("failed to filter metadata: %w", err)
+		}
+	}
+
+	return processedBuffer, nil
+}
+
+// outputType maps an output format name to its libvips type. An empty format
+// keeps the input type.
+func outputType(format string) (bimg.ImageType, error) {
+	switch format {
+	case "":
+		return bimg.UNKNOWN, nil
+	case "jpeg", "jpg":
+		return bimg.JPEG, nil
+	case "png":
+		return bimg.PNG, nil
+	case "webp":
+		return bimg.WEBP, nil
+	case "avif":
+		return bimg.AVIF, nil
+	case "gif":
+		return bimg.GIF, nil
+	default:
+		return bimg.UNKNOWN, fmt.Errorf("unsupported output format: %s", format)
+	}
+}
+
+// animationFrames returns how many of the pages of an input to keep: -1 for
+// all, 0 when the output is a still image because either side cannot
+// animate, the input has a single frame or a poster was requested.
+func animationFrames(inputType, outputType bimg.ImageType, pages int, opts domain.AnimationOptions) int {
+	if outputType == bimg.UNKNOWN {
+		outputType = inputType
+	}
+	if !canAnimate(inputType) || !canAnimate(outputType) || pages <= 1 || opts.FirstFrame {
+		return 0
+	}
+	if opts.MaxFrames > 0 && opts.MaxFrames < pages {
+		return opts.MaxFrames
+	}
+	return -1
+}
+
+func canAnimate(t bimg.ImageType) bool {
+	return t == bimg.GIF || t == bimg.WEBP
+}
+
+// swapsAxes reports whether an EXIF orientation turns the image by 90 degrees,
+// so the upright width is the stored height.
+func swapsAxes(orientation int) bool {
+	return orientation >= 5 && orientation <= 8
+}
+
+// fitWithin scales width x height down to fit maxWidth x maxHeight keeping the
+// aspect ratio. It returns zeros when no resize is needed; zero limits are ignored.
+func fitWithin(width, height, maxWidth, maxHeight int) (int, int) {
+	scale := 1.0
+	if maxWidth > 0 && width > maxWidth {
+		scale = float64(maxWidth) / float64(width)
+	}
+	if maxHeight > 0 && height > maxHeight {
+		scale = min(scale, float64(maxHeight)/float64(height))
+	}
+	if scale == 1.0 {
+		return 0, 0
+	}
+
+	return max(1, int(float64(width)*scale+0.5)), max(1, int(float64(height)*scale+0.5))
+}
@@ -0,0 +1,85 @@
+//go:build cgo
+
+package bimg
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+
+	"github.com/andreychano/compressor-golang/internal/adapter/outbound/processor/search"
+	"github.com/andreychano/compressor-golang/internal/core/domain"
+	"github.com/h2non/bimg"
+)
+
+// searchTarget finds the highest quality that keeps the output within
+// targetBytes, stepping the dimensions down when needed, and returns the
+// dimensions it encoded at.
+func (e encoder) searchTarget(targetBytes int64, maxQuality, width, height int) ([]byte, int, image.Point, error) {
+	source := image.Pt(e.source.Width, e.source.Height)
+	return search.Target(e.encode, source, targetBytes, maxQuality, width, height)
+}
+
+// searchSSIM finds the lowest quality whose output still scores targetSSIM
+// against the source at the same dimensions.
+func (e encoder) searchSSIM(targetSSIM float64, maxQuality, width, height int) ([]byte, int, float64, error) {
+	reference, err := e.reference(width, height)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	return search.SSIM(e.encode, decode, reference, targetSSIM, maxQuality, width, height)
+}
+
+// reference decodes the source at the output dimensions, losslessly.
+func (e encoder) reference(width, height int) (image.Image, error) {
+	lossless := e
+	lossless.imageType, lossless.frames, lossless.retain = bimg.PNG, 0, false
+	lossless.jpeg, lossless.png, lossless.webp = domain.JPEGOptions{}, domain.PNGOptions{}, domain.WebPOptions{}
+
+	buf, err := lossless.encode(0, width, height)
+	if err != nil {
+		return nil, err
+	}
+
+	return decode(buf)
+}
+
+// report measures output against the source at the output dimensions.
+// Sizes and dimensions are left for the caller to fill.
+func (e encoder) report(output []byte, width, height int) (*domain.Report, error) {
+	reference, err := e.reference(width, height)
+	if err != nil {
+		return nil, err
+	}
+
+	decoded, err := decode(output)
+	if err != nil {
+		return nil, err
+	}
+
+	return search.Report(reference, decoded)
+}
+
+// decode turns an encoded buffer into pixels for metric computation. Formats
+// the standard library cannot read are converted to PNG through libvips first.
+func decode(buf []byte) (image.Image, error) {
+	switch bimg.DetermineImageType(buf) {
+	case bimg.JPEG, bimg.PNG:
+	default:
+		converted, err := bimg.NewImage(buf).Process(bimg.Options{Type: bimg.PNG, Compression: 1})
+		if err != nil {
+			return nil, fmt.Errorf("failed to convert image for decoding: %w", err)
+		}
+		buf = converted
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(buf))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image: %w", err)
+	}
+
+	return img, nil
+}
@@ -0,0 +1,55 @@
+//go:build cgo
+
+package bimg
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/andreychano/compressor-golang/internal/core/domain"
+	"github.com/andreychano/compressor-golang/internal/svg"
+	"github.com/h2non/bimg"
+)
+
+const (
+	svgMimeType = "image/svg+xml"
+	formatSVG   = "svg"
+)
+
+// sanitizeSVG removes scripts, external references and entities from an SVG
+// input so librsvg cannot run code, fetch remote resources or read local files.
+func sanitizeSVG(buf []byte) ([]byte, error) {
+	clean, err := svg.Sanitize(buf)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", domain.ErrInvalidImage, err)
+	}
+	return clean, nil
+}
+
+// minifySVG produces SVG output from an SVG input. Only lossless cleanup
+// applies, so quality and size targets are rejected.
+func minifySVG(buf []byte, encode domain.EncodeStep) (domain.ProcessedFile, error) {
+	if encode.TargetBytes > 0 || encode.TargetSSIM > 0 {
+		return domain.ProcessedFile{}, fmt.Errorf("%w: svg output has no quality to search", domain.ErrInvalidOptions)
+	}
+
+	minified, err := svg.Minify(buf)
+	if err != nil {
+		return domain.ProcessedFile{}, fmt.Errorf("%w: %v", domain.ErrInvalidImage, err)
+	}
+
+	// Dimensions are informational; documents without a size have none.
+	size, _ := bimg.Size(minified)
+
+	return domain.ProcessedFile{
+		File: domain.File{
+			Content:  bytes.NewReader(minified),
+			MimeType: svgMimeType,
+			Size:     int64(len(minified)),
+		},
+		Width:        size.Width,
+		Height:       size.Height,
+		SourceWidth:  size.Width,
+		SourceHeight: size.Height,
+	}, nil
+}
@@ -0,0 +1,43 @@
+//go:build cgo
+
+package bimg
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/andreychano/compressor-golang/internal/config"
+	"github.com/andreychano/compressor-golang/internal/core/domain"
+)
+
+func TestProcess_SVGCanvas(t *testing.T) {
+	p := NewProcessor(config.Color{})
+	if !p.Supports(svgMimeType) {
+		t.Skip("libvips has no SVG loader")
+	}
+
+	// A few bytes describing a 40000x40000 canvas.
+	huge := []byte(`<svg xmlns="http://www.w3.org/2000/svg" width="40000" height="40000" viewBox="0 0 1 1"><rect width="1" height="1"/></svg>`)
+	tests := []struct {
+		name    string
+		svg     domain.SVGOptions
+		wantErr error
+	}{
+		{"own size", domain.SVGOptions{}, domain.ErrInvalidOptions},
+		{"scaled down", domain.SVGOptions{Width: 100}, nil},
+		{"still too wide", domain.SVGOptions{Width: 16000}, domain.ErrInvalidOptions},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := p.Process(domain.File{
+				Content:  bytes.NewReader(huge),
+				MimeType: svgMimeType,
+				Size:     int64(len(huge)),
+			}, domain.Options{Format: "png", SVG: tt.svg})
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("got %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,749 @@
+#include <string.h>
+
+#include "vips.h"
+
+#define COMPRESSOR_VIPS_AT_LEAST(major, minor) \
+	(VIPS_MAJOR_VERSION > (major) || (VIPS_MAJOR_VERSION == (major) && VIPS_MINOR_VERSION >= (minor)))
+
+int compressor_avifsave(const void *buf, size_t len, void **out, size_t *out_len, CompressorAvifOptions o) {
+#if COMPRESSOR_VIPS_AT_LEAST(8, 13)
+	VipsImage *image = vips_image_new_from_buffer(buf, len, "", NULL);
+	if (image == NULL) {
+		return -1;
+	}
+
+	int err = vips_heifsave_buffer(image, out, out_len,
+		"compression", VIPS_FOREIGN_HEIF_COMPRESSION_AV1,
+		"Q", o.quality,
+		"lossless", o.lossless,
+		"effort", o.effort,
+		"subsample_mode", o.subsample,
+		"strip", TRUE,
+		NULL);
+
+	g_object_unref(image);
+	return err;
+#else
+	vips_error("compressor", "AVIF encoding needs libvips 8.13 or newer");
+	return -1;
+#endif
+}
+
+int compressor_pngsave(const void *buf, size_t len, void **out, size_t *out_len, CompressorPngOptions o) {
+	VipsImage *image = vips_image_new_from_buffer(buf, len, "", NULL);
+	if (image == NULL) {
+		return -1;
+	}
+
+#if COMPRESSOR_VIPS_AT_LEAST(8, 12)
+	int err = vips_pngsave_buffer(image, out, out_len,
+		"compression", o.compression,
+		"filter", o.filter,
+		"palette", o.palette,
+		"Q", o.quality,
+		"dither", o.dither,
+		"bitdepth", o.bitdepth,
+		"effort", 10,
+		NULL);
+#else
+	int err;
+	if (o.palette) {
+		vips_error("compressor", "PNG palettes need libvips 8.12 or newer");
+		err = -1;
+	} else {
+		err = vips_pngsave_buffer(image, out, out_len,
+			"compression", o.compression,
+			"filter", o.filter,
+			NULL);
+	}
+#endif
+
+	g_object_unref(image);
+	return err;
+}
+
+int compressor_jpegsave(const void *buf, size_t len, void **out, size_t *out_len, CompressorJpegOptions o) {
+	VipsImage *image = vips_image_new_from_buffer(buf, len, "", NULL);
+	if (image == NULL) {
+		return -1;
+	}
+
+	int err = vips_jpegsave_buffer(image, out, out_len,
+		"Q", o.quality,
+		"interlace", o.interlace,
+		"optimize_coding", TRUE,
+		"subsample_mode", o.subsample,
+		"trellis_quant", o.trellis,
+		NULL);
+
+	g_object_unref(image);
+	return err;
+}
+
+int compressor_webpsave(const void *buf, size_t len, void **out, size_t *out_len, CompressorWebpOptions o) {
+	VipsImage *image = vips_image_new_from_buffer(buf, len, "", NULL);
+	if (image == NULL) {
+		return -1;
+	}
+
+	int err = vips_webpsave_buffer(image, out, out_len,
+		"Q", o.quality,
+		"lossless", o.lossless,
+		"near_lossless", o.near_lossless,
+		"alpha_q", o.alpha_quality,
+		"smart_subsample", o.smart_subsample,
+#if COMPRESSOR_VIPS_AT_LEAST(8, 12)
+		"effort", o.effort,
+#else
+		"reduction_effort", o.effort,
+#endif
+		NULL);
+
+	g_object_unref(image);
+	return err;
+}
+
+int compressor_pages(const void *buf, size_t len) {
+	VipsImage *image = vips_image_new_from_buffer(buf, len, "", NULL);
+	if (image == NULL) {
+		return -1;
+	}
+	int pages = vips_image_get_n_pages(image);
+	g_object_unref(image);
+	return pages;
+}
+
+int compressor_animsave(const void *buf, size_t len, void **out, size_t *out_len, CompressorAnimOptions o) {
+	VipsImage *image = NULL;
+
+	if (o.width > 0 && o.height > 0) {
+		/* thumbnail resizes each page of a page-height stack separately and
+		 * updates page-height to match. */
+		char load_options[32];
+		g_snprintf(load_options, sizeof(load_options), "n=%d", o.frames);
+		if (vips_thumbnail_buffer((void *) buf, len, &image, o.width,
+			"height", o.height,
+			"size", VIPS_SIZE_FORCE,
+			"option_string", load_options,
+			NULL)) {
+			return -1;
+		}
+	} else {
+		image = vips_image_new_from_buffer(buf, len, "", "n", o.frames, NULL);
+		if (image == NULL) {
+			return -1;
+		}
+	}
+
+	int err;
+	if (o.format == COMPRESSOR_ANIM_GIF) {
+#if COMPRESSOR_VIPS_AT_LEAST(8, 12)
+		err = vips_gifsave_buffer(image, out, out_len, "strip", TRUE, NULL);
+#else
+		vips_error("compressor", "GIF encoding needs libvips 8.12 or newer");
+		err = -1;
+#endif
+	} else {
+		err = vips_webpsave_buffer(image, out, out_len,
+			"Q", o.quality,
+			"strip", TRUE,
+			NULL);
+	}
+
+	g_object_unref(image);
+	return err;
+}
+
+int compressor_has_operation(const char *name) {
+	return vips_type_find("VipsOperation", name) != 0;
+}
+
+const char *compressor_loader(const void *buf, size_t len) {
+	/* Returns the class name, e.g. VipsForeignLoadJpegBuffer. */
+	const char *name = vips_foreign_find_load_buffer(buf, len);
+	if (name == NULL) {
+		vips_error_clear();
+		return NULL;
+	}
+	return vips_nickname_find(g_type_from_name(name));
+}
+
+/* Reduces a high bit depth image to 8 bits per channel. Colourspace
+ * conversion rescales 16-bit and float data properly; a plain cast would clip
+ * everything above 255. */
+static int compressor_to_uchar(VipsImage *in, VipsImage **out) {
+	if (vips_colourspace_issupported(in)) {
+		VipsInterpretation target = vips_image_get_bands(in) < 3
+			? VIPS_INTERPRETATION_B_W
+			: VIPS_INTERPRETATION_sRGB;
+		if (vips_colourspace(in, out, target, NULL)) {
+			return -1;
+		}
+		if ((*out)->BandFmt == VIPS_FORMAT_UCHAR) {
+			return 0;
+		}
+		in = *out;
+		int err = vips_cast_uchar(in, out, "shift", TRUE, NULL);
+		g_object_unref(in);
+		return err;
+	}
+	return vips_cast_uchar(in, out, "shift", TRUE, NULL);
+}
+
+/* Converts CMYK to sRGB through the embedded profile, or the built-in CMYK
+ * profile when there is none. Adobe CMYK JPEGs store inverted ink values;
+ * jpegload undoes that before the image gets here. */
+static int compressor_cmyk_to_srgb(VipsImage *in, VipsImage **out) {
+	return vips_icc_transform(in, out, "srgb",
+		"input_profile", "cmyk",
+		"embedded", TRUE,
+		"intent", VIPS_INTENT_PERCEPTUAL,
+		NULL);
+}
+
+/* Loads an image buffer, applying the orientation tag when autorot is set.
+ * Returns NULL on error. */
+static VipsImage *compressor_load_upright(const void *buf, size_t len, int autorot) {
+	VipsImage *image = vips_image_new_from_buffer(buf, len, "", NULL);
+	if (image == NULL || !autorot || vips_image_get_orientation(image) <= 1) {
+		return image;
+	}
+
+	VipsImage *upright;
+	int err = vips_autorot(image, &upright, NULL);
+	g_object_unref(image);
+	return err ? NULL : upright;
+}
+
+/* Converts grey images to sRGB, so an sRGB colour can be composited. */
+static int compressor_to_rgb(VipsImage *in, VipsImage **out) {
+	if (in->Bands >= 3) {
+		*out = in;
+		g_object_ref(in);
+		return 0;
+	}
+	return vips_colourspace(in, out, VIPS_INTERPRETATION_sRGB, NULL);
+}
+
+int compressor_normalize(const void *buf, size_t len, int page, int force, int autorot, void **out, size_t *out_len) {
+	*out = NULL;
+	*out_len = 0;
+
+	VipsImage *image = vips_image_new_from_buffer(buf, len, "", NULL);
+	if (image == NULL) {
+		return -1;
+	}
+
+	if (page > 0) {
+		int pages = vips_image_get_n_pages(image);
+		g_object_unref(image);
+		if (page >= pages) {
+			vips_error("compressor", "page %d out of range, image has %d", page, pages);
+			return COMPRESSOR_ERROR_PAGE;
+		}
+
+		char load_options[32];
+		g_snprintf(load_options, sizeof(load_options), "page=%d", page);
+		image = vips_image_new_from_buffer(buf, len, load_options, NULL);
+		if (image == NULL) {
+			return -1;
+		}
+	}
+
+	int high_depth = image->BandFmt != VIPS_FORMAT_UCHAR;
+	int rotate = autorot && vips_image_get_orientation(image) > 1;
+	int cmyk = vips_image_guess_interpretation(image) == VIPS_INTERPRETATION_CMYK;
+	if (!force && page == 0 && !high_depth && !rotate && !cmyk) {
+		g_object_unref(image);
+		return 0;
+	}
+
+	if (rotate) {
+		VipsImage *upright;
+		if (vips_autorot(image, &upright, NULL)) {
+			g_object_unref(image);
+			return -1;
+		}
+		g_object_unref(image);
+		image = upright;
+	}
+
+	if (cmyk) {
+		VipsImage *rgb;
+		if (compressor_cmyk_to_srgb(image, &rgb)) {
+			g_object_unref(image);
+			return -1;
+		}
+		g_object_unref(image);
+		image = rgb;
+	}
+
+	if (image->BandFmt != VIPS_FORMAT_UCHAR) {
+		VipsImage *reduced;
+		if (compressor_to_uchar(image, &reduced)) {
+			g_object_unref(image);
+			return -1;
+		}
+		g_object_unref(image);
+		image = reduced;
+	}
+
+	/* Compression 1 trades size for speed: the buffer is only an intermediate. */
+	int err = vips_pngsave_buffer(image, out, out_len, "compression", 1, NULL);
+	g_object_unref(image);
+	return err;
+}
+
+int compressor_opaque(const void *buf, size_t len, int *opaque) {
+	*opaque = 1;
+
+	VipsImage *image = vips_image_new_from_buffer(buf, len, "", NULL);
+	if (image == NULL) {
+		return -1;
+	}
+	if (!vips_image_hasalpha(image)) {
+		g_object_unref(image);
+		return 0;
+	}
+
+	VipsImage *alpha;
+	if (vips_extract_band(image, &alpha, image->Bands - 1, NULL)) {
+		g_object_unref(image);
+		return -1;
+	}
+	double max_alpha = vips_interpretation_max_alpha(image->Type);
+	g_object_unref(image);
+
+	double min;
+	int err = vips_min(alpha, &min, NULL);
+	g_object_unref(alpha);
+	if (err) {
+		return -1;
+	}
+
+	*opaque = min >= max_alpha;
+	return 0;
+}
+
+int compressor_flatten(const void *buf, size_t len, int autorot, double r, double g, double b, void **out, size_t *out_len) {
+	VipsImage *image = compressor_load_upright(buf, len, autorot);
+	if (image == NULL) {
+		return -1;
+	}
+
+	if (vips_image_hasalpha(image)) {
+		/* The background has three bands, so grey images become sRGB. */
+		VipsImage *rgb;
+		if (compressor_to_rgb(image, &rgb)) {
+			g_object_unref(image);
+			return -1;
+		}
+		g_object_unref(image);
+		image = rgb;
+
+		VipsArrayDouble *background = vips_array_double_newv(3, r, g, b);
+		VipsImage *flat;
+		int err = vips_flatten(image, &flat, "background", background, NULL);
+		vips_area_unref(VIPS_AREA(background));
+		g_object_unref(image);
+		if (err) {
+			return -1;
+		}
+		image = flat;
+	}
+
+	int err = vips_pngsave_buffer(image, out, out_len, "compression", 1, NULL);
+	g_object_unref(image);
+	return err;
+}
+
+int compressor_crop(const void *buf, size_t len, CompressorCropOptions o, void **out, size_t *out_len) {
+	VipsImage *image = compressor_load_upright(buf, len, o.autorot);
+	if (image == NULL) {
+		return -1;
+	}
+
+	if (o.scaled_width != image->Xsize || o.scaled_height != image->Ysize) {
+		VipsImage *scaled;
+		if (vips_resize(image, &scaled, (double) o.scaled_width / image->Xsize,
+			"vscale", (double) o.scaled_height / image->Ysize,
+			NULL)) {
+			g_object_unref(image);
+			return -1;
+		}
+		g_object_unref(image);
+		image = scaled;
+	}
+
+	VipsImage *cropped;
+	int err;
+	if (o.interesting != VIPS_INTERESTING_NONE) {
+		err = vips_smartcrop(image, &cropped, o.width, o.height, "interesting", o.interesting, NULL);
+	} else {
+		err = vips_extract_area(image, &cropped, o.left, o.top, o.width, o.height, NULL);
+	}
+	g_object_unref(image);
+	if (err) {
+		return -1;
+	}
+
+	err = vips_pngsave_buffer(cropped, out, out_len, "compression", 1, NULL);
+	g_object_unref(cropped);
+	return err;
+}
+
+int compressor_composite(const void *buf, size_t len, int autorot, const void *overlay, size_t overlay_len, int left, int top, void **out, size_t *out_len) {
+	VipsImage *image = compressor_load_upright(buf, len, autorot);
+	if (image == NULL) {
+		return -1;
+	}
+	int alpha = vips_image_hasalpha(image);
+
+	/* The overlay is sRGB, so grey images become sRGB. */
+	VipsImage *rgb;
+	if (compressor_to_rgb(image, &rgb)) {
+		g_object_unref(image);
+		return -1;
+	}
+	g_object_unref(image);
+	image = rgb;
+
+	VipsImage *mark = vips_image_new_from_buffer(overlay, overlay_len, "", NULL);
+	if (mark == NULL) {
+		g_object_unref(image);
+		return -1;
+	}
+	VipsImage *composed;
+	int err = vips_composite2(image, mark, &composed, VIPS_BLEND_MODE_OVER, "x", left, "y", top, NULL);
+	g_object_unref(image);
+	g_object_unref(mark);
+	if (err) {
+		return -1;
+	}
+	image = composed;
+
+	/* Compositing always adds alpha; an opaque image stays opaque. */
+	if (!alpha) {
+		VipsImage *opaque;
+		err = vips_extract_band(image, &opaque, 0, "n", 3, NULL);
+		g_object_unref(image);
+		if (err) {
+			return -1;
+		}
+		image = opaque;
+	}
+
+	err = vips_pngsave_buffer(image, out, out_len, "compression", 1, NULL);
+	g_object_unref(image);
+	return err;
+}
+
+/* Returns a background for an image with at least three bands: the colour,
+ * plus opaque alpha when the image has an alpha channel. */
+static VipsArrayDouble *compressor_background(VipsImage *image, const double rgb[3]) {
+	double v[4] = {rgb[0], rgb[1], rgb[2], vips_interpretation_max_alpha(image->Type)};
+	return vips_array_double_new(v, image->Bands >= 4 ? 4 : 3);
+}
+
+/* Cuts off borders the colour of the top left pixel. */
+static int compressor_trim(VipsImage *in, VipsImage **out, double threshold) {
+	double *point;
+	int n;
+	if (vips_getpoint(in, &point, &n, 0, 0, NULL)) {
+		return -1;
+	}
+	VipsArrayDouble *background = vips_array_double_new(point, n);
+	g_free(point);
+
+	int left, top, width, height;
+	int err = vips_find_trim(in, &left, &top, &width, &height,
+		"threshold", threshold,
+		"background", background,
+		NULL);
+	vips_area_unref(VIPS_AREA(background));
+	if (err) {
+		return -1;
+	}
+
+	/* A uniform image has nothing to keep; leave it alone. */
+	if (width == 0 || height == 0) {
+		*out = in;
+		g_object_ref(in);
+		return 0;
+	}
+	return vips_extract_area(in, out, left, top, width, height, NULL);
+}
+
+/* Sharpens with an unsharp mask: in + amount * (in - blurred). */
+static int compressor_sharpen(VipsImage *in, VipsImage **out, double sigma, double amount) {
+	VipsImage *blurred;
+	if (vips_gaussblur(in, &blurred, sigma, NULL)) {
+		return -1;
+	}
+	VipsImage *boosted, *subtracted;
+	if (vips_linear1(in, &boosted, 1 + amount, 0, NULL)) {
+		g_object_unref(blurred);
+		return -1;
+	}
+	int err = vips_linear1(blurred, &subtracted, -amount, 0, NULL);
+	g_object_unref(blurred);
+	if (err) {
+		g_object_unref(boosted);
+		return -1;
+	}
+
+	VipsImage *sum;
+	err = vips_add(boosted, subtracted, &sum, NULL);
+	g_object_unref(boosted);
+	g_object_unref(subtracted);
+	if (err) {
+		return -1;
+	}
+	err = vips_cast_uchar(sum, out, NULL);
+	g_object_unref(sum);
+	return err;
+}
+
+/* Applies a recolor or gamma operation to the sRGB colour of an image,
+ * leaving its alpha channel alone. */
+static int compressor_colour(VipsImage *in, VipsImage **out, CompressorOp op) {
+	VipsImage *rgb;
+	if (compressor_to_rgb(in, &rgb)) {
+		return -1;
+	}
+
+	VipsImage *colour, *alpha = NULL;
+	int err = vips_extract_band(rgb, &colour, 0, "n", 3, NULL);
+	if (!err && rgb->Bands > 3) {
+		err = vips_extract_band(rgb, &alpha, 3, NULL);
+		if (err) {
+			g_object_unref(colour);
+		}
+	}
+	g_object_unref(rgb);
+	if (err) {
+		return -1;
+	}
+
+	VipsImage *mapped;
+	if (op.type == COMPRESSOR_OP_RECOLOR) {
+		VipsImage *matrix = vips_image_new_matrix_from_array(3, 3, op.matrix, 9);
+		VipsImage *recombined;
+		err = -1;
+		if (matrix != NULL) {
+			err = vips_recomb(colour, &recombined, matrix, NULL);
+			g_object_unref(matrix);
+		}
+		if (!err) {
+			/* Offsets and rounding back to 8 bits, clipped. */
+			double a[3] = {1, 1, 1};
+			err = vips_linear(recombined, &mapped, a, op.offset, 3, "uchar", TRUE, NULL);
+			g_object_unref(recombined);
+		}
+	} else {
+		err = vips_gamma(colour, &mapped, "exponent", op.gamma, NULL);
+	}
+	g_object_unref(colour);
+	if (err) {
+		if (alpha != NULL) {
+			g_object_unref(alpha);
+		}
+		return -1;
+	}
+
+	if (alpha == NULL) {
+		*out = mapped;
+		return 0;
+	}
+	err = vips_bandjoin2(mapped, alpha, out, NULL);
+	g_object_unref(mapped);
+	g_object_unref(alpha);
+	return err;
+}
+
+/* Sets the libvips error and returns COMPRESSOR_ERROR_CANVAS when a width x
+ * height image has more than max_pixels pixels. */
+static int compressor_check_canvas(double width, double height, double max_pixels) {
+	if (width * height > max_pixels) {
+		vips_error("compressor", "a %.0fx%.0f canvas exceeds %.0f pixels", width, height, max_pixels);
+		return COMPRESSOR_ERROR_CANVAS;
+	}
+	return 0;
+}
+
+/* Applies one operation. */
+static int compressor_apply(VipsImage *in, VipsImage **out, CompressorOp op, double max_pixels) {
+	switch (op.type) {
+	case COMPRESSOR_OP_ROTATE: {
+		double angle = op.angle;
+		if (angle == 0) {
+			*out = in;
+			g_object_ref(in);
+			return 0;
+		}
+		if (angle == 90 || angle == 180 || angle == 270) {
+			VipsAngle right = angle == 90 ? VIPS_ANGLE_D90 : angle == 180 ? VIPS_ANGLE_D180 : VIPS_ANGLE_D270;
+			return vips_rot(in, out, right, NULL);
+		}
+
+		VipsImage *rgb;
+		if (compressor_to_rgb(in, &rgb)) {
+			return -1;
+		}
+		VipsArrayDouble *background = compressor_background(rgb, op.background);
+		int err = vips_rotate(rgb, out, angle, "background", background, NULL);
+		vips_area_unref(VIPS_AREA(background));
+		g_object_unref(rgb);
+		return err;
+	}
+	case COMPRESSOR_OP_FLIP:
+		return vips_flip(in, out, VIPS_DIRECTION_VERTICAL, NULL);
+	case COMPRESSOR_OP_FLOP:
+		return vips_flip(in, out, VIPS_DIRECTION_HORIZONTAL, NULL);
+	case COMPRESSOR_OP_CROP:
+		if (op.left + op.width > in->Xsize || op.top + op.height > in->Ysize) {
+			vips_error("compressor", "crop %dx%d+%d+%d is outside the %dx%d image",
+				op.width, op.height, op.left, op.top, in->Xsize, in->Ysize);
+			return COMPRESSOR_ERROR_BOUNDS;
+		}
+		return vips_extract_area(in, out, op.left, op.top, op.width, op.height, NULL);
+	case COMPRESSOR_OP_TRIM:
+		return compressor_trim(in, out, op.threshold);
+	case COMPRESSOR_OP_PAD: {
+		/* In doubles: the padded size may not fit an int. */
+		double width = in->Xsize, height = in->Ysize;
+		if (width / height < op.ratio) {
+			width = VIPS_MAX(width, (double) (long long) (height * op.ratio + 0.5));
+		} else {
+			height = VIPS_MAX(height, (double) (long long) (width / op.ratio + 0.5));
+		}
+		int err = compressor_check_canvas(width, height, max_pixels);
+		if (err) {
+			return err;
+		}
+
+		VipsImage *rgb;
+		if (compressor_to_rgb(in, &rgb)) {
+			return -1;
+		}
+		VipsArrayDouble *background = compressor_background(rgb, op.background);
+		err = vips_gravity(rgb, out, VIPS_COMPASS_DIRECTION_CENTRE, (int) width, (int) height,
+			"extend", VIPS_EXTEND_BACKGROUND,
+			"background", background,
+			NULL);
+		vips_area_unref(VIPS_AREA(background));
+		g_object_unref(rgb);
+		return err;
+	}
+	case COMPRESSOR_OP_RESIZE: {
+		double scale = 1;
+		if (op.width > 0 && in->Xsize > op.width) {
+			scale = (double) op.width / in->Xsize;
+		}
+		if (op.height > 0 && in->Ysize > op.height && (double) op.height / in->Ysize < scale) {
+			scale = (double) op.height / in->Ysize;
+		}
+		if (scale == 1) {
+			*out = in;
+			g_object_ref(in);
+			return 0;
+		}
+		return vips_resize(in, out, scale, NULL);
+	}
+	case COMPRESSOR_OP_BLUR:
+		return vips_gaussblur(in, out, op.sigma, NULL);
+	case COMPRESSOR_OP_SHARPEN:
+		return compressor_sharpen(in, out, op.sigma, op.amount);
+	case COMPRESSOR_OP_RECOLOR:
+	case COMPRESSOR_OP_GAMMA:
+		return compressor_colour(in, out, op);
+	default:
+		vips_error("compressor", "unknown operation %d", op.type);
+		return -1;
+	}
+}
+
+int compressor_transform(const void *buf, size_t len, int autorot, const CompressorOp *ops, int n, double max_pixels, void **out, size_t *out_len) {
+	VipsImage *image = compressor_load_upright(buf, len, autorot);
+	if (image == NULL) {
+		return -1;
+	}
+
+	for (int i = 0; i < n; i++) {
+		VipsImage *next;
+		int err = compressor_apply(image, &next, ops[i], max_pixels);
+		g_object_unref(image);
+		if (err) {
+			return err;
+		}
+		image = next;
+		/* libvips builds the pipeline lazily, so this catches rotations
+		 * before any pixel of the canvas is computed. */
+		if ((err = compressor_check_canvas(image->Xsize, image->Ysize, max_pixels))) {
+			g_object_unref(image);
+			return err;
+		}
+	}
+
+	int err = vips_pngsave_buffer(image, out, out_len, "compression", 1, NULL);
+	g_object_unref(image);
+	return err;
+}
+
+int compressor_svgrender(const void *buf, size_t len, double dpi, int width, double max_pixels, void **out, size_t *out_len) {
+	VipsImage *image;
+	if (vips_svgload_buffer((void *) buf, len, &image, "dpi", dpi, NULL)) {
+		return -1;
+	}
+
+	/* Scale the vector source rather than resizing pixels, so large renders
+	 * stay sharp. */
+	if (width > 0 && image->Xsize != width) {
+		double scale = (double) width / image->Xsize;
+		g_object_unref(image);
+		if (vips_svgload_buffer((void *) buf, len, &image, "dpi", dpi, "scale", scale, NULL)) {
+			return -1;
+		}
+	}
+
+	/* Loading only reads the size; pixels are rendered by pngsave. */
+	int err = compressor_check_canvas(image->Xsize, image->Ysize, max_pixels);
+	if (err) {
+		g_object_unref(image);
+		return err;
+	}
+
+	err = vips_pngsave_buffer(image, out, out_len, "compression", 1, NULL);
+	g_object_unref(image);
+	return err;
+}
+
+/* Returns a copy of a blob field, NULL when the image has none. */
+static void *compressor_copy_blob(VipsImage *image, const char *name, size_t *len) {
+	const void *data;
+	*len = 0;
+	if (!vips_image_get_typeof(image, name) || vips_image_get_blob(image, name, &data, len)) {
+		*len = 0;
+		return NULL;
+	}
+	void *copy = g_malloc(*len);
+	memcpy(copy, data, *len);
+	return copy;
+}
+
+int compressor_metadata(const void *buf, size_t len, CompressorMetadata *m) {
+	memset(m, 0, sizeof(*m));
+
+	VipsImage *image = vips_image_new_from_buffer(buf, len, "", NULL);
+	if (image == NULL) {
+		return -1;
+	}
+
+	m->exif = compressor_copy_blob(image, VIPS_META_EXIF_NAME, &m->exif_len);
+	m->xmp = compressor_copy_blob(image, VIPS_META_XMP_NAME, &m->xmp_len);
+	m->iptc = compressor_copy_blob(image, VIPS_META_IPTC_NAME, &m->iptc_len);
+	m->icc = vips_image_get_typeof(image, VIPS_META_ICC_NAME) != 0;
+
+	g_object_unref(image);
+	return 0;
+}
@@ -0,0 +1,618 @@
+//go:build cgo
+
+package bimg
+
+/*
+#cgo pkg-config: vips
+#include "vips.h"
+*/
+import "C"
+
+import (
+	"bytes"
+	"cmp"
+	"errors"
+	"fmt"
+	"image/png"
+	"math/bits"
+	"strings"
+	"unsafe"
+
+	"github.com/andreychano/compressor-golang/internal/adapter/outbound/processor/overlay"
+	"github.com/andreychano/compressor-golang/internal/core/domain"
+	"github.com/andreychano/compressor-golang/internal/metadata"
+	"github.com/h2non/bimg"
+)
+
+// defaultAVIFEffort matches the libvips default.
+const defaultAVIFEffort = 4
+
+// avifSave encodes buf, in any format libvips can load, as AVIF. bimg has no
+// knobs for effort or chroma subsampling, so this goes to libvips directly.
+func avifSave(buf []byte, quality int, o domain.AVIFOptions) ([]byte, error) {
+	if len(buf) == 0 {
+		return nil, errors.New("image buffer is empty")
+	}
+	defer C.vips_thread_shutdown()
+
+	effort := o.Effort
+	if effort == 0 {
+		effort = defaultAVIFEffort
+	}
+
+	var (
+		out    unsafe.Pointer
+		outLen C.size_t
+	)
+	opts := C.CompressorAvifOptions{
+		quality:   C.int(quality),
+		effort:    C.int(effort),
+		lossless:  C.int(boolToInt(o.Lossless)),
+		subsample: subsampleMode(o.Subsample),
+	}
+	if C.compressor_avifsave(unsafe.Pointer(&buf[0]), C.size_t(len(buf)), &out, &outLen, opts) != 0 {
+		return nil, vipsError()
+	}
+	defer C.g_free(C.gpointer(out))
+
+	return C.GoBytes(out, C.int(outLen)), nil
+}
+
+// subsampleMode maps a chroma subsampling option to a VipsForeignSubsample.
+func subsampleMode(subsample string) C.int {
+	switch subsample {
+	case "420":
+		return C.VIPS_FOREIGN_SUBSAMPLE_ON
+	case "444":
+		return C.VIPS_FOREIGN_SUBSAMPLE_OFF
+	default:
+		return C.VIPS_FOREIGN_SUBSAMPLE_AUTO
+	}
+}
+
+// jpegSave encodes buf, in any format libvips can load, as JPEG. bimg only
+// passes quality and interlacing, so chroma subsampling and trellis
+// quantisation go to libvips directly.
+func jpegSave(buf []byte, quality int, o domain.JPEGOptions) ([]byte, error) {
+	if len(buf) == 0 {
+		return nil, errors.New("image buffer is empty")
+	}
+	defer C.vips_thread_shutdown()
+
+	opts := C.CompressorJpegOptions{
+		quality:   C.int(cmp.Or(quality, defaultQuality)),
+		interlace: C.int(boolToInt(o.Progressive)),
+		subsample: subsampleMode(o.Subsample),
+		trellis:   C.int(boolToInt(o.Trellis)),
+	}
+
+	var (
+		out    unsafe.Pointer
+		outLen C.size_t
+	)
+	if C.compressor_jpegsave(unsafe.Pointer(&buf[0]), C.size_t(len(buf)), &out, &outLen, opts) != 0 {
+		return nil, vipsError()
+	}
+	defer C.g_free(C.gpointer(out))
+
+	return C.GoBytes(out, C.int(outLen)), nil
+}
+
+// defaultWebPMethod matches the libvips default.
+const defaultWebPMethod = 4
+
+// webpSave encodes buf, in any format libvips can load, as still WebP. bimg
+// only passes quality and lossless mode.
+func webpSave(buf []byte, quality int, o domain.WebPOptions) ([]byte, error) {
+	if len(buf) == 0 {
+		return nil, errors.New("image buffer is empty")
+	}
+	defer C.vips_thread_shutdown()
+
+	method := defaultWebPMethod
+	if o.Method != nil {
+		method = *o.Method
+	}
+	opts := C.CompressorWebpOptions{
+		quality:         C.int(cmp.Or(quality, defaultQuality)),
+		effort:          C.int(method),
+		lossless:        C.int(boolToInt(o.Lossless)),
+		near_lossless:   C.int(boolToInt(o.NearLossless)),
+		alpha_quality:   C.int(cmp.Or(o.AlphaQuality, 100)),
+		smart_subsample: C.int(boolToInt(o.SmartSubsample)),
+	}
+
+	var (
+		out    unsafe.Pointer
+		outLen C.size_t
+	)
+	if C.compressor_webpsave(unsafe.Pointer(&buf[0]), C.size_t(len(buf)), &out, &outLen, opts) != 0 {
+		return nil, vipsError()
+	}
+	defer C.g_free(C.gpointer(out))
+
+	return C.GoBytes(out, C.int(outLen)), nil
+}
+
+// defaultPNGCompression matches the bimg default.
+const defaultPNGCompression = 6
+
+// pngSave encodes buf, in any format libvips can load, as PNG. bimg always
+// writes 8-bit truecolour with every row filter, so palettes, bit depths and
+// dithering go to libvips directly. quality is the palette quality.
+func pngSave(buf []byte, quality int, o domain.PNGOptions) ([]byte, error) {
+	if len(buf) == 0 {
+		return nil, errors.New("image buffer is empty")
+	}
+	defer C.vips_thread_shutdown()
+
+	opts := C.CompressorPngOptions{
+		compression: C.int(cmp.Or(o.Compression, defaultPNGCompression)),
+		filter:      C.VIPS_FOREIGN_PNG_FILTER_ALL,
+		palette:     C.int(boolToInt(o.Quantised())),
+		quality:     C.int(cmp.Or(quality, 100)),
+		dither:      1,
+		bitdepth:    C.int(pngBitDepth(o)),
+	}
+	if o.Filter == "none" {
+		opts.filter = C.VIPS_FOREIGN_PNG_FILTER_NONE
+	}
+	if o.Dither != nil {
+		opts.dither = C.double(*o.Dither)
+	}
+
+	var (
+		out    unsafe.Pointer
+		outLen C.size_t
+	)
+	if C.compressor_pngsave(unsafe.Pointer(&buf[0]), C.size_t(len(buf)), &out, &outLen, opts) != 0 {
+		return nil, vipsError()
+	}
+	defer C.g_free(C.gpointer(out))
+
+	return C.GoBytes(out, C.int(outLen)), nil
+}
+
+// pngBitDepth returns the bits per sample to write: the smallest depth that
+// holds MaxColors palette entries, capped by BitDepth.
+func pngBitDepth(o domain.PNGOptions) int {
+	depth := 8
+	if o.MaxColors > 0 {
+		depth = max(1, bits.Len(uint(o.MaxColors-1)))
+	}
+	if o.BitDepth > 0 {
+		depth = min(depth, o.BitDepth)
+	}
+	return depth
+}
+
+// defaultQuality matches the bimg default.
+const defaultQuality = 75
+
+// vipsPages returns the number of frames of an image, 1 for still images.
+func vipsPages(buf []byte) (int, error) {
+	if len(buf) == 0 {
+		return 0, errors.New("image buffer is empty")
+	}
+	defer C.vips_thread_shutdown()
+
+	pages := C.compressor_pages(unsafe.Pointer(&buf[0]), C.size_t(len(buf)))
+	if pages < 0 {
+		return 0, vipsError()
+	}
+	return int(pages), nil
+}
+
+// animSave re-encodes an animated GIF or WebP as imageType (GIF or WebP),
+// keeping frame timing and loop count. frames is the number of frames to keep,
+// at most as many as the input has, -1 for all; zero width and height keep the frame size. bimg only ever loads
+// the first frame, so this goes to libvips directly.
+func animSave(buf []byte, frames, width, height int, imageType bimg.ImageType, quality int) ([]byte, error) {
+	if len(buf) == 0 {
+		return nil, errors.New("image buffer is empty")
+	}
+	defer C.vips_thread_shutdown()
+
+	format := C.COMPRESSOR_ANIM_WEBP
+	if imageType == bimg.GIF {
+		format = C.COMPRESSOR_ANIM_GIF
+	}
+	if quality == 0 {
+		quality = defaultQuality
+	}
+
+	var (
+		out    unsafe.Pointer
+		outLen C.size_t
+	)
+	opts := C.CompressorAnimOptions{
+		frames:  C.int(frames),
+		width:   C.int(width),
+		height:  C.int(height),
+		format:  C.int(format),
+		quality: C.int(quality),
+	}
+	if C.compressor_animsave(unsafe.Pointer(&buf[0]), C.size_t(len(buf)), &out, &outLen, opts) != 0 {
+		return nil, vipsError()
+	}
+	defer C.g_free(C.gpointer(out))
+
+	return C.GoBytes(out, C.int(outLen)), nil
+}
+
+// errPageOutOfRange is returned by normalize for a page past the last one.
+var errPageOutOfRange = errors.New("page out of range")
+
+// normalize turns an input into something bimg processes correctly: the given
+// page of multi-page images, 8 bits per channel, upright when autorot is set,
+// and a format bimg can load. It returns nil when buf can be used as is;
+// force always converts.
+func normalize(buf []byte, page int, force, autorot bool) ([]byte, error) {
+	if len(buf) == 0 {
+		return nil, errors.New("image buffer is empty")
+	}
+	defer C.vips_thread_shutdown()
+
+	var (
+		out    unsafe.Pointer
+		outLen C.size_t
+	)
+	switch C.compressor_normalize(unsafe.Pointer(&buf[0]), C.size_t(len(buf)), C.int(page), C.int(boolToInt(force)), C.int(boolToInt(autorot)), &out, &outLen) {
+	case 0:
+	case C.COMPRESSOR_ERROR_PAGE:
+		C.vips_error_clear()
+		return nil, errPageOutOfRange
+	default:
+		return nil, vipsError()
+	}
+	if out == nil {
+		return nil, nil
+	}
+	defer C.g_free(C.gpointer(out))
+
+	return C.GoBytes(out, C.int(outLen)), nil
+}
+
+// opaque reports whether every pixel of buf is fully opaque.
+func opaque(buf []byte) (bool, error) {
+	if len(buf) == 0 {
+		return false, errors.New("image buffer is empty")
+	}
+	defer C.vips_thread_shutdown()
+
+	var o C.int
+	if C.compressor_opaque(unsafe.Pointer(&buf[0]), C.size_t(len(buf)), &o) != 0 {
+		return false, vipsError()
+	}
+	return o != 0, nil
+}
+
+// flatten composites buf onto background and returns it as PNG without an
+// alpha channel, upright when autorot is set. bimg cannot flatten onto black,
+// which it treats as no background.
+func flatten(buf []byte, background domain.Color, autorot bool) ([]byte, error) {
+	if len(buf) == 0 {
+		return nil, errors.New("image buffer is empty")
+	}
+	defer C.vips_thread_shutdown()
+
+	var (
+		out    unsafe.Pointer
+		outLen C.size_t
+	)
+	if C.compressor_flatten(unsafe.Pointer(&buf[0]), C.size_t(len(buf)), C.int(boolToInt(autorot)),
+		C.double(background.R), C.double(background.G), C.double(background.B), &out, &outLen) != 0 {
+		return nil, vipsError()
+	}
+	defer C.g_free(C.gpointer(out))
+
+	return C.GoBytes(out, C.int(outLen)), nil
+}
+
+// crop scales buf, upright when autorot is set, to cover the crop box of o
+// and cuts it out, returning a PNG. width and height are the upright size.
+// bimg has no corner gravities, focal points or entropy crops.
+func crop(buf []byte, width, height int, o domain.CropOptions, autorot bool) ([]byte, error) {
+	if len(buf) == 0 {
+		return nil, errors.New("image buffer is empty")
+	}
+	defer C.vips_thread_shutdown()
+
+	scaledW, scaledH, cropW, cropH := o.Window(width, height)
+	left, top := o.Offset(scaledW, scaledH, cropW, cropH)
+	opts := C.CompressorCropOptions{
+		autorot:       C.int(boolToInt(autorot)),
+		scaled_width:  C.int(scaledW),
+		scaled_height: C.int(scaledH),
+		left:          C.int(left),
+		top:           C.int(top),
+		width:         C.int(cropW),
+		height:        C.int(cropH),
+		interesting:   C.VIPS_INTERESTING_NONE,
+	}
+	if o.Smart() {
+		opts.interesting = C.VIPS_INTERESTING_ATTENTION
+		if o.Strategy == domain.StrategyEntropy {
+			opts.interesting = C.VIPS_INTERESTING_ENTROPY
+		}
+	}
+
+	var (
+		out    unsafe.Pointer
+		outLen C.size_t
+	)
+	if C.compressor_crop(unsafe.Pointer(&buf[0]), C.size_t(len(buf)), opts, &out, &outLen) != 0 {
+		return nil, vipsError()
+	}
+	defer C.g_free(C.gpointer(out))
+
+	return C.GoBytes(out, C.int(outLen)), nil
+}
+
+// watermark draws the watermark of step over buf, upright when autorot is
+// set, and returns a PNG. size is the upright size. The watermark is rendered
+// in Go, as libvips text needs fontconfig and cannot tile.
+func watermark(buf []byte, size bimg.ImageSize, step domain.WatermarkStep, autorot bool) ([]byte, error) {
+	if len(buf) == 0 {
+		return nil, errors.New("image buffer is empty")
+	}
+	mark, at, err := overlay.Render(step, size.Width, size.Height)
+	if err != nil {
+		return nil, err
+	}
+	var encoded bytes.Buffer
+	if err := (&png.Encoder{CompressionLevel: png.BestSpeed}).Encode(&encoded, mark); err != nil {
+		return nil, err
+	}
+	layer := encoded.Bytes()
+	defer C.vips_thread_shutdown()
+
+	var (
+		out    unsafe.Pointer
+		outLen C.size_t
+	)
+	if C.compressor_composite(unsafe.Pointer(&buf[0]), C.size_t(len(buf)), C.int(boolToInt(autorot)),
+		unsafe.Pointer(&layer[0]), C.size_t(len(layer)), C.int(at.X), C.int(at.Y), &out, &outLen) != 0 {
+		return nil, vipsError()
+	}
+	defer C.g_free(C.gpointer(out))
+
+	return C.GoBytes(out, C.int(outLen)), nil
+}
+
+// Errors transform and svgRender return for operations that do not fit the
+// image.
+var (
+	errOutOfBounds = errors.New("crop outside the image")
+	errCanvas      = errors.New("canvas too large")
+)
+
+// defaultTrimThreshold matches the libvips default.
+const defaultTrimThreshold = 10
+
+// opTypes maps operations to their C counterparts.
+var opTypes = map[domain.OperationType]C.int{
+	domain.OpRotate: C.COMPRESSOR_OP_ROTATE,
+	domain.OpFlip:   C.COMPRESSOR_OP_FLIP,
+	domain.OpFlop:   C.COMPRESSOR_OP_FLOP,
+	domain.OpCrop:   C.COMPRESSOR_OP_CROP,
+	domain.OpTrim:   C.COMPRESSOR_OP_TRIM,
+	domain.OpPad:    C.COMPRESSOR_OP_PAD,
+}
+
+// transform runs the pipeline steps before encoding on buf, whose upright
+// size is size, upright unless opts.NoAutoRotate, and returns a PNG and its
+// size. Operations, inside resizes and filters run in libvips in batches; cover
+// resizes and watermarks need the size the batch before them produced. bimg applies its few
+// transforms in a fixed order and has no arbitrary rotation, trim threshold
+// or padding to a ratio.
+func transform(buf []byte, size bimg.ImageSize, steps domain.Pipeline, opts domain.Options) ([]byte, bimg.ImageSize, error) {
+	autorot := !opts.NoAutoRotate
+	var batch []C.CompressorOp
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		out, err := runOps(buf, batch, autorot)
+		if err != nil {
+			return err
+		}
+		buf, batch, autorot = out, nil, false
+		size, err = bimg.Size(buf)
+		return err
+	}
+
+	for _, step := range steps {
+		switch s := step.(type) {
+		case domain.Operation:
+			background, err := s.BackgroundColor(opts)
+			if err != nil {
+				return nil, size, err
+			}
+			batch = append(batch, C.CompressorOp{
+				_type:      opTypes[s.Type],
+				angle:      C.double(s.NormalizedAngle()),
+				left:       C.int(s.Left),
+				top:        C.int(s.Top),
+				width:      C.int(s.Width),
+				height:     C.int(s.Height),
+				threshold:  C.double(cmp.Or(s.Threshold, defaultTrimThreshold)),
+				ratio:      C.double(s.Ratio),
+				background: [3]C.double{C.double(background.R), C.double(background.G), C.double(background.B)},
+			})
+		case domain.ResizeStep:
+			o, ok := s.Cover()
+			if !ok {
+				batch = append(batch, C.CompressorOp{_type: C.COMPRESSOR_OP_RESIZE, width: C.int(s.Width), height: C.int(s.Height)})
+				continue
+			}
+			if err := flush(); err != nil {
+				return nil, size, err
+			}
+			cropped, err := crop(buf, size.Width, size.Height, o, autorot)
+			if err != nil {
+				return nil, size, fmt.Errorf("failed to crop image: %w", err)
+			}
+			buf, autorot = cropped, false
+			_, _, size.Width, size.Height = o.Window(size.Width, size.Height)
+		case domain.Filter:
+			batch = append(batch, filterOp(s))
+		case domain.WatermarkStep:
+			if err := flush(); err != nil {
+				return nil, size, err
+			}
+			marked, err := watermark(buf, size, s, autorot)
+			if err != nil {
+				return nil, size, fmt.Errorf("failed to watermark image: %w", err)
+			}
+			buf, autorot = marked, false
+		default:
+			return nil, size, fmt.Errorf("%w: unsupported step %q", domain.ErrInvalidOptions, step.Kind())
+		}
+	}
+	if err := flush(); err != nil {
+		return nil, size, err
+	}
+	return buf, size, nil
+}
+
+// filterOp maps a filter to its C operation.
+func filterOp(f domain.Filter) C.CompressorOp {
+	if matrix, offset, ok := f.Recolor(); ok {
+		op := C.CompressorOp{_type: C.COMPRESSOR_OP_RECOLOR}
+		for i := range matrix {
+			for j := range matrix[i] {
+				op.matrix[i*3+j] = C.double(matrix[i][j])
+			}
+			op.offset[i] = C.double(offset[i])
+		}
+		return op
+	}
+	switch f.Type {
+	case domain.OpBlur:
+		return C.CompressorOp{_type: C.COMPRESSOR_OP_BLUR, sigma: C.double(f.Sigma)}
+	case domain.OpSharpen:
+		return C.CompressorOp{_type: C.COMPRESSOR_OP_SHARPEN, sigma: C.double(f.SharpenSigma()), amount: C.double(f.SharpenAmount())}
+	default:
+		return C.CompressorOp{_type: C.COMPRESSOR_OP_GAMMA, gamma: C.double(f.Gamma)}
+	}
+}
+
+// runOps applies ops to buf in order, upright when autorot is set, and
+// returns a PNG.
+func runOps(buf []byte, ops []C.CompressorOp, autorot bool) ([]byte, error) {
+	if len(buf) == 0 {
+		return nil, errors.New("image buffer is empty")
+	}
+	defer C.vips_thread_shutdown()
+
+	var (
+		out    unsafe.Pointer
+		outLen C.size_t
+	)
+	switch C.compressor_transform(unsafe.Pointer(&buf[0]), C.size_t(len(buf)), C.int(boolToInt(autorot)), &ops[0], C.int(len(ops)), C.double(domain.MaxCanvasPixels), &out, &outLen) {
+	case 0:
+	case C.COMPRESSOR_ERROR_BOUNDS:
+		err := vipsError()
+		return nil, fmt.Errorf("%w: %v", errOutOfBounds, err)
+	case C.COMPRESSOR_ERROR_CANVAS:
+		err := vipsError()
+		return nil, fmt.Errorf("%w: %v", errCanvas, err)
+	default:
+		return nil, vipsError()
+	}
+	defer C.g_free(C.gpointer(out))
+
+	return C.GoBytes(out, C.int(outLen)), nil
+}
+
+// defaultSVGDPI matches the libvips default.
+const defaultSVGDPI = 72
+
+// svgRender rasterizes an SVG document to PNG at dpi, scaled to width pixels
+// when width is positive. bimg can neither set the density nor scale the
+// vector source. Renders over domain.MaxCanvasPixels return errCanvas.
+func svgRender(buf []byte, dpi float64, width int) ([]byte, error) {
+	if len(buf) == 0 {
+		return nil, errors.New("image buffer is empty")
+	}
+	defer C.vips_thread_shutdown()
+
+	if dpi == 0 {
+		dpi = defaultSVGDPI
+	}
+
+	var (
+		out    unsafe.Pointer
+		outLen C.size_t
+	)
+	switch C.compressor_svgrender(unsafe.Pointer(&buf[0]), C.size_t(len(buf)), C.double(dpi), C.int(width), C.double(domain.MaxCanvasPixels), &out, &outLen) {
+	case 0:
+	case C.COMPRESSOR_ERROR_CANVAS:
+		err := vipsError()
+		return nil, fmt.Errorf("%w: %v", errCanvas, err)
+	default:
+		return nil, vipsError()
+	}
+	defer C.g_free(C.gpointer(out))
+
+	return C.GoBytes(out, C.int(outLen)), nil
+}
+
+// readMetadata returns the metadata blocks of buf, in any format libvips can
+// load. bimg only exposes parsed EXIF fields, not the blocks themselves.
+func readMetadata(buf []byte) (metadata.Blobs, error) {
+	if len(buf) == 0 {
+		return metadata.Blobs{}, errors.New("image buffer is empty")
+	}
+	defer C.vips_thread_shutdown()
+
+	var m C.CompressorMetadata
+	if C.compressor_metadata(unsafe.Pointer(&buf[0]), C.size_t(len(buf)), &m) != 0 {
+		return metadata.Blobs{}, vipsError()
+	}
+	defer C.g_free(C.gpointer(m.exif))
+	defer C.g_free(C.gpointer(m.xmp))
+	defer C.g_free(C.gpointer(m.iptc))
+
+	return metadata.Blobs{
+		EXIF: C.GoBytes(m.exif, C.int(m.exif_len)),
+		XMP:  C.GoBytes(m.xmp, C.int(m.xmp_len)),
+		IPTC: C.GoBytes(m.iptc, C.int(m.iptc_len)),
+		ICC:  m.icc != 0,
+	}, nil
+}
+
+// vipsHasOperation reports whether libvips has the operation, e.g. a loader.
+func vipsHasOperation(name string) bool {
+	cName := C.CString(name)
+	defer C.free(unsafe.Pointer(cName))
+
+	return C.compressor_has_operation(cName) != 0
+}
+
+// vipsLoader returns the nickname of the libvips loader for buf, e.g.
+// "jpegload_buffer", or "" when no loader recognises it.
+func vipsLoader(buf []byte) string {
+	if len(buf) == 0 {
+		return ""
+	}
+	return C.GoString(C.compressor_loader(unsafe.Pointer(&buf[0]), C.size_t(len(buf))))
+}
+
+// vipsError takes the pending libvips error message.
+func vipsError() error {
+	msg := strings.TrimSpace(C.GoString(C.vips_error_buffer()))
+	C.vips_error_clear()
+	if msg == "" {
+		msg = "unknown libvips error"
+	}
+	return errors.New(msg)
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
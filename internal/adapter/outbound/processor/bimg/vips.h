@@ -0,0 +1,210 @@
+#include <stdlib.h>
+#include <vips/vips.h>
+
+/* Settings for compressor_avifsave. subsample is a VipsForeignSubsample. */
+typedef struct {
+	int quality;
+	int effort;
+	int lossless;
+	int subsample;
+} CompressorAvifOptions;
+
+/* Encodes an image buffer libvips can load as AVIF. Returns non-zero on error;
+ * the caller frees *out with g_free. */
+int compressor_avifsave(const void *buf, size_t len, void **out, size_t *out_len, CompressorAvifOptions o);
+
+/* Settings for compressor_pngsave. filter is a VipsForeignPngFilter; quality
+ * and dither only apply with palette, bitdepth sets the palette size. */
+typedef struct {
+	int compression;
+	int filter;
+	int palette;
+	int quality;
+	double dither;
+	int bitdepth;
+} CompressorPngOptions;
+
+/* Encodes an image buffer libvips can load as PNG, keeping its metadata.
+ * Returns non-zero on error; the caller frees *out with g_free. */
+int compressor_pngsave(const void *buf, size_t len, void **out, size_t *out_len, CompressorPngOptions o);
+
+/* Settings for compressor_jpegsave. subsample is a VipsForeignSubsample;
+ * trellis needs libvips built with mozjpeg. */
+typedef struct {
+	int quality;
+	int interlace;
+	int subsample;
+	int trellis;
+} CompressorJpegOptions;
+
+/* Encodes an image buffer libvips can load as JPEG, keeping its metadata.
+ * Returns non-zero on error; the caller frees *out with g_free. */
+int compressor_jpegsave(const void *buf, size_t len, void **out, size_t *out_len, CompressorJpegOptions o);
+
+/* Settings for compressor_webpsave. effort is the WebP method 0..6. */
+typedef struct {
+	int quality;
+	int effort;
+	int lossless;
+	int near_lossless;
+	int alpha_quality;
+	int smart_subsample;
+} CompressorWebpOptions;
+
+/* Encodes an image buffer libvips can load as still WebP, keeping its
+ * metadata. Returns non-zero on error; the caller frees *out with g_free. */
+int compressor_webpsave(const void *buf, size_t len, void **out, size_t *out_len, CompressorWebpOptions o);
+
+/* Returns the number of pages or frames of an image buffer, 1 for still
+ * images, or -1 on error. */
+int compressor_pages(const void *buf, size_t len);
+
+/* Output formats compressor_animsave can write. */
+typedef enum {
+	COMPRESSOR_ANIM_WEBP,
+	COMPRESSOR_ANIM_GIF
+} CompressorAnimFormat;
+
+/* Settings for compressor_animsave. frames is -1 for all frames; zero width
+ * and height keep the frame size. */
+typedef struct {
+	int frames;
+	int width;
+	int height;
+	int format;
+	int quality;
+} CompressorAnimOptions;
+
+/* Re-encodes a multi-frame image buffer, resizing every frame and keeping
+ * frame delays and loop count. Returns non-zero on error; the caller frees
+ * *out with g_free. */
+int compressor_animsave(const void *buf, size_t len, void **out, size_t *out_len, CompressorAnimOptions o);
+
+/* Returns non-zero when libvips has an operation with the given nickname,
+ * e.g. "tiffload_buffer". */
+int compressor_has_operation(const char *name);
+
+/* Returns the nickname of the loader libvips picks for an image buffer, e.g.
+ * "jpegload_buffer", or NULL when none recognises it. */
+const char *compressor_loader(const void *buf, size_t len);
+
+/* Returned by compressor_normalize when page is past the last page. */
+#define COMPRESSOR_ERROR_PAGE -2
+
+/* Loads page of an image buffer and re-encodes it as 8-bit lossless PNG,
+ * reducing 16-bit and float images to 8 bits per channel, converting CMYK to
+ * sRGB and, when autorot is set, applying the orientation tag. PNG keeps no
+ * orientation of formats such as TIFF, so it has to be applied here. When
+ * force is zero and the input already is an upright first-page 8-bit RGB or
+ * grey image, *out is left NULL. Returns
+ * non-zero on error; the caller frees *out with g_free. */
+int compressor_normalize(const void *buf, size_t len, int page, int force, int autorot, void **out, size_t *out_len);
+
+/* Sets *opaque to whether every pixel of an image buffer is fully opaque,
+ * which is the case when it has no alpha channel. Returns non-zero on
+ * error. */
+int compressor_opaque(const void *buf, size_t len, int *opaque);
+
+/* Composites an image buffer onto an sRGB background colour, applying the
+ * orientation tag first when autorot is set, and encodes it as lossless PNG
+ * without an alpha channel. Returns non-zero on error; the caller frees *out
+ * with g_free. */
+int compressor_flatten(const void *buf, size_t len, int autorot, double r, double g, double b, void **out, size_t *out_len);
+
+/* Settings for compressor_crop. The image is scaled to scaled_width x
+ * scaled_height, then cut to width x height at left, top or, when interesting
+ * is a VipsInteresting other than NONE, where libvips finds most interest. */
+typedef struct {
+	int autorot;
+	int scaled_width;
+	int scaled_height;
+	int left;
+	int top;
+	int width;
+	int height;
+	int interesting;
+} CompressorCropOptions;
+
+/* Scales and crops an image buffer, applying the orientation tag first when
+ * autorot is set, and encodes it as lossless PNG. Returns non-zero on error;
+ * the caller frees *out with g_free. */
+int compressor_crop(const void *buf, size_t len, CompressorCropOptions o, void **out, size_t *out_len);
+
+/* Composites an overlay buffer over an image buffer with its top left corner
+ * at left, top, applying the orientation tag first when autorot is set, and
+ * encodes it as lossless PNG. Grey images become sRGB; an image without an
+ * alpha channel stays without one. Returns non-zero on error; the caller
+ * frees *out with g_free. */
+int compressor_composite(const void *buf, size_t len, int autorot, const void *overlay, size_t overlay_len, int left, int top, void **out, size_t *out_len);
+
+/* Returned by compressor_transform when a crop falls outside the image. */
+#define COMPRESSOR_ERROR_BOUNDS -3
+
+/* Returned by compressor_transform when an operation would make an image of
+ * more than max_pixels pixels. */
+#define COMPRESSOR_ERROR_CANVAS -4
+
+/* Operations compressor_transform applies. */
+typedef enum {
+	COMPRESSOR_OP_ROTATE,
+	COMPRESSOR_OP_FLIP,
+	COMPRESSOR_OP_FLOP,
+	COMPRESSOR_OP_CROP,
+	COMPRESSOR_OP_TRIM,
+	COMPRESSOR_OP_PAD,
+	COMPRESSOR_OP_RESIZE,
+	COMPRESSOR_OP_BLUR,
+	COMPRESSOR_OP_SHARPEN,
+	COMPRESSOR_OP_RECOLOR,
+	COMPRESSOR_OP_GAMMA
+} CompressorOpType;
+
+/* One operation. angle is in degrees clockwise from 0 up to 360, ratio the width to height
+ * ratio pads extend to, background the sRGB colour of new pixels. Resizes shrink the image
+ * to fit within width x height, 0 for no limit. Blurs and unsharp masks use sigma, the
+ * mask adds amount times the difference from the blur. Recolors map the sRGB colour to
+ * matrix (row-major 3x3) times the colour plus offset; gamma raises it to 1/gamma. */
+typedef struct {
+	int type;
+	double angle;
+	int left;
+	int top;
+	int width;
+	int height;
+	double threshold;
+	double ratio;
+	double background[3];
+	double sigma;
+	double amount;
+	double matrix[9];
+	double offset[3];
+	double gamma;
+} CompressorOp;
+
+/* Applies n operations in order to an image buffer, after the orientation
+ * tag when autorot is set, and encodes the result as lossless PNG. No
+ * operation may make an image of more than max_pixels pixels. Returns
+ * non-zero on error; the caller frees *out with g_free. */
+int compressor_transform(const void *buf, size_t len, int autorot, const CompressorOp *ops, int n, double max_pixels, void **out, size_t *out_len);
+
+/* Renders an SVG buffer at dpi, scaled to width pixels when width is
+ * positive, and encodes it as lossless PNG. Returns COMPRESSOR_ERROR_CANVAS
+ * when the render would have more than max_pixels pixels, non-zero on other
+ * errors; the caller frees *out with g_free. */
+int compressor_svgrender(const void *buf, size_t len, double dpi, int width, double max_pixels, void **out, size_t *out_len);
+
+/* Metadata blocks of an image, copied out of libvips. Absent blocks are NULL. */
+typedef struct {
+	void *exif;
+	size_t exif_len;
+	void *xmp;
+	size_t xmp_len;
+	void *iptc;
+	size_t iptc_len;
+	int icc;
+} CompressorMetadata;
+
+/* Reads the EXIF, XMP and IPTC blocks of an image buffer and whether it has
+ * a colour profile, without decoding pixels. Returns non-zero on error; the
+ * caller frees the blocks with g_free. */
+int compressor_metadata(const void *buf, size_t len, CompressorMetadata *m);
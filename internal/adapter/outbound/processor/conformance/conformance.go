@@ -0,0 +1,777 @@
+// Package conformance checks that a port.Processor honours domain.Options
+// the way the service relies on. Processor packages call Run from their
+// tests, so every implementation is held to the same semantics.
+package conformance
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"slices"
+	"testing"
+
+	"github.com/andreychano/compressor-golang/internal/adapter/outbound/processor/search"
+	"github.com/andreychano/compressor-golang/internal/core/domain"
+	"github.com/andreychano/compressor-golang/internal/core/port"
+	"github.com/andreychano/compressor-golang/internal/metadata"
+)
+
+// The fixture is wider than tall and has four distinct quadrants, so every
+// resize and orientation is observable.
+const (
+	fixtureWidth  = 400
+	fixtureHeight = 300
+)
+
+// Run checks p against the shared option semantics. It only uses JPEG and
+// PNG, which every processor reads and writes.
+func Run(t *testing.T, p port.Processor) {
+	t.Helper()
+
+	photo := encodeJPEG(t, fixture(), 90)
+	graphic := encodePNG(t, fixture())
+
+	t.Run("supports", func(t *testing.T) {
+		for _, mimeType := range []string{"image/jpeg", "image/png"} {
+			if !p.Supports(mimeType) {
+				t.Errorf("Supports(%q) = false", mimeType)
+			}
+		}
+		if p.Supports("application/x-unknown") {
+			t.Error("Supports(application/x-unknown) = true")
+		}
+	})
+
+	t.Run("format", func(t *testing.T) {
+		tests := []struct {
+			name     string
+			input    []byte
+			mimeType string
+			format   string
+			want     string
+		}{
+			{"jpeg to png", photo, "image/jpeg", "png", "image/png"},
+			{"png to jpeg", graphic, "image/png", "jpeg", "image/jpeg"},
+			{"jpg alias", graphic, "image/png", "jpg", "image/jpeg"},
+			{"keep jpeg", photo, "image/jpeg", "", "image/jpeg"},
+			{"keep png", graphic, "image/png", "", "image/png"},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				result := process(t, p, tt.input, tt.mimeType, domain.Options{Format: tt.format, Quality: 80})
+				if result.MimeType != tt.want {
+					t.Errorf("MimeType = %q, want %q", result.MimeType, tt.want)
+				}
+				decodeAs(t, result, tt.want)
+			})
+		}
+	})
+
+	t.Run("fit within", func(t *testing.T) {
+		tests := []struct {
+			name                string
+			maxWidth, maxHeight int
+			wantW, wantH        int
+		}{
+			{"no limits", 0, 0, 400, 300},
+			{"width", 200, 0, 200, 150},
+			{"height", 0, 100, 133, 100},
+			{"both", 200, 100, 133, 100},
+			{"never upscales", 1000, 1000, 400, 300},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				result := process(t, p, photo, "image/jpeg", domain.Options{
+					Format: "jpeg", Quality: 80, MaxWidth: tt.maxWidth, MaxHeight: tt.maxHeight,
+				})
+				assertSize(t, result.Width, result.Height, tt.wantW, tt.wantH)
+				if result.SourceWidth != fixtureWidth || result.SourceHeight != fixtureHeight {
+					t.Errorf("source = %dx%d, want %dx%d", result.SourceWidth, result.SourceHeight, fixtureWidth, fixtureHeight)
+				}
+				img := decodeAs(t, result, "image/jpeg")
+				assertSize(t, img.Bounds().Dx(), img.Bounds().Dy(), result.Width, result.Height)
+			})
+		}
+	})
+
+	t.Run("quality", func(t *testing.T) {
+		low := process(t, p, photo, "image/jpeg", domain.Options{Format: "jpeg", Quality: 20})
+		high := process(t, p, photo, "image/jpeg", domain.Options{Format: "jpeg", Quality: 95})
+		if low.Size >= high.Size {
+			t.Errorf("quality 20 gave %d bytes, quality 95 gave %d", low.Size, high.Size)
+		}
+		if low.Quality != 20 || high.Quality != 95 {
+			t.Errorf("Quality = %d, %d; want 20, 95", low.Quality, high.Quality)
+		}
+	})
+
+	t.Run("target bytes", func(t *testing.T) {
+		reference := process(t, p, photo, "image/jpeg", domain.Options{Format: "jpeg", Quality: 50})
+		target := reference.Size
+		result := process(t, p, photo, "image/jpeg", domain.Options{Format: "jpeg", Quality: 90, TargetBytes: target})
+		if result.Size > target {
+			t.Errorf("Size = %d, want at most %d", result.Size, target)
+		}
+		if result.Quality < 50 || result.Quality > 90 {
+			t.Errorf("Quality = %d, want within [50, 90]", result.Quality)
+		}
+
+		_, err := p.Process(file(photo, "image/jpeg"), domain.Options{Format: "jpeg", Quality: 90, TargetBytes: 100})
+		if !errors.Is(err, domain.ErrTargetUnreachable) {
+			t.Errorf("unreachable target: got %v, want ErrTargetUnreachable", err)
+		}
+	})
+
+	t.Run("target bytes with report", func(t *testing.T) {
+		// Half the smallest encoding at the source size forces a downscale.
+		smallest := process(t, p, photo, "image/jpeg", domain.Options{Format: "jpeg", Quality: search.MinQuality})
+		result := process(t, p, photo, "image/jpeg", domain.Options{
+			Format: "jpeg", Quality: 90, TargetBytes: smallest.Size / 2, Report: true, Placeholders: true,
+		})
+		img := decodeAs(t, result, "image/jpeg")
+		if size := img.Bounds().Size(); size.X >= fixtureWidth || result.Width != size.X || result.Height != size.Y {
+			t.Errorf("reported %dx%d, encoded %v, want a downscaled size reported", result.Width, result.Height, size)
+		}
+		if result.Report == nil || result.Placeholders == nil {
+			t.Fatalf("Report = %v, Placeholders = %v", result.Report, result.Placeholders)
+		}
+		if result.Report.SSIM <= 0.5 || result.Report.SSIM > 1 {
+			t.Errorf("report SSIM %.4f out of range", result.Report.SSIM)
+		}
+	})
+
+	t.Run("target ssim", func(t *testing.T) {
+		result := process(t, p, photo, "image/jpeg", domain.Options{Format: "jpeg", Quality: 95, TargetSSIM: 0.95})
+		if result.SSIM < 0.95 {
+			t.Errorf("SSIM = %.4f, want at least 0.95", result.SSIM)
+		}
+		if result.Quality > 95 {
+			t.Errorf("Quality = %d, want at most 95", result.Quality)
+		}
+	})
+
+	t.Run("report", func(t *testing.T) {
+		result := process(t, p, photo, "image/jpeg", domain.Options{Format: "jpeg", Quality: 80, MaxWidth: 200, Report: true})
+		if result.Report == nil {
+			t.Fatal("Report = nil")
+		}
+		if result.Report.SSIM <= 0.5 || result.Report.SSIM > 1 || result.Report.PSNR <= 20 {
+			t.Errorf("report SSIM %.4f, PSNR %.2f out of range", result.Report.SSIM, result.Report.PSNR)
+		}
+		if plain := process(t, p, photo, "image/jpeg", domain.Options{Format: "jpeg", Quality: 80}); plain.Report != nil {
+			t.Error("Report set without Options.Report")
+		}
+	})
+
+	t.Run("placeholders", func(t *testing.T) {
+		result := process(t, p, photo, "image/jpeg", domain.Options{Format: "png", MaxWidth: 200, Placeholders: true})
+		if result.Placeholders == nil {
+			t.Fatal("Placeholders = nil")
+		}
+		if n := len(result.Placeholders.BlurHash); n != 28 {
+			t.Errorf("BlurHash %q has %d characters, want 28 for 4x3 components", result.Placeholders.BlurHash, n)
+		}
+		if hash, err := base64.StdEncoding.DecodeString(result.Placeholders.ThumbHash); err != nil || len(hash) < 5 {
+			t.Errorf("ThumbHash %q is not a base64 hash: %v", result.Placeholders.ThumbHash, err)
+		}
+		if plain := process(t, p, photo, "image/jpeg", domain.Options{Format: "png"}); plain.Placeholders != nil {
+			t.Error("Placeholders set without Options.Placeholders")
+		}
+	})
+
+	t.Run("analysis", func(t *testing.T) {
+		result := process(t, p, graphic, "image/png", domain.Options{Format: "png", MaxWidth: 200, Analyze: true})
+		if result.Analysis == nil {
+			t.Fatal("Analysis = nil")
+		}
+		palette := result.Analysis.Palette
+		if len(palette) < 4 || palette[0].Color != result.Analysis.Dominant {
+			t.Fatalf("palette %+v, dominant %s: want the quadrants, largest first", palette, result.Analysis.Dominant)
+		}
+		sum := 0.0
+		for _, s := range palette {
+			sum += s.Proportion
+		}
+		if sum < 0.999 || sum > 1.001 {
+			t.Errorf("proportions sum to %f, want 1", sum)
+		}
+		// Every quadrant is a quarter of the image.
+		for _, q := range quadrants {
+			found := false
+			for _, s := range palette {
+				c, err := domain.ParseColor(s.Color)
+				if err == nil && abs(int(c.R)-int(q.R)) < 40 && abs(int(c.G)-int(q.G)) < 40 && abs(int(c.B)-int(q.B)) < 40 {
+					found = true
+				}
+			}
+			if !found {
+				t.Errorf("no swatch near quadrant %v in %+v", q, palette)
+			}
+		}
+
+		transparent := process(t, p, encodePNG(t, translucent()), "image/png", domain.Options{Format: "png", Analyze: true})
+		if a := transparent.Analysis; a == nil || len(a.Palette) == 0 || a.Palette[0].Proportion < 0.9 {
+			t.Errorf("translucent analysis %+v, want the opaque blue only", a)
+		}
+	})
+
+	t.Run("orientation", func(t *testing.T) {
+		for orientation := 1; orientation <= 8; orientation++ {
+			input := withEXIF(encodeJPEG(t, stored(orientation), 95), orientation)
+
+			upright := process(t, p, input, "image/jpeg", domain.Options{Format: "png"})
+			assertFixture(t, decodeAs(t, upright, "image/png"), orientation)
+
+			kept := process(t, p, input, "image/jpeg", domain.Options{Format: "png", NoAutoRotate: true})
+			if img := decodeAs(t, kept, "image/png"); img.Bounds().Size() != stored(orientation).Bounds().Size() {
+				t.Errorf("orientation %d with NoAutoRotate: got %v, want the stored size", orientation, img.Bounds().Size())
+			}
+		}
+	})
+
+	t.Run("strip metadata", func(t *testing.T) {
+		result := process(t, p, withEXIF(photo, 1), "image/jpeg", domain.Options{Format: "jpeg", Quality: 80, Metadata: domain.MetadataStrip})
+		if !slices.Contains(result.RemovedMetadata, "exif:GPSLatitudeRef") {
+			t.Errorf("RemovedMetadata = %v, want exif:GPSLatitudeRef", result.RemovedMetadata)
+		}
+		out, err := io.ReadAll(result.Content)
+		if err != nil {
+			t.Fatalf("read output: %v", err)
+		}
+		if blobs, err := metadata.Read(out); err != nil || len(blobs.EXIF) > 0 {
+			t.Errorf("output keeps EXIF (err %v)", err)
+		}
+	})
+
+	t.Run("crop", func(t *testing.T) {
+		tests := []struct {
+			name         string
+			crop         domain.CropOptions
+			wantW, wantH int
+			left         [2]int // Expected quadrants at the top and bottom of the left edge, -1 to skip
+		}{
+			{"cover", domain.CropOptions{Width: 100, Height: 100}, 100, 100, [2]int{-1, -1}},
+			{"west", domain.CropOptions{Width: 100, Height: 300, Gravity: domain.GravityWest}, 100, 300, [2]int{0, 2}},
+			{"east", domain.CropOptions{Width: 100, Height: 300, Gravity: domain.GravityEast}, 100, 300, [2]int{1, 3}},
+			{"focus", domain.CropOptions{Width: 100, Height: 300, Focus: &domain.FocalPoint{X: 1, Y: 0.5}}, 100, 300, [2]int{1, 3}},
+			{"not enlarged", domain.CropOptions{Width: 1000, Height: 1000}, 300, 300, [2]int{-1, -1}},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				result := process(t, p, graphic, "image/png", domain.Options{Format: "png", Crop: tt.crop})
+				img := decodeAs(t, result, "image/png")
+				assertSize(t, img.Bounds().Dx(), img.Bounds().Dy(), tt.wantW, tt.wantH)
+				if result.SourceWidth != fixtureWidth || result.SourceHeight != fixtureHeight {
+					t.Errorf("source size %dx%d, want the input size", result.SourceWidth, result.SourceHeight)
+				}
+				for i, y := range []int{tt.wantH / 4, tt.wantH * 3 / 4} {
+					if q := tt.left[i]; q >= 0 {
+						assertColor(t, img.At(tt.wantW/4, y), quadrants[q])
+					}
+				}
+			})
+		}
+	})
+
+	t.Run("operations", func(t *testing.T) {
+		bordered := encodePNG(t, withBorder(fixture(), 20))
+
+		tests := []struct {
+			name         string
+			input        []byte
+			ops          []domain.Operation
+			wantW, wantH int
+			corners      [4]int // Expected quadrants at the corners: top left, top right, bottom left, bottom right
+		}{
+			{"rotate 90", graphic, []domain.Operation{{Type: domain.OpRotate, Angle: 90}}, 300, 400, [4]int{2, 0, 3, 1}},
+			{"rotate -90", graphic, []domain.Operation{{Type: domain.OpRotate, Angle: -90}}, 300, 400, [4]int{1, 3, 0, 2}},
+			{"rotate 180", graphic, []domain.Operation{{Type: domain.OpRotate, Angle: 180}}, 400, 300, [4]int{3, 2, 1, 0}},
+			{"flip", graphic, []domain.Operation{{Type: domain.OpFlip}}, 400, 300, [4]int{2, 3, 0, 1}},
+			{"flop", graphic, []domain.Operation{{Type: domain.OpFlop}}, 400, 300, [4]int{1, 0, 3, 2}},
+			{"crop", graphic, []domain.Operation{{Type: domain.OpCrop, Left: 100, Top: 75, Width: 200, Height: 150}}, 200, 150, [4]int{0, 1, 2, 3}},
+			{"crop then rotate", graphic, []domain.Operation{
+				{Type: domain.OpCrop, Width: 200, Height: 150},
+				{Type: domain.OpRotate, Angle: 90},
+			}, 150, 200, [4]int{0, 0, 0, 0}},
+			{"rotate then crop", graphic, []domain.Operation{
+				{Type: domain.OpRotate, Angle: 90},
+				{Type: domain.OpCrop, Width: 150, Height: 200},
+			}, 150, 200, [4]int{2, 2, 2, 2}},
+			{"trim", bordered, []domain.Operation{{Type: domain.OpTrim}}, 400, 300, [4]int{0, 1, 2, 3}},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				result := process(t, p, tt.input, "image/png", domain.Options{Format: "png", Operations: tt.ops})
+				img := decodeAs(t, result, "image/png")
+				assertSize(t, img.Bounds().Dx(), img.Bounds().Dy(), tt.wantW, tt.wantH)
+				w, h := img.Bounds().Dx(), img.Bounds().Dy()
+				for i, at := range []image.Point{{w / 8, h / 8}, {w * 7 / 8, h / 8}, {w / 8, h * 7 / 8}, {w * 7 / 8, h * 7 / 8}} {
+					assertColor(t, img.At(at.X, at.Y), quadrants[tt.corners[i]])
+				}
+			})
+		}
+
+		t.Run("pad", func(t *testing.T) {
+			result := process(t, p, graphic, "image/png", domain.Options{Format: "png", Operations: []domain.Operation{
+				{Type: domain.OpPad, Ratio: 1, Background: "#000000"},
+			}})
+			img := decodeAs(t, result, "image/png")
+			assertSize(t, img.Bounds().Dx(), img.Bounds().Dy(), 400, 400)
+			assertColor(t, img.At(200, 10), color.RGBA{A: 255})
+			assertColor(t, img.At(10, 60), quadrants[0])
+		})
+
+		t.Run("arbitrary angle", func(t *testing.T) {
+			result := process(t, p, graphic, "image/png", domain.Options{Format: "png", Operations: []domain.Operation{
+				{Type: domain.OpRotate, Angle: 45, Background: "#ff00ff"},
+			}})
+			img := decodeAs(t, result, "image/png")
+			// The bounding box of the turned 400x300 image is about 495x495.
+			assertSize(t, img.Bounds().Dx(), img.Bounds().Dy(), 495, 495)
+			assertColor(t, img.At(5, 5), color.RGBA{R: 255, B: 255, A: 255})
+		})
+
+		if _, err := p.Process(file(graphic, "image/png"), domain.Options{Format: "png", Operations: []domain.Operation{
+			{Type: domain.OpCrop, Left: 300, Width: 200, Height: 100},
+		}}); !errors.Is(err, domain.ErrInvalidOptions) {
+			t.Errorf("crop outside the image: got %v, want ErrInvalidOptions", err)
+		}
+		// 400x4000, then 400000x4000: far more than domain.MaxCanvasPixels.
+		if _, err := p.Process(file(graphic, "image/png"), domain.Options{Format: "png", Operations: []domain.Operation{
+			{Type: domain.OpPad, Ratio: 0.1}, {Type: domain.OpPad, Ratio: 100},
+		}}); !errors.Is(err, domain.ErrInvalidOptions) {
+			t.Errorf("chained pads: got %v, want ErrInvalidOptions", err)
+		}
+	})
+
+	t.Run("pipeline", func(t *testing.T) {
+		// Resize before the crop, so the crop takes the top left quadrant of
+		// the 200x150 image; then cover a square and shrink it at the end.
+		pipeline, err := domain.ParsePipeline("resize:200:0/crop:0:0:100:75/rotate:90/resize:60:60:cover/resize:30:0/encode:png")
+		if err != nil {
+			t.Fatal(err)
+		}
+		result := process(t, p, graphic, "image/png", domain.Options{Pipeline: pipeline})
+		img := decodeAs(t, result, "image/png")
+		assertSize(t, img.Bounds().Dx(), img.Bounds().Dy(), 30, 30)
+		assertColor(t, img.At(15, 15), quadrants[0])
+		if result.SourceWidth != 400 || result.SourceHeight != 300 {
+			t.Errorf("source = %dx%d, want 400x300", result.SourceWidth, result.SourceHeight)
+		}
+	})
+
+	t.Run("filters", func(t *testing.T) {
+		tests := []struct {
+			name   string
+			filter domain.Filter
+			at     image.Point
+			want   color.RGBA
+		}{
+			{"grayscale", domain.Filter{Type: domain.OpGrayscale}, image.Pt(100, 75), color.RGBA{R: 84, G: 84, B: 84, A: 255}},
+			{"blur", domain.Filter{Type: domain.OpBlur, Sigma: 20}, image.Pt(200, 75), color.RGBA{R: 130, G: 120, B: 40, A: 255}},
+			{"blur keeps flat areas", domain.Filter{Type: domain.OpBlur, Sigma: 20}, image.Pt(50, 40), quadrants[0]},
+			{"sharpen keeps flat areas", domain.Filter{Type: domain.OpSharpen, Amount: 2}, image.Pt(100, 75), quadrants[0]},
+			{"gamma", domain.Filter{Type: domain.OpGamma, Gamma: 2.2}, image.Pt(100, 75), color.RGBA{R: 233, G: 96, B: 96, A: 255}},
+			{"tint", domain.Filter{Type: domain.OpTint, Color: "#fff", Amount: 1}, image.Pt(100, 75), color.RGBA{R: 255, G: 255, B: 255, A: 255}},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				result := process(t, p, graphic, "image/png", domain.Options{Format: "png", Filters: []domain.Filter{tt.filter}})
+				img := decodeAs(t, result, "image/png")
+				assertSize(t, img.Bounds().Dx(), img.Bounds().Dy(), fixtureWidth, fixtureHeight)
+				assertColor(t, img.At(tt.at.X, tt.at.Y), tt.want)
+			})
+		}
+
+		// Filters run after resizing, so sigma is in output pixels and 10
+		// blurs across the thumbnail's quadrant edge.
+		result := process(t, p, graphic, "image/png", domain.Options{
+			Format:   "png",
+			MaxWidth: 100,
+			Filters:  []domain.Filter{{Type: domain.OpBlur, Sigma: 10}},
+		})
+		img := decodeAs(t, result, "image/png")
+		assertSize(t, img.Bounds().Dx(), img.Bounds().Dy(), 100, 75)
+		assertColor(t, img.At(45, 18), color.RGBA{R: 130, G: 120, B: 40, A: 255})
+	})
+
+	t.Run("watermark", func(t *testing.T) {
+		magenta := color.RGBA{R: 255, B: 255, A: 255}
+		mark := image.NewRGBA(image.Rect(0, 0, 40, 40))
+		draw.Draw(mark, mark.Rect, image.NewUniform(magenta), image.Point{}, draw.Src)
+		logo := encodePNG(t, mark)
+
+		tests := []struct {
+			name  string
+			step  domain.WatermarkStep
+			at    []image.Point // Covered by the watermark
+			clear []image.Point // Left as it was
+			want  color.RGBA
+		}{
+			{
+				name:  "corner",
+				step:  domain.WatermarkStep{Image: "logo.png", Margin: 10},
+				at:    []image.Point{{351, 251}, {388, 288}},
+				clear: []image.Point{{395, 295}, {340, 280}},
+				want:  magenta,
+			},
+			{
+				name:  "opacity",
+				step:  domain.WatermarkStep{Image: "logo.png", Gravity: domain.GravityNorthWest, Opacity: 0.5},
+				at:    []image.Point{{20, 20}},
+				clear: []image.Point{{60, 20}},
+				want:  color.RGBA{R: 240, G: 20, B: 150, A: 255}, // Half magenta over the top left quadrant
+			},
+			{
+				name:  "tile",
+				step:  domain.WatermarkStep{Image: "logo.png", Margin: 10, Tile: true},
+				at:    []image.Point{{30, 30}, {130, 130}, {380, 280}},
+				clear: []image.Point{{55, 30}, {130, 105}},
+				want:  magenta,
+			},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				tt.step.ImageData = logo
+				result := process(t, p, graphic, "image/png", domain.Options{Format: "png", Watermarks: []domain.WatermarkStep{tt.step}})
+				img := decodeAs(t, result, "image/png")
+				assertSize(t, img.Bounds().Dx(), img.Bounds().Dy(), fixtureWidth, fixtureHeight)
+				for _, pt := range tt.at {
+					assertColor(t, img.At(pt.X, pt.Y), tt.want)
+				}
+				for _, pt := range tt.clear {
+					assertColor(t, img.At(pt.X, pt.Y), quadrants[quadrant(pt.X, pt.Y, fixtureWidth, fixtureHeight)])
+				}
+			})
+		}
+
+		t.Run("text", func(t *testing.T) {
+			step := domain.WatermarkStep{Text: "MMM", Font: domain.FontSansBold, Color: "#000", Scale: 0.5}
+			result := process(t, p, graphic, "image/png", domain.Options{Format: "png", Watermarks: []domain.WatermarkStep{step}})
+			img := decodeAs(t, result, "image/png")
+			dark := 0
+			for y := fixtureHeight / 2; y < fixtureHeight; y++ {
+				for x := fixtureWidth / 2; x < fixtureWidth; x++ {
+					if r, g, b, _ := img.At(x, y).RGBA(); r>>8 < 40 && g>>8 < 40 && b>>8 < 40 {
+						dark++
+					}
+				}
+			}
+			if dark < 500 {
+				t.Errorf("%d dark pixels in the bottom right quadrant, want the text", dark)
+			}
+			assertColor(t, img.At(100, 75), quadrants[0])
+		})
+
+		if _, err := p.Process(file(graphic, "image/png"), domain.Options{Format: "png", Watermarks: []domain.WatermarkStep{{Image: "logo.png"}}}); !errors.Is(err, domain.ErrInvalidOptions) {
+			t.Errorf("watermark image not loaded: got %v, want ErrInvalidOptions", err)
+		}
+	})
+
+	t.Run("alpha", func(t *testing.T) {
+		logo := encodePNG(t, translucent())
+
+		tests := []struct {
+			name       string
+			background string
+			want       color.RGBA // Left half, which is transparent
+		}{
+			{"white by default", "", color.RGBA{R: 255, G: 255, B: 255, A: 255}},
+			{"background", "#ff0000", color.RGBA{R: 255, A: 255}},
+			{"black background", "000", color.RGBA{A: 255}},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				result := process(t, p, logo, "image/png", domain.Options{Format: "jpeg", Quality: 90, Background: tt.background})
+				img := decodeAs(t, result, "image/jpeg")
+				assertColor(t, img.At(fixtureWidth/4, fixtureHeight/2), tt.want)
+				assertColor(t, img.At(fixtureWidth*3/4, fixtureHeight/2), quadrants[2])
+			})
+		}
+
+		if _, err := p.Process(file(logo, "image/png"), domain.Options{Format: "jpeg", Quality: 80, Alpha: domain.AlphaReject}); !errors.Is(err, domain.ErrInvalidOptions) {
+			t.Errorf("reject: got %v, want ErrInvalidOptions", err)
+		}
+		if _, err := p.Process(file(photo, "image/jpeg"), domain.Options{Format: "jpeg", Quality: 80, Alpha: domain.AlphaReject}); err != nil {
+			t.Errorf("reject of an opaque image: %v", err)
+		}
+
+		for _, policy := range []domain.AlphaPolicy{domain.AlphaAuto, domain.AlphaFlatten} {
+			format := "jpeg"
+			if policy == domain.AlphaFlatten {
+				format = "png"
+			}
+			result := process(t, p, logo, "image/png", domain.Options{Format: format, Quality: 80, Alpha: policy})
+			img := decodeAs(t, result, "image/png")
+			if _, _, _, a := img.At(fixtureWidth/4, fixtureHeight/2).RGBA(); a != 0 {
+				t.Errorf("%s to %s: transparent pixel has alpha %d", policy, format, a>>8)
+			}
+		}
+	})
+
+	t.Run("invalid options", func(t *testing.T) {
+		_, err := p.Process(file(photo, "image/jpeg"), domain.Options{Format: "jpeg", Quality: 80, Page: 1})
+		if !errors.Is(err, domain.ErrInvalidOptions) {
+			t.Errorf("page 1 of a jpeg: got %v, want ErrInvalidOptions", err)
+		}
+		if _, err := p.Process(file(photo, "image/jpeg"), domain.Options{Format: "bmp", Quality: 80}); err == nil {
+			t.Error("unknown format: expected an error")
+		}
+		if _, err := p.Process(file([]byte("not an image"), "image/jpeg"), domain.Options{Format: "jpeg", Quality: 80}); err == nil {
+			t.Error("undecodable input: expected an error")
+		}
+	})
+}
+
+// quadrants are the fixture colours: top-left, top-right, bottom-left,
+// bottom-right.
+var quadrants = [4]color.RGBA{
+	{R: 220, G: 40, B: 40, A: 255},
+	{R: 40, G: 200, B: 40, A: 255},
+	{R: 40, G: 40, B: 220, A: 255},
+	{R: 230, G: 230, B: 40, A: 255},
+}
+
+// fixture draws the upright image: four quadrants with a fine texture, so
+// JPEG quality makes a difference to the size.
+func fixture() *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, fixtureWidth, fixtureHeight))
+	for y := range fixtureHeight {
+		for x := range fixtureWidth {
+			c := quadrants[quadrant(x, y, fixtureWidth, fixtureHeight)]
+			shade := uint8((x*7 + y*13) % 24)
+			c.R, c.G, c.B = c.R-shade, c.G-shade, c.B-shade
+			img.SetRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+func quadrant(x, y, width, height int) int {
+	q := 0
+	if x >= width/2 {
+		q++
+	}
+	if y >= height/2 {
+		q += 2
+	}
+	return q
+}
+
+// withBorder surrounds img with a white border of the given width.
+func withBorder(img *image.RGBA, width int) *image.RGBA {
+	b := img.Bounds()
+	out := image.NewRGBA(image.Rect(0, 0, b.Dx()+2*width, b.Dy()+2*width))
+	for i := range out.Pix {
+		out.Pix[i] = 0xff
+	}
+	for y := range b.Dy() {
+		for x := range b.Dx() {
+			out.SetRGBA(x+width, y+width, img.RGBAAt(x, y))
+		}
+	}
+	return out
+}
+
+// translucent is a logo-like image: transparent on the left, opaque blue on
+// the right.
+func translucent() *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, fixtureWidth, fixtureHeight))
+	for y := range fixtureHeight {
+		for x := fixtureWidth / 2; x < fixtureWidth; x++ {
+			img.SetNRGBA(x, y, color.NRGBA(quadrants[2]))
+		}
+	}
+	return img
+}
+
+// stored lays the fixture out the way a camera stores it for an EXIF
+// orientation, so that applying the orientation gives the fixture back.
+func stored(orientation int) *image.RGBA {
+	upright := fixture()
+	w, h := fixtureWidth, fixtureHeight
+	sw, sh := w, h
+	if orientation >= 5 {
+		sw, sh = h, w
+	}
+	out := image.NewRGBA(image.Rect(0, 0, sw, sh))
+	for y := range h {
+		for x := range w {
+			var sx, sy int
+			switch orientation {
+			case 2:
+				sx, sy = w-1-x, y
+			case 3:
+				sx, sy = w-1-x, h-1-y
+			case 4:
+				sx, sy = x, h-1-y
+			case 5:
+				sx, sy = y, x
+			case 6:
+				sx, sy = y, w-1-x
+			case 7:
+				sx, sy = h-1-y, w-1-x
+			case 8:
+				sx, sy = h-1-y, x
+			default:
+				sx, sy = x, y
+			}
+			out.SetRGBA(sx, sy, upright.RGBAAt(x, y))
+		}
+	}
+	return out
+}
+
+// assertFixture checks the size and samples the centre of every quadrant,
+// allowing for JPEG loss.
+func assertFixture(t *testing.T, img image.Image, orientation int) {
+	t.Helper()
+
+	b := img.Bounds()
+	if b.Dx() != fixtureWidth || b.Dy() != fixtureHeight {
+		t.Errorf("orientation %d: got %dx%d, want %dx%d", orientation, b.Dx(), b.Dy(), fixtureWidth, fixtureHeight)
+		return
+	}
+	for q, want := range quadrants {
+		x := b.Min.X + fixtureWidth/4 + (q%2)*fixtureWidth/2
+		y := b.Min.Y + fixtureHeight/4 + (q/2)*fixtureHeight/2
+		r, g, bl, _ := img.At(x, y).RGBA()
+		if !near(r>>8, want.R) || !near(g>>8, want.G) || !near(bl>>8, want.B) {
+			t.Errorf("orientation %d: quadrant %d is (%d,%d,%d), want about %v", orientation, q, r>>8, g>>8, bl>>8, want)
+		}
+	}
+}
+
+func assertColor(t *testing.T, got color.Color, want color.RGBA) {
+	t.Helper()
+
+	r, g, b, _ := got.RGBA()
+	if !near(r>>8, want.R) || !near(g>>8, want.G) || !near(b>>8, want.B) {
+		t.Errorf("colour is (%d,%d,%d), want about %v", r>>8, g>>8, b>>8, want)
+	}
+}
+
+func near(got uint32, want uint8) bool {
+	d := int(got) - int(want)
+	return d > -48 && d < 48
+}
+
+// assertSize allows one pixel of rounding difference between resamplers.
+func assertSize(t *testing.T, width, height, wantW, wantH int) {
+	t.Helper()
+
+	if abs(width-wantW) > 1 || abs(height-wantH) > 1 {
+		t.Errorf("got %dx%d, want %dx%d", width, height, wantW, wantH)
+	}
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+func file(data []byte, mimeType string) domain.File {
+	return domain.File{Content: bytes.NewReader(data), MimeType: mimeType, Size: int64(len(data))}
+}
+
+func process(t *testing.T, p port.Processor, input []byte, mimeType string, opts domain.Options) domain.ProcessedFile {
+	t.Helper()
+
+	result, err := p.Process(file(input, mimeType), opts)
+	if err != nil {
+		t.Fatalf("Process(%+v): %v", opts, err)
+	}
+	if result.Size <= 0 {
+		t.Fatalf("Process(%+v): empty output", opts)
+	}
+	return result
+}
+
+// decodeAs decodes the output, failing the test unless it is of mimeType.
+// The content is rewound, so it can be read again.
+func decodeAs(t *testing.T, result domain.ProcessedFile, mimeType string) image.Image {
+	t.Helper()
+
+	data, err := io.ReadAll(result.Content)
+	if err != nil {
+		t.Fatalf("read output: %v", err)
+	}
+	if _, err := result.Content.Seek(0, io.SeekStart); err != nil {
+		t.Fatalf("rewind output: %v", err)
+	}
+
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("decode output: %v", err)
+	}
+	if "image/"+format != mimeType {
+		t.Fatalf("output is %s, want %s", format, mimeType)
+	}
+	return img
+}
+
+func encodeJPEG(t *testing.T, img image.Image, quality int) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality}); err != nil {
+		t.Fatalf("encode fixture: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func encodePNG(t *testing.T, img image.Image) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("encode fixture: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// withEXIF inserts an APP1 EXIF segment right after the JPEG SOI marker. It
+// holds the orientation and a GPS latitude reference, the least a phone
+// writes.
+func withEXIF(jpg []byte, orientation int) []byte {
+	be := binary.BigEndian
+	var tiff bytes.Buffer
+	tiff.WriteString("MM\x00\x2a")              // Big-endian TIFF header
+	_ = binary.Write(&tiff, be, uint32(8))      // Offset of IFD0
+	_ = binary.Write(&tiff, be, uint16(2))      // Two entries
+	_ = binary.Write(&tiff, be, uint16(0x0112)) // Orientation
+	_ = binary.Write(&tiff, be, uint16(3))      // SHORT
+	_ = binary.Write(&tiff, be, uint32(1))
+	_ = binary.Write(&tiff, be, uint16(orientation))
+	_ = binary.Write(&tiff, be, uint16(0))      // Padding
+	_ = binary.Write(&tiff, be, uint16(0x8825)) // GPS IFD pointer
+	_ = binary.Write(&tiff, be, uint16(4))      // LONG
+	_ = binary.Write(&tiff, be, uint32(1))
+	_ = binary.Write(&tiff, be, uint32(38))     // 8 + 2 + 2*12 + 4
+	_ = binary.Write(&tiff, be, uint32(0))      // No next IFD
+	_ = binary.Write(&tiff, be, uint16(1))      // One GPS entry
+	_ = binary.Write(&tiff, be, uint16(0x0001)) // GPSLatitudeRef
+	_ = binary.Write(&tiff, be, uint16(2))      // ASCII
+	_ = binary.Write(&tiff, be, uint32(2))
+	tiff.WriteString("N\x00\x00\x00")
+	_ = binary.Write(&tiff, be, uint32(0)) // No next IFD
+
+	payload := append([]byte("Exif\x00\x00"), tiff.Bytes()...)
+
+	var out bytes.Buffer
+	out.Write(jpg[:2]) // SOI
+	out.Write([]byte{0xff, 0xe1})
+	_ = binary.Write(&out, be, uint16(len(payload)+2))
+	out.Write(payload)
+	out.Write(jpg[2:])
+	return out.Bytes()
+}
@@ -0,0 +1,24 @@
+// Package describe turns the pure-Go image analyses into the domain values
+// processors return, so that every processor reports them alike.
+package describe
+
+import (
+	"image"
+
+	"github.com/andreychano/compressor-golang/internal/core/domain"
+	"github.com/andreychano/compressor-golang/internal/palette"
+)
+
+// Palette returns the palette.DefaultColors palette of img and its dominant
+// colour.
+func Palette(img image.Image) *domain.Analysis {
+	analysis := &domain.Analysis{Palette: []domain.Swatch{}}
+	for _, s := range palette.Extract(img, palette.DefaultColors) {
+		c := domain.Color{R: s.Color.R, G: s.Color.G, B: s.Color.B}
+		analysis.Palette = append(analysis.Palette, domain.Swatch{Color: c.String(), Proportion: s.Proportion})
+	}
+	if len(analysis.Palette) > 0 {
+		analysis.Dominant = analysis.Palette[0].Color
+	}
+	return analysis
+}
@@ -0,0 +1,50 @@
+package describe
+
+import (
+	"image"
+	"image/color"
+	"slices"
+	"testing"
+
+	"github.com/andreychano/compressor-golang/internal/core/domain"
+)
+
+// stripes fills a width x height image with vertical bands of colours, each
+// as wide as its weight out of the total.
+func stripes(width, height int, colors []color.NRGBA, weights []int) *image.NRGBA {
+	total := 0
+	for _, w := range weights {
+		total += w
+	}
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for x := 0; x < width; x++ {
+		i, edge := 0, weights[0]*width/total
+		for x >= edge {
+			i++
+			edge += weights[i] * width / total
+		}
+		for y := 0; y < height; y++ {
+			img.SetNRGBA(x, y, colors[i])
+		}
+	}
+	return img
+}
+
+func TestPalette(t *testing.T) {
+	red := color.NRGBA{R: 220, G: 30, B: 30, A: 255}
+	blue := color.NRGBA{R: 20, G: 40, B: 200, A: 255}
+
+	got := Palette(stripes(40, 10, []color.NRGBA{red, blue}, []int{3, 1}))
+	if got.Dominant != "#dc1e1e" || got.Palette[0].Color != got.Dominant {
+		t.Fatalf("expected dominant red, got %+v", got)
+	}
+	if !slices.ContainsFunc(got.Palette, func(s domain.Swatch) bool { return s.Color == "#1428c8" }) {
+		t.Errorf("expected blue in the palette, got %+v", got.Palette)
+	}
+
+	// A transparent image has an empty palette, not a nil one.
+	got = Palette(image.NewNRGBA(image.Rect(0, 0, 8, 8)))
+	if got.Dominant != "" || got.Palette == nil || len(got.Palette) != 0 {
+		t.Fatalf("expected an empty analysis, got %+v", got)
+	}
+}
@@ -0,0 +1,36 @@
+package goimage
+
+import (
+	"cmp"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+
+	"github.com/andreychano/compressor-golang/internal/core/domain"
+)
+
+// applyAlpha enforces opts.Alpha on img and returns the image and format to
+// encode. Only JPEG lacks alpha; the encoders drop opaque alpha channels
+// themselves.
+func applyAlpha(img *image.RGBA, format string, opts domain.Options) (*image.RGBA, string, error) {
+	if format != "jpeg" || img.Opaque() {
+		return img, format, nil
+	}
+
+	switch cmp.Or(opts.Alpha, domain.AlphaFlatten) {
+	case domain.AlphaReject:
+		return nil, "", fmt.Errorf("%w: image is transparent and jpeg has no alpha channel", domain.ErrInvalidOptions)
+	case domain.AlphaAuto:
+		return img, "png", nil
+	}
+
+	background, err := opts.BackgroundColor()
+	if err != nil {
+		return nil, "", err
+	}
+	out := image.NewRGBA(img.Rect)
+	draw.Draw(out, out.Rect, image.NewUniform(color.RGBA{R: background.R, G: background.G, B: background.B, A: 0xff}), image.Point{}, draw.Src)
+	draw.Draw(out, out.Rect, img, img.Rect.Min, draw.Over)
+	return out, format, nil
+}
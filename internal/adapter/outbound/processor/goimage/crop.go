@@ -0,0 +1,30 @@
+package goimage
+
+import (
+	"fmt"
+	"image"
+
+	"github.com/andreychano/compressor-golang/internal/core/domain"
+	"golang.org/x/image/draw"
+)
+
+// cropImage scales img to cover the crop box and cuts the box out at the
+// requested gravity or focal point.
+func cropImage(img *image.RGBA, o domain.CropOptions) (*image.RGBA, error) {
+	if o.Smart() {
+		return nil, fmt.Errorf("%w: smart crop needs libvips", domain.ErrInvalidOptions)
+	}
+
+	size := img.Bounds().Size()
+	scaledW, scaledH, cropW, cropH := o.Window(size.X, size.Y)
+	scaled := img
+	if scaledW != size.X || scaledH != size.Y {
+		scaled = image.NewRGBA(image.Rect(0, 0, scaledW, scaledH))
+		draw.CatmullRom.Scale(scaled, scaled.Bounds(), img, img.Bounds(), draw.Src, nil)
+	}
+
+	left, top := o.Offset(scaledW, scaledH, cropW, cropH)
+	out := image.NewRGBA(image.Rect(0, 0, cropW, cropH))
+	draw.Copy(out, image.Point{}, scaled, image.Rect(left, top, left+cropW, top+cropH), draw.Src, nil)
+	return out, nil
+}
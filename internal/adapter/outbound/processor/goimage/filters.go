@@ -0,0 +1,167 @@
+package goimage
+
+import (
+	"fmt"
+	"image"
+	"math"
+
+	"github.com/andreychano/compressor-golang/internal/core/domain"
+)
+
+// blurPasses is how many box blurs approximate a gaussian blur. Their cost
+// does not grow with sigma.
+const blurPasses = 3
+
+// filter runs one filter on img.
+func filter(img *image.RGBA, f domain.Filter) (*image.RGBA, error) {
+	if matrix, offset, ok := f.Recolor(); ok {
+		return mapColor(img, func(c [3]float64) [3]float64 {
+			var out [3]float64
+			for i := range out {
+				out[i] = matrix[i][0]*c[0] + matrix[i][1]*c[1] + matrix[i][2]*c[2] + offset[i]
+			}
+			return out
+		}), nil
+	}
+
+	switch f.Type {
+	case domain.OpBlur:
+		return toImage(blur(img, f.Sigma), img.Rect.Dx(), img.Rect.Dy()), nil
+	case domain.OpSharpen:
+		return sharpen(img, f.SharpenSigma(), f.SharpenAmount()), nil
+	case domain.OpGamma:
+		var lut [256]float64
+		for i := range lut {
+			lut[i] = 255 * math.Pow(float64(i)/255, 1/f.Gamma)
+		}
+		return mapColor(img, func(c [3]float64) [3]float64 {
+			for i, v := range c {
+				c[i] = lut[clampByte(v)]
+			}
+			return c
+		}), nil
+	default:
+		return nil, fmt.Errorf("%w: unknown filter %q", domain.ErrInvalidOptions, f.Type)
+	}
+}
+
+// mapColor applies fn to the unpremultiplied colour of every pixel, keeping
+// the alpha.
+func mapColor(img *image.RGBA, fn func(c [3]float64) [3]float64) *image.RGBA {
+	out := image.NewRGBA(image.Rect(0, 0, img.Rect.Dx(), img.Rect.Dy()))
+	for y := range out.Rect.Dy() {
+		src := img.Pix[img.PixOffset(img.Rect.Min.X, img.Rect.Min.Y+y):]
+		dst := out.Pix[y*out.Stride:]
+		for x := range out.Rect.Dx() {
+			s, d := src[x*4:x*4+4], dst[x*4:x*4+4]
+			a := s[3]
+			if a == 0 {
+				continue
+			}
+			scale := 255 / float64(a)
+			c := fn([3]float64{float64(s[0]) * scale, float64(s[1]) * scale, float64(s[2]) * scale})
+			for i, v := range c {
+				d[i] = clampByte(v * float64(a) / 255)
+			}
+			d[3] = a
+		}
+	}
+	return out
+}
+
+// sharpen applies an unsharp mask: every channel moves away from its
+// blurred value by amount times the difference.
+func sharpen(img *image.RGBA, sigma, amount float64) *image.RGBA {
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	blurred := blur(img, sigma)
+	out := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := range h {
+		src := img.Pix[img.PixOffset(img.Rect.Min.X, img.Rect.Min.Y+y):]
+		for x := range w {
+			i, o := x*4, (y*w+x)*4
+			d := out.Pix[y*out.Stride+i : y*out.Stride+i+4]
+			d[3] = clampByte(float64(src[i+3]) + amount*(float64(src[i+3])-blurred[o+3]))
+			for c := range 3 {
+				v := float64(src[i+c]) + amount*(float64(src[i+c])-blurred[o+c])
+				// Premultiplied colour cannot exceed the alpha.
+				d[c] = min(clampByte(v), d[3])
+			}
+		}
+	}
+	return out
+}
+
+// blur returns the premultiplied channels of img after a gaussian blur of
+// sigma pixels, approximated by box blurs, with the edges extended.
+func blur(img *image.RGBA, sigma float64) []float64 {
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	buf := make([]float64, w*h*4)
+	for y := range h {
+		src := img.Pix[img.PixOffset(img.Rect.Min.X, img.Rect.Min.Y+y):]
+		for i := range w * 4 {
+			buf[y*w*4+i] = float64(src[i])
+		}
+	}
+	tmp := make([]float64, len(buf))
+	for _, size := range boxSizes(sigma, blurPasses) {
+		r := (size - 1) / 2
+		boxBlur(tmp, buf, w, h, 4, w*4, r) // Rows
+		boxBlur(buf, tmp, h, w, w*4, 4, r) // Columns
+	}
+	return buf
+}
+
+// boxBlur averages each channel over 2r+1 neighbours along lines of n
+// pixels, step apart, that start lineStep apart, clamping at the ends.
+func boxBlur(dst, src []float64, n, lines, step, lineStep, r int) {
+	at := func(line, i int) int { return line*lineStep + min(max(i, 0), n-1)*step }
+	norm := 1 / float64(2*r+1)
+	for line := range lines {
+		for c := range 4 {
+			var acc float64
+			for i := -r; i <= r; i++ {
+				acc += src[at(line, i)+c]
+			}
+			for i := range n {
+				dst[at(line, i)+c] = acc * norm
+				acc += src[at(line, i+r+1)+c] - src[at(line, i-r)+c]
+			}
+		}
+	}
+}
+
+// boxSizes returns the odd widths of passes box blurs that together
+// approximate a gaussian blur of sigma.
+func boxSizes(sigma float64, passes int) []int {
+	n := float64(passes)
+	ideal := math.Sqrt(12*sigma*sigma/n + 1)
+	lower := int(ideal)
+	if lower%2 == 0 {
+		lower--
+	}
+	upper := lower + 2
+	l := float64(lower)
+	m := int(math.Round((12*sigma*sigma - n*l*l - 4*n*l - 3*n) / (-4*l - 4)))
+
+	sizes := make([]int, passes)
+	for i := range sizes {
+		sizes[i] = upper
+		if i < m {
+			sizes[i] = lower
+		}
+	}
+	return sizes
+}
+
+// toImage turns premultiplied channels back into an image.
+func toImage(channels []float64, w, h int) *image.RGBA {
+	out := image.NewRGBA(image.Rect(0, 0, w, h))
+	for i, v := range channels {
+		out.Pix[i] = clampByte(v)
+	}
+	return out
+}
+
+func clampByte(v float64) uint8 {
+	return uint8(min(max(v+0.5, 0), 255))
+}
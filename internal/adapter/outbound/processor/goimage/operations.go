@@ -0,0 +1,176 @@
+package goimage
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+
+	"github.com/andreychano/compressor-golang/internal/adapter/outbound/processor/overlay"
+	"github.com/andreychano/compressor-golang/internal/core/domain"
+	"golang.org/x/image/draw"
+	"golang.org/x/image/math/f64"
+)
+
+// defaultTrimThreshold matches the libvips default.
+const defaultTrimThreshold = 10
+
+// transform runs the pipeline steps before encoding on img in order.
+func transform(img *image.RGBA, steps domain.Pipeline, opts domain.Options) (*image.RGBA, error) {
+	for i, step := range steps {
+		var err error
+		switch s := step.(type) {
+		case domain.Operation:
+			img, err = apply(img, s, opts)
+		case domain.ResizeStep:
+			if o, ok := s.Cover(); ok {
+				img, err = cropImage(img, o)
+			} else {
+				img = shrink(img, s.Width, s.Height)
+			}
+		case domain.Filter:
+			img, err = filter(img, s)
+		case domain.WatermarkStep:
+			img, err = watermark(img, s)
+		default:
+			err = fmt.Errorf("%w: unsupported step %q", domain.ErrInvalidOptions, step.Kind())
+		}
+		if err != nil {
+			return nil, fmt.Errorf("step %d: %w", i+1, err)
+		}
+	}
+	return img, nil
+}
+
+// apply runs one geometric operation.
+func apply(img *image.RGBA, op domain.Operation, opts domain.Options) (*image.RGBA, error) {
+	background, err := op.BackgroundColor(opts)
+	if err != nil {
+		return nil, err
+	}
+	fill := color.RGBA{R: background.R, G: background.G, B: background.B, A: 0xff}
+
+	switch op.Type {
+	case domain.OpRotate:
+		return rotate(img, op, fill)
+	case domain.OpFlip:
+		return orient(img, 4), nil
+	case domain.OpFlop:
+		return orient(img, 2), nil
+	case domain.OpCrop:
+		rect := image.Rect(op.Left, op.Top, op.Left+op.Width, op.Top+op.Height)
+		if !rect.In(img.Rect) {
+			return nil, fmt.Errorf("%w: crop %v is outside the %v image", domain.ErrInvalidOptions, rect, img.Rect.Size())
+		}
+		return toRGBA(img.SubImage(rect)), nil
+	case domain.OpTrim:
+		return trim(img, op.Threshold), nil
+	case domain.OpPad:
+		return pad(img, op, fill)
+	default:
+		return nil, fmt.Errorf("%w: unknown operation %q", domain.ErrInvalidOptions, op.Type)
+	}
+}
+
+// shrink scales img down to fit within width x height; zero limits are
+// ignored.
+func shrink(img *image.RGBA, width, height int) *image.RGBA {
+	w, h := fitWithin(img.Rect.Dx(), img.Rect.Dy(), width, height)
+	if w == 0 && h == 0 {
+		return img
+	}
+	out := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(out, out.Bounds(), img, img.Bounds(), draw.Src, nil)
+	return out
+}
+
+// watermark draws the watermark of step over img.
+func watermark(img *image.RGBA, step domain.WatermarkStep) (*image.RGBA, error) {
+	mark, at, err := overlay.Render(step, img.Rect.Dx(), img.Rect.Dy())
+	if err != nil {
+		return nil, err
+	}
+	at = at.Add(img.Rect.Min)
+	draw.Draw(img, image.Rectangle{Min: at, Max: at.Add(mark.Rect.Size())}, mark, image.Point{}, draw.Over)
+	return img, nil
+}
+
+// rotate turns img clockwise. Right angles move pixels; other angles
+// resample onto a canvas large enough for the corners, filled with fill.
+func rotate(img *image.RGBA, op domain.Operation, fill color.RGBA) (*image.RGBA, error) {
+	if angle, ok := op.RightAngle(); ok {
+		// The EXIF orientations corrected by these clockwise turns.
+		return orient(img, map[int]int{0: 1, 90: 6, 180: 3, 270: 8}[angle]), nil
+	}
+
+	ow, oh, err := op.RotateSize(img.Rect.Dx(), img.Rect.Dy())
+	if err != nil {
+		return nil, err
+	}
+	theta := op.NormalizedAngle() * math.Pi / 180
+	sin, cos := math.Sin(theta), math.Cos(theta)
+	w, h := float64(img.Rect.Dx()), float64(img.Rect.Dy())
+
+	out := image.NewRGBA(image.Rect(0, 0, ow, oh))
+	draw.Draw(out, out.Rect, image.NewUniform(fill), image.Point{}, draw.Src)
+	// Maps source to destination: centre, turn, move to the new centre.
+	cx, cy, ocx, ocy := w/2, h/2, float64(ow)/2, float64(oh)/2
+	m := f64.Aff3{
+		cos, -sin, ocx - cos*cx + sin*cy,
+		sin, cos, ocy - sin*cx - cos*cy,
+	}
+	draw.BiLinear.Transform(out, m, img, img.Rect, draw.Over, nil)
+	return out, nil
+}
+
+// trim cuts off borders whose colour is within threshold of the top left
+// pixel. A uniform image is returned unchanged.
+func trim(img *image.RGBA, threshold float64) *image.RGBA {
+	if threshold == 0 {
+		threshold = defaultTrimThreshold
+	}
+	border := img.RGBAAt(img.Rect.Min.X, img.Rect.Min.Y)
+	differs := func(c color.RGBA) bool {
+		d := max(diff(c.R, border.R), diff(c.G, border.G), diff(c.B, border.B), diff(c.A, border.A))
+		return float64(d) > threshold
+	}
+
+	content := image.Rectangle{}
+	for y := img.Rect.Min.Y; y < img.Rect.Max.Y; y++ {
+		for x := img.Rect.Min.X; x < img.Rect.Max.X; x++ {
+			if differs(img.RGBAAt(x, y)) {
+				content = content.Union(image.Rect(x, y, x+1, y+1))
+			}
+		}
+	}
+	if content.Empty() {
+		return img
+	}
+	return toRGBA(img.SubImage(content))
+}
+
+func diff(a, b uint8) int {
+	if a > b {
+		return int(a - b)
+	}
+	return int(b - a)
+}
+
+// pad centres img on a canvas of the operation's aspect ratio filled with
+// fill.
+func pad(img *image.RGBA, op domain.Operation, fill color.RGBA) (*image.RGBA, error) {
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	pw, ph, err := op.PadSize(w, h)
+	if err != nil {
+		return nil, err
+	}
+	if pw == w && ph == h {
+		return img, nil
+	}
+
+	out := image.NewRGBA(image.Rect(0, 0, pw, ph))
+	draw.Draw(out, out.Rect, image.NewUniform(fill), image.Point{}, draw.Src)
+	at := image.Pt((pw-w)/2, (ph-h)/2)
+	draw.Draw(out, image.Rectangle{Min: at, Max: at.Add(img.Rect.Size())}, img, img.Rect.Min, draw.Src)
+	return out, nil
+}
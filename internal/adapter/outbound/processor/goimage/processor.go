@@ -0,0 +1,344 @@
+// Package goimage implements image compression in pure Go, for builds
+// without cgo and libvips. It reads JPEG, PNG, GIF and WebP and writes JPEG,
+// PNG and GIF. Outputs carry no metadata and pixels are not colour managed;
+// animations keep their first frame.
+package goimage
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/andreychano/compressor-golang/internal/adapter/outbound/processor/describe"
+	"github.com/andreychano/compressor-golang/internal/adapter/outbound/processor/search"
+	"github.com/andreychano/compressor-golang/internal/core/domain"
+	"github.com/andreychano/compressor-golang/internal/metadata"
+	"github.com/andreychano/compressor-golang/internal/placeholder"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+// Processor implements image compression with the Go standard library and
+// golang.org/x/image.
+type Processor struct {
+	maxPixels int
+}
+
+// NewProcessor creates a new pure-Go processor. Inputs of more than
+// maxPixels pixels are rejected before decoding, which holds the whole image
+// in memory; 0 means domain.MaxCanvasPixels.
+func NewProcessor(maxPixels int) *Processor {
+	if maxPixels <= 0 {
+		maxPixels = domain.MaxCanvasPixels
+	}
+	return &Processor{maxPixels: maxPixels}
+}
+
+// inputs maps loadable MIME types to the format names image.Decode reports.
+var inputs = map[string]string{
+	"image/jpeg": "jpeg",
+	"image/png":  "png",
+	"image/gif":  "gif",
+	"image/webp": "webp",
+}
+
+// Supports reports whether the given MIME type is supported.
+func (p *Processor) Supports(mimeType string) bool {
+	_, ok := inputs[mimeType]
+	return ok
+}
+
+// SupportedInputs lists the input MIME types the processor can decode.
+func (p *Processor) SupportedInputs() []string {
+	return slices.Sorted(maps.Keys(inputs))
+}
+
+// SupportsOutput reports whether the processor can encode the given format.
+func (p *Processor) SupportsOutput(format string) bool {
+	switch format {
+	case "jpeg", "jpg", "png", "gif":
+		return true
+	default:
+		return false
+	}
+}
+
+// SupportsStep reports whether the processor can run a pipeline step. Smart
+// crops and the encoders it lacks need libvips.
+func (p *Processor) SupportsStep(step domain.Step) bool {
+	switch s := step.(type) {
+	case domain.Operation, domain.Filter, domain.WatermarkStep:
+		return true
+	case domain.ResizeStep:
+		o, _ := s.Cover()
+		return !o.Smart()
+	case domain.EncodeStep:
+		if s.Format == "" {
+			// Decided once the input format is known.
+			return true
+		}
+		format, err := outputFormat(s.Format, "")
+		return err == nil && encoderSupports(s, format) == nil
+	default:
+		return false
+	}
+}
+
+// Process compresses the input file according to the provided options.
+func (p *Processor) Process(inputFile domain.File, opts domain.Options) (domain.ProcessedFile, error) {
+	if _, err := inputFile.Content.Seek(0, 0); err != nil {
+		return domain.ProcessedFile{}, fmt.Errorf("failed to seek file content: %w", err)
+	}
+
+	buffer, err := io.ReadAll(inputFile.Content)
+	if err != nil {
+		return domain.ProcessedFile{}, fmt.Errorf("cannot read: %w", err)
+	}
+
+	header, _, err := image.DecodeConfig(bytes.NewReader(buffer))
+	if err != nil {
+		return domain.ProcessedFile{}, fmt.Errorf("%w: %v", domain.ErrInvalidImage, err)
+	}
+	if pixels := int64(header.Width) * int64(header.Height); pixels > int64(p.maxPixels) {
+		return domain.ProcessedFile{}, fmt.Errorf("%w: %dx%d is more than %d pixels", domain.ErrInvalidImage, header.Width, header.Height, p.maxPixels)
+	}
+
+	src, inputFormat, err := image.Decode(bytes.NewReader(buffer))
+	if err != nil {
+		return domain.ProcessedFile{}, fmt.Errorf("%w: %v", domain.ErrInvalidImage, err)
+	}
+	if opts.Page > 0 {
+		// None of the readable formats has pages.
+		return domain.ProcessedFile{}, fmt.Errorf("%w: page %d does not exist", domain.ErrInvalidOptions, opts.Page)
+	}
+
+	steps, fit, encode := opts.Compile().Stages()
+	format, err := outputFormat(encode.Format, inputFormat)
+	if err != nil {
+		return domain.ProcessedFile{}, err
+	}
+	if err := encoderSupports(encode, format); err != nil {
+		return domain.ProcessedFile{}, err
+	}
+
+	blobs, err := metadata.Read(buffer)
+	if err != nil && !errors.Is(err, metadata.ErrUnsupported) {
+		return domain.ProcessedFile{}, fmt.Errorf("failed to read metadata: %w", err)
+	}
+
+	upright := toRGBA(src)
+	if !opts.NoAutoRotate {
+		upright = orient(upright, metadata.Orientation(blobs.EXIF))
+	}
+	if upright, format, err = applyAlpha(upright, format, opts); err != nil {
+		return domain.ProcessedFile{}, err
+	}
+	source := upright.Bounds().Size()
+	if len(steps) > 0 {
+		if upright, err = transform(upright, steps, opts); err != nil {
+			return domain.ProcessedFile{}, err
+		}
+	}
+
+	width, height := fitWithin(upright.Rect.Dx(), upright.Rect.Dy(), fit.Width, fit.Height)
+	enc := &encoder{source: upright, format: format, pngLevel: pngLevel(encode.PNG.Compression)}
+
+	var (
+		processedBuffer []byte
+		quality         = encode.Quality
+		ssim            float64
+		start           = time.Now()
+	)
+	switch {
+	case encode.TargetBytes > 0:
+		// The search may scale the output down further.
+		var encoded image.Point
+		processedBuffer, quality, encoded, err = search.Target(enc.encode, upright.Bounds().Size(), encode.TargetBytes, encode.Quality, width, height)
+		width, height = encoded.X, encoded.Y
+	case encode.TargetSSIM > 0:
+		processedBuffer, quality, ssim, err = search.SSIM(enc.encode, decode, enc.resized(width, height), encode.TargetSSIM, encode.Quality, width, height)
+	default:
+		processedBuffer, err = enc.encode(quality, width, height)
+	}
+	if err != nil {
+		return domain.ProcessedFile{}, err
+	}
+	encodeTime := time.Since(start)
+
+	// The pixels that were encoded, at the dimensions the search settled on.
+	encoded := enc.resized(width, height)
+	output := encoded.Bounds().Size()
+
+	var report *domain.Report
+	if opts.Report {
+		decoded, err := decode(processedBuffer)
+		if err != nil {
+			return domain.ProcessedFile{}, err
+		}
+		if report, err = search.Report(encoded, decoded); err != nil {
+			return domain.ProcessedFile{}, err
+		}
+		report.EncodeMS = encodeTime.Milliseconds()
+	}
+
+	// Placeholders and analyses use the pixels before encoding: lossy
+	// encoding changes nothing they show.
+	var placeholders *domain.Placeholders
+	if opts.Placeholders {
+		blurHash, thumbHash := placeholder.Hashes(encoded)
+		placeholders = &domain.Placeholders{BlurHash: blurHash, ThumbHash: base64.StdEncoding.EncodeToString(thumbHash)}
+	}
+	var analysis *domain.Analysis
+	if opts.Analyze {
+		analysis = describe.Palette(encoded)
+	}
+
+	return domain.ProcessedFile{
+		File: domain.File{
+			Content:  bytes.NewReader(processedBuffer),
+			MimeType: "image/" + format,
+			Size:     int64(len(processedBuffer)),
+		},
+		Width:        output.X,
+		Height:       output.Y,
+		SourceWidth:  source.X,
+		SourceHeight: source.Y,
+		Quality:      quality,
+		SSIM:         ssim,
+		Report:       report,
+		Placeholders: placeholders,
+		Analysis:     analysis,
+		// The encoders write no metadata at all.
+		RemovedMetadata: metadata.Removed(blobs, metadata.Blobs{}),
+	}, nil
+}
+
+// outputFormat resolves the requested format name. An empty format keeps the
+// input format, except WebP, which there is no encoder for and becomes PNG.
+func outputFormat(format, inputFormat string) (string, error) {
+	switch format {
+	case "":
+		if inputFormat == "webp" {
+			return "png", nil
+		}
+		return inputFormat, nil
+	case "jpeg", "jpg":
+		return "jpeg", nil
+	case "png", "gif":
+		return format, nil
+	case "webp", "avif":
+		return "", fmt.Errorf("%w: cannot encode %s without libvips", domain.ErrInvalidOptions, format)
+	case "svg":
+		return "", fmt.Errorf("%w: svg output needs an svg input", domain.ErrInvalidOptions)
+	default:
+		return "", fmt.Errorf("unsupported output format: %s", format)
+	}
+}
+
+// encoderSupports rejects encoder options the standard library encoders
+// lack.
+func encoderSupports(encode domain.EncodeStep, format string) error {
+	if format == "png" && encode.PNG.Quantised() {
+		return fmt.Errorf("%w: png palettes need libvips", domain.ErrInvalidOptions)
+	}
+	if format == "jpeg" && (encode.JPEG.Progressive || encode.JPEG.Subsample == "444") {
+		// image/jpeg writes baseline 4:2:0 only.
+		return fmt.Errorf("%w: progressive and 4:4:4 jpeg need libvips", domain.ErrInvalidOptions)
+	}
+	return nil
+}
+
+// encoder encodes one upright source image with varying parameters and
+// keeps the last resize, which searches request repeatedly.
+type encoder struct {
+	source   *image.RGBA
+	format   string
+	pngLevel png.CompressionLevel
+
+	size   image.Point
+	resize *image.RGBA
+}
+
+// resized returns the source scaled to width x height; zero width and height
+// keep the source dimensions.
+func (e *encoder) resized(width, height int) *image.RGBA {
+	if width == 0 && height == 0 {
+		return e.source
+	}
+	if size := image.Pt(width, height); e.resize == nil || e.size != size {
+		e.resize = image.NewRGBA(image.Rect(0, 0, width, height))
+		draw.CatmullRom.Scale(e.resize, e.resize.Bounds(), e.source, e.source.Bounds(), draw.Src, nil)
+		e.size = size
+	}
+	return e.resize
+}
+
+// encode produces the output at the given quality; quality only affects JPEG.
+func (e *encoder) encode(quality, width, height int) ([]byte, error) {
+	img := e.resized(width, height)
+
+	var (
+		buf bytes.Buffer
+		err error
+	)
+	switch e.format {
+	case "jpeg":
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality})
+	case "png":
+		err = (&png.Encoder{CompressionLevel: e.pngLevel}).Encode(&buf, img)
+	case "gif":
+		err = gif.Encode(&buf, img, nil)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode %s: %w", e.format, err)
+	}
+	return buf.Bytes(), nil
+}
+
+// pngLevel maps a zlib level to the few the encoder offers; zero, the
+// default, compresses best.
+func pngLevel(compression int) png.CompressionLevel {
+	switch {
+	case compression == 0 || compression >= 7:
+		return png.BestCompression
+	case compression <= 3:
+		return png.BestSpeed
+	default:
+		return png.DefaultCompression
+	}
+}
+
+// decode turns an encoded buffer into pixels for metric computation.
+func decode(buf []byte) (image.Image, error) {
+	img, _, err := image.Decode(bytes.NewReader(buf))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image: %w", err)
+	}
+	return img, nil
+}
+
+// fitWithin scales width x height down to fit maxWidth x maxHeight keeping the
+// aspect ratio. It returns zeros when no resize is needed; zero limits are ignored.
+func fitWithin(width, height, maxWidth, maxHeight int) (int, int) {
+	scale := 1.0
+	if maxWidth > 0 && width > maxWidth {
+		scale = float64(maxWidth) / float64(width)
+	}
+	if maxHeight > 0 && height > maxHeight {
+		scale = min(scale, float64(maxHeight)/float64(height))
+	}
+	if scale == 1.0 {
+		return 0, 0
+	}
+
+	return max(1, int(float64(width)*scale+0.5)), max(1, int(float64(height)*scale+0.5))
+}
@@ -0,0 +1,33 @@
+package goimage
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/png"
+	"testing"
+
+	"github.com/andreychano/compressor-golang/internal/adapter/outbound/processor/conformance"
+	"github.com/andreychano/compressor-golang/internal/core/domain"
+)
+
+func TestConformance(t *testing.T) {
+	conformance.Run(t, NewProcessor(0))
+}
+
+func TestProcess_MaxPixels(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 20, 10))); err != nil {
+		t.Fatal(err)
+	}
+	input := func() domain.File {
+		return domain.File{Name: "wide.png", MimeType: "image/png", Size: int64(buf.Len()), Content: bytes.NewReader(buf.Bytes())}
+	}
+
+	if _, err := NewProcessor(199).Process(input(), domain.Options{Format: "png"}); !errors.Is(err, domain.ErrInvalidImage) {
+		t.Errorf("20x10 with a 199 pixel cap: got %v, want ErrInvalidImage", err)
+	}
+	if _, err := NewProcessor(200).Process(input(), domain.Options{Format: "png"}); err != nil {
+		t.Errorf("20x10 with a 200 pixel cap: %v", err)
+	}
+}
@@ -0,0 +1,191 @@
+// Package overlay renders watermarks in pure Go, so every processor places
+// and draws them the same way and only has to composite the result.
+package overlay
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"math"
+	"sync"
+
+	"github.com/andreychano/compressor-golang/internal/core/domain"
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/goitalic"
+	"golang.org/x/image/font/gofont/gomono"
+	"golang.org/x/image/font/gofont/gomonobold"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+	_ "golang.org/x/image/webp"
+)
+
+// defaultTextScale is the text width as a fraction of the image width when
+// the step sets no scale.
+const defaultTextScale = 0.25
+
+// fonts maps font names to TrueType data.
+var fonts = map[string][]byte{
+	domain.FontSans:       goregular.TTF,
+	domain.FontSansBold:   gobold.TTF,
+	domain.FontSansItalic: goitalic.TTF,
+	domain.FontMono:       gomono.TTF,
+	domain.FontMonoBold:   gomonobold.TTF,
+}
+
+var (
+	parsedMu sync.Mutex
+	parsed   = map[string]*opentype.Font{}
+)
+
+// Render draws the watermark of step for a width x height image. It returns
+// the watermark and where its top left corner goes; a tiled watermark comes
+// back as a width x height layer at the origin.
+func Render(step domain.WatermarkStep, width, height int) (*image.NRGBA, image.Point, error) {
+	var (
+		mark *image.NRGBA
+		err  error
+	)
+	if step.Text != "" {
+		mark, err = text(step, width)
+	} else {
+		mark, err = picture(step, width)
+	}
+	if err != nil {
+		return nil, image.Point{}, err
+	}
+	mark = fit(mark, width-2*step.Margin, height-2*step.Margin)
+	fade(mark, step.Opacity)
+
+	if !step.Tile {
+		left, top := step.Position(width, height, mark.Rect.Dx(), mark.Rect.Dy())
+		return mark, image.Pt(left, top), nil
+	}
+	layer := image.NewNRGBA(image.Rect(0, 0, width, height))
+	strideX, strideY := mark.Rect.Dx()+step.Margin, mark.Rect.Dy()+step.Margin
+	for y := step.Margin; y < height; y += strideY {
+		for x := step.Margin; x < width; x += strideX {
+			at := image.Pt(x, y)
+			draw.Draw(layer, image.Rectangle{Min: at, Max: at.Add(mark.Rect.Size())}, mark, image.Point{}, draw.Src)
+		}
+	}
+	return layer, image.Point{}, nil
+}
+
+// picture decodes the watermark image and scales it to step.Scale of the
+// image width.
+func picture(step domain.WatermarkStep, width int) (*image.NRGBA, error) {
+	if len(step.ImageData) == 0 {
+		return nil, fmt.Errorf("%w: watermark image %q is not loaded", domain.ErrInvalidOptions, step.Image)
+	}
+	img, _, err := image.Decode(bytes.NewReader(step.ImageData))
+	if err != nil {
+		return nil, fmt.Errorf("%w: watermark image %q: %v", domain.ErrInvalidOptions, step.Image, err)
+	}
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if step.Scale > 0 {
+		w = max(1, int(step.Scale*float64(width)+0.5))
+		h = max(1, int(float64(b.Dy())*float64(w)/float64(b.Dx())+0.5))
+	}
+	mark := image.NewNRGBA(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(mark, mark.Rect, img, b, draw.Src, nil)
+	return mark, nil
+}
+
+// text draws step.Text in its font and colour at the size that makes it
+// step.Scale of the image width.
+func text(step domain.WatermarkStep, width int) (*image.NRGBA, error) {
+	f, err := parse(step.Font)
+	if err != nil {
+		return nil, err
+	}
+	scale := step.Scale
+	if scale == 0 {
+		scale = defaultTextScale
+	}
+
+	// Measure at a reference size, then scale the size to the target width.
+	const reference = 100
+	face, err := opentype.NewFace(f, &opentype.FaceOptions{Size: reference, DPI: 72})
+	if err != nil {
+		return nil, err
+	}
+	advance := font.MeasureString(face, step.Text).Ceil()
+	face.Close()
+	if advance == 0 {
+		return nil, fmt.Errorf("%w: watermark text has no visible characters", domain.ErrInvalidOptions)
+	}
+	size := math.Max(1, reference*scale*float64(width)/float64(advance))
+	face, err = opentype.NewFace(f, &opentype.FaceOptions{Size: size, DPI: 72, Hinting: font.HintingNone})
+	if err != nil {
+		return nil, err
+	}
+	defer face.Close()
+
+	metrics := face.Metrics()
+	w := max(1, font.MeasureString(face, step.Text).Ceil())
+	h := max(1, (metrics.Ascent + metrics.Descent).Ceil())
+	mark := image.NewNRGBA(image.Rect(0, 0, w, h))
+	c := step.TextColor()
+	d := font.Drawer{
+		Dst:  mark,
+		Src:  image.NewUniform(color.NRGBA{R: c.R, G: c.G, B: c.B, A: 0xff}),
+		Face: face,
+		Dot:  fixed.Point26_6{Y: metrics.Ascent},
+	}
+	d.DrawString(step.Text)
+	return mark, nil
+}
+
+// parse returns the named font, parsing it once.
+func parse(name string) (*opentype.Font, error) {
+	if name == "" {
+		name = domain.FontSans
+	}
+	parsedMu.Lock()
+	defer parsedMu.Unlock()
+	if f, ok := parsed[name]; ok {
+		return f, nil
+	}
+	data, ok := fonts[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown watermark font %q", domain.ErrInvalidOptions, name)
+	}
+	f, err := opentype.Parse(data)
+	if err != nil {
+		return nil, err
+	}
+	parsed[name] = f
+	return f, nil
+}
+
+// fit scales mark down to fit within width x height, keeping its aspect
+// ratio.
+func fit(mark *image.NRGBA, width, height int) *image.NRGBA {
+	w, h := mark.Rect.Dx(), mark.Rect.Dy()
+	width, height = max(width, 1), max(height, 1)
+	if w <= width && h <= height {
+		return mark
+	}
+	ratio := math.Min(float64(width)/float64(w), float64(height)/float64(h))
+	out := image.NewNRGBA(image.Rect(0, 0, max(1, int(float64(w)*ratio)), max(1, int(float64(h)*ratio))))
+	draw.CatmullRom.Scale(out, out.Rect, mark, mark.Rect, draw.Src, nil)
+	return out
+}
+
+// fade multiplies the alpha of mark by opacity; 0 leaves it unchanged.
+func fade(mark *image.NRGBA, opacity float64) {
+	if opacity == 0 || opacity == 1 {
+		return
+	}
+	for i := 3; i < len(mark.Pix); i += 4 {
+		mark.Pix[i] = uint8(float64(mark.Pix[i])*opacity + 0.5)
+	}
+}
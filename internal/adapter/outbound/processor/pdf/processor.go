@@ -0,0 +1,101 @@
+//go:build cgo
+
+package pdf
+
+import (
+	"bytes"
+	"cmp"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/andreychano/compressor-golang/internal/config"
+	"github.com/andreychano/compressor-golang/internal/core/domain"
+	"github.com/andreychano/compressor-golang/internal/core/port"
+)
+
+const pdfMimeType = "application/pdf"
+
+// Processor renders a page of a PDF and hands the image to a raster
+// processor for resizing and encoding.
+type Processor struct {
+	cfg       config.PDF
+	raster    port.Processor
+	supported bool
+}
+
+// NewProcessor creates a PDF processor encoding through raster. It supports
+// nothing when libvips was built without a PDF loader.
+func NewProcessor(cfg config.PDF, raster port.Processor) *Processor {
+	return &Processor{
+		cfg:       cfg,
+		raster:    raster,
+		supported: pdfSupported(),
+	}
+}
+
+// Supports reports whether the given MIME type is supported.
+func (p *Processor) Supports(mimeType string) bool {
+	return p.supported && mimeType == pdfMimeType
+}
+
+// SupportsStep reports whether the raster processor can run a pipeline step.
+func (p *Processor) SupportsStep(step domain.Step) bool {
+	return p.raster.SupportsStep(step)
+}
+
+// Process renders page opts.Page at opts.PDF.DPI and encodes it like any
+// other image.
+func (p *Processor) Process(inputFile domain.File, opts domain.Options) (domain.ProcessedFile, error) {
+	dpi := opts.PDF.DPI
+	if dpi == 0 {
+		dpi = p.cfg.DefaultDPI
+	}
+	if dpi > p.cfg.MaxDPI {
+		return domain.ProcessedFile{}, fmt.Errorf("%w: pdf dpi must not exceed %g", domain.ErrInvalidOptions, p.cfg.MaxDPI)
+	}
+
+	if _, err := inputFile.Content.Seek(0, 0); err != nil {
+		return domain.ProcessedFile{}, fmt.Errorf("failed to seek file content: %w", err)
+	}
+	doc, err := io.ReadAll(inputFile.Content)
+	if err != nil {
+		return domain.ProcessedFile{}, fmt.Errorf("cannot read: %w", err)
+	}
+
+	pg, err := openPage(doc, opts.Page, dpi)
+	if errors.Is(err, errPageOutOfRange) {
+		return domain.ProcessedFile{}, fmt.Errorf("%w: page %d does not exist", domain.ErrInvalidOptions, opts.Page)
+	}
+	if errors.Is(err, errCanvas) {
+		return domain.ProcessedFile{}, fmt.Errorf("%w: %v, lower the dpi", domain.ErrInvalidOptions, err)
+	}
+	if err != nil {
+		return domain.ProcessedFile{}, fmt.Errorf("%w: %v", domain.ErrInvalidImage, err)
+	}
+	defer pg.close()
+
+	if pg.pages > p.cfg.MaxPages {
+		return domain.ProcessedFile{}, fmt.Errorf("%w: pdf has %d pages, limit is %d", domain.ErrInvalidImage, pg.pages, p.cfg.MaxPages)
+	}
+
+	rendered, err := pg.render(p.cfg.RenderTimeout)
+	if err != nil {
+		return domain.ProcessedFile{}, err
+	}
+
+	// The page is now a plain image; the page number must not be applied again.
+	opts.Page = 0
+	if len(opts.Pipeline) > 0 {
+		opts.Pipeline = opts.Pipeline.WithEncodeDefaults("png", 0)
+	} else {
+		opts.Format = cmp.Or(opts.Format, "png")
+	}
+
+	return p.raster.Process(domain.File{
+		Content:  bytes.NewReader(rendered),
+		MimeType: "image/png",
+		Size:     int64(len(rendered)),
+		Name:     inputFile.Name,
+	}, opts)
+}
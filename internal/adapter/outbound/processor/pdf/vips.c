@@ -0,0 +1,40 @@
+#include "vips.h"
+
+int compressor_pdf_supported(void) {
+	return vips_type_find("VipsOperation", "pdfload_buffer") != 0;
+}
+
+int compressor_pdfload(const void *buf, size_t len, int page, double dpi, double max_pixels, int *pages, VipsImage **out) {
+	VipsImage *image;
+	if (vips_pdfload_buffer((void *) buf, len, &image, "dpi", dpi, NULL)) {
+		return -1;
+	}
+	*pages = vips_image_get_n_pages(image);
+	if (page > 0) {
+		g_object_unref(image);
+		if (page >= *pages) {
+			vips_error("compressor", "page %d out of range, document has %d", page, *pages);
+			return COMPRESSOR_ERROR_PAGE;
+		}
+		if (vips_pdfload_buffer((void *) buf, len, &image, "page", page, "dpi", dpi, NULL)) {
+			return -1;
+		}
+	}
+
+	/* Loading only reads the page size; pixels are rendered by pngsave. */
+	if ((double) image->Xsize * image->Ysize > max_pixels) {
+		vips_error("compressor", "a %dx%d page exceeds %.0f pixels", image->Xsize, image->Ysize, max_pixels);
+		g_object_unref(image);
+		return COMPRESSOR_ERROR_CANVAS;
+	}
+	*out = image;
+	return 0;
+}
+
+int compressor_pdf_pngsave(VipsImage *image, void **out, size_t *out_len) {
+	return vips_pngsave_buffer(image, out, out_len, "compression", 1, NULL);
+}
+
+void compressor_kill(VipsImage *image) {
+	vips_image_set_kill(image, TRUE);
+}
@@ -0,0 +1,137 @@
+//go:build cgo
+
+package pdf
+
+/*
+#cgo pkg-config: vips
+#include "vips.h"
+*/
+import "C"
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unsafe"
+
+	"github.com/andreychano/compressor-golang/internal/core/domain"
+	"github.com/h2non/bimg"
+)
+
+// Errors openPage returns for pages that cannot be rendered as asked.
+var (
+	errPageOutOfRange = errors.New("page out of range")
+	errCanvas         = errors.New("page too large")
+)
+
+// pdfSupported reports whether libvips was built with poppler or PDFium.
+func pdfSupported() bool {
+	bimg.Initialize()
+	return C.compressor_pdf_supported() != 0
+}
+
+// page is one opened, not yet rendered, page of a document.
+type page struct {
+	buf   unsafe.Pointer // C copy of the document; libvips reads it lazily
+	image *C.VipsImage
+	pages int // Pages in the document
+	// detached is set when a timed out render was left to free the page
+	// once libvips gives up.
+	detached bool
+}
+
+// openPage parses the document and prepares page n for rendering at dpi.
+// Pages over domain.MaxCanvasPixels at dpi return errCanvas. The caller must
+// close the page.
+func openPage(doc []byte, n int, dpi float64) (*page, error) {
+	if len(doc) == 0 {
+		return nil, errors.New("document is empty")
+	}
+	defer C.vips_thread_shutdown()
+
+	p := &page{buf: C.CBytes(doc)}
+	var pages C.int
+	code := C.compressor_pdfload(p.buf, C.size_t(len(doc)), C.int(n), C.double(dpi), C.double(domain.MaxCanvasPixels), &pages, &p.image)
+	p.pages = int(pages)
+	if code == 0 {
+		return p, nil
+	}
+
+	C.free(p.buf)
+	switch code {
+	case C.COMPRESSOR_ERROR_PAGE:
+		C.vips_error_clear()
+		return nil, errPageOutOfRange
+	case C.COMPRESSOR_ERROR_CANVAS:
+		err := vipsError()
+		return nil, fmt.Errorf("%w: %v", errCanvas, err)
+	default:
+		return nil, vipsError()
+	}
+}
+
+// render rasterizes the page to PNG. Past timeout it returns ErrTimeout at
+// once; the renderer is told to stop, which poppler only notices between
+// tiles, and frees the page when it does.
+func (p *page) render(timeout time.Duration) ([]byte, error) {
+	type result struct {
+		buf []byte
+		err error
+	}
+	done := make(chan result, 1)
+
+	go func() {
+		defer C.vips_thread_shutdown()
+
+		var (
+			out    unsafe.Pointer
+			outLen C.size_t
+		)
+		if C.compressor_pdf_pngsave(p.image, &out, &outLen) != 0 {
+			done <- result{err: vipsError()}
+			return
+		}
+		defer C.g_free(C.gpointer(out))
+		done <- result{buf: C.GoBytes(out, C.int(outLen))}
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case r := <-done:
+		return r.buf, r.err
+	case <-timer.C:
+		// The renderer still holds the image, so it closes the page.
+		C.compressor_kill(p.image)
+		p.detached = true
+		go func() {
+			<-done
+			p.free()
+		}()
+		return nil, fmt.Errorf("%w: rendering took longer than %s", domain.ErrTimeout, timeout)
+	}
+}
+
+// close frees the page unless a timed out render does so.
+func (p *page) close() {
+	if !p.detached {
+		p.free()
+	}
+}
+
+func (p *page) free() {
+	C.g_object_unref(C.gpointer(p.image))
+	C.free(p.buf)
+}
+
+// vipsError takes the pending libvips error message.
+func vipsError() error {
+	msg := strings.TrimSpace(C.GoString(C.vips_error_buffer()))
+	C.vips_error_clear()
+	if msg == "" {
+		msg = "unknown libvips error"
+	}
+	return errors.New(msg)
+}
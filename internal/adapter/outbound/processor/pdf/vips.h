@@ -0,0 +1,25 @@
+#include <stdlib.h>
+#include <vips/vips.h>
+
+/* Returns non-zero when libvips was built with a PDF loader. */
+int compressor_pdf_supported(void);
+
+/* Returned by compressor_pdfload when page is past the last page. */
+#define COMPRESSOR_ERROR_PAGE -2
+
+/* Returned by compressor_pdfload when the page would render to more than
+ * max_pixels pixels. */
+#define COMPRESSOR_ERROR_CANVAS -4
+
+/* Opens page of a PDF held in buf at dpi into *out, without rendering it yet.
+ * *pages gets the page count, also when page is out of range. Returns
+ * non-zero on error. buf must outlive *out. */
+int compressor_pdfload(const void *buf, size_t len, int page, double dpi, double max_pixels, int *pages, VipsImage **out);
+
+/* Renders image and encodes it as lossless PNG. Returns non-zero on error,
+ * including when compressor_kill was called; the caller frees *out with
+ * g_free. */
+int compressor_pdf_pngsave(VipsImage *image, void **out, size_t *out_len);
+
+/* Makes a running compressor_pdf_pngsave on image fail as soon as possible. */
+void compressor_kill(VipsImage *image);
@@ -0,0 +1,99 @@
+//go:build cgo
+
+package pdf
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image/png"
+	"testing"
+	"time"
+
+	"github.com/andreychano/compressor-golang/internal/core/domain"
+)
+
+// document builds a one-page PDF of a filled 595x842 point (A4) rectangle.
+func document() []byte {
+	content := "0.2 0.4 0.8 rg 0 0 595 842 re f"
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 595 842] /Contents 4 0 R >>",
+		fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", len(content), content),
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, obj := range objects {
+		offsets[i] = buf.Len()
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", i+1, obj)
+	}
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+	return buf.Bytes()
+}
+
+func TestRender(t *testing.T) {
+	if !pdfSupported() {
+		t.Skip("libvips has no PDF loader")
+	}
+
+	pg, err := openPage(document(), 0, 72)
+	if err != nil {
+		t.Fatalf("openPage: %v", err)
+	}
+	defer pg.close()
+
+	out, err := pg.render(time.Minute)
+	if err != nil {
+		t.Fatalf("render: %v", err)
+	}
+	img, err := png.Decode(bytes.NewReader(out))
+	if err != nil {
+		t.Fatalf("decode output: %v", err)
+	}
+	if b := img.Bounds(); b.Dx() != 595 || b.Dy() != 842 {
+		t.Errorf("got %dx%d, want 595x842", b.Dx(), b.Dy())
+	}
+}
+
+func TestRender_Timeout(t *testing.T) {
+	if !pdfSupported() {
+		t.Skip("libvips has no PDF loader")
+	}
+
+	// At 1000 dpi the page is about 8300x11700 pixels, far too many to
+	// render before an expired timer fires.
+	pg, err := openPage(document(), 0, 1000)
+	if err != nil {
+		t.Fatalf("openPage: %v", err)
+	}
+
+	start := time.Now()
+	_, err = pg.render(0)
+	if !errors.Is(err, domain.ErrTimeout) {
+		t.Fatalf("got %v, want ErrTimeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("render returned after %s, want at once", elapsed)
+	}
+	// The killed renderer frees the page; closing must not free it again.
+	pg.close()
+}
+
+func TestOpenPage_Canvas(t *testing.T) {
+	if !pdfSupported() {
+		t.Skip("libvips has no PDF loader")
+	}
+
+	// At 1200 dpi the page is about 9900x14000 pixels.
+	if _, err := openPage(document(), 0, 1200); !errors.Is(err, errCanvas) {
+		t.Fatalf("got %v, want errCanvas", err)
+	}
+}
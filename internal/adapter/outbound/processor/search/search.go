@@ -0,0 +1,169 @@
+// Package search finds encoder settings that meet a size or quality target.
+// It drives any encoder through EncodeFunc, so processors share one search.
+package search
+
+import (
+	"fmt"
+	"image"
+
+	"github.com/andreychano/compressor-golang/internal/core/domain"
+	"github.com/andreychano/compressor-golang/internal/metrics"
+)
+
+const (
+	// MinQuality is the lowest quality tried before dimensions are reduced.
+	MinQuality = 10
+	// scaleStep shrinks both dimensions when no quality fits the target.
+	scaleStep = 0.8
+	// minSide stops the dimension search, smaller images are useless.
+	minSide = 16
+)
+
+// EncodeFunc encodes one source image at quality; zero width and height keep
+// the source dimensions.
+type EncodeFunc func(quality, width, height int) ([]byte, error)
+
+// DecodeFunc turns an encoded buffer back into pixels for measurement.
+type DecodeFunc func(buf []byte) (image.Image, error)
+
+// Target finds the highest quality that keeps the output within targetBytes,
+// stepping the dimensions down from width x height, or the source size when
+// they are zero, when even MinQuality is too large. maxQuality caps the
+// search; zero means 100. It also returns the width and height the output
+// was encoded at, which are only the requested ones when no step was needed.
+func Target(encode EncodeFunc, source image.Point, targetBytes int64, maxQuality, width, height int) ([]byte, int, image.Point, error) {
+	if maxQuality <= 0 || maxQuality > 100 {
+		maxQuality = 100
+	}
+
+	for {
+		buf, quality, err := searchQuality(encode, targetBytes, maxQuality, width, height)
+		if err != nil {
+			return nil, 0, image.Point{}, err
+		}
+		if buf != nil {
+			return buf, quality, image.Pt(width, height), nil
+		}
+
+		if width == 0 && height == 0 {
+			width, height = source.X, source.Y
+		}
+
+		width = int(float64(width) * scaleStep)
+		height = int(float64(height) * scaleStep)
+		if width < minSide || height < minSide {
+			return nil, 0, image.Point{}, fmt.Errorf("%w: %d bytes", domain.ErrTargetUnreachable, targetBytes)
+		}
+	}
+}
+
+// searchQuality binary-searches quality in [MinQuality, maxQuality] at fixed
+// dimensions. It returns a nil buffer when nothing fits.
+func searchQuality(encode EncodeFunc, targetBytes int64, maxQuality, width, height int) ([]byte, int, error) {
+	best, err := encode(maxQuality, width, height)
+	if err != nil {
+		return nil, 0, err
+	}
+	if int64(len(best)) <= targetBytes {
+		return best, maxQuality, nil
+	}
+
+	lowest := min(MinQuality, maxQuality)
+	best, err = encode(lowest, width, height)
+	if err != nil {
+		return nil, 0, err
+	}
+	if int64(len(best)) > targetBytes {
+		return nil, 0, nil
+	}
+
+	bestQuality := lowest
+	lo, hi := lowest+1, maxQuality-1
+	for lo <= hi {
+		mid := (lo + hi) / 2
+
+		buf, err := encode(mid, width, height)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		if int64(len(buf)) <= targetBytes {
+			best, bestQuality = buf, mid
+			lo = mid + 1
+		} else {
+			hi = mid - 1
+		}
+	}
+
+	return best, bestQuality, nil
+}
+
+// SSIM finds the lowest quality in [MinQuality, maxQuality] whose output
+// still scores targetSSIM against reference, the source at the output
+// dimensions. When no quality reaches the target, the maxQuality encoding is
+// returned.
+func SSIM(encode EncodeFunc, decode DecodeFunc, reference image.Image, targetSSIM float64, maxQuality, width, height int) ([]byte, int, float64, error) {
+	if maxQuality <= 0 || maxQuality > 100 {
+		maxQuality = 100
+	}
+
+	score := func(quality int) ([]byte, float64, error) {
+		buf, err := encode(quality, width, height)
+		if err != nil {
+			return nil, 0, err
+		}
+		decoded, err := decode(buf)
+		if err != nil {
+			return nil, 0, err
+		}
+		ssim, err := metrics.SSIM(reference, decoded)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to compute ssim: %w", err)
+		}
+		return buf, ssim, nil
+	}
+
+	best, bestScore, err := score(maxQuality)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	bestQuality := maxQuality
+	if bestScore < targetSSIM {
+		return best, bestQuality, bestScore, nil
+	}
+
+	lo, hi := min(MinQuality, maxQuality), maxQuality-1
+	for lo <= hi {
+		mid := (lo + hi) / 2
+
+		buf, s, err := score(mid)
+		if err != nil {
+			return nil, 0, 0, err
+		}
+
+		if s >= targetSSIM {
+			best, bestQuality, bestScore = buf, mid, s
+			hi = mid - 1
+		} else {
+			lo = mid + 1
+		}
+	}
+
+	return best, bestQuality, bestScore, nil
+}
+
+// Report measures output against reference. Sizes and dimensions are left
+// for the caller to fill.
+func Report(reference, output image.Image) (*domain.Report, error) {
+	ssim, err := metrics.SSIM(reference, output)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute ssim: %w", err)
+	}
+
+	psnr, err := metrics.PSNR(reference, output)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute psnr: %w", err)
+	}
+
+	return &domain.Report{PSNR: psnr, SSIM: ssim}, nil
+}
@@ -0,0 +1,198 @@
+package search
+
+import (
+	"cmp"
+	"errors"
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/andreychano/compressor-golang/internal/core/domain"
+)
+
+// source is the size of the image the fake encoders compress.
+var source = image.Point{X: 100, Y: 100}
+
+// call is one invocation of a fake encoder.
+type call struct {
+	quality, width, height int
+}
+
+// sizedEncoder fakes an encoder whose output grows with quality and pixels:
+// quality bytes per 100 pixels. It records every call.
+func sizedEncoder(calls *[]call) EncodeFunc {
+	return func(quality, width, height int) ([]byte, error) {
+		*calls = append(*calls, call{quality, width, height})
+		if width == 0 && height == 0 {
+			width, height = source.X, source.Y
+		}
+		return make([]byte, quality*width*height/100), nil
+	}
+}
+
+func TestTarget(t *testing.T) {
+	tests := []struct {
+		name        string
+		targetBytes int64
+		maxQuality  int
+		wantQuality int
+		wantSize    int
+		wantWidth   int // Of the last encode; 0 = source size
+	}{
+		{name: "max quality fits", targetBytes: 10000, maxQuality: 80, wantQuality: 80, wantSize: 8000},
+		{name: "zero max quality", targetBytes: 100000, maxQuality: 0, wantQuality: 100, wantSize: 10000},
+		{name: "binary search", targetBytes: 5050, maxQuality: 100, wantQuality: 50, wantSize: 5000},
+		{name: "exactly min quality", targetBytes: 1000, maxQuality: 100, wantQuality: MinQuality, wantSize: 1000},
+		// 10 bytes per 100 pixels at MinQuality: 80x80 is still too large,
+		// 64x64 at quality 14 fits.
+		{name: "below min quality", targetBytes: 600, maxQuality: 100, wantQuality: 14, wantSize: 573, wantWidth: 64},
+		// Quality 5 caps the search and also becomes the lowest quality tried.
+		{name: "max below min quality", targetBytes: 400, maxQuality: 5, wantQuality: 5, wantSize: 320, wantWidth: 80},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls []call
+			buf, quality, size, err := Target(sizedEncoder(&calls), source, tt.targetBytes, tt.maxQuality, 0, 0)
+			if err != nil {
+				t.Fatalf("Target: %v", err)
+			}
+			if quality != tt.wantQuality || len(buf) != tt.wantSize {
+				t.Errorf("got quality %d, %d bytes, want quality %d, %d bytes", quality, len(buf), tt.wantQuality, tt.wantSize)
+			}
+			if last := calls[len(calls)-1]; last.width != tt.wantWidth || size.X != tt.wantWidth {
+				t.Errorf("last encode at width %d, returned %d, want %d", last.width, size.X, tt.wantWidth)
+			}
+			ceiling := cmp.Or(tt.maxQuality, 100)
+			for _, c := range calls {
+				if c.quality > ceiling || c.quality < min(MinQuality, ceiling) {
+					t.Errorf("encoded at quality %d, outside %d..%d", c.quality, min(MinQuality, ceiling), ceiling)
+				}
+			}
+		})
+	}
+}
+
+func TestTarget_StepsDownFromRequestedSize(t *testing.T) {
+	var calls []call
+	// 40x20 at MinQuality is 80 bytes; 32x16 is 51.
+	_, _, size, err := Target(sizedEncoder(&calls), source, 60, 100, 40, 20)
+	if err != nil {
+		t.Fatalf("Target: %v", err)
+	}
+	if last := calls[len(calls)-1]; last.width != 32 || last.height != 16 {
+		t.Errorf("last encode at %dx%d, want 32x16", last.width, last.height)
+	}
+	if size != image.Pt(32, 16) {
+		t.Errorf("returned %v, want 32x16", size)
+	}
+}
+
+func TestTarget_Unreachable(t *testing.T) {
+	var calls []call
+	_, _, _, err := Target(sizedEncoder(&calls), source, 1, 100, 0, 0)
+	if !errors.Is(err, domain.ErrTargetUnreachable) {
+		t.Fatalf("got %v, want ErrTargetUnreachable", err)
+	}
+	if last := calls[len(calls)-1]; last.width < minSide || last.height < minSide {
+		t.Errorf("encoded at %dx%d, below the %d pixel minimum", last.width, last.height, minSide)
+	}
+}
+
+func TestTarget_EncodeError(t *testing.T) {
+	failed := errors.New("encoder failed")
+	encode := func(quality, width, height int) ([]byte, error) {
+		return nil, failed
+	}
+	if _, _, _, err := Target(encode, source, 1000, 80, 0, 0); !errors.Is(err, failed) {
+		t.Fatalf("got %v, want the encoder error", err)
+	}
+}
+
+// reference is a gradient the fake codec degrades.
+func reference() *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, 32, 32))
+	for y := range 32 {
+		for x := range 32 {
+			img.SetGray(x, y, color.Gray{Y: uint8(x*6 + y*2)})
+		}
+	}
+	return img
+}
+
+// fadingCodec fakes a codec whose output loses contrast as quality drops: the
+// buffer holds the quality, decoding blends the reference towards grey by
+// (100-quality)%.
+func fadingCodec(calls *[]call) (EncodeFunc, DecodeFunc) {
+	ref := reference()
+	encode := func(quality, width, height int) ([]byte, error) {
+		*calls = append(*calls, call{quality, width, height})
+		return []byte{byte(quality)}, nil
+	}
+	decode := func(buf []byte) (image.Image, error) {
+		quality := int(buf[0])
+		out := image.NewGray(ref.Rect)
+		for i, v := range ref.Pix {
+			out.Pix[i] = uint8((int(v)*quality + 128*(100-quality)) / 100)
+		}
+		return out, nil
+	}
+	return encode, decode
+}
+
+func TestSSIM(t *testing.T) {
+	var calls []call
+	encode, decode := fadingCodec(&calls)
+
+	// The lowest quality reaching the target, checked against its neighbour.
+	_, quality, score, err := SSIM(encode, decode, reference(), 0.95, 90, 0, 0)
+	if err != nil {
+		t.Fatalf("SSIM: %v", err)
+	}
+	if score < 0.95 || quality >= 90 || quality < MinQuality {
+		t.Fatalf("got quality %d scoring %.4f, want the lowest reaching 0.95 below 90", quality, score)
+	}
+	_, _, below, _ := SSIM(encode, decode, reference(), 1.01, quality-1, 0, 0)
+	if below >= 0.95 {
+		t.Errorf("quality %d also scores %.4f, want the lowest quality", quality-1, below)
+	}
+	for _, c := range calls {
+		if c.quality > 90 {
+			t.Errorf("encoded at quality %d above the maximum 90", c.quality)
+		}
+	}
+}
+
+func TestSSIM_Unreachable(t *testing.T) {
+	var calls []call
+	encode, decode := fadingCodec(&calls)
+
+	// Nothing reaches 1.01: the maxQuality encoding comes back.
+	_, quality, score, err := SSIM(encode, decode, reference(), 1.01, 70, 0, 0)
+	if err != nil {
+		t.Fatalf("SSIM: %v", err)
+	}
+	if quality != 70 || score >= 1 {
+		t.Errorf("got quality %d scoring %.4f, want 70", quality, score)
+	}
+	if len(calls) != 1 {
+		t.Errorf("encoded %d times, want once", len(calls))
+	}
+}
+
+func TestSSIM_MaxBelowMinQuality(t *testing.T) {
+	var calls []call
+	encode, decode := fadingCodec(&calls)
+
+	_, quality, _, err := SSIM(encode, decode, reference(), 0.01, 5, 0, 0)
+	if err != nil {
+		t.Fatalf("SSIM: %v", err)
+	}
+	if quality > 5 {
+		t.Errorf("got quality %d, want at most 5", quality)
+	}
+	for _, c := range calls {
+		if c.quality > 5 {
+			t.Errorf("encoded at quality %d above the maximum 5", c.quality)
+		}
+	}
+}
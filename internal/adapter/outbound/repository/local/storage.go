@@ -2,15 +2,21 @@ package local
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/andreychano/compressor-golang/internal/adapter/outbound/repository/local/pathvalidator"
 	"github.com/andreychano/compressor-golang/internal/core/domain"
 )
 
+// metaSuffix replaces the file extension to form the sidecar metadata path,
+// so compressed/<id>.webp is described by compressed/<id>.meta.json.
+const metaSuffix = ".meta.json"
+
 type LocalFileStorage struct {
 	basePath      string
 	pathValidator *pathvalidator.Validator
@@ -87,3 +93,65 @@ func (s *LocalFileStorage) Get(ctx context.Context, relativePath string) (domain
 		MimeType: "application/octet-stream",
 	}, nil
 }
+
+// Delete removes the file at relativePath.
+func (s *LocalFileStorage) Delete(ctx context.Context, relativePath string) error {
+	if err := s.pathValidator.Validate(relativePath); err != nil {
+		return fmt.Errorf("access denied: invalid path")
+	}
+
+	if err := os.Remove(filepath.Join(s.basePath, relativePath)); err != nil {
+		return fmt.Errorf("failed to delete file: %w", err)
+	}
+
+	return nil
+}
+
+// SaveMeta writes the metadata record of the file at relativePath as a JSON sidecar.
+func (s *LocalFileStorage) SaveMeta(ctx context.Context, meta domain.FileMeta, relativePath string) error {
+	metaPath := sidecarPath(relativePath)
+	if err := s.pathValidator.Validate(metaPath); err != nil {
+		return fmt.Errorf("access denied: invalid path")
+	}
+
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode metadata: %w", err)
+	}
+
+	fullPath := filepath.Join(s.basePath, metaPath)
+	if err := os.MkdirAll(filepath.Dir(fullPath), 0o755); err != nil {
+		return fmt.Errorf("failed to create directory %s: %w", filepath.Dir(fullPath), err)
+	}
+
+	if err := os.WriteFile(fullPath, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write metadata: %w", err)
+	}
+
+	return nil
+}
+
+// Stat reads the metadata sidecar of the file at relativePath. The path may
+// omit the file extension.
+func (s *LocalFileStorage) Stat(ctx context.Context, relativePath string) (domain.FileMeta, error) {
+	metaPath := sidecarPath(relativePath)
+	if err := s.pathValidator.Validate(metaPath); err != nil {
+		return domain.FileMeta{}, err
+	}
+
+	data, err := os.ReadFile(filepath.Join(s.basePath, metaPath))
+	if err != nil {
+		return domain.FileMeta{}, fmt.Errorf("failed to read metadata: %w", err)
+	}
+
+	var meta domain.FileMeta
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return domain.FileMeta{}, fmt.Errorf("failed to decode metadata: %w", err)
+	}
+
+	return meta, nil
+}
+
+func sidecarPath(relativePath string) string {
+	return strings.TrimSuffix(relativePath, filepath.Ext(relativePath)) + metaSuffix
+}
@@ -16,6 +16,11 @@ type Config struct {
 	HTTP    HTTP    `mapstructure:"http" yaml:"http" validate:"required"`
 	Storage Storage `mapstructure:"storage" yaml:"storage" validate:"required"`
 	Image   Image   `mapstructure:"image" yaml:"image" validate:"required"`
+	PDF     PDF     `mapstructure:"pdf" yaml:"pdf" validate:"required"`
+	Color   Color   `mapstructure:"color" yaml:"color"`
+
+	// Presets are named option sets requests select with the preset field.
+	Presets map[string]Preset `mapstructure:"presets" yaml:"presets" validate:"dive"`
 }
 
 // LoggerConfig оборачивает gotoolslog.Config и добавляет env-теги
@@ -60,11 +65,82 @@ type Storage struct {
 }
 
 type Image struct {
-	DefaultFormat  string   `mapstructure:"default_format" yaml:"default_format" validate:"required,oneof=jpeg png webp"`
+	DefaultFormat  string   `mapstructure:"default_format" yaml:"default_format" validate:"required,oneof=jpeg png webp avif"`
 	DefaultQuality int      `mapstructure:"default_quality" yaml:"default_quality" validate:"min=1,max=100"`
 	MaxWidth       int      `mapstructure:"max_width" yaml:"max_width" validate:"min=100"`
 	MaxHeight      int      `mapstructure:"max_height" yaml:"max_height" validate:"min=100"`
 	AllowFormats   []string `mapstructure:"allow_formats" yaml:"allow_formats" validate:"required"`
+	// MinSavingsPercent is the smallest size reduction worth keeping; below it
+	// the original is returned when the format allows.
+	MinSavingsPercent float64 `mapstructure:"min_savings_percent" yaml:"min_savings_percent" validate:"min=0,max=100"`
+	// MaxPixels rejects larger inputs before the pure-Go processor decodes
+	// them; 0 means 100 megapixels.
+	MaxPixels int `mapstructure:"max_pixels" yaml:"max_pixels" validate:"min=0"`
+	// Metadata is the default metadata policy: strip, icc, copyright or no_private.
+	Metadata string `mapstructure:"metadata" yaml:"metadata" validate:"omitempty,oneof=strip icc copyright no_private"`
+}
+
+// Preset is a named set of options. Zero fields leave the request value or
+// the image defaults in place.
+type Preset struct {
+	Format    string `mapstructure:"format" yaml:"format" validate:"omitempty,oneof=jpeg png webp avif gif"`
+	Quality   int    `mapstructure:"quality" yaml:"quality" validate:"min=0,max=100"`
+	MaxWidth  int    `mapstructure:"max_width" yaml:"max_width" validate:"min=0"`
+	MaxHeight int    `mapstructure:"max_height" yaml:"max_height" validate:"min=0"`
+	Metadata  string `mapstructure:"metadata" yaml:"metadata" validate:"omitempty,oneof=strip icc copyright no_private"`
+	// Filters run after resizing unless the request sends its own.
+	Filters []Filter `mapstructure:"filters" yaml:"filters" validate:"max=8,dive"`
+	// Watermark is drawn over every image made with the preset, whatever
+	// the request asks for.
+	Watermark *Watermark `mapstructure:"watermark" yaml:"watermark"`
+}
+
+// Filter is one image filter. See domain.Filter for the fields.
+type Filter struct {
+	Op         string  `mapstructure:"op" yaml:"op" validate:"oneof=blur sharpen grayscale adjust gamma tint"`
+	Sigma      float64 `mapstructure:"sigma" yaml:"sigma"`
+	Amount     float64 `mapstructure:"amount" yaml:"amount"`
+	Brightness float64 `mapstructure:"brightness" yaml:"brightness"`
+	Contrast   float64 `mapstructure:"contrast" yaml:"contrast"`
+	Saturation float64 `mapstructure:"saturation" yaml:"saturation"`
+	Gamma      float64 `mapstructure:"gamma" yaml:"gamma"`
+	Color      string  `mapstructure:"color" yaml:"color"`
+}
+
+// Watermark is an image from the storage or a line of text drawn over the
+// output. See domain.WatermarkStep for the fields.
+type Watermark struct {
+	Image   string  `mapstructure:"image" yaml:"image" validate:"required_without=Text"`
+	Text    string  `mapstructure:"text" yaml:"text" validate:"required_without=Image"`
+	Font    string  `mapstructure:"font" yaml:"font" validate:"omitempty,oneof=sans sans-bold sans-italic mono mono-bold"`
+	Color   string  `mapstructure:"color" yaml:"color"`
+	Opacity float64 `mapstructure:"opacity" yaml:"opacity" validate:"min=0,max=1"`
+	Gravity string  `mapstructure:"gravity" yaml:"gravity"`
+	Margin  int     `mapstructure:"margin" yaml:"margin" validate:"min=0"`
+	Scale   float64 `mapstructure:"scale" yaml:"scale" validate:"min=0,max=1"`
+	Tile    bool    `mapstructure:"tile" yaml:"tile"`
+}
+
+// PDF configures rendering of PDF pages to images.
+type PDF struct {
+	DefaultDPI float64 `mapstructure:"default_dpi" yaml:"default_dpi" validate:"min=1"`
+	MaxDPI     float64 `mapstructure:"max_dpi" yaml:"max_dpi" validate:"gtefield=DefaultDPI,max=1200"`
+	// MaxPages rejects documents with more pages, whichever page is requested.
+	MaxPages int `mapstructure:"max_pages" yaml:"max_pages" validate:"min=1"`
+	// RenderTimeout fails requests whose page renders longer; the renderer
+	// stops in the background once poppler notices.
+	RenderTimeout time.Duration `mapstructure:"render_timeout" yaml:"render_timeout" validate:"min=100ms"`
+}
+
+// Color configures colour management of raster output.
+type Color struct {
+	// Profile is the ICC profile output pixels are converted to: "srgb" or
+	// "p3", both built into libvips, or the path of an ICC file. Empty means
+	// srgb. Outputs that cannot carry a profile are always sRGB.
+	Profile string `mapstructure:"profile" yaml:"profile"`
+	// EmbedProfile attaches the profile to the output. Profiles other than
+	// sRGB are attached regardless.
+	EmbedProfile bool `mapstructure:"embed_profile" yaml:"embed_profile"`
 }
 
 func (c *Config) Validate() error {
@@ -0,0 +1,37 @@
+package domain
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Color is an 8-bit sRGB colour.
+type Color struct {
+	R, G, B uint8
+}
+
+// White is the background transparent pixels are flattened onto by default.
+var White = Color{R: 255, G: 255, B: 255}
+
+// ParseColor parses a hex colour such as "#fa0" or "#ffaa00"; the leading
+// '#' is optional.
+func ParseColor(hex string) (Color, error) {
+	s := strings.TrimPrefix(hex, "#")
+	if len(s) == 3 {
+		s = string([]byte{s[0], s[0], s[1], s[1], s[2], s[2]})
+	}
+	if len(s) != 6 {
+		return Color{}, fmt.Errorf("%w: color %q must be #rgb or #rrggbb", ErrInvalidOptions, hex)
+	}
+	v, err := strconv.ParseUint(s, 16, 32)
+	if err != nil {
+		return Color{}, fmt.Errorf("%w: color %q must be #rgb or #rrggbb", ErrInvalidOptions, hex)
+	}
+	return Color{R: uint8(v >> 16), G: uint8(v >> 8), B: uint8(v)}, nil
+}
+
+// String returns the colour as "#rrggbb".
+func (c Color) String() string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
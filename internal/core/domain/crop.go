@@ -0,0 +1,139 @@
+package domain
+
+import (
+	"fmt"
+	"math"
+)
+
+// Gravity names the part of the image a crop keeps.
+type Gravity string
+
+const (
+	GravityCenter    Gravity = "center"
+	GravityNorth     Gravity = "n"
+	GravityNorthEast Gravity = "ne"
+	GravityEast      Gravity = "e"
+	GravitySouthEast Gravity = "se"
+	GravitySouth     Gravity = "s"
+	GravitySouthWest Gravity = "sw"
+	GravityWest      Gravity = "w"
+	GravityNorthWest Gravity = "nw"
+	// GravitySmart keeps the most interesting part, as CropOptions.Strategy
+	// defines it.
+	GravitySmart Gravity = "smart"
+)
+
+// Smart crop strategies.
+const (
+	StrategyAttention = "attention" // Skin tones, saturated colour and edges
+	StrategyEntropy   = "entropy"   // The most detailed region
+)
+
+// gravityPoints places each compass gravity as a focal point.
+var gravityPoints = map[Gravity]FocalPoint{
+	"":               {0.5, 0.5},
+	GravityCenter:    {0.5, 0.5},
+	GravityNorth:     {0.5, 0},
+	GravityNorthEast: {1, 0},
+	GravityEast:      {1, 0.5},
+	GravitySouthEast: {1, 1},
+	GravitySouth:     {0.5, 1},
+	GravitySouthWest: {0, 1},
+	GravityWest:      {0, 0.5},
+	GravityNorthWest: {0, 0},
+}
+
+// FocalPoint is a point of an image in fractions of its width and height,
+// from the top left.
+type FocalPoint struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+}
+
+// CropOptions makes the output exactly Width x Height by scaling the image
+// to cover the box and cutting off the overflow. Images are not enlarged: a
+// smaller image is cut to the largest box of the same aspect ratio instead.
+type CropOptions struct {
+	Width   int     `json:"width,omitempty"`
+	Height  int     `json:"height,omitempty"`
+	Gravity Gravity `json:"gravity,omitempty"` // Part kept, default center
+	// Strategy picks what smart gravity keeps: "attention" (default) or
+	// "entropy".
+	Strategy string `json:"strategy,omitempty"`
+	// Focus centres the crop on a point, such as a face position callers
+	// store per image, as far as the edges allow. It excludes Gravity.
+	Focus *FocalPoint `json:"focus,omitempty"`
+}
+
+// Enabled reports whether a crop is requested.
+func (o CropOptions) Enabled() bool {
+	return o.Width > 0 && o.Height > 0
+}
+
+// Smart reports whether the crop picks its region from the image content.
+func (o CropOptions) Smart() bool {
+	return o.Gravity == GravitySmart
+}
+
+// Validate checks crop option values and combinations.
+func (o CropOptions) Validate() error {
+	if o.Width < 0 || o.Height < 0 {
+		return fmt.Errorf("%w: crop size must not be negative", ErrInvalidOptions)
+	}
+	if !o.Enabled() {
+		if o.Width != 0 || o.Height != 0 || o.Gravity != "" || o.Strategy != "" || o.Focus != nil {
+			return fmt.Errorf("%w: crop needs both width and height", ErrInvalidOptions)
+		}
+		return nil
+	}
+	if _, ok := gravityPoints[o.Gravity]; !ok && !o.Smart() {
+		return fmt.Errorf("%w: crop gravity must be center, n, ne, e, se, s, sw, w, nw or smart", ErrInvalidOptions)
+	}
+	switch o.Strategy {
+	case "", StrategyAttention, StrategyEntropy:
+	default:
+		return fmt.Errorf("%w: crop strategy must be attention or entropy", ErrInvalidOptions)
+	}
+	if o.Strategy != "" && !o.Smart() {
+		return fmt.Errorf("%w: crop strategy needs smart gravity", ErrInvalidOptions)
+	}
+	if o.Focus != nil {
+		if o.Gravity != "" {
+			return fmt.Errorf("%w: crop focus and gravity are mutually exclusive", ErrInvalidOptions)
+		}
+		if o.Focus.X < 0 || o.Focus.X > 1 || o.Focus.Y < 0 || o.Focus.Y > 1 {
+			return fmt.Errorf("%w: crop focus must be in 0..1", ErrInvalidOptions)
+		}
+	}
+	return nil
+}
+
+// Window returns the size an upright width x height image is scaled to, and
+// the size of the crop taken out of it.
+func (o CropOptions) Window(width, height int) (scaledW, scaledH, cropW, cropH int) {
+	scale := max(float64(o.Width)/float64(width), float64(o.Height)/float64(height))
+	if scale >= 1 {
+		// Keep the pixels and shrink the box to fit instead.
+		return width, height, min(width, max(1, round(float64(o.Width)/scale))), min(height, max(1, round(float64(o.Height)/scale)))
+	}
+	scaledW = max(o.Width, round(float64(width)*scale))
+	scaledH = max(o.Height, round(float64(height)*scale))
+	return scaledW, scaledH, o.Width, o.Height
+}
+
+// Offset returns the top left corner of a cropW x cropH crop of a
+// scaledW x scaledH image, centred on the focal point or gravity and clamped
+// to the edges. Smart crops choose their own.
+func (o CropOptions) Offset(scaledW, scaledH, cropW, cropH int) (left, top int) {
+	focus := gravityPoints[o.Gravity]
+	if o.Focus != nil {
+		focus = *o.Focus
+	}
+	left = round(focus.X*float64(scaledW) - float64(cropW)/2)
+	top = round(focus.Y*float64(scaledH) - float64(cropH)/2)
+	return min(max(left, 0), scaledW-cropW), min(max(top, 0), scaledH-cropH)
+}
+
+func round(v float64) int {
+	return int(math.Round(v))
+}
@@ -0,0 +1,82 @@
+package domain
+
+import "testing"
+
+func TestCropOptions_Window(t *testing.T) {
+	tests := []struct {
+		name                 string
+		width, height        int
+		boxW, boxH           int
+		scaledW, scaledH     int
+		wantCropW, wantCropH int
+	}{
+		{"landscape to square", 400, 300, 100, 100, 133, 100, 100, 100},
+		{"portrait to banner", 300, 600, 150, 50, 150, 300, 150, 50},
+		{"exact scale", 400, 300, 200, 150, 200, 150, 200, 150},
+		{"smaller than box", 400, 300, 1000, 1000, 400, 300, 300, 300},
+		{"one side larger", 400, 300, 400, 100, 400, 300, 400, 100},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := CropOptions{Width: tt.boxW, Height: tt.boxH}
+			sw, sh, cw, ch := o.Window(tt.width, tt.height)
+			if sw != tt.scaledW || sh != tt.scaledH || cw != tt.wantCropW || ch != tt.wantCropH {
+				t.Errorf("Window = %dx%d, crop %dx%d; want %dx%d, crop %dx%d", sw, sh, cw, ch, tt.scaledW, tt.scaledH, tt.wantCropW, tt.wantCropH)
+			}
+		})
+	}
+}
+
+func TestCropOptions_Offset(t *testing.T) {
+	tests := []struct {
+		name      string
+		opts      CropOptions
+		left, top int
+	}{
+		{"center", CropOptions{}, 50, 25},
+		{"north west", CropOptions{Gravity: GravityNorthWest}, 0, 0},
+		{"east", CropOptions{Gravity: GravityEast}, 100, 25},
+		{"south", CropOptions{Gravity: GravitySouth}, 50, 50},
+		{"focus", CropOptions{Focus: &FocalPoint{X: 0.25, Y: 0.5}}, 0, 25},
+		{"focus inside", CropOptions{Focus: &FocalPoint{X: 0.6, Y: 0.4}}, 70, 10},
+		{"focus past the edge", CropOptions{Focus: &FocalPoint{X: 1, Y: 1}}, 100, 50},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			left, top := tt.opts.Offset(200, 150, 100, 100)
+			if left != tt.left || top != tt.top {
+				t.Errorf("Offset = (%d,%d), want (%d,%d)", left, top, tt.left, tt.top)
+			}
+		})
+	}
+}
+
+func TestCropOptions_Validate(t *testing.T) {
+	valid := []CropOptions{
+		{},
+		{Width: 100, Height: 100},
+		{Width: 100, Height: 100, Gravity: GravitySouthWest},
+		{Width: 100, Height: 100, Gravity: GravitySmart, Strategy: StrategyEntropy},
+		{Width: 100, Height: 100, Focus: &FocalPoint{X: 0, Y: 1}},
+	}
+	for _, o := range valid {
+		if err := o.Validate(); err != nil {
+			t.Errorf("Validate(%+v): %v", o, err)
+		}
+	}
+
+	invalid := []CropOptions{
+		{Width: 100},
+		{Gravity: GravityNorth},
+		{Width: 100, Height: 100, Gravity: "up"},
+		{Width: 100, Height: 100, Strategy: StrategyEntropy},
+		{Width: 100, Height: 100, Gravity: GravitySmart, Strategy: "faces"},
+		{Width: 100, Height: 100, Gravity: GravityNorth, Focus: &FocalPoint{}},
+		{Width: 100, Height: 100, Focus: &FocalPoint{X: 1.5}},
+	}
+	for _, o := range invalid {
+		if err := o.Validate(); err == nil {
+			t.Errorf("Validate(%+v): expected an error", o)
+		}
+	}
+}
@@ -1,23 +1,131 @@
 package domain
 
-import "io"
+import (
+	"errors"
+	"io"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrInvalidFileID is returned when a stored file is looked up by a malformed ID.
+	ErrInvalidFileID = errors.New("invalid file id")
+	// ErrTargetUnreachable is returned when no quality or size fits Options.TargetBytes.
+	ErrTargetUnreachable = errors.New("cannot reach target size")
+	// ErrInvalidImage is returned when the input cannot be decoded or is unsafe to render.
+	ErrInvalidImage = errors.New("invalid image")
+	// ErrTimeout is returned when rendering an input takes longer than allowed.
+	ErrTimeout = errors.New("processing timed out")
+)
 
 type File struct {
 	Content  io.ReadSeeker // Re-readable file content stream
 	MimeType string        // MIME type, e.g. "image/jpeg"
 	Size     int64         // File size in bytes
+	Name     string        // Original file name, empty if unknown
+}
+
+// Extension names the file after what it contains, e.g. "svg" for
+// image/svg+xml, which differs from the requested format when the original
+// is kept or a transparent image was written as PNG. format is the fallback
+// for types that are not images.
+func (f File) Extension(format string) string {
+	if subtype, ok := strings.CutPrefix(f.MimeType, "image/"); ok {
+		subtype, _, _ = strings.Cut(subtype, "+")
+		return subtype
+	}
+	return format
+}
+
+// Outcome tells which variant of the image a compression returned.
+type Outcome string
+
+const (
+	OutcomeCompressed Outcome = "compressed" // Processor output, smaller than the input
+	OutcomeOriginal   Outcome = "original"   // Input kept as is, recompression did not pay off
+	OutcomeTranscoded Outcome = "transcoded" // Processor output kept only because the format, size or metadata had to change
+)
+
+// ProcessedFile is a processor output together with facts about the encoded image.
+type ProcessedFile struct {
+	File
+	Width        int     // Output width in pixels
+	Height       int     // Output height in pixels
+	SourceWidth  int     // Input width in pixels
+	SourceHeight int     // Input height in pixels
+	Quality      int     // Quality the output was encoded with
+	SSIM         float64 // SSIM against the source, zero when not computed
+	Outcome      Outcome // Set by the service, empty when returned by a processor
+	Report       *Report // Quality report, only when Options.Report is set
+	// Placeholders of the output, only when Options.Placeholders is set.
+	Placeholders *Placeholders
+	Analysis     *Analysis // Colours of the output, only when Options.Analyze is set
+	// RemovedMetadata lists the input metadata fields missing from the output,
+	// such as "exif:GPSLatitude" or "icc".
+	RemovedMetadata []string
 }
 
-// Options defines parameters for compression operations.
-type Options struct {
-	Format    string // Target format (e.g. "webp", "jpeg")
-	Quality   int    // Compression quality
-	MaxWidth  int    // Maximum width in pixels
-	MaxHeight int    // Maximum height in pixels
+// Report describes how much a compression changed the image.
+type Report struct {
+	PSNR         float64 `json:"psnr"`          // Peak signal-to-noise ratio in dB
+	SSIM         float64 `json:"ssim"`          // Structural similarity, 1 = identical
+	InputSize    int64   `json:"input_size"`    // Input size in bytes
+	OutputSize   int64   `json:"output_size"`   // Output size in bytes
+	SavedBytes   int64   `json:"saved_bytes"`   // Negative when the output grew
+	SavedPercent float64 `json:"saved_percent"` // SavedBytes relative to InputSize
+	InputWidth   int     `json:"input_width"`
+	InputHeight  int     `json:"input_height"`
+	OutputWidth  int     `json:"output_width"`
+	OutputHeight int     `json:"output_height"`
+	EncodeMS     int64   `json:"encode_ms"` // Time spent encoding, including quality searches
+}
+
+// Placeholders are tiny encodings of an image a client can draw, blurred,
+// while the image loads.
+type Placeholders struct {
+	BlurHash  string `json:"blurhash"`  // See blurha.sh
+	ThumbHash string `json:"thumbhash"` // Base64; see evanw.github.io/thumbhash
+}
+
+// Analysis describes the colours of an image.
+type Analysis struct {
+	Dominant string   `json:"dominant"` // Hex colour of the largest swatch
+	Palette  []Swatch `json:"palette"`  // Most common first, empty for fully transparent images
+}
+
+// Swatch is one palette colour and the share of the opaque pixels it stands
+// for.
+type Swatch struct {
+	Color      string  `json:"color"`      // "#rrggbb"
+	Proportion float64 `json:"proportion"` // 0..1; the palette sums to 1
 }
 
 // SaveResult describes result of compress+save operation.
 type SavedFile struct {
-	Path           string // Full path where file is stored (e.g. storage/compressed/...)
-	CompressedSize int64  // Size of compressed file in bytes
+	Path           string   // Full path where file is stored (e.g. storage/compressed/...)
+	CompressedSize int64    // Size of compressed file in bytes
+	Meta           FileMeta // Metadata record persisted alongside the file
+	Report         *Report  // Quality report, only when Options.Report is set
+}
+
+// FileMeta is the metadata record persisted for every stored file.
+type FileMeta struct {
+	ID               string        `json:"id"`
+	Path             string        `json:"path"`
+	OriginalName     string        `json:"original_name,omitempty"`
+	OriginalSize     int64         `json:"original_size"`
+	OriginalMimeType string        `json:"original_mime_type"`
+	MimeType         string        `json:"mime_type"`
+	Size             int64         `json:"size"`
+	Width            int           `json:"width"`
+	Height           int           `json:"height"`
+	Quality          int           `json:"quality"`
+	SSIM             float64       `json:"ssim,omitempty"`
+	Outcome          Outcome       `json:"outcome"`
+	RemovedMetadata  []string      `json:"removed_metadata,omitempty"`
+	Placeholders     *Placeholders `json:"placeholders,omitempty"`
+	Analysis         *Analysis     `json:"analysis,omitempty"`
+	Options          Options       `json:"options"`
+	ProcessingTimeMS int64         `json:"processing_time_ms"`
+	CreatedAt        time.Time     `json:"created_at"`
 }
@@ -0,0 +1,21 @@
+package domain
+
+import "testing"
+
+func TestFile_Extension(t *testing.T) {
+	tests := []struct {
+		mimeType string
+		want     string
+	}{
+		{"image/png", "png"},
+		{"image/svg+xml", "svg"},
+		// Fallback to the requested format.
+		{"", "jpeg"},
+		{"application/octet-stream", "jpeg"},
+	}
+	for _, tt := range tests {
+		if got := (File{MimeType: tt.mimeType}).Extension("jpeg"); got != tt.want {
+			t.Errorf("Extension of %q = %q, want %q", tt.mimeType, got, tt.want)
+		}
+	}
+}
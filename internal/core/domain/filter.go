@@ -0,0 +1,169 @@
+package domain
+
+import "fmt"
+
+// maxFilters bounds Options.Filters.
+const maxFilters = 8
+
+const (
+	OpBlur      OperationType = "blur"      // Gaussian blur of Sigma pixels
+	OpSharpen   OperationType = "sharpen"   // Unsharp mask of Sigma pixels and Amount strength
+	OpGrayscale OperationType = "grayscale" // Drop the colour, keep the luma
+	OpAdjust    OperationType = "adjust"    // Scale Brightness, Contrast and Saturation
+	OpGamma     OperationType = "gamma"     // Raise to the power 1/Gamma
+	OpTint      OperationType = "tint"      // Blend towards Color by Amount
+)
+
+// Filter defaults.
+const (
+	defaultSharpenSigma  = 1
+	defaultSharpenAmount = 1
+	defaultTintAmount    = 0.5
+)
+
+// lumaWeights are the Rec. 601 weights grayscale and saturation use.
+var lumaWeights = [3]float64{0.299, 0.587, 0.114}
+
+// Filter is one step of Options.Filters, applied after resizing. Only the
+// fields of its type are used.
+type Filter struct {
+	Type OperationType `json:"op"`
+	// Sigma is the blur radius of blur and sharpen in pixels, 0.3..100.
+	// Sharpen defaults to 1.
+	Sigma float64 `json:"sigma,omitempty"`
+	// Amount is the strength of sharpen, 0..10, 0 = 1, and of tint, 0..1,
+	// 0 = 0.5.
+	Amount float64 `json:"amount,omitempty"`
+	// Brightness, Contrast and Saturation are adjust factors 0..4, 0 = 1.
+	Brightness float64 `json:"brightness,omitempty"`
+	Contrast   float64 `json:"contrast,omitempty"`
+	Saturation float64 `json:"saturation,omitempty"`
+	Gamma      float64 `json:"gamma,omitempty"` // 0.1..10; above 1 brightens the mid-tones
+	Color      string  `json:"color,omitempty"` // Hex colour of tint
+}
+
+// Kind returns the filter type.
+func (f Filter) Kind() OperationType {
+	return f.Type
+}
+
+// Validate checks the values of the filter's type.
+func (f Filter) Validate() error {
+	switch f.Type {
+	case OpBlur:
+		if !inRange(f.Sigma, 0.3, 100) {
+			return fmt.Errorf("%w: blur sigma must be in 0.3..100", ErrInvalidOptions)
+		}
+	case OpSharpen:
+		if f.Sigma != 0 && !inRange(f.Sigma, 0.3, 100) {
+			return fmt.Errorf("%w: sharpen sigma must be in 0.3..100", ErrInvalidOptions)
+		}
+		if !inRange(f.Amount, 0, 10) {
+			return fmt.Errorf("%w: sharpen amount must be in 0..10", ErrInvalidOptions)
+		}
+	case OpGrayscale:
+	case OpAdjust:
+		if f.Brightness == 0 && f.Contrast == 0 && f.Saturation == 0 {
+			return fmt.Errorf("%w: adjust needs a brightness, contrast or saturation", ErrInvalidOptions)
+		}
+		for _, v := range []float64{f.Brightness, f.Contrast, f.Saturation} {
+			if !inRange(v, 0, 4) {
+				return fmt.Errorf("%w: adjust brightness, contrast and saturation must be in 0..4", ErrInvalidOptions)
+			}
+		}
+	case OpGamma:
+		if !inRange(f.Gamma, 0.1, 10) {
+			return fmt.Errorf("%w: gamma must be in 0.1..10", ErrInvalidOptions)
+		}
+	case OpTint:
+		if f.Color == "" {
+			return fmt.Errorf("%w: tint needs a color", ErrInvalidOptions)
+		}
+		if _, err := ParseColor(f.Color); err != nil {
+			return err
+		}
+		if !inRange(f.Amount, 0, 1) {
+			return fmt.Errorf("%w: tint amount must be in 0..1", ErrInvalidOptions)
+		}
+	default:
+		return fmt.Errorf("%w: unknown filter %q", ErrInvalidOptions, f.Type)
+	}
+	return nil
+}
+
+// inRange reports whether lo <= v <= hi; NaN is out of range.
+func inRange(v, lo, hi float64) bool {
+	return v >= lo && v <= hi
+}
+
+// SharpenSigma returns the blur radius of an unsharp mask.
+func (f Filter) SharpenSigma() float64 {
+	if f.Sigma == 0 {
+		return defaultSharpenSigma
+	}
+	return f.Sigma
+}
+
+// SharpenAmount returns the strength of an unsharp mask: the output is the
+// pixel plus Amount times its difference from the blurred pixel.
+func (f Filter) SharpenAmount() float64 {
+	if f.Amount == 0 {
+		return defaultSharpenAmount
+	}
+	return f.Amount
+}
+
+// Recolor returns the colour transform of grayscale, adjust and tint as a
+// matrix and an offset on 0..255 RGB: out = matrix × rgb + offset. ok is
+// false for other filters. Processors apply it to the colour, not the alpha.
+func (f Filter) Recolor() (matrix [3][3]float64, offset [3]float64, ok bool) {
+	switch f.Type {
+	case OpGrayscale:
+		return saturation(0), offset, true
+	case OpAdjust:
+		// Saturation first, then brightness, then contrast around mid-grey.
+		matrix = saturation(factor(f.Saturation))
+		scale := factor(f.Brightness) * factor(f.Contrast)
+		for i := range matrix {
+			for j := range matrix[i] {
+				matrix[i][j] *= scale
+			}
+			offset[i] = 128 * (1 - factor(f.Contrast))
+		}
+		return matrix, offset, true
+	case OpTint:
+		amount := f.Amount
+		if amount == 0 {
+			amount = defaultTintAmount
+		}
+		c, _ := ParseColor(f.Color)
+		for i, v := range [3]uint8{c.R, c.G, c.B} {
+			matrix[i][i] = 1 - amount
+			offset[i] = float64(v) * amount
+		}
+		return matrix, offset, true
+	default:
+		return matrix, offset, false
+	}
+}
+
+// saturation returns the matrix that scales the saturation by s: 0 is
+// grey, 1 unchanged.
+func saturation(s float64) [3][3]float64 {
+	var m [3][3]float64
+	for i := range m {
+		for j := range m[i] {
+			m[i][j] = (1 - s) * lumaWeights[j]
+		}
+		m[i][i] += s
+	}
+	return m
+}
+
+// factor returns an adjust factor, 0 meaning unchanged.
+func factor(v float64) float64 {
+	if v == 0 {
+		return 1
+	}
+	return v
+}
@@ -0,0 +1,90 @@
+package domain
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestFilter_Validate(t *testing.T) {
+	valid := []Filter{
+		{Type: OpBlur, Sigma: 2},
+		{Type: OpSharpen},
+		{Type: OpSharpen, Sigma: 0.5, Amount: 3},
+		{Type: OpGrayscale},
+		{Type: OpAdjust, Brightness: 1.2},
+		{Type: OpAdjust, Contrast: 0.8, Saturation: 1.5},
+		{Type: OpGamma, Gamma: 2.2},
+		{Type: OpTint, Color: "#704214", Amount: 0.3},
+	}
+	for _, f := range valid {
+		if err := f.Validate(); err != nil {
+			t.Errorf("Validate(%+v) = %v", f, err)
+		}
+	}
+
+	invalid := []Filter{
+		{Type: "emboss"},
+		{Type: OpBlur},
+		{Type: OpBlur, Sigma: 101},
+		{Type: OpBlur, Sigma: math.NaN()},
+		{Type: OpSharpen, Amount: 11},
+		{Type: OpAdjust},
+		{Type: OpAdjust, Brightness: -1},
+		{Type: OpGamma, Gamma: 0.05},
+		{Type: OpTint},
+		{Type: OpTint, Color: "purple"},
+		{Type: OpTint, Color: "#fff", Amount: 2},
+	}
+	for _, f := range invalid {
+		if err := f.Validate(); !errors.Is(err, ErrInvalidOptions) {
+			t.Errorf("Validate(%+v) = %v, want ErrInvalidOptions", f, err)
+		}
+	}
+}
+
+func TestFilter_Recolor(t *testing.T) {
+	apply := func(f Filter, rgb [3]float64) [3]float64 {
+		m, off, ok := f.Recolor()
+		if !ok {
+			t.Fatalf("Recolor(%+v) not ok", f)
+		}
+		var out [3]float64
+		for i := range out {
+			out[i] = m[i][0]*rgb[0] + m[i][1]*rgb[1] + m[i][2]*rgb[2] + off[i]
+		}
+		return out
+	}
+	near := func(got, want [3]float64) bool {
+		for i := range got {
+			if math.Abs(got[i]-want[i]) > 0.5 {
+				return false
+			}
+		}
+		return true
+	}
+
+	red := [3]float64{200, 0, 0}
+	tests := []struct {
+		name string
+		f    Filter
+		want [3]float64
+	}{
+		{"grayscale", Filter{Type: OpGrayscale}, [3]float64{59.8, 59.8, 59.8}},
+		{"brightness", Filter{Type: OpAdjust, Brightness: 1.25}, [3]float64{250, 0, 0}},
+		{"contrast", Filter{Type: OpAdjust, Contrast: 0.5}, [3]float64{164, 64, 64}},
+		{"saturation", Filter{Type: OpAdjust, Saturation: 0.5}, [3]float64{129.9, 29.9, 29.9}},
+		{"tint", Filter{Type: OpTint, Color: "#0000ff"}, [3]float64{100, 0, 127.5}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := apply(tt.f, red); !near(got, tt.want) {
+				t.Errorf("recolored red = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if _, _, ok := (Filter{Type: OpBlur, Sigma: 1}).Recolor(); ok {
+		t.Error("Recolor of a blur is ok")
+	}
+}
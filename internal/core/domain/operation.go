@@ -0,0 +1,151 @@
+package domain
+
+import (
+	"fmt"
+	"math"
+)
+
+// maxOperations bounds Options.Operations; every operation decodes and
+// copies the image.
+const maxOperations = 16
+
+// MaxCanvasPixels bounds the image a step may produce. Pads and rotations
+// enlarge the canvas, chained ones without limit, and the input size is only
+// known once the processor has decoded it.
+const MaxCanvasPixels = 100_000_000
+
+// CheckCanvas returns ErrInvalidOptions when a width x height canvas exceeds
+// MaxCanvasPixels. The size is taken as floats so that callers can check it
+// before converting, and overflowing, an int.
+func CheckCanvas(width, height float64) error {
+	if width*height > MaxCanvasPixels {
+		return fmt.Errorf("%w: a %.0fx%.0f canvas exceeds %d pixels", ErrInvalidOptions, width, height, MaxCanvasPixels)
+	}
+	return nil
+}
+
+// OperationType names a geometric operation.
+type OperationType string
+
+const (
+	OpRotate OperationType = "rotate" // Turn Angle degrees clockwise
+	OpFlip   OperationType = "flip"   // Mirror top to bottom
+	OpFlop   OperationType = "flop"   // Mirror left to right
+	OpCrop   OperationType = "crop"   // Cut out the Left, Top, Width, Height rectangle
+	OpTrim   OperationType = "trim"   // Cut off borders the colour of the top left pixel
+	OpPad    OperationType = "pad"    // Extend evenly to the Ratio aspect ratio
+)
+
+// Operation is one step of Options.Operations. Only the fields of its type
+// are used.
+type Operation struct {
+	Type OperationType `json:"op"`
+	// Angle is the rotation in degrees. Multiples of 90 are lossless; other
+	// angles enlarge the canvas and fill the corners with Background.
+	Angle  float64 `json:"angle,omitempty"`
+	Left   int     `json:"left,omitempty"`
+	Top    int     `json:"top,omitempty"`
+	Width  int     `json:"width,omitempty"`
+	Height int     `json:"height,omitempty"`
+	// Threshold is how far a colour may be from the border colour and still
+	// be trimmed, 0..255, 0 = 10.
+	Threshold float64 `json:"threshold,omitempty"`
+	Ratio     float64 `json:"ratio,omitempty"` // Width divided by height, e.g. 1.7778 for 16:9
+	// Background fills new pixels of rotations and pads, empty =
+	// Options.Background.
+	Background string `json:"background,omitempty"`
+}
+
+// NormalizedAngle returns the rotation in degrees from 0 up to 360.
+func (op Operation) NormalizedAngle() float64 {
+	a := math.Mod(op.Angle, 360)
+	if a < 0 {
+		a += 360
+	}
+	return a
+}
+
+// RightAngle reports whether a rotation is a multiple of 90 degrees and
+// returns it as 0, 90, 180 or 270.
+func (op Operation) RightAngle() (int, bool) {
+	a := op.NormalizedAngle()
+	if a != math.Trunc(a) || int(a)%90 != 0 {
+		return 0, false
+	}
+	return int(a), true
+}
+
+// BackgroundColor returns the colour new pixels get, falling back to the
+// options' background.
+func (op Operation) BackgroundColor(opts Options) (Color, error) {
+	if op.Background == "" {
+		return opts.BackgroundColor()
+	}
+	return ParseColor(op.Background)
+}
+
+// Validate checks the values of the operation's type.
+func (op Operation) Validate() error {
+	switch op.Type {
+	case OpRotate:
+		if math.IsNaN(op.Angle) || math.IsInf(op.Angle, 0) {
+			return fmt.Errorf("%w: rotate angle must be a number", ErrInvalidOptions)
+		}
+	case OpFlip, OpFlop:
+	case OpCrop:
+		if op.Left < 0 || op.Top < 0 || op.Width <= 0 || op.Height <= 0 {
+			return fmt.Errorf("%w: crop needs a non-negative left and top and a positive width and height", ErrInvalidOptions)
+		}
+	case OpTrim:
+		if op.Threshold < 0 || op.Threshold > 255 {
+			return fmt.Errorf("%w: trim threshold must be in 0..255 (0 = 10)", ErrInvalidOptions)
+		}
+	case OpPad:
+		if op.Ratio < 0.01 || op.Ratio > 100 {
+			return fmt.Errorf("%w: pad ratio must be in 0.01..100", ErrInvalidOptions)
+		}
+	default:
+		return fmt.Errorf("%w: unknown operation %q", ErrInvalidOptions, op.Type)
+	}
+	if op.Background != "" {
+		if _, err := ParseColor(op.Background); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PadSize returns the size a width x height image is extended to for a pad
+// operation, or an error when it exceeds MaxCanvasPixels.
+func (op Operation) PadSize(width, height int) (int, int, error) {
+	w, h := float64(width), float64(height)
+	if w/h < op.Ratio {
+		w = max(w, math.Round(h*op.Ratio))
+	} else {
+		h = max(h, math.Round(w/op.Ratio))
+	}
+	if err := CheckCanvas(w, h); err != nil {
+		return 0, 0, err
+	}
+	return int(w), int(h), nil
+}
+
+// RotateSize returns the size of the canvas a width x height image is turned
+// on, or an error when it exceeds MaxCanvasPixels. Right angles only swap the
+// sides.
+func (op Operation) RotateSize(width, height int) (int, int, error) {
+	if angle, ok := op.RightAngle(); ok {
+		if angle%180 != 0 {
+			return height, width, nil
+		}
+		return width, height, nil
+	}
+	theta := op.NormalizedAngle() * math.Pi / 180
+	sin, cos := math.Abs(math.Sin(theta)), math.Abs(math.Cos(theta))
+	w, h := float64(width), float64(height)
+	rw, rh := math.Ceil(w*cos+h*sin), math.Ceil(w*sin+h*cos)
+	if err := CheckCanvas(rw, rh); err != nil {
+		return 0, 0, err
+	}
+	return int(rw), int(rh), nil
+}
@@ -0,0 +1,60 @@
+package domain
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestOperation_PadSize(t *testing.T) {
+	tests := []struct {
+		ratio         float64
+		width, height int
+		wantW, wantH  int
+	}{
+		{1, 400, 300, 400, 400},
+		{2, 400, 300, 600, 300},
+		{0.5, 400, 300, 400, 800},
+		{4.0 / 3, 400, 300, 400, 300},
+	}
+	for _, tt := range tests {
+		w, h, err := Operation{Type: OpPad, Ratio: tt.ratio}.PadSize(tt.width, tt.height)
+		if err != nil || w != tt.wantW || h != tt.wantH {
+			t.Errorf("PadSize(%d, %d) with ratio %v = %dx%d, %v, want %dx%d", tt.width, tt.height, tt.ratio, w, h, err, tt.wantW, tt.wantH)
+		}
+	}
+
+	// An int would overflow long before the limit is checked.
+	if _, _, err := (Operation{Type: OpPad, Ratio: 100}).PadSize(1<<40, 1<<40); !errors.Is(err, ErrInvalidOptions) {
+		t.Errorf("huge pad: got %v, want ErrInvalidOptions", err)
+	}
+	if _, _, err := (Operation{Type: OpPad, Ratio: 100}).PadSize(400, 4000); !errors.Is(err, ErrInvalidOptions) {
+		t.Errorf("pad over MaxCanvasPixels: got %v, want ErrInvalidOptions", err)
+	}
+}
+
+func TestOperation_RotateSize(t *testing.T) {
+	tests := []struct {
+		angle        float64
+		wantW, wantH int
+	}{
+		{0, 400, 300},
+		{90, 300, 400},
+		{-180, 400, 300},
+		{270, 300, 400},
+		{45, 495, 495},
+	}
+	for _, tt := range tests {
+		w, h, err := Operation{Type: OpRotate, Angle: tt.angle}.RotateSize(400, 300)
+		if err != nil || w != tt.wantW || h != tt.wantH {
+			t.Errorf("RotateSize(400, 300) by %v = %dx%d, %v, want %dx%d", tt.angle, w, h, err, tt.wantW, tt.wantH)
+		}
+	}
+
+	// 1x100000 at 45 degrees needs a 70712x70712 canvas.
+	if _, _, err := (Operation{Type: OpRotate, Angle: 45}).RotateSize(1, 100_000); !errors.Is(err, ErrInvalidOptions) {
+		t.Errorf("rotation over MaxCanvasPixels: got %v, want ErrInvalidOptions", err)
+	}
+	if _, _, err := (Operation{Type: OpRotate, Angle: 90}).RotateSize(1, 100_000); err != nil {
+		t.Errorf("right angle: got %v, want no limit beyond the input", err)
+	}
+}
@@ -0,0 +1,390 @@
+package domain
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrInvalidOptions is returned by Options.Validate.
+var ErrInvalidOptions = errors.New("invalid options")
+
+// Options defines parameters for compression operations.
+type Options struct {
+	Format    string `json:"format"`     // Target format (e.g. "webp", "jpeg")
+	Quality   int    `json:"quality"`    // Compression quality
+	MaxWidth  int    `json:"max_width"`  // Maximum width in pixels
+	MaxHeight int    `json:"max_height"` // Maximum height in pixels
+	// Pipeline spells out the steps explicitly, in place of Format, Quality,
+	// MaxWidth, MaxHeight, Operations, Crop, Filters, Watermarks, the targets
+	// and the encoder options, which Compile turns into a pipeline.
+	Pipeline Pipeline `json:"pipeline,omitempty"`
+	// Operations transform the upright image in order, before Crop and
+	// resizing.
+	Operations []Operation `json:"operations,omitempty"`
+	// Crop cuts the output to an exact size instead of fitting it within
+	// MaxWidth x MaxHeight.
+	Crop CropOptions `json:"crop,omitempty"`
+	// Filters run in order on the resized image, before Watermarks.
+	Filters []Filter `json:"filters,omitempty"`
+	// Watermarks are drawn in order over the resized image.
+	Watermarks []WatermarkStep `json:"watermarks,omitempty"`
+	// TargetBytes, when set, makes the processor search for the highest quality
+	// (capped by Quality) and, if needed, smaller dimensions that fit this size.
+	TargetBytes int64 `json:"target_bytes,omitempty"`
+	// TargetSSIM, when set, makes the processor pick the smallest encoding
+	// whose SSIM against the source is at least this value (0..1).
+	TargetSSIM float64 `json:"target_ssim,omitempty"`
+	// Report requests a quality report (PSNR, SSIM, savings) with the result.
+	Report bool `json:"report,omitempty"`
+	// Placeholders requests a BlurHash and a ThumbHash of the output.
+	Placeholders bool `json:"placeholders,omitempty"`
+	// Analyze requests the dominant colour and palette of the output.
+	Analyze bool `json:"analyze,omitempty"`
+	// Page selects the page of a multi-page input (TIFF, HEIF, PDF), counted from 0.
+	Page int `json:"page,omitempty"`
+	// NoAutoRotate keeps the stored pixel order instead of applying the EXIF
+	// orientation. Unless Metadata retains EXIF the orientation tag is lost,
+	// so the caller must handle orientation itself.
+	NoAutoRotate bool `json:"no_auto_rotate,omitempty"`
+	// Preset names a configured set of options. Fields set in the request
+	// take precedence over the preset's.
+	Preset string `json:"preset,omitempty"`
+	// Metadata selects the metadata kept in the output, empty = configured default.
+	Metadata MetadataPolicy `json:"metadata,omitempty"`
+	// Background is the hex colour transparent pixels are flattened onto,
+	// empty = white.
+	Background string `json:"background,omitempty"`
+	// Alpha selects what happens to transparency the output format cannot
+	// keep, empty = flatten.
+	Alpha AlphaPolicy `json:"alpha,omitempty"`
+
+	JPEG      JPEGOptions      `json:"jpeg,omitempty"`      // Used when the output is JPEG
+	PNG       PNGOptions       `json:"png,omitempty"`       // Used when the output is PNG
+	WebP      WebPOptions      `json:"webp,omitempty"`      // Used when the output is a still WebP
+	AVIF      AVIFOptions      `json:"avif,omitempty"`      // Used when Format is "avif"
+	Animation AnimationOptions `json:"animation,omitempty"` // Used for animated inputs
+	SVG       SVGOptions       `json:"svg,omitempty"`       // Used to rasterize SVG inputs
+	PDF       PDFOptions       `json:"pdf,omitempty"`       // Used to render PDF pages
+}
+
+// MetadataPolicy selects which metadata survives compression.
+type MetadataPolicy string
+
+const (
+	MetadataStrip     MetadataPolicy = "strip"      // Remove everything, converting pixels to sRGB
+	MetadataICC       MetadataPolicy = "icc"        // Keep only the colour profile
+	MetadataCopyright MetadataPolicy = "copyright"  // Keep the profile and author, copyright and credit fields
+	MetadataNoPrivate MetadataPolicy = "no_private" // Keep everything except location and serial numbers
+)
+
+// Validate checks that the policy is known.
+func (p MetadataPolicy) Validate() error {
+	switch p {
+	case "", MetadataStrip, MetadataICC, MetadataCopyright, MetadataNoPrivate:
+		return nil
+	default:
+		return fmt.Errorf("%w: metadata must be strip, icc, copyright or no_private", ErrInvalidOptions)
+	}
+}
+
+// AlphaPolicy selects what happens to transparency when the output format,
+// such as JPEG, has no alpha channel.
+type AlphaPolicy string
+
+const (
+	AlphaFlatten AlphaPolicy = "flatten" // Composite onto Options.Background
+	AlphaReject  AlphaPolicy = "reject"  // Fail with ErrInvalidOptions if any pixel is transparent
+	// AlphaAuto writes PNG instead when any pixel is transparent, and drops
+	// alpha channels that are fully opaque whatever the format.
+	AlphaAuto AlphaPolicy = "auto"
+)
+
+// Validate checks that the policy is known.
+func (p AlphaPolicy) Validate() error {
+	switch p {
+	case "", AlphaFlatten, AlphaReject, AlphaAuto:
+		return nil
+	default:
+		return fmt.Errorf("%w: alpha must be flatten, reject or auto", ErrInvalidOptions)
+	}
+}
+
+// BackgroundColor parses Background, defaulting to white.
+func (o Options) BackgroundColor() (Color, error) {
+	if o.Background == "" {
+		return White, nil
+	}
+	return ParseColor(o.Background)
+}
+
+// JPEGOptions tunes JPEG encoding. Zero values write baseline JPEG with
+// optimised Huffman tables and encoder-chosen chroma subsampling.
+type JPEGOptions struct {
+	Progressive bool   `json:"progressive,omitempty"` // Interlaced scans; usually smaller above ~10 KB
+	Subsample   string `json:"subsample,omitempty"`   // Chroma subsampling: "auto", "420" or "444" for sharp text
+	// Trellis enables trellis quantisation when libvips is built with
+	// mozjpeg; other builds ignore it.
+	Trellis bool `json:"trellis,omitempty"`
+}
+
+// Validate checks JPEG option values.
+func (o JPEGOptions) Validate() error {
+	switch o.Subsample {
+	case "", "auto", "420", "444":
+		return nil
+	default:
+		return fmt.Errorf("%w: jpeg subsample must be auto, 420 or 444", ErrInvalidOptions)
+	}
+}
+
+// WebPOptions tunes still WebP encoding. Animations only honour Quality.
+type WebPOptions struct {
+	// Method trades speed for size, 0 (fast) .. 6 (small), nil = 4.
+	Method *int `json:"method,omitempty"`
+	// NearLossless encodes losslessly after a lossy preprocessing pass whose
+	// strength Quality sets.
+	NearLossless bool `json:"near_lossless,omitempty"`
+	Lossless     bool `json:"lossless,omitempty"`
+	// AlphaQuality is the quality of a lossy alpha channel, 0..100, 0 = 100.
+	AlphaQuality int `json:"alpha_quality,omitempty"`
+	// SmartSubsample spends more time on chroma for sharper colour edges.
+	SmartSubsample bool `json:"smart_subsample,omitempty"`
+}
+
+// Validate checks WebP option ranges and that lossless modes ask for nothing
+// lossy.
+func (o WebPOptions) Validate() error {
+	if o.Method != nil && (*o.Method < 0 || *o.Method > 6) {
+		return fmt.Errorf("%w: webp method must be in 0..6", ErrInvalidOptions)
+	}
+	if o.AlphaQuality < 0 || o.AlphaQuality > 100 {
+		return fmt.Errorf("%w: webp alpha_quality must be in 0..100 (0 = 100)", ErrInvalidOptions)
+	}
+	if o.Lossless && o.NearLossless {
+		return fmt.Errorf("%w: webp lossless and near_lossless are mutually exclusive", ErrInvalidOptions)
+	}
+	if (o.Lossless || o.NearLossless) && (o.AlphaQuality > 0 || o.SmartSubsample) {
+		return fmt.Errorf("%w: webp alpha_quality and smart_subsample only apply to lossy webp", ErrInvalidOptions)
+	}
+	return nil
+}
+
+// AVIFOptions tunes AVIF encoding. Zero values use encoder defaults.
+type AVIFOptions struct {
+	Effort    int    `json:"effort,omitempty"`    // CPU effort 1 (fast) .. 9 (small), 0 = default
+	Subsample string `json:"subsample,omitempty"` // Chroma subsampling: "auto", "420" or "444"
+	Lossless  bool   `json:"lossless,omitempty"`
+}
+
+// Validate checks AVIF option ranges.
+func (o AVIFOptions) Validate() error {
+	if o.Effort < 0 || o.Effort > 9 {
+		return fmt.Errorf("%w: avif effort must be in 0..9 (0 = default)", ErrInvalidOptions)
+	}
+	switch o.Subsample {
+	case "", "auto", "420", "444":
+	default:
+		return fmt.Errorf("%w: avif subsample must be auto, 420 or 444", ErrInvalidOptions)
+	}
+	return nil
+}
+
+// PNGOptions tunes PNG encoding. Zero values keep the defaults: zlib level 6,
+// adaptive filtering, 8-bit truecolour.
+type PNGOptions struct {
+	Compression int    `json:"compression,omitempty"` // zlib level 1 (fast) .. 9 (small), 0 = default
+	Filter      string `json:"filter,omitempty"`      // Row filtering: "adaptive" or "none"
+	// Palette quantises to at most 256 colours, pngquant-style; Quality sets
+	// the quantisation quality. MaxColors and BitDepth below 8 imply it.
+	Palette   bool     `json:"palette,omitempty"`
+	MaxColors int      `json:"max_colors,omitempty"` // 2..256, rounded up to a power of two
+	Dither    *float64 `json:"dither,omitempty"`     // Error diffusion 0..1 for palettes, nil = 1
+	BitDepth  int      `json:"bit_depth,omitempty"`  // 1, 2, 4 or 8, 0 = 8
+	// Lossless rejects every option that changes pixel values.
+	Lossless bool `json:"lossless,omitempty"`
+}
+
+// Quantised reports whether the options ask for a palette image.
+func (o PNGOptions) Quantised() bool {
+	return o.Palette || o.MaxColors > 0 || (o.BitDepth > 0 && o.BitDepth < 8)
+}
+
+// Validate checks PNG option ranges and that lossless mode asks for nothing
+// lossy.
+func (o PNGOptions) Validate() error {
+	if o.Compression < 0 || o.Compression > 9 {
+		return fmt.Errorf("%w: png compression must be in 0..9 (0 = default)", ErrInvalidOptions)
+	}
+	switch o.Filter {
+	case "", "adaptive", "none":
+	default:
+		return fmt.Errorf("%w: png filter must be adaptive or none", ErrInvalidOptions)
+	}
+	if o.MaxColors != 0 && (o.MaxColors < 2 || o.MaxColors > 256) {
+		return fmt.Errorf("%w: png max_colors must be in 2..256", ErrInvalidOptions)
+	}
+	if o.Dither != nil && (*o.Dither < 0 || *o.Dither > 1) {
+		return fmt.Errorf("%w: png dither must be in 0..1", ErrInvalidOptions)
+	}
+	switch o.BitDepth {
+	case 0, 1, 2, 4, 8:
+	default:
+		return fmt.Errorf("%w: png bit_depth must be 1, 2, 4 or 8", ErrInvalidOptions)
+	}
+	if o.Lossless && (o.Quantised() || o.Dither != nil) {
+		return fmt.Errorf("%w: png lossless excludes palette, max_colors, dither and bit_depth", ErrInvalidOptions)
+	}
+	return nil
+}
+
+// AnimationOptions controls animated inputs. Animations are kept when both
+// input and output formats can animate (GIF, WebP); other outputs get the
+// first frame.
+type AnimationOptions struct {
+	FirstFrame bool `json:"first_frame,omitempty"` // Output a still poster from the first frame
+	MaxFrames  int  `json:"max_frames,omitempty"`  // Keep at most this many frames, 0 = all
+}
+
+// Validate checks animation option ranges.
+func (o AnimationOptions) Validate() error {
+	if o.MaxFrames < 0 {
+		return fmt.Errorf("%w: max_frames must not be negative", ErrInvalidOptions)
+	}
+	if o.FirstFrame && o.MaxFrames > 0 {
+		return fmt.Errorf("%w: first_frame and max_frames are mutually exclusive", ErrInvalidOptions)
+	}
+	return nil
+}
+
+// maxSVGWidth bounds the rasterization width of SVG inputs.
+const maxSVGWidth = 16384
+
+// SVGOptions controls how SVG inputs are rasterized. Zero values render at
+// the document's own size and 72 DPI.
+type SVGOptions struct {
+	Width int     `json:"width,omitempty"` // Render at this width in pixels, keeping the aspect ratio
+	DPI   float64 `json:"dpi,omitempty"`   // Density for physical units such as mm or pt
+}
+
+// Validate checks SVG option ranges.
+func (o SVGOptions) Validate() error {
+	if o.Width < 0 || o.Width > maxSVGWidth {
+		return fmt.Errorf("%w: svg width must be in 0..%d (0 = document size)", ErrInvalidOptions, maxSVGWidth)
+	}
+	if o.DPI < 0 || o.DPI > 2400 {
+		return fmt.Errorf("%w: svg dpi must be in 0..2400 (0 = 72)", ErrInvalidOptions)
+	}
+	return nil
+}
+
+// PDFOptions controls how PDF pages are rendered. Options.Page picks the page.
+type PDFOptions struct {
+	DPI float64 `json:"dpi,omitempty"` // Render density, 0 = configured default
+}
+
+// Validate checks PDF option ranges. The upper DPI bound is configuration.
+func (o PDFOptions) Validate() error {
+	if o.DPI < 0 {
+		return fmt.Errorf("%w: pdf dpi must not be negative", ErrInvalidOptions)
+	}
+	return nil
+}
+
+// Compile returns the pipeline the options describe: Pipeline when set,
+// otherwise Operations, then Crop as a cover resize, then MaxWidth x
+// MaxHeight as an inside resize, then Filters, then Watermarks, then the
+// encoder settings.
+func (o Options) Compile() Pipeline {
+	if len(o.Pipeline) > 0 {
+		return o.Pipeline
+	}
+
+	p := make(Pipeline, 0, len(o.Operations)+len(o.Filters)+len(o.Watermarks)+3)
+	for _, op := range o.Operations {
+		p = append(p, op)
+	}
+	if o.Crop.Enabled() {
+		p = append(p, ResizeStep{
+			Width:    o.Crop.Width,
+			Height:   o.Crop.Height,
+			Fit:      FitCover,
+			Gravity:  o.Crop.Gravity,
+			Strategy: o.Crop.Strategy,
+			Focus:    o.Crop.Focus,
+		})
+	}
+	if o.MaxWidth > 0 || o.MaxHeight > 0 {
+		p = append(p, ResizeStep{Width: o.MaxWidth, Height: o.MaxHeight})
+	}
+	for _, f := range o.Filters {
+		p = append(p, f)
+	}
+	for _, w := range o.Watermarks {
+		p = append(p, w)
+	}
+	return append(p, EncodeStep{
+		Format:      o.Format,
+		Quality:     o.Quality,
+		TargetBytes: o.TargetBytes,
+		TargetSSIM:  o.TargetSSIM,
+		JPEG:        o.JPEG,
+		PNG:         o.PNG,
+		WebP:        o.WebP,
+		AVIF:        o.AVIF,
+	})
+}
+
+// Validate checks option ranges and combinations that no processor can honor.
+func (o Options) Validate() error {
+	if len(o.Pipeline) > 0 {
+		sugar := o
+		sugar.Pipeline = nil
+		if p := sugar.Compile(); len(p) > 1 || p[0] != Step(EncodeStep{}) {
+			return fmt.Errorf("%w: pipeline excludes format, quality, max dimensions, operations, crop, filters, watermarks, targets and encoder options", ErrInvalidOptions)
+		}
+	}
+	if o.MaxWidth < 0 || o.MaxHeight < 0 {
+		return fmt.Errorf("%w: max dimensions must not be negative", ErrInvalidOptions)
+	}
+	if len(o.Operations) > maxOperations {
+		return fmt.Errorf("%w: at most %d operations", ErrInvalidOptions, maxOperations)
+	}
+	if len(o.Filters) > maxFilters {
+		return fmt.Errorf("%w: at most %d filters", ErrInvalidOptions, maxFilters)
+	}
+	if len(o.Watermarks) > maxWatermarks {
+		return fmt.Errorf("%w: at most %d watermarks", ErrInvalidOptions, maxWatermarks)
+	}
+	if o.Page < 0 {
+		return fmt.Errorf("%w: page must not be negative", ErrInvalidOptions)
+	}
+	if o.Crop.Enabled() && (o.MaxWidth > 0 || o.MaxHeight > 0) {
+		return fmt.Errorf("%w: crop and max dimensions are mutually exclusive", ErrInvalidOptions)
+	}
+	if err := o.Crop.Validate(); err != nil {
+		return err
+	}
+	if err := o.Compile().Validate(); err != nil {
+		return err
+	}
+	if steps, _, encode := o.Compile().Stages(); encode.Format == "svg" && (len(steps) > 0 || o.Placeholders || o.Analyze) {
+		// SVG output is the minified input: no pixels to draw on or measure.
+		return fmt.Errorf("%w: svg output takes no operations, crops, filters, watermarks, placeholders or analysis", ErrInvalidOptions)
+	}
+	if err := o.Metadata.Validate(); err != nil {
+		return err
+	}
+	if _, err := o.BackgroundColor(); err != nil {
+		return err
+	}
+	if err := o.Alpha.Validate(); err != nil {
+		return err
+	}
+	if err := o.Animation.Validate(); err != nil {
+		return err
+	}
+	if err := o.SVG.Validate(); err != nil {
+		return err
+	}
+	return o.PDF.Validate()
+}
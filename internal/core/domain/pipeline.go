@@ -0,0 +1,521 @@
+package domain
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// maxSteps bounds a Pipeline: the operations, filters and watermarks Options
+// allow plus a cover resize, an inside resize and the encode step.
+const maxSteps = maxOperations + maxFilters + maxWatermarks + 3
+
+// Step kinds besides the geometric OperationType values.
+const (
+	OpResize OperationType = "resize" // ResizeStep
+	OpEncode OperationType = "encode" // EncodeStep
+)
+
+// Step is one typed operation of a Pipeline: an Operation, a ResizeStep, a
+// Filter, a WatermarkStep or an EncodeStep.
+type Step interface {
+	Kind() OperationType
+	Validate() error
+}
+
+// Kind returns the operation type.
+func (op Operation) Kind() OperationType {
+	return op.Type
+}
+
+// Resize fits.
+const (
+	FitInside = "inside" // Shrink to fit within the box, keeping the aspect ratio
+	FitCover  = "cover"  // Scale to cover the box and cut off the overflow
+)
+
+// ResizeStep scales the image. The inside fit shrinks it to fit within Width x
+// Height, either of which may be 0 for no limit. The cover fit makes it
+// exactly Width x Height like CropOptions, placed by Gravity, Strategy or
+// Focus.
+type ResizeStep struct {
+	Width    int         `json:"width,omitempty"`
+	Height   int         `json:"height,omitempty"`
+	Fit      string      `json:"fit,omitempty"` // Default inside
+	Gravity  Gravity     `json:"gravity,omitempty"`
+	Strategy string      `json:"strategy,omitempty"`
+	Focus    *FocalPoint `json:"focus,omitempty"`
+}
+
+// Kind returns OpResize.
+func (s ResizeStep) Kind() OperationType {
+	return OpResize
+}
+
+// Cover returns the crop a cover resize makes.
+func (s ResizeStep) Cover() (CropOptions, bool) {
+	if s.Fit != FitCover {
+		return CropOptions{}, false
+	}
+	return CropOptions{Width: s.Width, Height: s.Height, Gravity: s.Gravity, Strategy: s.Strategy, Focus: s.Focus}, true
+}
+
+// Validate checks the size and that only cover resizes are placed.
+func (s ResizeStep) Validate() error {
+	if s.Width < 0 || s.Height < 0 {
+		return fmt.Errorf("%w: resize size must not be negative", ErrInvalidOptions)
+	}
+	switch s.Fit {
+	case "", FitInside:
+		if s.Width == 0 && s.Height == 0 {
+			return fmt.Errorf("%w: resize needs a width or a height", ErrInvalidOptions)
+		}
+		if s.Gravity != "" || s.Strategy != "" || s.Focus != nil {
+			return fmt.Errorf("%w: resize gravity, strategy and focus need the cover fit", ErrInvalidOptions)
+		}
+		return nil
+	case FitCover:
+		if s.Width == 0 || s.Height == 0 {
+			return fmt.Errorf("%w: cover resize needs both width and height", ErrInvalidOptions)
+		}
+		crop, _ := s.Cover()
+		return crop.Validate()
+	default:
+		return fmt.Errorf("%w: resize fit must be inside or cover", ErrInvalidOptions)
+	}
+}
+
+// MarshalJSON adds the "op" field.
+func (s ResizeStep) MarshalJSON() ([]byte, error) {
+	type fields ResizeStep
+	return marshalStep(OpResize, fields(s))
+}
+
+// EncodeStep writes the output. An empty Format keeps the input format.
+type EncodeStep struct {
+	Format  string `json:"format,omitempty"`
+	Quality int    `json:"quality,omitempty"`
+	// TargetBytes, when set, makes the processor search for the highest
+	// quality (capped by Quality) and, if needed, smaller dimensions that fit
+	// this size.
+	TargetBytes int64 `json:"target_bytes,omitempty"`
+	// TargetSSIM, when set, makes the processor pick the smallest encoding
+	// whose SSIM against the source is at least this value (0..1).
+	TargetSSIM float64 `json:"target_ssim,omitempty"`
+
+	JPEG JPEGOptions `json:"jpeg,omitempty"` // Used when the output is JPEG
+	PNG  PNGOptions  `json:"png,omitempty"`  // Used when the output is PNG
+	WebP WebPOptions `json:"webp,omitempty"` // Used when the output is a still WebP
+	AVIF AVIFOptions `json:"avif,omitempty"` // Used when Format is "avif"
+}
+
+// Kind returns OpEncode.
+func (s EncodeStep) Kind() OperationType {
+	return OpEncode
+}
+
+// Validate checks encoder option ranges and combinations.
+func (s EncodeStep) Validate() error {
+	if s.Quality < 0 || s.Quality > 100 {
+		return fmt.Errorf("%w: quality must be in 0..100 (0 = default)", ErrInvalidOptions)
+	}
+	if s.TargetBytes < 0 {
+		return fmt.Errorf("%w: target_bytes must not be negative", ErrInvalidOptions)
+	}
+	if s.TargetSSIM < 0 || s.TargetSSIM >= 1 {
+		return fmt.Errorf("%w: target_ssim must be in (0, 1)", ErrInvalidOptions)
+	}
+	if s.TargetBytes > 0 && s.TargetSSIM > 0 {
+		return fmt.Errorf("%w: target_bytes and target_ssim are mutually exclusive", ErrInvalidOptions)
+	}
+	if err := s.JPEG.Validate(); err != nil {
+		return err
+	}
+	if err := s.PNG.Validate(); err != nil {
+		return err
+	}
+	if err := s.WebP.Validate(); err != nil {
+		return err
+	}
+	return s.AVIF.Validate()
+}
+
+// MarshalJSON adds the "op" field.
+func (s EncodeStep) MarshalJSON() ([]byte, error) {
+	type fields EncodeStep
+	return marshalStep(OpEncode, fields(s))
+}
+
+// marshalStep encodes the fields of a step, a type without MarshalJSON,
+// after its "op".
+func marshalStep(op OperationType, fields any) ([]byte, error) {
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return nil, err
+	}
+	head := fmt.Sprintf(`{"op":%q`, op)
+	if string(data) == "{}" {
+		return []byte(head + "}"), nil
+	}
+	return append([]byte(head+","), data[1:]...), nil
+}
+
+// Pipeline is an ordered list of steps run on the upright, decoded image. An
+// EncodeStep may only come last; without one the input format is kept at the
+// processor's default quality.
+//
+// In JSON a pipeline is an array of objects named by their "op" field:
+//
+//	[{"op":"rotate","angle":90},{"op":"resize","width":800},{"op":"encode","format":"webp","quality":80}]
+//
+// ParsePipeline and Compact use a shorter form for URLs, steps separated by
+// '/' and arguments by ':':
+//
+//	rotate:90/resize:800:0/encode:webp:80
+type Pipeline []Step
+
+// Encode returns the final encode step, or the zero step when there is none.
+func (p Pipeline) Encode() EncodeStep {
+	if len(p) > 0 {
+		if s, ok := p[len(p)-1].(EncodeStep); ok {
+			return s
+		}
+	}
+	return EncodeStep{}
+}
+
+// WithEncodeDefaults returns a copy of the pipeline whose encode step, added
+// when missing, falls back to format and quality.
+func (p Pipeline) WithEncodeDefaults(format string, quality int) Pipeline {
+	enc := p.Encode()
+	enc.Format = cmp.Or(enc.Format, format)
+	enc.Quality = cmp.Or(enc.Quality, quality)
+
+	out := make(Pipeline, 0, len(p)+1)
+	for _, step := range p {
+		if _, ok := step.(EncodeStep); !ok {
+			out = append(out, step)
+		}
+	}
+	return append(out, enc)
+}
+
+// Stages splits the pipeline the way processors run it: the steps applied to
+// the pixels in order, the bounds of a final inside resize and the encode
+// step. The encoder applies the bounds itself so size searches can shrink
+// the image further and animations keep their frames.
+func (p Pipeline) Stages() (steps Pipeline, fit ResizeStep, encode EncodeStep) {
+	encode = p.Encode()
+	steps = p
+	if len(steps) > 0 && steps[len(steps)-1].Kind() == OpEncode {
+		steps = steps[:len(steps)-1]
+	}
+	if len(steps) > 0 {
+		if last, ok := steps[len(steps)-1].(ResizeStep); ok && last.Fit != FitCover {
+			steps, fit = steps[:len(steps)-1], last
+		}
+	}
+	return steps, fit, encode
+}
+
+// Validate checks every step and the position of the encode step.
+func (p Pipeline) Validate() error {
+	if len(p) > maxSteps {
+		return fmt.Errorf("%w: at most %d pipeline steps", ErrInvalidOptions, maxSteps)
+	}
+	for i, step := range p {
+		if step == nil {
+			return fmt.Errorf("step %d: %w: missing step", i+1, ErrInvalidOptions)
+		}
+		if step.Kind() == OpEncode && i != len(p)-1 {
+			return fmt.Errorf("step %d: %w: encode must be the last step", i+1, ErrInvalidOptions)
+		}
+		if err := step.Validate(); err != nil {
+			return fmt.Errorf("step %d: %w", i+1, err)
+		}
+	}
+	return nil
+}
+
+// UnmarshalJSON decodes each step into the type its "op" field names.
+func (p *Pipeline) UnmarshalJSON(data []byte) error {
+	var raw []json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	steps := make(Pipeline, 0, len(raw))
+	for i, r := range raw {
+		var head struct {
+			Op OperationType `json:"op"`
+		}
+		if err := json.Unmarshal(r, &head); err != nil {
+			return fmt.Errorf("step %d: %w", i+1, err)
+		}
+
+		var (
+			step Step
+			err  error
+		)
+		switch head.Op {
+		case OpResize:
+			var s ResizeStep
+			err = json.Unmarshal(r, &s)
+			step = s
+		case OpEncode:
+			var s EncodeStep
+			err = json.Unmarshal(r, &s)
+			step = s
+		case OpWatermark:
+			var s WatermarkStep
+			err = json.Unmarshal(r, &s)
+			step = s
+		case OpBlur, OpSharpen, OpGrayscale, OpAdjust, OpGamma, OpTint:
+			var f Filter
+			err = json.Unmarshal(r, &f)
+			step = f
+		default:
+			var op Operation
+			err = json.Unmarshal(r, &op)
+			step = op
+		}
+		if err != nil {
+			return fmt.Errorf("step %d: %w", i+1, err)
+		}
+		steps = append(steps, step)
+	}
+	*p = steps
+	return nil
+}
+
+// ParsePipeline parses a pipeline as a JSON array or in the compact form:
+//
+//	rotate:<angle>[:<background>]
+//	flip
+//	flop
+//	crop:<left>:<top>:<width>:<height>
+//	trim[:<threshold>]
+//	pad:<ratio>[:<background>]
+//	resize:<width>:<height>[:cover[:<gravity>[:<strategy>]|:<x>,<y>]]
+//	blur:<sigma>
+//	sharpen[:<sigma>[:<amount>]]
+//	grayscale
+//	adjust:<brightness>[:<contrast>[:<saturation>]]
+//	gamma:<gamma>
+//	tint:<color>[:<amount>]
+//	encode:[<format>][:<quality>]
+//
+// Colours go without '#'; 0 or an empty argument keeps the default.
+// Watermarks, encoder tuning and size targets need JSON.
+func ParsePipeline(s string) (Pipeline, error) {
+	var p Pipeline
+	if strings.HasPrefix(strings.TrimSpace(s), "[") {
+		if err := json.Unmarshal([]byte(s), &p); err != nil {
+			return nil, fmt.Errorf("%w: pipeline: %v", ErrInvalidOptions, err)
+		}
+		return p, nil
+	}
+	for i, part := range strings.Split(s, "/") {
+		step, err := parseStep(part)
+		if err != nil {
+			return nil, fmt.Errorf("step %d: %w", i+1, err)
+		}
+		p = append(p, step)
+	}
+	return p, nil
+}
+
+// parseStep parses one step of the compact form.
+func parseStep(s string) (Step, error) {
+	name, rest, _ := strings.Cut(s, ":")
+	var args []string
+	if rest != "" {
+		args = strings.Split(rest, ":")
+	}
+	a := stepArgs{op: name, args: args}
+
+	var step Step
+	switch op := OperationType(name); op {
+	case OpRotate:
+		step = Operation{Type: op, Angle: a.float(0, true), Background: a.string(1)}
+		a.max(2)
+	case OpFlip, OpFlop:
+		step = Operation{Type: op}
+		a.max(0)
+	case OpCrop:
+		step = Operation{Type: op, Left: a.int(0, true), Top: a.int(1, true), Width: a.int(2, true), Height: a.int(3, true)}
+		a.max(4)
+	case OpTrim:
+		step = Operation{Type: op, Threshold: a.float(0, false)}
+		a.max(1)
+	case OpPad:
+		step = Operation{Type: op, Ratio: a.float(0, true), Background: a.string(1)}
+		a.max(2)
+	case OpResize:
+		r := ResizeStep{Width: a.int(0, true), Height: a.int(1, true), Fit: a.string(2)}
+		if x, y, ok := strings.Cut(a.string(3), ","); ok {
+			r.Focus = &FocalPoint{X: a.parseFloat(x), Y: a.parseFloat(y)}
+			a.max(4)
+		} else {
+			r.Gravity, r.Strategy = Gravity(a.string(3)), a.string(4)
+			a.max(5)
+		}
+		step = r
+	case OpEncode:
+		step = EncodeStep{Format: a.string(0), Quality: a.int(1, false)}
+		a.max(2)
+	case OpBlur:
+		step = Filter{Type: op, Sigma: a.float(0, true)}
+		a.max(1)
+	case OpSharpen:
+		step = Filter{Type: op, Sigma: a.float(0, false), Amount: a.float(1, false)}
+		a.max(2)
+	case OpGrayscale:
+		step = Filter{Type: op}
+		a.max(0)
+	case OpAdjust:
+		step = Filter{Type: op, Brightness: a.float(0, false), Contrast: a.float(1, false), Saturation: a.float(2, false)}
+		a.max(3)
+	case OpGamma:
+		step = Filter{Type: op, Gamma: a.float(0, true)}
+		a.max(1)
+	case OpTint:
+		step = Filter{Type: op, Color: a.arg(0, true), Amount: a.float(1, false)}
+		a.max(2)
+	case OpWatermark:
+		return nil, fmt.Errorf("%w: watermark steps need the JSON form", ErrInvalidOptions)
+	default:
+		return nil, fmt.Errorf("%w: unknown operation %q", ErrInvalidOptions, name)
+	}
+	return step, a.err
+}
+
+// stepArgs reads the positional arguments of a compact step and keeps the
+// first error.
+type stepArgs struct {
+	op   string
+	args []string
+	err  error
+}
+
+func (a *stepArgs) string(i int) string {
+	if i < len(a.args) {
+		return a.args[i]
+	}
+	return ""
+}
+
+func (a *stepArgs) int(i int, required bool) int {
+	v := a.arg(i, required)
+	if v == "" {
+		return 0
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil && a.err == nil {
+		a.err = fmt.Errorf("%w: %s argument %d must be an integer", ErrInvalidOptions, a.op, i+1)
+	}
+	return n
+}
+
+func (a *stepArgs) float(i int, required bool) float64 {
+	return a.parseFloat(a.arg(i, required))
+}
+
+func (a *stepArgs) parseFloat(v string) float64 {
+	if v == "" {
+		return 0
+	}
+	n, err := strconv.ParseFloat(v, 64)
+	if err != nil && a.err == nil {
+		a.err = fmt.Errorf("%w: %s argument %q must be a number", ErrInvalidOptions, a.op, v)
+	}
+	return n
+}
+
+func (a *stepArgs) arg(i int, required bool) string {
+	v := a.string(i)
+	if v == "" && required && a.err == nil {
+		a.err = fmt.Errorf("%w: %s needs argument %d", ErrInvalidOptions, a.op, i+1)
+	}
+	return v
+}
+
+// max fails when more than n arguments are given.
+func (a *stepArgs) max(n int) {
+	if len(a.args) > n && a.err == nil {
+		a.err = fmt.Errorf("%w: %s takes at most %d arguments", ErrInvalidOptions, a.op, n)
+	}
+}
+
+// Compact returns the pipeline in the form ParsePipeline reads. It fails for
+// encode steps with options that form cannot express.
+func (p Pipeline) Compact() (string, error) {
+	parts := make([]string, len(p))
+	for i, step := range p {
+		var args []string
+		switch s := step.(type) {
+		case Operation:
+			switch s.Type {
+			case OpRotate:
+				args = []string{formatFloat(s.Angle), strings.TrimPrefix(s.Background, "#")}
+			case OpCrop:
+				args = []string{strconv.Itoa(s.Left), strconv.Itoa(s.Top), strconv.Itoa(s.Width), strconv.Itoa(s.Height)}
+			case OpTrim:
+				if s.Threshold != 0 {
+					args = []string{formatFloat(s.Threshold)}
+				}
+			case OpPad:
+				args = []string{formatFloat(s.Ratio), strings.TrimPrefix(s.Background, "#")}
+			}
+		case ResizeStep:
+			args = []string{strconv.Itoa(s.Width), strconv.Itoa(s.Height), s.Fit}
+			if s.Focus != nil {
+				args = append(args, formatFloat(s.Focus.X)+","+formatFloat(s.Focus.Y))
+			} else {
+				args = append(args, string(s.Gravity), s.Strategy)
+			}
+		case EncodeStep:
+			if s != (EncodeStep{Format: s.Format, Quality: s.Quality}) {
+				return "", fmt.Errorf("%w: step %d has encoder options only JSON can express", ErrInvalidOptions, i+1)
+			}
+			args = []string{s.Format, ""}
+			if s.Quality != 0 {
+				args[1] = strconv.Itoa(s.Quality)
+			}
+		case Filter:
+			switch s.Type {
+			case OpBlur:
+				args = []string{formatFloat(s.Sigma)}
+			case OpSharpen:
+				args = []string{optionalFloat(s.Sigma), optionalFloat(s.Amount)}
+			case OpAdjust:
+				args = []string{optionalFloat(s.Brightness), optionalFloat(s.Contrast), optionalFloat(s.Saturation)}
+			case OpGamma:
+				args = []string{formatFloat(s.Gamma)}
+			case OpTint:
+				args = []string{strings.TrimPrefix(s.Color, "#"), optionalFloat(s.Amount)}
+			}
+		case WatermarkStep:
+			return "", fmt.Errorf("%w: step %d is a watermark, which only JSON can express", ErrInvalidOptions, i+1)
+		}
+		for len(args) > 0 && args[len(args)-1] == "" {
+			args = args[:len(args)-1]
+		}
+		parts[i] = strings.Join(append([]string{string(step.Kind())}, args...), ":")
+	}
+	return strings.Join(parts, "/"), nil
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// optionalFloat formats v, leaving 0 empty.
+func optionalFloat(v float64) string {
+	if v == 0 {
+		return ""
+	}
+	return formatFloat(v)
+}
@@ -0,0 +1,113 @@
+package domain
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParsePipeline_Compact(t *testing.T) {
+	tests := []struct {
+		compact string
+		want    Pipeline
+	}{
+		{"rotate:90", Pipeline{Operation{Type: OpRotate, Angle: 90}}},
+		{"rotate:12.5:ff00ff/flip/flop", Pipeline{Operation{Type: OpRotate, Angle: 12.5, Background: "ff00ff"}, Operation{Type: OpFlip}, Operation{Type: OpFlop}}},
+		{"crop:10:20:300:200/trim:25/pad:1.5", Pipeline{
+			Operation{Type: OpCrop, Left: 10, Top: 20, Width: 300, Height: 200},
+			Operation{Type: OpTrim, Threshold: 25},
+			Operation{Type: OpPad, Ratio: 1.5},
+		}},
+		{"resize:800:0", Pipeline{ResizeStep{Width: 800}}},
+		{"resize:400:300:cover:ne", Pipeline{ResizeStep{Width: 400, Height: 300, Fit: FitCover, Gravity: GravityNorthEast}}},
+		{"resize:400:300:cover:smart:entropy", Pipeline{ResizeStep{Width: 400, Height: 300, Fit: FitCover, Gravity: GravitySmart, Strategy: StrategyEntropy}}},
+		{"resize:400:300:cover:0.25,0.75", Pipeline{ResizeStep{Width: 400, Height: 300, Fit: FitCover, Focus: &FocalPoint{X: 0.25, Y: 0.75}}}},
+		{"blur:2.5/sharpen/sharpen::3/grayscale", Pipeline{
+			Filter{Type: OpBlur, Sigma: 2.5},
+			Filter{Type: OpSharpen},
+			Filter{Type: OpSharpen, Amount: 3},
+			Filter{Type: OpGrayscale},
+		}},
+		{"adjust:1.1::0.8/gamma:2.2/tint:704214:0.4", Pipeline{
+			Filter{Type: OpAdjust, Brightness: 1.1, Saturation: 0.8},
+			Filter{Type: OpGamma, Gamma: 2.2},
+			Filter{Type: OpTint, Color: "704214", Amount: 0.4},
+		}},
+		{"trim/encode:webp:80", Pipeline{Operation{Type: OpTrim}, EncodeStep{Format: "webp", Quality: 80}}},
+		{"encode::60", Pipeline{EncodeStep{Quality: 60}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.compact, func(t *testing.T) {
+			got, err := ParsePipeline(tt.compact)
+			if err != nil {
+				t.Fatalf("ParsePipeline: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("ParsePipeline = %#v, want %#v", got, tt.want)
+			}
+			compact, err := got.Compact()
+			if err != nil {
+				t.Fatalf("Compact: %v", err)
+			}
+			if compact != tt.compact {
+				t.Errorf("Compact = %q, want %q", compact, tt.compact)
+			}
+		})
+	}
+}
+
+func TestParsePipeline_Invalid(t *testing.T) {
+	for _, compact := range []string{"skew:10", "rotate", "crop:1:2:3", "resize:wide:0", "flip:1", "blur", "tint", "grayscale:1", "encode:webp:80:1", "watermark", "[{\"op\":"} {
+		if _, err := ParsePipeline(compact); !errors.Is(err, ErrInvalidOptions) {
+			t.Errorf("ParsePipeline(%q) = %v, want ErrInvalidOptions", compact, err)
+		}
+	}
+}
+
+func TestPipeline_JSON(t *testing.T) {
+	method := 6
+	p := Pipeline{
+		Operation{Type: OpRotate, Angle: 90},
+		ResizeStep{Width: 200, Height: 100, Fit: FitCover, Gravity: GravitySmart},
+		WatermarkStep{Text: "©", Opacity: 0.5, Tile: true},
+		EncodeStep{Format: "webp", Quality: 80, WebP: WebPOptions{Method: &method}},
+	}
+	data, err := json.Marshal(p)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+
+	got, err := ParsePipeline(string(data))
+	if err != nil {
+		t.Fatalf("ParsePipeline(%s): %v", data, err)
+	}
+	if !reflect.DeepEqual(got, p) {
+		t.Fatalf("round trip of %s = %#v, want %#v", data, got, p)
+	}
+	if _, err := got.Compact(); !errors.Is(err, ErrInvalidOptions) {
+		t.Errorf("Compact with a watermark = %v, want ErrInvalidOptions", err)
+	}
+}
+
+func TestOptions_Compile(t *testing.T) {
+	opts := Options{
+		Format:     "jpeg",
+		Quality:    70,
+		Operations: []Operation{{Type: OpFlip}},
+		Crop:       CropOptions{Width: 100, Height: 50, Gravity: GravitySouth},
+	}
+	want := Pipeline{
+		Operation{Type: OpFlip},
+		ResizeStep{Width: 100, Height: 50, Fit: FitCover, Gravity: GravitySouth},
+		EncodeStep{Format: "jpeg", Quality: 70},
+	}
+	if got := opts.Compile(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("Compile = %#v, want %#v", got, want)
+	}
+
+	steps, fit, encode := Options{Format: "png", MaxWidth: 320}.Compile().Stages()
+	if len(steps) != 0 || fit != (ResizeStep{Width: 320}) || encode.Format != "png" {
+		t.Errorf("Stages = %v, %+v, %+v", steps, fit, encode)
+	}
+}
@@ -0,0 +1,118 @@
+package domain
+
+import (
+	"fmt"
+	"unicode/utf8"
+)
+
+// OpWatermark is the kind of WatermarkStep.
+const OpWatermark OperationType = "watermark"
+
+// maxWatermarks bounds Options.Watermarks.
+const maxWatermarks = 4
+
+// maxWatermarkText bounds the text of a watermark in characters.
+const maxWatermarkText = 200
+
+// Watermark fonts, from the Go font family.
+const (
+	FontSans       = "sans" // Default
+	FontSansBold   = "sans-bold"
+	FontSansItalic = "sans-italic"
+	FontMono       = "mono"
+	FontMonoBold   = "mono-bold"
+)
+
+// WatermarkStep overlays an image from the repository or a line of text.
+type WatermarkStep struct {
+	Image string `json:"image,omitempty"` // Repository path of a PNG, JPEG, GIF or WebP
+	Text  string `json:"text,omitempty"`  // Drawn instead of an image
+	Font  string `json:"font,omitempty"`  // Font of Text, default sans
+	Color string `json:"color,omitempty"` // Hex colour of Text, default white
+	// Opacity scales the watermark's own alpha, 0..1, 0 = 1.
+	Opacity float64 `json:"opacity,omitempty"`
+	// Gravity is the corner or edge the watermark sits at, default se.
+	// Tiled watermarks ignore it.
+	Gravity Gravity `json:"gravity,omitempty"`
+	// Margin is the distance in pixels from the edges, and between tiles.
+	Margin int `json:"margin,omitempty"`
+	// Scale is the watermark width as a fraction of the image width. 0 keeps
+	// an image at its own size and makes text a quarter of the image wide.
+	Scale float64 `json:"scale,omitempty"`
+	Tile  bool    `json:"tile,omitempty"` // Repeat the watermark over the whole image
+
+	// ImageData is the content of Image, which the service loads from the
+	// repository.
+	ImageData []byte `json:"-"`
+}
+
+// Kind returns OpWatermark.
+func (s WatermarkStep) Kind() OperationType {
+	return OpWatermark
+}
+
+// Validate checks that the step names one source and that its placement is
+// in range.
+func (s WatermarkStep) Validate() error {
+	if (s.Image == "") == (s.Text == "") {
+		return fmt.Errorf("%w: watermark needs either an image or a text", ErrInvalidOptions)
+	}
+	if s.Image != "" && (s.Font != "" || s.Color != "") {
+		return fmt.Errorf("%w: watermark font and color only apply to text", ErrInvalidOptions)
+	}
+	if utf8.RuneCountInString(s.Text) > maxWatermarkText {
+		return fmt.Errorf("%w: watermark text is longer than %d characters", ErrInvalidOptions, maxWatermarkText)
+	}
+	switch s.Font {
+	case "", FontSans, FontSansBold, FontSansItalic, FontMono, FontMonoBold:
+	default:
+		return fmt.Errorf("%w: watermark font must be sans, sans-bold, sans-italic, mono or mono-bold", ErrInvalidOptions)
+	}
+	if s.Color != "" {
+		if _, err := ParseColor(s.Color); err != nil {
+			return err
+		}
+	}
+	if s.Opacity < 0 || s.Opacity > 1 {
+		return fmt.Errorf("%w: watermark opacity must be in 0..1", ErrInvalidOptions)
+	}
+	if _, ok := gravityPoints[s.Gravity]; !ok {
+		return fmt.Errorf("%w: watermark gravity must be center, n, ne, e, se, s, sw, w or nw", ErrInvalidOptions)
+	}
+	if s.Margin < 0 {
+		return fmt.Errorf("%w: watermark margin must not be negative", ErrInvalidOptions)
+	}
+	if s.Scale < 0 || s.Scale > 1 {
+		return fmt.Errorf("%w: watermark scale must be in 0..1", ErrInvalidOptions)
+	}
+	return nil
+}
+
+// TextColor returns the colour of the text, white by default.
+func (s WatermarkStep) TextColor() Color {
+	c, err := ParseColor(s.Color)
+	if err != nil {
+		return White
+	}
+	return c
+}
+
+// Position returns the top left corner of a markW x markH watermark on a
+// width x height image: at the gravity, Margin pixels in from the edges it
+// touches.
+func (s WatermarkStep) Position(width, height, markW, markH int) (left, top int) {
+	gravity := s.Gravity
+	if gravity == "" {
+		gravity = GravitySouthEast
+	}
+	point := gravityPoints[gravity]
+	left = s.Margin + round(point.X*float64(width-markW-2*s.Margin))
+	top = s.Margin + round(point.Y*float64(height-markH-2*s.Margin))
+	return left, top
+}
+
+// MarshalJSON adds the "op" field.
+func (s WatermarkStep) MarshalJSON() ([]byte, error) {
+	type fields WatermarkStep
+	return marshalStep(OpWatermark, fields(s))
+}
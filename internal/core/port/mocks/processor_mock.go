@@ -35,10 +35,10 @@ func (m *MockProcessor) EXPECT() *MockProcessorMockRecorder {
 }
 
 // Process mocks base method.
-func (m *MockProcessor) Process(inputFile domain.File, opts domain.Options) (domain.File, error) {
+func (m *MockProcessor) Process(inputFile domain.File, opts domain.Options) (domain.ProcessedFile, error) {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "Process", inputFile, opts)
-	ret0, _ := ret[0].(domain.File)
+	ret0, _ := ret[0].(domain.ProcessedFile)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
@@ -62,3 +62,17 @@ func (mr *MockProcessorMockRecorder) Supports(mimeType interface{}) *gomock.Call
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Supports", reflect.TypeOf((*MockProcessor)(nil).Supports), mimeType)
 }
+
+// SupportsStep mocks base method.
+func (m *MockProcessor) SupportsStep(step domain.Step) bool {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SupportsStep", step)
+	ret0, _ := ret[0].(bool)
+	return ret0
+}
+
+// SupportsStep indicates an expected call of SupportsStep.
+func (mr *MockProcessorMockRecorder) SupportsStep(step interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SupportsStep", reflect.TypeOf((*MockProcessor)(nil).SupportsStep), step)
+}
@@ -35,6 +35,20 @@ func (m *MockFileRepository) EXPECT() *MockFileRepositoryMockRecorder {
 	return m.recorder
 }
 
+// Delete mocks base method.
+func (m *MockFileRepository) Delete(ctx context.Context, path string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Delete", ctx, path)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Delete indicates an expected call of Delete.
+func (mr *MockFileRepositoryMockRecorder) Delete(ctx, path interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Delete", reflect.TypeOf((*MockFileRepository)(nil).Delete), ctx, path)
+}
+
 // Get mocks base method.
 func (m *MockFileRepository) Get(ctx context.Context, path string) (domain.File, error) {
 	m.ctrl.T.Helper()
@@ -64,3 +78,32 @@ func (mr *MockFileRepositoryMockRecorder) Save(ctx, file, path interface{}) *gom
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Save", reflect.TypeOf((*MockFileRepository)(nil).Save), ctx, file, path)
 }
+
+// SaveMeta mocks base method.
+func (m *MockFileRepository) SaveMeta(ctx context.Context, meta domain.FileMeta, path string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SaveMeta", ctx, meta, path)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SaveMeta indicates an expected call of SaveMeta.
+func (mr *MockFileRepositoryMockRecorder) SaveMeta(ctx, meta, path interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveMeta", reflect.TypeOf((*MockFileRepository)(nil).SaveMeta), ctx, meta, path)
+}
+
+// Stat mocks base method.
+func (m *MockFileRepository) Stat(ctx context.Context, path string) (domain.FileMeta, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Stat", ctx, path)
+	ret0, _ := ret[0].(domain.FileMeta)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Stat indicates an expected call of Stat.
+func (mr *MockFileRepositoryMockRecorder) Stat(ctx, path interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Stat", reflect.TypeOf((*MockFileRepository)(nil).Stat), ctx, path)
+}
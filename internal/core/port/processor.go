@@ -4,6 +4,8 @@ import "github.com/andreychano/compressor-golang/internal/core/domain"
 
 // Processor defines a contract for any compression algorithm
 type Processor interface {
-	Process(inputFile domain.File, opts domain.Options) (domain.File, error)
+	Process(inputFile domain.File, opts domain.Options) (domain.ProcessedFile, error)
 	Supports(mimeType string) bool
+	// SupportsStep reports whether the processor can run a pipeline step.
+	SupportsStep(step domain.Step) bool
 }
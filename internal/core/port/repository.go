@@ -11,4 +11,10 @@ import (
 type FileRepository interface {
 	Save(ctx context.Context, file domain.File, path string) (domain.SavedFile, error)
 	Get(ctx context.Context, path string) (domain.File, error)
+	// Delete removes the file stored at path.
+	Delete(ctx context.Context, path string) error
+	// SaveMeta persists the metadata record of the file stored at path.
+	SaveMeta(ctx context.Context, meta domain.FileMeta, path string) error
+	// Stat reads the metadata record of the file stored at path.
+	Stat(ctx context.Context, path string) (domain.FileMeta, error)
 }
@@ -1,13 +1,22 @@
 package service
 
 import (
+	"bytes"
+	"cmp"
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"path/filepath"
+	"slices"
+	"time"
 
 	"github.com/andreychano/compressor-golang/internal/config"
 	"github.com/andreychano/compressor-golang/internal/core/domain"
 	"github.com/andreychano/compressor-golang/internal/core/port"
+	"github.com/andreychano/compressor-golang/internal/metadata"
+	"github.com/andreychano/compressor-golang/internal/metrics"
+	"github.com/andreychano/compressor-golang/internal/thumbnail"
 	"github.com/google/uuid"
 )
 
@@ -25,21 +34,343 @@ func NewCompressionService(repo port.FileRepository, cfg config.Config, processo
 	}
 }
 
-func (s *CompressionService) Process(file domain.File, opts domain.Options) (domain.File, error) {
-	var selectedProcessor port.Processor
+func (s *CompressionService) Process(file domain.File, opts domain.Options) (domain.ProcessedFile, error) {
+	opts, err := s.applyPreset(opts)
+	if err != nil {
+		return domain.ProcessedFile{}, err
+	}
+	return s.process(context.Background(), file, opts)
+}
+
+// process compresses file with options the preset was already applied to,
+// so that preset watermarks are added once.
+func (s *CompressionService) process(ctx context.Context, file domain.File, opts domain.Options) (domain.ProcessedFile, error) {
+	opts.Metadata = cmp.Or(opts.Metadata, domain.MetadataPolicy(s.cfg.Image.Metadata))
+	if err := opts.Validate(); err != nil {
+		return domain.ProcessedFile{}, err
+	}
+	opts, err := s.loadWatermarks(ctx, opts)
+	if err != nil {
+		return domain.ProcessedFile{}, err
+	}
 
+	pipeline := opts.Compile()
+	selectedProcessor, err := s.selectProcessor(file.MimeType, pipeline)
+	if err != nil {
+		return domain.ProcessedFile{}, err
+	}
+
+	processed, err := selectedProcessor.Process(file, opts)
+	if err != nil {
+		return domain.ProcessedFile{}, err
+	}
+
+	steps, _, encode := pipeline.Stages()
+	processed, err = s.applySavingsPolicy(file, processed, opts.Metadata, len(steps) > 0, encode.TargetBytes)
+	if err != nil {
+		return domain.ProcessedFile{}, err
+	}
+
+	if processed.Report != nil {
+		completeReport(processed.Report, file, processed)
+	}
+
+	return processed, nil
+}
+
+// Placeholders returns the BlurHash and ThumbHash of file after the
+// operations, crops, filters and watermarks in opts. Only a thumbnail is
+// rendered, so this is much cheaper than Process; encoder options are
+// ignored.
+func (s *CompressionService) Placeholders(ctx context.Context, file domain.File, opts domain.Options) (domain.Placeholders, error) {
+	processed, err := s.thumbnail(ctx, file, opts, domain.Options{Placeholders: true})
+	if err != nil {
+		return domain.Placeholders{}, err
+	}
+	if processed.Placeholders == nil {
+		return domain.Placeholders{}, errors.New("processor returned no placeholders")
+	}
+	return *processed.Placeholders, nil
+}
+
+// Analyze returns the dominant colour and palette of file after the steps in
+// opts, computed on a thumbnail like Placeholders.
+func (s *CompressionService) Analyze(ctx context.Context, file domain.File, opts domain.Options) (domain.Analysis, error) {
+	processed, err := s.thumbnail(ctx, file, opts, domain.Options{Analyze: true})
+	if err != nil {
+		return domain.Analysis{}, err
+	}
+	if processed.Analysis == nil {
+		return domain.Analysis{}, errors.New("processor returned no analysis")
+	}
+	return *processed.Analysis, nil
+}
+
+// thumbnail renders file after the pixel steps of opts, scaled to fit within
+// thumbnail.MaxSide, as PNG: both placeholders and the palette downscale to
+// it anyway, so more pixels would only cost time. want sets what to compute from it.
+func (s *CompressionService) thumbnail(ctx context.Context, file domain.File, opts, want domain.Options) (domain.ProcessedFile, error) {
+	opts, err := s.applyPreset(opts)
+	if err != nil {
+		return domain.ProcessedFile{}, err
+	}
+	if err := opts.Validate(); err != nil {
+		return domain.ProcessedFile{}, err
+	}
+
+	steps, fit, _ := opts.Compile().Stages()
+	if fit != (domain.ResizeStep{}) {
+		steps = append(slices.Clip(steps), fit)
+	}
+	thumbnail := domain.ResizeStep{Width: thumbnail.MaxSide, Height: thumbnail.MaxSide}
+
+	return s.process(ctx, file, domain.Options{
+		Pipeline:     append(slices.Clip(steps), thumbnail, domain.EncodeStep{Format: "png"}),
+		Placeholders: want.Placeholders,
+		Analyze:      want.Analyze,
+		Page:         opts.Page,
+		NoAutoRotate: opts.NoAutoRotate,
+		Metadata:     domain.MetadataStrip,
+		Background:   opts.Background,
+		Animation:    domain.AnimationOptions{FirstFrame: true},
+		SVG:          opts.SVG,
+		PDF:          opts.PDF,
+	})
+}
+
+// selectProcessor returns the first processor that loads the file type and
+// runs every step of the pipeline.
+func (s *CompressionService) selectProcessor(mimeType string, pipeline domain.Pipeline) (port.Processor, error) {
+	missing := -1
 	for _, p := range s.processors {
-		if p.Supports(file.MimeType) {
-			selectedProcessor = p
-			break
+		if !p.Supports(mimeType) {
+			continue
+		}
+		i := slices.IndexFunc(pipeline, func(step domain.Step) bool { return !p.SupportsStep(step) })
+		if i < 0 {
+			return p, nil
+		}
+		missing = max(missing, i)
+	}
+
+	if missing >= 0 {
+		return nil, fmt.Errorf("%w: step %d (%s) is not supported for %s", domain.ErrInvalidOptions, missing+1, pipeline[missing].Kind(), mimeType)
+	}
+	return nil, fmt.Errorf("unsupport file type: %s", mimeType)
+}
+
+// applyPreset fills the options the request left unset from the preset it
+// names.
+func (s *CompressionService) applyPreset(opts domain.Options) (domain.Options, error) {
+	if opts.Preset == "" {
+		return opts, nil
+	}
+	preset, ok := s.cfg.Presets[opts.Preset]
+	if !ok {
+		return domain.Options{}, fmt.Errorf("%w: unknown preset %q", domain.ErrInvalidOptions, opts.Preset)
+	}
+
+	if len(opts.Pipeline) > 0 {
+		// The pipeline sets the size itself.
+		opts.Pipeline = opts.Pipeline.WithEncodeDefaults(preset.Format, preset.Quality)
+	} else {
+		opts.Format = cmp.Or(opts.Format, preset.Format)
+		opts.Quality = cmp.Or(opts.Quality, preset.Quality)
+		if !opts.Crop.Enabled() {
+			// A crop sets the size itself.
+			opts.MaxWidth = cmp.Or(opts.MaxWidth, preset.MaxWidth)
+			opts.MaxHeight = cmp.Or(opts.MaxHeight, preset.MaxHeight)
+		}
+		if len(opts.Filters) == 0 {
+			for _, f := range preset.Filters {
+				opts.Filters = append(opts.Filters, domain.Filter{
+					Type:       domain.OperationType(f.Op),
+					Sigma:      f.Sigma,
+					Amount:     f.Amount,
+					Brightness: f.Brightness,
+					Contrast:   f.Contrast,
+					Saturation: f.Saturation,
+					Gamma:      f.Gamma,
+					Color:      f.Color,
+				})
+			}
+		}
+	}
+	opts.Metadata = cmp.Or(opts.Metadata, domain.MetadataPolicy(preset.Metadata))
+
+	if w := preset.Watermark; w != nil {
+		// Appended last, so nothing the request asks for can cover or cut it.
+		step := domain.WatermarkStep{
+			Image:   w.Image,
+			Text:    w.Text,
+			Font:    w.Font,
+			Color:   w.Color,
+			Opacity: w.Opacity,
+			Gravity: domain.Gravity(w.Gravity),
+			Margin:  w.Margin,
+			Scale:   w.Scale,
+			Tile:    w.Tile,
+		}
+		if len(opts.Pipeline) > 0 {
+			// WithEncodeDefaults copied the pipeline and ended it with the
+			// encode step.
+			opts.Pipeline = slices.Insert(opts.Pipeline, len(opts.Pipeline)-1, domain.Step(step))
+		} else {
+			opts.Watermarks = append(slices.Clip(opts.Watermarks), step)
+		}
+	}
+	return opts, nil
+}
+
+// loadWatermarks reads the images of the watermarks in opts from the
+// repository. The slices are copied, the caller's options stay unchanged.
+func (s *CompressionService) loadWatermarks(ctx context.Context, opts domain.Options) (domain.Options, error) {
+	load := func(w domain.WatermarkStep) (domain.WatermarkStep, error) {
+		if w.Image == "" || w.ImageData != nil {
+			return w, nil
 		}
+		file, err := s.repository.Get(ctx, w.Image)
+		if err != nil {
+			return w, fmt.Errorf("%w: watermark image %q: %v", domain.ErrInvalidOptions, w.Image, err)
+		}
+		if c, ok := file.Content.(io.Closer); ok {
+			defer c.Close()
+		}
+		if w.ImageData, err = io.ReadAll(file.Content); err != nil {
+			return w, fmt.Errorf("failed to read watermark image %q: %w", w.Image, err)
+		}
+		return w, nil
 	}
 
-	if selectedProcessor == nil {
-		return domain.File{}, fmt.Errorf("unsupport file type: %s", file.MimeType)
+	if len(opts.Watermarks) > 0 {
+		opts.Watermarks = slices.Clone(opts.Watermarks)
+		for i, w := range opts.Watermarks {
+			var err error
+			if opts.Watermarks[i], err = load(w); err != nil {
+				return domain.Options{}, err
+			}
+		}
 	}
+	if slices.ContainsFunc(opts.Pipeline, isWatermark) {
+		opts.Pipeline = slices.Clone(opts.Pipeline)
+		for i, step := range opts.Pipeline {
+			if w, ok := step.(domain.WatermarkStep); ok {
+				var err error
+				if opts.Pipeline[i], err = load(w); err != nil {
+					return domain.Options{}, err
+				}
+			}
+		}
+	}
+	return opts, nil
+}
 
-	return selectedProcessor.Process(file, opts)
+func isWatermark(step domain.Step) bool {
+	return step.Kind() == domain.OpWatermark
+}
+
+// completeReport fills the size and geometry part of a processor report.
+func completeReport(report *domain.Report, file domain.File, processed domain.ProcessedFile) {
+	report.InputSize = file.Size
+	report.OutputSize = processed.Size
+	report.SavedBytes = file.Size - processed.Size
+	if file.Size > 0 {
+		report.SavedPercent = float64(report.SavedBytes) * 100 / float64(file.Size)
+	}
+	report.InputWidth = processed.SourceWidth
+	report.InputHeight = processed.SourceHeight
+	report.OutputWidth = processed.Width
+	report.OutputHeight = processed.Height
+}
+
+// applySavingsPolicy falls back to the original when recompression made the
+// image larger or saved less than cfg.Image.MinSavingsPercent. The original is
+// only usable when neither the format, the dimensions nor the pixels had to
+// change, it has no metadata the policy removes and it is within targetBytes,
+// if set. transformed reports pipeline steps, such as watermarks, that changed
+// the pixels.
+func (s *CompressionService) applySavingsPolicy(
+	file domain.File,
+	processed domain.ProcessedFile,
+	policy domain.MetadataPolicy,
+	transformed bool,
+	targetBytes int64,
+) (domain.ProcessedFile, error) {
+	processed.Outcome = domain.OutcomeCompressed
+
+	if file.Size <= 0 {
+		return processed, nil
+	}
+
+	minSavings := float64(file.Size) * s.cfg.Image.MinSavingsPercent / 100
+	if processed.Size <= file.Size && float64(file.Size-processed.Size) >= minSavings {
+		return processed, nil
+	}
+	if targetBytes > 0 && file.Size > targetBytes {
+		// The client's size limit outranks the minimum saving.
+		return processed, nil
+	}
+
+	resized := processed.Width != processed.SourceWidth || processed.Height != processed.SourceHeight
+	if processed.MimeType != file.MimeType || resized || transformed || len(processed.RemovedMetadata) > 0 {
+		processed.Outcome = domain.OutcomeTranscoded
+		return processed, nil
+	}
+	if ok, err := complies(file, policy); err != nil || !ok {
+		processed.Outcome = domain.OutcomeTranscoded
+		return processed, err
+	}
+
+	if _, err := file.Content.Seek(0, 0); err != nil {
+		return domain.ProcessedFile{}, fmt.Errorf("failed to seek file content: %w", err)
+	}
+
+	original := domain.ProcessedFile{
+		File:         file,
+		Width:        processed.SourceWidth,
+		Height:       processed.SourceHeight,
+		SourceWidth:  processed.SourceWidth,
+		SourceHeight: processed.SourceHeight,
+		Outcome:      domain.OutcomeOriginal,
+		// Close enough: the output had the same format, size and pixels.
+		Placeholders: processed.Placeholders,
+		Analysis:     processed.Analysis,
+	}
+	if processed.Report != nil {
+		// The original is returned untouched, so it matches the source exactly.
+		original.Report = &domain.Report{
+			PSNR:     metrics.MaxPSNR,
+			SSIM:     1,
+			EncodeMS: processed.Report.EncodeMS,
+		}
+	}
+
+	return original, nil
+}
+
+// complies reports whether file already holds only the metadata policy keeps,
+// so returning it leaks nothing the output dropped, including fields
+// processors do not list in RemovedMetadata. Containers metadata.Filter cannot
+// rewrite rely on that list alone.
+func complies(file domain.File, policy domain.MetadataPolicy) (bool, error) {
+	if _, err := file.Content.Seek(0, 0); err != nil {
+		return false, fmt.Errorf("failed to seek file content: %w", err)
+	}
+	data, err := io.ReadAll(file.Content)
+	if err != nil {
+		return false, fmt.Errorf("failed to read file content: %w", err)
+	}
+
+	// The orientation tag stays: the original's pixels were not turned.
+	filtered, err := metadata.Filter(data, metadata.PolicyOf(policy), true)
+	switch {
+	case errors.Is(err, metadata.ErrUnsupported):
+		return true, nil
+	case err != nil:
+		// Unparseable metadata cannot be vouched for.
+		return false, nil
+	}
+	return bytes.Equal(filtered, data), nil
 }
 
 func (s *CompressionService) CompressAndSave(
@@ -47,6 +378,11 @@ func (s *CompressionService) CompressAndSave(
 	file domain.File,
 	reqOpts domain.Options,
 ) (domain.SavedFile, error) {
+	reqOpts, err := s.applyPreset(reqOpts)
+	if err != nil {
+		return domain.SavedFile{}, err
+	}
+
 	opts := domain.Options{
 		Format:    s.cfg.Image.DefaultFormat,
 		Quality:   s.cfg.Image.DefaultQuality,
@@ -54,29 +390,107 @@ func (s *CompressionService) CompressAndSave(
 		MaxHeight: s.cfg.Image.MaxHeight,
 	}
 
+	if len(reqOpts.Pipeline) > 0 {
+		// The pipeline replaces the configured format, quality and size.
+		opts = domain.Options{Pipeline: reqOpts.Pipeline.WithEncodeDefaults(opts.Format, opts.Quality)}
+	}
+
 	if reqOpts.Format != "" {
 		opts.Format = reqOpts.Format
 	}
 	if reqOpts.Quality != 0 {
 		opts.Quality = reqOpts.Quality
 	}
-	if reqOpts.MaxWidth != 0 {
+	if reqOpts.MaxWidth != 0 || reqOpts.Crop.Enabled() {
 		opts.MaxWidth = reqOpts.MaxWidth
 	}
-	if reqOpts.MaxHeight != 0 {
+	if reqOpts.MaxHeight != 0 || reqOpts.Crop.Enabled() {
 		opts.MaxHeight = reqOpts.MaxHeight
 	}
+	opts.Operations = reqOpts.Operations
+	opts.Crop = reqOpts.Crop
+	opts.Filters = reqOpts.Filters
+	opts.Watermarks = reqOpts.Watermarks
+	opts.TargetBytes = reqOpts.TargetBytes
+	opts.TargetSSIM = reqOpts.TargetSSIM
+	opts.Report = reqOpts.Report
+	opts.Placeholders = reqOpts.Placeholders
+	opts.Analyze = reqOpts.Analyze
+	opts.Page = reqOpts.Page
+	opts.NoAutoRotate = reqOpts.NoAutoRotate
+	opts.Preset = reqOpts.Preset
+	opts.Metadata = cmp.Or(reqOpts.Metadata, domain.MetadataPolicy(s.cfg.Image.Metadata))
+	opts.Background = reqOpts.Background
+	opts.Alpha = reqOpts.Alpha
+	opts.JPEG = reqOpts.JPEG
+	opts.PNG = reqOpts.PNG
+	opts.WebP = reqOpts.WebP
+	opts.AVIF = reqOpts.AVIF
+	opts.Animation = reqOpts.Animation
+	opts.SVG = reqOpts.SVG
+	opts.PDF = reqOpts.PDF
 
-	compressedFile, err := s.Process(file, opts)
+	start := time.Now()
+	compressedFile, err := s.process(ctx, file, opts)
 	if err != nil {
 		return domain.SavedFile{}, err
 	}
+	elapsed := time.Since(start)
 
 	uniqueID := uuid.New().String()
-	fileName := fmt.Sprintf("%s.%s", uniqueID, opts.Format)
+	fileName := fmt.Sprintf("%s.%s", uniqueID, compressedFile.Extension(opts.Compile().Encode().Format))
 	filePath := filepath.Join(s.cfg.Storage.CompressedSubdir, fileName)
 
-	return s.repository.Save(ctx, compressedFile, filePath)
+	saved, err := s.repository.Save(ctx, compressedFile.File, filePath)
+	if err != nil {
+		return domain.SavedFile{}, err
+	}
+
+	saved.Meta = domain.FileMeta{
+		ID:               uniqueID,
+		Path:             saved.Path,
+		OriginalName:     file.Name,
+		OriginalSize:     file.Size,
+		OriginalMimeType: file.MimeType,
+		MimeType:         compressedFile.MimeType,
+		Size:             saved.CompressedSize,
+		Width:            compressedFile.Width,
+		Height:           compressedFile.Height,
+		Quality:          compressedFile.Quality,
+		SSIM:             compressedFile.SSIM,
+		Outcome:          compressedFile.Outcome,
+		RemovedMetadata:  compressedFile.RemovedMetadata,
+		Placeholders:     compressedFile.Placeholders,
+		Analysis:         compressedFile.Analysis,
+		Options:          opts,
+		ProcessingTimeMS: elapsed.Milliseconds(),
+		CreatedAt:        time.Now().UTC(),
+	}
+
+	if err := s.repository.SaveMeta(ctx, saved.Meta, filePath); err != nil {
+		// A file without its sidecar could never be looked up.
+		if delErr := s.repository.Delete(ctx, filePath); delErr != nil {
+			return domain.SavedFile{}, errors.Join(err, delErr)
+		}
+		return domain.SavedFile{}, err
+	}
+	saved.Report = compressedFile.Report
+
+	return saved, nil
+}
+
+// FileMeta returns the metadata record of the stored file with the given ID.
+func (s *CompressionService) FileMeta(ctx context.Context, id string) (domain.FileMeta, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return domain.FileMeta{}, fmt.Errorf("%w: %s", domain.ErrInvalidFileID, id)
+	}
+
+	return s.repository.Stat(ctx, filepath.Join(s.cfg.Storage.CompressedSubdir, id))
+}
+
+// AllowedFormats lists the output formats this service may produce.
+func (s *CompressionService) AllowedFormats() []string {
+	return s.cfg.Image.AllowFormats
 }
 
 func (s *CompressionService) GetFile(ctx context.Context, path string) (domain.File, error) {
@@ -1,7 +1,15 @@
 package service_test
 
 import (
+	"bytes"
 	"context"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"reflect"
+	"slices"
 	"testing"
 
 	"github.com/golang/mock/gomock"
@@ -40,20 +48,30 @@ func TestCompressionService_CompressAndSave_Success(t *testing.T) {
 	processorMock.EXPECT().
 		Supports(file.MimeType).
 		Return(true)
+	processorMock.EXPECT().SupportsStep(gomock.Any()).Return(true).AnyTimes()
 
-	compressed := domain.File{MimeType: "image/jpeg"}
+	compressed := domain.ProcessedFile{
+		File:         domain.File{MimeType: "image/jpeg"},
+		Width:        640,
+		Height:       480,
+		Placeholders: &domain.Placeholders{BlurHash: "LEHV6nWB2yk8pyo0adR*.7kCMdnj", ThumbHash: "1QcSHQRnh493V4dIh4eXh1h4kJUI"},
+	}
 
 	processorMock.EXPECT().
 		Process(file, gomock.Any()).
 		Return(compressed, nil)
 
 	repoMock.EXPECT().
-		Save(gomock.Any(), compressed, gomock.Any()).
+		Save(gomock.Any(), compressed.File, gomock.Any()).
 		Return(domain.SavedFile{
 			Path:           "compressed/some-id.jpeg",
 			CompressedSize: 123,
 		}, nil)
 
+	repoMock.EXPECT().
+		SaveMeta(gomock.Any(), gomock.Any(), gomock.Any()).
+		Return(nil)
+
 	saved, err := s.CompressAndSave(context.Background(), file, opts)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
@@ -61,4 +79,586 @@ func TestCompressionService_CompressAndSave_Success(t *testing.T) {
 	if saved.Path == "" {
 		t.Fatalf("expected non-empty path")
 	}
+	if saved.Meta.ID == "" || saved.Meta.Width != 640 || saved.Meta.Size != 123 {
+		t.Fatalf("unexpected meta: %+v", saved.Meta)
+	}
+	if saved.Meta.Placeholders != compressed.Placeholders {
+		t.Fatalf("expected the placeholders in the meta, got %+v", saved.Meta.Placeholders)
+	}
+}
+
+func TestCompressionService_CompressAndSave_SaveMetaFails(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	repoMock := portmocks.NewMockFileRepository(ctrl)
+	processorMock := portmocks.NewMockProcessor(ctrl)
+	cfg := config.Config{Storage: config.Storage{CompressedSubdir: "compressed"}}
+	s := service.NewCompressionService(repoMock, cfg, processorMock)
+
+	file := domain.File{MimeType: "image/png"}
+	processorMock.EXPECT().Supports(file.MimeType).Return(true)
+	processorMock.EXPECT().SupportsStep(gomock.Any()).Return(true).AnyTimes()
+	processorMock.EXPECT().
+		Process(file, gomock.Any()).
+		Return(domain.ProcessedFile{File: domain.File{MimeType: "image/jpeg"}, Width: 640, Height: 480}, nil)
+
+	var savedPath string
+	repoMock.EXPECT().
+		Save(gomock.Any(), gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, _ domain.File, path string) (domain.SavedFile, error) {
+			savedPath = path
+			return domain.SavedFile{Path: path, CompressedSize: 123}, nil
+		})
+	diskFull := errors.New("disk full")
+	repoMock.EXPECT().SaveMeta(gomock.Any(), gomock.Any(), gomock.Any()).Return(diskFull)
+	// The image must not stay behind without its sidecar.
+	repoMock.EXPECT().
+		Delete(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, path string) error {
+			if path != savedPath {
+				t.Errorf("deleted %q, want the saved %q", path, savedPath)
+			}
+			return nil
+		})
+
+	_, err := s.CompressAndSave(context.Background(), file, domain.Options{Format: "jpeg"})
+	if !errors.Is(err, diskFull) {
+		t.Fatalf("expected the SaveMeta error, got %v", err)
+	}
+}
+
+func TestCompressionService_FileMeta_InvalidID(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	repoMock := portmocks.NewMockFileRepository(ctrl)
+	s := service.NewCompressionService(repoMock, config.Config{})
+
+	_, err := s.FileMeta(context.Background(), "../etc/passwd")
+	if !errors.Is(err, domain.ErrInvalidFileID) {
+		t.Fatalf("expected ErrInvalidFileID, got %v", err)
+	}
+}
+
+func TestCompressionService_Process_SavingsPolicy(t *testing.T) {
+	cfg := config.Config{Image: config.Image{MinSavingsPercent: 10}}
+
+	tests := []struct {
+		name        string
+		inputMime   string
+		format      string
+		outputSize  int64
+		outWidth    int
+		targetBytes int64
+		removed     []string
+		watermarks  []domain.WatermarkStep
+		want        domain.Outcome
+	}{
+		{name: "smaller output", inputMime: "image/jpeg", format: "jpeg", outputSize: 500, outWidth: 100, want: domain.OutcomeCompressed},
+		{name: "larger output", inputMime: "image/png", format: "png", outputSize: 1500, outWidth: 100, want: domain.OutcomeOriginal},
+		{name: "saving under threshold", inputMime: "image/jpeg", format: "jpeg", outputSize: 950, outWidth: 100, want: domain.OutcomeOriginal},
+		{name: "original over target bytes", inputMime: "image/jpeg", format: "jpeg", outputSize: 950, outWidth: 100, targetBytes: 960, want: domain.OutcomeCompressed},
+		{name: "format change", inputMime: "image/png", format: "webp", outputSize: 1500, outWidth: 100, want: domain.OutcomeTranscoded},
+		{name: "resized", inputMime: "image/jpeg", format: "jpeg", outputSize: 1500, outWidth: 50, want: domain.OutcomeTranscoded},
+		{name: "heic to jpeg", inputMime: "image/heic", format: "jpeg", outputSize: 1500, outWidth: 100, want: domain.OutcomeTranscoded},
+		{name: "metadata removed", inputMime: "image/jpeg", format: "jpeg", outputSize: 1500, outWidth: 100, removed: []string{"exif:GPSLatitude"}, want: domain.OutcomeTranscoded},
+		{name: "watermarked", inputMime: "image/jpeg", format: "jpeg", outputSize: 1500, outWidth: 100, watermarks: []domain.WatermarkStep{{Text: "©"}}, want: domain.OutcomeTranscoded},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			processorMock := portmocks.NewMockProcessor(ctrl)
+			s := service.NewCompressionService(portmocks.NewMockFileRepository(ctrl), cfg, processorMock)
+
+			file := domain.File{
+				Content:  bytes.NewReader(make([]byte, 1000)),
+				MimeType: tt.inputMime,
+				Size:     1000,
+			}
+
+			processorMock.EXPECT().Supports(tt.inputMime).Return(true)
+			processorMock.EXPECT().SupportsStep(gomock.Any()).Return(true).AnyTimes()
+			processorMock.EXPECT().
+				Process(file, gomock.Any()).
+				Return(domain.ProcessedFile{
+					File:            domain.File{MimeType: "image/" + tt.format, Size: tt.outputSize},
+					Width:           tt.outWidth,
+					Height:          100,
+					SourceWidth:     100,
+					SourceHeight:    100,
+					RemovedMetadata: tt.removed,
+				}, nil)
+
+			got, err := s.Process(file, domain.Options{Format: tt.format, TargetBytes: tt.targetBytes, Watermarks: tt.watermarks})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got.Outcome != tt.want {
+				t.Fatalf("expected outcome %q, got %q", tt.want, got.Outcome)
+			}
+			if tt.want == domain.OutcomeOriginal && got.Size != file.Size {
+				t.Fatalf("expected original size %d, got %d", file.Size, got.Size)
+			}
+		})
+	}
+}
+
+// pngWithText encodes a 100x100 PNG, with a tEXt chunk of keyword when it is
+// not empty.
+func pngWithText(t *testing.T, keyword string) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 100, 100))); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	if keyword == "" {
+		return data
+	}
+
+	// After the signature and the 25 byte IHDR chunk.
+	body := append([]byte("tEXt"+keyword+"\x00"), "Jane Doe"...)
+	chunk := binary.BigEndian.AppendUint32(nil, uint32(len(body)-4))
+	chunk = append(chunk, body...)
+	chunk = binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(body))
+	return slices.Concat(data[:33], chunk, data[33:])
+}
+
+func TestCompressionService_Process_SavingsPolicyMetadata(t *testing.T) {
+	cfg := config.Config{Image: config.Image{MinSavingsPercent: 10}}
+
+	tests := []struct {
+		name    string
+		keyword string
+		policy  domain.MetadataPolicy
+		want    domain.Outcome
+	}{
+		{"no metadata", "", domain.MetadataStrip, domain.OutcomeOriginal},
+		// Processors do not list PNG text chunks among the removed fields.
+		{"text chunk stripped", "Author", domain.MetadataStrip, domain.OutcomeTranscoded},
+		{"text chunk kept", "Author", domain.MetadataCopyright, domain.OutcomeOriginal},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			processorMock := portmocks.NewMockProcessor(ctrl)
+			s := service.NewCompressionService(portmocks.NewMockFileRepository(ctrl), cfg, processorMock)
+
+			data := pngWithText(t, tt.keyword)
+			file := domain.File{Content: bytes.NewReader(data), MimeType: "image/png", Size: int64(len(data))}
+
+			processorMock.EXPECT().Supports("image/png").Return(true)
+			processorMock.EXPECT().SupportsStep(gomock.Any()).Return(true).AnyTimes()
+			processorMock.EXPECT().
+				Process(file, gomock.Any()).
+				Return(domain.ProcessedFile{
+					File:         domain.File{MimeType: "image/png", Size: file.Size + 100},
+					Width:        100,
+					Height:       100,
+					SourceWidth:  100,
+					SourceHeight: 100,
+				}, nil)
+
+			got, err := s.Process(file, domain.Options{Format: "png", Metadata: tt.policy})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got.Outcome != tt.want {
+				t.Fatalf("expected outcome %q, got %q", tt.want, got.Outcome)
+			}
+		})
+	}
+}
+
+func TestCompressionService_Process_InvalidOptions(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	// The processor must not be consulted for options no processor can honor.
+	s := service.NewCompressionService(portmocks.NewMockFileRepository(ctrl), config.Config{}, portmocks.NewMockProcessor(ctrl))
+
+	tests := map[string]domain.Options{
+		"two targets":         {TargetBytes: 1000, TargetSSIM: 0.98},
+		"lossless palette":    {Format: "png", PNG: domain.PNGOptions{Lossless: true, Palette: true}},
+		"too many colors":     {Format: "png", PNG: domain.PNGOptions{MaxColors: 300}},
+		"unknown bit depth":   {Format: "png", PNG: domain.PNGOptions{BitDepth: 3}},
+		"jpeg subsample":      {Format: "jpeg", JPEG: domain.JPEGOptions{Subsample: "422"}},
+		"background":          {Format: "jpeg", Background: "#12345g"},
+		"alpha policy":        {Format: "jpeg", Alpha: "drop"},
+		"crop and max width":  {Format: "jpeg", MaxWidth: 100, Crop: domain.CropOptions{Width: 50, Height: 50}},
+		"crop gravity":        {Format: "jpeg", Crop: domain.CropOptions{Width: 50, Height: 50, Gravity: "top"}},
+		"unknown operation":   {Format: "jpeg", Operations: []domain.Operation{{Type: "skew"}}},
+		"pipeline and format": {Format: "jpeg", Pipeline: domain.Pipeline{domain.EncodeStep{Format: "png"}}},
+		"encode not last":     {Pipeline: domain.Pipeline{domain.EncodeStep{}, domain.ResizeStep{Width: 10}}},
+		"resize without size": {Pipeline: domain.Pipeline{domain.ResizeStep{Fit: domain.FitCover}}},
+		"empty crop rect":     {Format: "jpeg", Operations: []domain.Operation{{Type: domain.OpCrop}}},
+		"webp lossless":       {Format: "webp", WebP: domain.WebPOptions{Lossless: true, AlphaQuality: 50}},
+		"svg watermark":       {Format: "svg", Watermarks: []domain.WatermarkStep{{Text: "©"}}},
+		"svg placeholders":    {Format: "svg", Placeholders: true},
+	}
+	for name, opts := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := s.Process(domain.File{MimeType: "image/jpeg"}, opts)
+			if !errors.Is(err, domain.ErrInvalidOptions) {
+				t.Fatalf("expected ErrInvalidOptions, got %v", err)
+			}
+		})
+	}
+}
+
+func TestCompressionService_Process_PresetWatermarkRejectsSVG(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	cfg := config.Config{Presets: map[string]config.Preset{
+		"stamped": {Watermark: &config.Watermark{Text: "©"}},
+	}}
+	s := service.NewCompressionService(portmocks.NewMockFileRepository(ctrl), cfg, portmocks.NewMockProcessor(ctrl))
+
+	// Minified SVG could not carry the enforced watermark.
+	_, err := s.Process(domain.File{MimeType: "image/svg+xml"}, domain.Options{Preset: "stamped", Format: "svg"})
+	if !errors.Is(err, domain.ErrInvalidOptions) {
+		t.Fatalf("expected ErrInvalidOptions, got %v", err)
+	}
+}
+
+func TestCompressionService_Process_ReportOnOriginal(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	processorMock := portmocks.NewMockProcessor(ctrl)
+	s := service.NewCompressionService(portmocks.NewMockFileRepository(ctrl), config.Config{}, processorMock)
+
+	file := domain.File{
+		Content:  bytes.NewReader(make([]byte, 1000)),
+		MimeType: "image/png",
+		Size:     1000,
+	}
+
+	processorMock.EXPECT().Supports(file.MimeType).Return(true)
+	processorMock.EXPECT().SupportsStep(gomock.Any()).Return(true).AnyTimes()
+	processorMock.EXPECT().
+		Process(file, gomock.Any()).
+		Return(domain.ProcessedFile{
+			File:         domain.File{MimeType: "image/png", Size: 1200},
+			Width:        100,
+			Height:       80,
+			SourceWidth:  100,
+			SourceHeight: 80,
+			Report:       &domain.Report{PSNR: 45, SSIM: 0.99, EncodeMS: 7},
+		}, nil)
+
+	got, err := s.Process(file, domain.Options{Format: "png", Report: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	report := got.Report
+	if report == nil {
+		t.Fatalf("expected report")
+	}
+	if report.SSIM != 1 || report.SavedBytes != 0 || report.OutputWidth != 100 || report.EncodeMS != 7 {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+}
+
+func TestCompressionService_Process_SelectsProcessorByMimeType(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	rasterMock := portmocks.NewMockProcessor(ctrl)
+	pdfMock := portmocks.NewMockProcessor(ctrl)
+	s := service.NewCompressionService(portmocks.NewMockFileRepository(ctrl), config.Config{}, rasterMock, pdfMock)
+
+	file := domain.File{MimeType: "application/pdf"}
+
+	rasterMock.EXPECT().Supports("application/pdf").Return(false)
+	pdfMock.EXPECT().Supports("application/pdf").Return(true)
+	pdfMock.EXPECT().SupportsStep(gomock.Any()).Return(true).AnyTimes()
+	pdfMock.EXPECT().
+		Process(file, gomock.Any()).
+		Return(domain.ProcessedFile{
+			File:         domain.File{MimeType: "image/png", Size: 10},
+			Width:        595,
+			Height:       842,
+			SourceWidth:  595,
+			SourceHeight: 842,
+		}, nil)
+
+	got, err := s.Process(file, domain.Options{Format: "png"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got.MimeType != "image/png" {
+		t.Fatalf("expected image/png, got %s", got.MimeType)
+	}
+}
+
+func TestCompressionService_Process_SelectsProcessorBySteps(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	fallbackMock := portmocks.NewMockProcessor(ctrl)
+	vipsMock := portmocks.NewMockProcessor(ctrl)
+	s := service.NewCompressionService(portmocks.NewMockFileRepository(ctrl), config.Config{}, fallbackMock, vipsMock)
+
+	file := domain.File{MimeType: "image/jpeg"}
+	smart := domain.ResizeStep{Width: 100, Height: 100, Fit: domain.FitCover, Gravity: domain.GravitySmart}
+
+	fallbackMock.EXPECT().Supports(file.MimeType).Return(true)
+	fallbackMock.EXPECT().SupportsStep(smart).Return(false)
+	vipsMock.EXPECT().Supports(file.MimeType).Return(true)
+	vipsMock.EXPECT().SupportsStep(gomock.Any()).Return(true).Times(2)
+	vipsMock.EXPECT().
+		Process(file, gomock.Any()).
+		Return(domain.ProcessedFile{File: domain.File{MimeType: "image/jpeg"}}, nil)
+
+	if _, err := s.Process(file, domain.Options{Format: "jpeg", Crop: domain.CropOptions{Width: 100, Height: 100, Gravity: domain.GravitySmart}}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestCompressionService_Process_UnsupportedStep(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	processorMock := portmocks.NewMockProcessor(ctrl)
+	s := service.NewCompressionService(portmocks.NewMockFileRepository(ctrl), config.Config{}, processorMock)
+
+	file := domain.File{MimeType: "image/jpeg"}
+	processorMock.EXPECT().Supports(file.MimeType).Return(true)
+	processorMock.EXPECT().SupportsStep(domain.EncodeStep{Format: "avif"}).Return(false)
+
+	_, err := s.Process(file, domain.Options{Format: "avif"})
+	if !errors.Is(err, domain.ErrInvalidOptions) {
+		t.Fatalf("expected ErrInvalidOptions, got %v", err)
+	}
+}
+
+func TestCompressionService_Process_Preset(t *testing.T) {
+	cfg := config.Config{
+		Image: config.Image{Metadata: "strip"},
+		Presets: map[string]config.Preset{
+			"thumbnail": {Format: "webp", Quality: 70, MaxWidth: 320, Metadata: "copyright", Filters: []config.Filter{{Op: "sharpen", Amount: 0.5}}},
+			"stamped":   {Watermark: &config.Watermark{Text: "©", Gravity: "sw"}},
+		},
+	}
+	stamp := domain.WatermarkStep{Text: "©", Gravity: domain.GravitySouthWest}
+
+	tests := []struct {
+		name string
+		opts domain.Options
+		want domain.Options
+	}{
+		{
+			name: "preset fills unset options",
+			opts: domain.Options{Preset: "thumbnail"},
+			want: domain.Options{
+				Preset: "thumbnail", Format: "webp", Quality: 70, MaxWidth: 320, Metadata: domain.MetadataCopyright,
+				Filters: []domain.Filter{{Type: domain.OpSharpen, Amount: 0.5}},
+			},
+		},
+		{
+			name: "request overrides preset",
+			opts: domain.Options{Preset: "thumbnail", Format: "png", Metadata: domain.MetadataNoPrivate, Filters: []domain.Filter{{Type: domain.OpGrayscale}}},
+			want: domain.Options{
+				Preset: "thumbnail", Format: "png", Quality: 70, MaxWidth: 320, Metadata: domain.MetadataNoPrivate,
+				Filters: []domain.Filter{{Type: domain.OpGrayscale}},
+			},
+		},
+		{
+			name: "pipeline takes preset encoder defaults",
+			opts: domain.Options{Preset: "thumbnail", Pipeline: domain.Pipeline{domain.ResizeStep{Width: 100}}},
+			want: domain.Options{
+				Preset:   "thumbnail",
+				Pipeline: domain.Pipeline{domain.ResizeStep{Width: 100}, domain.EncodeStep{Format: "webp", Quality: 70}},
+				Metadata: domain.MetadataCopyright,
+			},
+		},
+		{
+			name: "preset watermark follows the request's",
+			opts: domain.Options{Preset: "stamped", Format: "png", Watermarks: []domain.WatermarkStep{{Text: "mine"}}},
+			want: domain.Options{Preset: "stamped", Format: "png", Watermarks: []domain.WatermarkStep{{Text: "mine"}, stamp}, Metadata: domain.MetadataStrip},
+		},
+		{
+			name: "preset watermark precedes the encode step",
+			opts: domain.Options{Preset: "stamped", Pipeline: domain.Pipeline{domain.Operation{Type: domain.OpFlip}, domain.EncodeStep{Format: "png"}}},
+			want: domain.Options{
+				Preset:   "stamped",
+				Pipeline: domain.Pipeline{domain.Operation{Type: domain.OpFlip}, stamp, domain.EncodeStep{Format: "png"}},
+				Metadata: domain.MetadataStrip,
+			},
+		},
+		{
+			name: "default metadata policy",
+			opts: domain.Options{Format: "jpeg"},
+			want: domain.Options{Format: "jpeg", Metadata: domain.MetadataStrip},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			processorMock := portmocks.NewMockProcessor(ctrl)
+			s := service.NewCompressionService(portmocks.NewMockFileRepository(ctrl), cfg, processorMock)
+
+			file := domain.File{MimeType: "image/jpeg"}
+			processorMock.EXPECT().Supports(file.MimeType).Return(true)
+			processorMock.EXPECT().SupportsStep(gomock.Any()).Return(true).AnyTimes()
+			processorMock.EXPECT().
+				Process(file, tt.want).
+				Return(domain.ProcessedFile{File: domain.File{MimeType: "image/" + tt.want.Format}}, nil)
+
+			if _, err := s.Process(file, tt.opts); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestCompressionService_Process_UnknownPreset(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	s := service.NewCompressionService(portmocks.NewMockFileRepository(ctrl), config.Config{}, portmocks.NewMockProcessor(ctrl))
+
+	_, err := s.Process(domain.File{MimeType: "image/jpeg"}, domain.Options{Preset: "missing"})
+	if !errors.Is(err, domain.ErrInvalidOptions) {
+		t.Fatalf("expected ErrInvalidOptions, got %v", err)
+	}
+}
+
+func TestCompressionService_Process_WatermarkImage(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	repoMock := portmocks.NewMockFileRepository(ctrl)
+	processorMock := portmocks.NewMockProcessor(ctrl)
+	s := service.NewCompressionService(repoMock, config.Config{}, processorMock)
+
+	file := domain.File{MimeType: "image/jpeg"}
+	opts := domain.Options{Format: "jpeg", Watermarks: []domain.WatermarkStep{{Image: "marks/logo.png"}}}
+
+	repoMock.EXPECT().
+		Get(gomock.Any(), "marks/logo.png").
+		Return(domain.File{Content: bytes.NewReader([]byte("logo"))}, nil)
+	processorMock.EXPECT().Supports(file.MimeType).Return(true)
+	processorMock.EXPECT().SupportsStep(gomock.Any()).Return(true).AnyTimes()
+	processorMock.EXPECT().
+		Process(file, gomock.Any()).
+		DoAndReturn(func(_ domain.File, got domain.Options) (domain.ProcessedFile, error) {
+			if string(got.Watermarks[0].ImageData) != "logo" {
+				t.Errorf("watermark image data = %q, want the repository file", got.Watermarks[0].ImageData)
+			}
+			return domain.ProcessedFile{File: domain.File{MimeType: "image/jpeg"}}, nil
+		})
+
+	if _, err := s.Process(file, opts); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if opts.Watermarks[0].ImageData != nil {
+		t.Errorf("the caller's options were modified")
+	}
+}
+
+func TestCompressionService_Process_MissingWatermarkImage(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	repoMock := portmocks.NewMockFileRepository(ctrl)
+	s := service.NewCompressionService(repoMock, config.Config{}, portmocks.NewMockProcessor(ctrl))
+
+	repoMock.EXPECT().
+		Get(gomock.Any(), "marks/missing.png").
+		Return(domain.File{}, errors.New("failed to open file"))
+
+	opts := domain.Options{Format: "jpeg", Watermarks: []domain.WatermarkStep{{Image: "marks/missing.png"}}}
+	_, err := s.Process(domain.File{MimeType: "image/jpeg"}, opts)
+	if !errors.Is(err, domain.ErrInvalidOptions) {
+		t.Fatalf("expected ErrInvalidOptions, got %v", err)
+	}
+}
+
+func TestCompressionService_Placeholders(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	processorMock := portmocks.NewMockProcessor(ctrl)
+	s := service.NewCompressionService(portmocks.NewMockFileRepository(ctrl), config.Config{}, processorMock)
+
+	file := domain.File{MimeType: "image/jpeg"}
+	rotate := domain.Operation{Type: domain.OpRotate, Angle: 90}
+	want := domain.Placeholders{BlurHash: "LEHV6nWB2yk8pyo0adR*.7kCMdnj", ThumbHash: "1QcSHQRnh493V4dIh4eXh1h4kJUI"}
+
+	processorMock.EXPECT().Supports(file.MimeType).Return(true)
+	processorMock.EXPECT().SupportsStep(gomock.Any()).Return(true).AnyTimes()
+	processorMock.EXPECT().
+		Process(file, gomock.Any()).
+		DoAndReturn(func(_ domain.File, got domain.Options) (domain.ProcessedFile, error) {
+			pipeline := domain.Pipeline{
+				rotate,
+				domain.ResizeStep{Width: 800},
+				domain.ResizeStep{Width: 100, Height: 100},
+				domain.EncodeStep{Format: "png"},
+			}
+			if !got.Placeholders || !reflect.DeepEqual(got.Pipeline, pipeline) {
+				t.Errorf("unexpected options: %+v", got)
+			}
+			return domain.ProcessedFile{File: domain.File{MimeType: "image/png"}, Placeholders: &want}, nil
+		})
+
+	got, err := s.Placeholders(context.Background(), file, domain.Options{
+		Format:     "webp",
+		Quality:    90,
+		MaxWidth:   800,
+		Operations: []domain.Operation{rotate},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got != want {
+		t.Fatalf("expected %+v, got %+v", want, got)
+	}
+}
+
+func TestCompressionService_Analyze(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	processorMock := portmocks.NewMockProcessor(ctrl)
+	s := service.NewCompressionService(portmocks.NewMockFileRepository(ctrl), config.Config{}, processorMock)
+
+	file := domain.File{MimeType: "image/png"}
+	want := domain.Analysis{
+		Dominant: "#dc1e1e",
+		Palette:  []domain.Swatch{{Color: "#dc1e1e", Proportion: 0.75}, {Color: "#1428c8", Proportion: 0.25}},
+	}
+
+	processorMock.EXPECT().Supports(file.MimeType).Return(true)
+	processorMock.EXPECT().SupportsStep(gomock.Any()).Return(true).AnyTimes()
+	processorMock.EXPECT().
+		Process(file, gomock.Any()).
+		DoAndReturn(func(_ domain.File, got domain.Options) (domain.ProcessedFile, error) {
+			if !got.Analyze || got.Placeholders || got.Pipeline.Encode().Format != "png" {
+				t.Errorf("unexpected options: %+v", got)
+			}
+			return domain.ProcessedFile{File: domain.File{MimeType: "image/png"}, Analysis: &want}, nil
+		})
+
+	got, err := s.Analyze(context.Background(), file, domain.Options{Format: "jpeg"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %+v, got %+v", want, got)
+	}
 }
@@ -0,0 +1,311 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+)
+
+var errTruncated = errors.New("truncated image")
+
+// JPEG markers and APPn signatures.
+const (
+	markerSOS   = 0xda
+	markerEOI   = 0xd9
+	markerCOM   = 0xfe
+	markerAPP1  = 0xe1
+	markerAPP2  = 0xe2
+	markerAPP13 = 0xed
+)
+
+var (
+	xmpHeader         = []byte("http://ns.adobe.com/xap/1.0/\x00")
+	xmpExtendedHeader = []byte("http://ns.adobe.com/xmp/extension/\x00")
+	iccHeader         = []byte("ICC_PROFILE\x00")
+	pngSignature      = []byte("\x89PNG\r\n\x1a\n")
+)
+
+// xmpKeyword names the PNG text chunk holding XMP.
+const xmpKeyword = "XML:com.adobe.xmp"
+
+func isJPEG(data []byte) bool {
+	return len(data) > 2 && data[0] == 0xff && data[1] == 0xd8
+}
+
+func isPNG(data []byte) bool {
+	return bytes.HasPrefix(data, pngSignature)
+}
+
+func isWebP(data []byte) bool {
+	return len(data) >= 12 && string(data[:4]) == "RIFF" && string(data[8:12]) == "WEBP"
+}
+
+// filterJPEG copies the segments up to the start of scan, rewriting or
+// dropping the metadata ones, then the entropy-coded data untouched.
+func filterJPEG(data []byte, r rules) ([]byte, error) {
+	var out bytes.Buffer
+	out.Write(data[:2])
+
+	for i := 2; i+2 <= len(data); {
+		if data[i] != 0xff {
+			return nil, errTruncated
+		}
+		marker := data[i+1]
+		switch {
+		case marker == 0xff: // Fill byte
+			i++
+			continue
+		case marker == markerSOS || marker == markerEOI:
+			out.Write(data[i:])
+			return out.Bytes(), nil
+		case marker == 0x01 || (marker >= 0xd0 && marker <= 0xd7): // No length
+			out.Write(data[i : i+2])
+			i += 2
+			continue
+		}
+
+		if i+4 > len(data) {
+			return nil, errTruncated
+		}
+		end := i + 2 + int(binary.BigEndian.Uint16(data[i+2:]))
+		if end < i+4 || end > len(data) {
+			return nil, errTruncated
+		}
+		if payload := r.jpegSegment(marker, data[i+4:end]); payload != nil {
+			out.Write([]byte{0xff, marker})
+			_ = binary.Write(&out, binary.BigEndian, uint16(len(payload)+2))
+			out.Write(payload)
+		}
+		i = end
+	}
+	return nil, errTruncated
+}
+
+// jpegSegment returns the payload to write for a segment, nil to drop it.
+func (r rules) jpegSegment(marker byte, payload []byte) []byte {
+	switch {
+	case marker == markerAPP1 && bytes.HasPrefix(payload, exifHeader):
+		block := r.exif(payload)
+		if block == nil {
+			return nil
+		}
+		return append(bytes.Clone(exifHeader), block...)
+	case marker == markerAPP1 && bytes.HasPrefix(payload, xmpHeader):
+		xmp := r.xmp(payload[len(xmpHeader):])
+		if xmp == nil {
+			return nil
+		}
+		return append(bytes.Clone(xmpHeader), xmp...)
+	case marker == markerAPP1 && bytes.HasPrefix(payload, xmpExtendedHeader):
+		// Extended XMP is split across segments; it is never kept rather
+		// than filtered piecewise.
+		return nil
+	case marker == markerAPP2 && bytes.HasPrefix(payload, iccHeader):
+		if !r.keepProfile() {
+			return nil
+		}
+		return payload
+	case marker == markerAPP13 && bytes.HasPrefix(payload, photoshopHeader):
+		// Unparseable blocks are dropped like empty ones.
+		kept, _ := filterPhotoshop(payload, r)
+		return kept
+	case marker == markerCOM:
+		// Comments have no keyword to tell a copyright line from the rest.
+		if r.policy != KeepAllButPrivate {
+			return nil
+		}
+		return payload
+	default:
+		return payload
+	}
+}
+
+// exif returns the filtered block without header, nil when nothing is kept
+// or the block cannot be parsed.
+func (r rules) exif(data []byte) []byte {
+	e, err := parseEXIF(data)
+	if err != nil {
+		return nil
+	}
+	e.filter(r)
+	if e.empty() {
+		return nil
+	}
+	return e.encode()
+}
+
+// xmp returns the filtered packet, nil when no property is kept or the
+// packet cannot be parsed.
+func (r rules) xmp(packet []byte) []byte {
+	out, kept, err := filterXMP(packet, r)
+	if err != nil || kept == 0 {
+		return nil
+	}
+	return out
+}
+
+// filterPNG copies the chunks up to IEND, rewriting or dropping the metadata
+// ones.
+func filterPNG(data []byte, r rules) ([]byte, error) {
+	var out bytes.Buffer
+	out.Write(pngSignature)
+
+	for i := len(pngSignature); ; {
+		if i+12 > len(data) {
+			return nil, errTruncated
+		}
+		size := int(binary.BigEndian.Uint32(data[i:]))
+		end := i + 12 + size
+		if size < 0 || end > len(data) {
+			return nil, errTruncated
+		}
+		typ := string(data[i+4 : i+8])
+		chunk := data[i+8 : i+8+size]
+
+		switch typ {
+		case "iCCP":
+			if r.keepProfile() {
+				out.Write(data[i:end])
+			}
+		case "eXIf":
+			if block := r.exif(chunk); block != nil {
+				writeChunk(&out, typ, block)
+			}
+		case "tEXt", "zTXt", "iTXt":
+			keyword, _, _ := bytes.Cut(chunk, []byte{0})
+			switch {
+			case string(keyword) == xmpKeyword:
+				if kept := r.pngXMP(typ, chunk); kept != nil {
+					writeChunk(&out, typ, kept)
+				}
+			case r.keepText(string(keyword)):
+				out.Write(data[i:end])
+			}
+		case "IEND":
+			out.Write(data[i:end])
+			return out.Bytes(), nil
+		default:
+			out.Write(data[i:end])
+		}
+		i = end
+	}
+}
+
+// pngXMP filters an uncompressed iTXt XMP chunk. Compressed ones are dropped
+// rather than inflated.
+func (r rules) pngXMP(typ string, chunk []byte) []byte {
+	if typ != "iTXt" {
+		return nil
+	}
+	// keyword \0 compression-flag compression-method language \0 translated \0 text
+	rest := chunk[len(xmpKeyword)+1:]
+	if len(rest) < 2 || rest[0] != 0 {
+		return nil
+	}
+	language, rest, ok := bytes.Cut(rest[2:], []byte{0})
+	if !ok {
+		return nil
+	}
+	translated, text, ok := bytes.Cut(rest, []byte{0})
+	if !ok {
+		return nil
+	}
+	xmp := r.xmp(text)
+	if xmp == nil {
+		return nil
+	}
+
+	var out bytes.Buffer
+	out.WriteString(xmpKeyword)
+	out.Write([]byte{0, 0, 0})
+	out.Write(language)
+	out.WriteByte(0)
+	out.Write(translated)
+	out.WriteByte(0)
+	out.Write(xmp)
+	return out.Bytes()
+}
+
+func writeChunk(out *bytes.Buffer, typ string, data []byte) {
+	_ = binary.Write(out, binary.BigEndian, uint32(len(data)))
+	out.WriteString(typ)
+	out.Write(data)
+	crc := crc32.NewIEEE()
+	crc.Write([]byte(typ))
+	crc.Write(data)
+	_ = binary.Write(out, binary.BigEndian, crc.Sum32())
+}
+
+// VP8X feature flags for metadata chunks.
+const (
+	webpFlagICC  = 0x20
+	webpFlagEXIF = 0x08
+	webpFlagXMP  = 0x04
+)
+
+// filterWebP copies the RIFF chunks, rewriting or dropping the metadata ones
+// and updating the VP8X flags and the RIFF size to match.
+func filterWebP(data []byte, r rules) ([]byte, error) {
+	var out bytes.Buffer
+	out.Write(data[:12])
+
+	vp8x := -1 // Offset of the VP8X flags in out
+	var flags byte
+	for i := 12; i < len(data); {
+		if i+8 > len(data) {
+			return nil, errTruncated
+		}
+		fourcc := string(data[i : i+4])
+		size := int(binary.LittleEndian.Uint32(data[i+4:]))
+		end := i + 8 + size + size%2
+		if size < 0 || i+8+size > len(data) {
+			return nil, errTruncated
+		}
+		chunk := data[i+8 : i+8+size]
+		end = min(end, len(data))
+
+		switch fourcc {
+		case "VP8X":
+			vp8x = out.Len() + 8
+			out.Write(data[i:end])
+		case "ICCP":
+			if r.keepProfile() {
+				out.Write(data[i:end])
+				flags |= webpFlagICC
+			}
+		case "EXIF":
+			if block := r.exif(chunk); block != nil {
+				if bytes.HasPrefix(chunk, exifHeader) {
+					block = append(bytes.Clone(exifHeader), block...)
+				}
+				writeRIFFChunk(&out, fourcc, block)
+				flags |= webpFlagEXIF
+			}
+		case "XMP ":
+			if xmp := r.xmp(chunk); xmp != nil {
+				writeRIFFChunk(&out, fourcc, xmp)
+				flags |= webpFlagXMP
+			}
+		default:
+			out.Write(data[i:end])
+		}
+		i = end
+	}
+
+	buf := out.Bytes()
+	if vp8x >= 0 && vp8x < len(buf) {
+		buf[vp8x] = buf[vp8x]&^(webpFlagICC|webpFlagEXIF|webpFlagXMP) | flags
+	}
+	binary.LittleEndian.PutUint32(buf[4:], uint32(len(buf)-8))
+	return buf, nil
+}
+
+func writeRIFFChunk(out *bytes.Buffer, fourcc string, data []byte) {
+	out.WriteString(fourcc)
+	_ = binary.Write(out, binary.LittleEndian, uint32(len(data)))
+	out.Write(data)
+	if len(data)%2 != 0 {
+		out.WriteByte(0)
+	}
+}
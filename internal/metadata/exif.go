@@ -0,0 +1,368 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// exifHeader prefixes EXIF in JPEG APP1 segments; PNG and WebP may omit it.
+var exifHeader = []byte("Exif\x00\x00")
+
+// Tags with special handling: pointers linking IFDs, and the orientation.
+const (
+	tagExifIFD     = 0x8769
+	tagGPSIFD      = 0x8825
+	tagInteropIFD  = 0xa005
+	tagOrientation = 0x0112
+)
+
+// typeSizes gives the byte size of one value of each TIFF field type.
+var typeSizes = map[uint16]int{
+	1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 6: 1, 7: 1, 8: 2, 9: 4, 10: 8, 11: 4, 12: 8, 13: 4,
+}
+
+type ifdEntry struct {
+	tag   uint16
+	typ   uint16
+	count uint32
+	value []byte // count values, in the document's byte order
+}
+
+// exif is a parsed EXIF block. Only the IFDs that carry tags are kept;
+// interoperability data and the thumbnail are recorded as present.
+type exif struct {
+	order     binary.ByteOrder
+	ifd0      []ifdEntry
+	sub       []ifdEntry // Exif sub-IFD
+	gps       []ifdEntry
+	interop   bool
+	thumbnail bool
+}
+
+var errBadEXIF = errors.New("malformed exif")
+
+func parseEXIF(data []byte) (*exif, error) {
+	data = bytes.TrimPrefix(data, exifHeader)
+	if len(data) < 8 {
+		return nil, errBadEXIF
+	}
+
+	e := &exif{}
+	switch string(data[:4]) {
+	case "II*\x00":
+		e.order = binary.LittleEndian
+	case "MM\x00*":
+		e.order = binary.BigEndian
+	default:
+		return nil, errBadEXIF
+	}
+
+	ifd0, next, err := e.readIFD(data, e.order.Uint32(data[4:]))
+	if err != nil {
+		return nil, err
+	}
+	e.thumbnail = next != 0
+
+	// pointer reads the offset an IFD pointer holds: one LONG or IFD value.
+	pointer := func(entry ifdEntry) (uint32, error) {
+		if (entry.typ != 4 && entry.typ != 13) || len(entry.value) < 4 {
+			return 0, errBadEXIF
+		}
+		return e.order.Uint32(entry.value), nil
+	}
+
+	for _, entry := range ifd0 {
+		switch entry.tag {
+		case tagExifIFD:
+			offset, err := pointer(entry)
+			if err != nil {
+				return nil, err
+			}
+			sub, _, err := e.readIFD(data, offset)
+			if err != nil {
+				return nil, err
+			}
+			for _, s := range sub {
+				if s.tag == tagInteropIFD {
+					e.interop = true
+					continue
+				}
+				e.sub = append(e.sub, s)
+			}
+		case tagGPSIFD:
+			offset, err := pointer(entry)
+			if err != nil {
+				return nil, err
+			}
+			if e.gps, _, err = e.readIFD(data, offset); err != nil {
+				return nil, err
+			}
+		default:
+			e.ifd0 = append(e.ifd0, entry)
+		}
+	}
+	return e, nil
+}
+
+// readIFD reads the IFD at offset and returns its entries and the offset of
+// the next IFD.
+func (e *exif) readIFD(data []byte, offset uint32) ([]ifdEntry, uint32, error) {
+	if int64(offset)+2 > int64(len(data)) {
+		return nil, 0, errBadEXIF
+	}
+	n := int(e.order.Uint16(data[offset:]))
+	end := int(offset) + 2 + n*12
+	if end+4 > len(data) {
+		return nil, 0, errBadEXIF
+	}
+
+	entries := make([]ifdEntry, 0, n)
+	for i := range n {
+		raw := data[int(offset)+2+i*12:]
+		entry := ifdEntry{
+			tag:   e.order.Uint16(raw),
+			typ:   e.order.Uint16(raw[2:]),
+			count: e.order.Uint32(raw[4:]),
+		}
+		size, ok := typeSizes[entry.typ]
+		if !ok {
+			continue
+		}
+		total := int64(size) * int64(entry.count)
+		if total <= 4 {
+			entry.value = raw[8 : 8+total]
+		} else {
+			at := int64(e.order.Uint32(raw[8:]))
+			if at+total > int64(len(data)) {
+				return nil, 0, errBadEXIF
+			}
+			entry.value = data[at : at+total]
+		}
+		entries = append(entries, entry)
+	}
+	return entries, e.order.Uint32(data[end:]), nil
+}
+
+// fields lists the tags present, named as in the EXIF specification.
+func (e *exif) fields() []string {
+	var out []string
+	for _, entry := range e.ifd0 {
+		out = append(out, "exif:"+tagName(ifd0Names, entry.tag))
+	}
+	for _, entry := range e.sub {
+		out = append(out, "exif:"+tagName(subNames, entry.tag))
+	}
+	for _, entry := range e.gps {
+		out = append(out, "exif:"+tagName(gpsNames, entry.tag))
+	}
+	if e.interop {
+		out = append(out, "exif:Interoperability")
+	}
+	if e.thumbnail {
+		out = append(out, "exif:Thumbnail")
+	}
+	return out
+}
+
+// filter drops what the rules remove. The GPS IFD, interoperability data and
+// the thumbnail never survive: no policy keeps the location, whatever its
+// tags, and the thumbnail shows the unedited original.
+func (e *exif) filter(r rules) {
+	keep := func(ifd map[uint16]string, entries []ifdEntry) []ifdEntry {
+		return slices.DeleteFunc(entries, func(entry ifdEntry) bool {
+			if entry.tag == tagOrientation && !r.keepOrientation {
+				return true
+			}
+			return !r.keepEXIF(tagName(ifd, entry.tag))
+		})
+	}
+	e.ifd0 = keep(ifd0Names, e.ifd0)
+	e.sub = keep(subNames, e.sub)
+	e.gps = nil
+	e.interop, e.thumbnail = false, false
+}
+
+func (e *exif) empty() bool {
+	return len(e.ifd0) == 0 && len(e.sub) == 0 && len(e.gps) == 0
+}
+
+// encode writes the block as TIFF, without the "Exif" header: IFD0, then the
+// Exif and GPS sub-IFDs, each followed by its out-of-line values.
+func (e *exif) encode() []byte {
+	ifd0 := slices.Clone(e.ifd0)
+	pointer := func(tag uint16) ifdEntry {
+		return ifdEntry{tag: tag, typ: 4, count: 1, value: make([]byte, 4)}
+	}
+	if len(e.sub) > 0 {
+		ifd0 = append(ifd0, pointer(tagExifIFD))
+	}
+	if len(e.gps) > 0 {
+		ifd0 = append(ifd0, pointer(tagGPSIFD))
+	}
+
+	const headerSize = 8
+	subOffset := headerSize + ifdSize(ifd0)
+	gpsOffset := subOffset + ifdSize(e.sub)
+	for i := range ifd0 {
+		switch ifd0[i].tag {
+		case tagExifIFD:
+			e.order.PutUint32(ifd0[i].value, uint32(subOffset))
+		case tagGPSIFD:
+			e.order.PutUint32(ifd0[i].value, uint32(gpsOffset))
+		}
+	}
+
+	var buf bytes.Buffer
+	if e.order == binary.LittleEndian {
+		buf.WriteString("II*\x00")
+	} else {
+		buf.WriteString("MM\x00*")
+	}
+	_ = binary.Write(&buf, e.order, uint32(headerSize))
+	e.writeIFD(&buf, ifd0)
+	if len(e.sub) > 0 {
+		e.writeIFD(&buf, e.sub)
+	}
+	if len(e.gps) > 0 {
+		e.writeIFD(&buf, e.gps)
+	}
+	return buf.Bytes()
+}
+
+// ifdSize is the size of an IFD including its out-of-line values.
+func ifdSize(entries []ifdEntry) int {
+	if len(entries) == 0 {
+		return 0
+	}
+	size := 2 + 12*len(entries) + 4
+	for _, entry := range entries {
+		if len(entry.value) > 4 {
+			size += len(entry.value) + len(entry.value)%2
+		}
+	}
+	return size
+}
+
+// writeIFD appends an IFD at the current end of buf.
+func (e *exif) writeIFD(buf *bytes.Buffer, entries []ifdEntry) {
+	entries = slices.Clone(entries)
+	slices.SortFunc(entries, func(a, b ifdEntry) int { return int(a.tag) - int(b.tag) })
+
+	dataOffset := buf.Len() + 2 + 12*len(entries) + 4
+	var data bytes.Buffer
+
+	_ = binary.Write(buf, e.order, uint16(len(entries)))
+	for _, entry := range entries {
+		_ = binary.Write(buf, e.order, entry.tag)
+		_ = binary.Write(buf, e.order, entry.typ)
+		_ = binary.Write(buf, e.order, entry.count)
+		if len(entry.value) <= 4 {
+			var inline [4]byte
+			copy(inline[:], entry.value)
+			buf.Write(inline[:])
+			continue
+		}
+		_ = binary.Write(buf, e.order, uint32(dataOffset+data.Len()))
+		data.Write(entry.value)
+		if data.Len()%2 != 0 {
+			data.WriteByte(0)
+		}
+	}
+	_ = binary.Write(buf, e.order, uint32(0)) // No next IFD
+	buf.Write(data.Bytes())
+}
+
+func tagName(names map[uint16]string, tag uint16) string {
+	if name, ok := names[tag]; ok {
+		return name
+	}
+	return fmt.Sprintf("0x%04X", tag)
+}
+
+var ifd0Names = map[uint16]string{
+	0x010e: "ImageDescription",
+	0x010f: "Make",
+	0x0110: "Model",
+	0x0112: "Orientation",
+	0x011a: "XResolution",
+	0x011b: "YResolution",
+	0x0128: "ResolutionUnit",
+	0x0131: "Software",
+	0x0132: "DateTime",
+	0x013b: "Artist",
+	0x013e: "WhitePoint",
+	0x013f: "PrimaryChromaticities",
+	0x0211: "YCbCrCoefficients",
+	0x0213: "YCbCrPositioning",
+	0x0214: "ReferenceBlackWhite",
+	0x8298: "Copyright",
+	0xc62f: "CameraSerialNumber",
+}
+
+var subNames = map[uint16]string{
+	0x829a: "ExposureTime",
+	0x829d: "FNumber",
+	0x8822: "ExposureProgram",
+	0x8827: "ISOSpeedRatings",
+	0x9000: "ExifVersion",
+	0x9003: "DateTimeOriginal",
+	0x9004: "DateTimeDigitized",
+	0x9010: "OffsetTime",
+	0x9011: "OffsetTimeOriginal",
+	0x9012: "OffsetTimeDigitized",
+	0x9101: "ComponentsConfiguration",
+	0x9201: "ShutterSpeedValue",
+	0x9202: "ApertureValue",
+	0x9203: "BrightnessValue",
+	0x9204: "ExposureBiasValue",
+	0x9205: "MaxApertureValue",
+	0x9207: "MeteringMode",
+	0x9209: "Flash",
+	0x920a: "FocalLength",
+	0x9214: "SubjectArea",
+	0x927c: "MakerNote",
+	0x9286: "UserComment",
+	0x9290: "SubSecTime",
+	0x9291: "SubSecTimeOriginal",
+	0x9292: "SubSecTimeDigitized",
+	0xa000: "FlashpixVersion",
+	0xa001: "ColorSpace",
+	0xa002: "PixelXDimension",
+	0xa003: "PixelYDimension",
+	0xa217: "SensingMethod",
+	0xa301: "SceneType",
+	0xa402: "ExposureMode",
+	0xa403: "WhiteBalance",
+	0xa404: "DigitalZoomRatio",
+	0xa405: "FocalLengthIn35mmFilm",
+	0xa406: "SceneCaptureType",
+	0xa420: "ImageUniqueID",
+	0xa430: "CameraOwnerName",
+	0xa431: "BodySerialNumber",
+	0xa432: "LensSpecification",
+	0xa433: "LensMake",
+	0xa434: "LensModel",
+	0xa435: "LensSerialNumber",
+}
+
+var gpsNames = map[uint16]string{
+	0x0000: "GPSVersionID",
+	0x0001: "GPSLatitudeRef",
+	0x0002: "GPSLatitude",
+	0x0003: "GPSLongitudeRef",
+	0x0004: "GPSLongitude",
+	0x0005: "GPSAltitudeRef",
+	0x0006: "GPSAltitude",
+	0x0007: "GPSTimeStamp",
+	0x000c: "GPSSpeedRef",
+	0x000d: "GPSSpeed",
+	0x0010: "GPSImgDirectionRef",
+	0x0011: "GPSImgDirection",
+	0x0017: "GPSDestBearingRef",
+	0x0018: "GPSDestBearing",
+	0x001d: "GPSDateStamp",
+	0x001f: "GPSHPositioningError",
+}
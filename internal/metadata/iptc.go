@@ -0,0 +1,204 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// photoshopHeader prefixes the JPEG APP13 segment holding IPTC.
+var photoshopHeader = []byte("Photoshop 3.0\x00")
+
+// resourceIPTC is the Photoshop image resource holding IPTC-IIM records.
+const resourceIPTC = 0x0404
+
+// psResource is a Photoshop image resource block.
+type psResource struct {
+	id   uint16
+	name []byte // Pascal string, padded to even length
+	data []byte
+}
+
+// iimRecord is one IPTC-IIM dataset.
+type iimRecord struct {
+	record, dataset byte
+	data            []byte
+}
+
+var errBadIPTC = errors.New("malformed iptc")
+
+func parsePhotoshop(data []byte) ([]psResource, error) {
+	data = bytes.TrimPrefix(data, photoshopHeader)
+	var out []psResource
+	for len(data) > 0 {
+		if len(data) < 8 || string(data[:4]) != "8BIM" {
+			return nil, errBadIPTC
+		}
+		r := psResource{id: binary.BigEndian.Uint16(data[4:])}
+		nameLen := 1 + int(data[6])
+		nameLen += nameLen % 2
+		if len(data) < 6+nameLen+4 {
+			return nil, errBadIPTC
+		}
+		r.name = data[6 : 6+nameLen]
+		data = data[6+nameLen:]
+		size := int(binary.BigEndian.Uint32(data))
+		if size < 0 || 4+size > len(data) {
+			return nil, errBadIPTC
+		}
+		r.data = data[4 : 4+size]
+		data = data[min(len(data), 4+size+size%2):]
+		out = append(out, r)
+	}
+	return out, nil
+}
+
+func encodePhotoshop(resources []psResource) []byte {
+	var buf bytes.Buffer
+	buf.Write(photoshopHeader)
+	for _, r := range resources {
+		buf.WriteString("8BIM")
+		_ = binary.Write(&buf, binary.BigEndian, r.id)
+		buf.Write(r.name)
+		_ = binary.Write(&buf, binary.BigEndian, uint32(len(r.data)))
+		buf.Write(r.data)
+		if len(r.data)%2 != 0 {
+			buf.WriteByte(0)
+		}
+	}
+	return buf.Bytes()
+}
+
+func parseIIM(data []byte) ([]iimRecord, error) {
+	var out []iimRecord
+	for len(data) > 0 {
+		if data[0] != 0x1c {
+			// Padding after the last record.
+			if bytes.Count(data, []byte{0}) == len(data) {
+				break
+			}
+			return nil, errBadIPTC
+		}
+		if len(data) < 5 {
+			return nil, errBadIPTC
+		}
+		size := int(binary.BigEndian.Uint16(data[3:]))
+		if size&0x8000 != 0 || 5+size > len(data) {
+			// Extended datasets only hold binary objects; refuse them.
+			return nil, errBadIPTC
+		}
+		out = append(out, iimRecord{record: data[1], dataset: data[2], data: data[5 : 5+size]})
+		data = data[5+size:]
+	}
+	return out, nil
+}
+
+func encodeIIM(records []iimRecord) []byte {
+	var buf bytes.Buffer
+	for _, r := range records {
+		buf.Write([]byte{0x1c, r.record, r.dataset})
+		_ = binary.Write(&buf, binary.BigEndian, uint16(len(r.data)))
+		buf.Write(r.data)
+	}
+	return buf.Bytes()
+}
+
+// photoshopFields lists the IPTC datasets and other image resources.
+func photoshopFields(data []byte) ([]string, error) {
+	resources, err := parsePhotoshop(data)
+	if err != nil {
+		return nil, err
+	}
+	var out []string
+	for _, r := range resources {
+		if r.id != resourceIPTC {
+			out = append(out, fmt.Sprintf("photoshop:0x%04X", r.id))
+			continue
+		}
+		records, err := parseIIM(r.data)
+		if err != nil {
+			return nil, err
+		}
+		for _, rec := range records {
+			out = append(out, "iptc:"+rec.name())
+		}
+	}
+	return out, nil
+}
+
+// filterPhotoshop returns the block without what rules drop, or nil when
+// nothing meaningful is left.
+func filterPhotoshop(data []byte, r rules) ([]byte, error) {
+	resources, err := parsePhotoshop(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var kept []psResource
+	for _, res := range resources {
+		if res.id != resourceIPTC {
+			// Thumbnails, print settings and the like: the thumbnail shows
+			// the unedited original, the rest is of no use on the web.
+			continue
+		}
+		records, err := parseIIM(res.data)
+		if err != nil {
+			return nil, err
+		}
+		var keptRecords []iimRecord
+		meaningful := false
+		for _, rec := range records {
+			name := rec.name()
+			if structuralIIM[name] || r.keepIPTC(name) {
+				keptRecords = append(keptRecords, rec)
+				meaningful = meaningful || !structuralIIM[name]
+			}
+		}
+		if meaningful {
+			res.data = encodeIIM(keptRecords)
+			kept = append(kept, res)
+		}
+	}
+	if len(kept) == 0 {
+		return nil, nil
+	}
+	return encodePhotoshop(kept), nil
+}
+
+func (r iimRecord) name() string {
+	if name, ok := iimNames[uint16(r.record)<<8|uint16(r.dataset)]; ok {
+		return name
+	}
+	return fmt.Sprintf("%d:%d", r.record, r.dataset)
+}
+
+// structuralIIM datasets describe the encoding of the others and go wherever
+// they go.
+var structuralIIM = map[string]bool{
+	"CodedCharacterSet": true,
+	"RecordVersion":     true,
+}
+
+var iimNames = map[uint16]string{
+	0x015a: "CodedCharacterSet",
+	0x0200: "RecordVersion",
+	0x0205: "ObjectName",
+	0x0219: "Keywords",
+	0x0228: "SpecialInstructions",
+	0x0237: "DateCreated",
+	0x023c: "TimeCreated",
+	0x0250: "By-line",
+	0x0255: "By-lineTitle",
+	0x025a: "City",
+	0x025c: "Sub-location",
+	0x025f: "Province-State",
+	0x0264: "Country-PrimaryLocationCode",
+	0x0265: "Country-PrimaryLocationName",
+	0x0269: "Headline",
+	0x026e: "Credit",
+	0x0273: "Source",
+	0x0274: "CopyrightNotice",
+	0x0278: "Caption-Abstract",
+	0x027a: "Writer-Editor",
+}
@@ -0,0 +1,201 @@
+// Package metadata removes selected metadata from encoded images without
+// re-encoding them.
+//
+// Filter rewrites the metadata blocks of JPEG, PNG and WebP files (EXIF, XMP,
+// IPTC, ICC profiles and comments) according to a Policy. Fields lists what a
+// set of metadata blocks holds, so callers can report what was removed; Read
+// extracts the blocks from a file when no decoder exposes them.
+package metadata
+
+import (
+	"errors"
+	"slices"
+	"strings"
+
+	"github.com/andreychano/compressor-golang/internal/core/domain"
+)
+
+// ErrUnsupported is returned by Filter for formats it cannot rewrite.
+var ErrUnsupported = errors.New("unsupported container")
+
+// Policy selects the metadata that survives Filter.
+type Policy int
+
+const (
+	// StripAll removes everything, including the colour profile.
+	StripAll Policy = iota
+	// KeepICC keeps only the colour profile.
+	KeepICC
+	// KeepCopyright keeps the colour profile and the author, copyright and
+	// credit fields of EXIF, XMP and IPTC.
+	KeepCopyright
+	// KeepAllButPrivate keeps everything except location, serial numbers,
+	// maker notes (which embed serials) and the EXIF thumbnail.
+	KeepAllButPrivate
+)
+
+// PolicyOf returns the Policy a domain metadata policy applies; empty and
+// strip policies remove everything.
+func PolicyOf(p domain.MetadataPolicy) Policy {
+	switch p {
+	case domain.MetadataICC:
+		return KeepICC
+	case domain.MetadataCopyright:
+		return KeepCopyright
+	case domain.MetadataNoPrivate:
+		return KeepAllButPrivate
+	default:
+		return StripAll
+	}
+}
+
+// Blobs are the raw metadata blocks of an image, as a decoder exposes them.
+type Blobs struct {
+	EXIF []byte // TIFF structure, optionally prefixed with "Exif\0\0"
+	XMP  []byte // XMP packet
+	IPTC []byte // Photoshop image resources, optionally prefixed with "Photoshop 3.0\0"
+	ICC  bool   // Whether a colour profile is attached
+}
+
+// Fields lists the fields held by b, such as "icc", "exif:GPSLatitude",
+// "xmp:dc:creator" or "iptc:Credit". Blocks that cannot be parsed are listed
+// as a whole.
+func Fields(b Blobs) []string {
+	var out []string
+	if b.ICC {
+		out = append(out, "icc")
+	}
+	if len(b.EXIF) > 0 {
+		if e, err := parseEXIF(b.EXIF); err == nil {
+			out = append(out, e.fields()...)
+		} else {
+			out = append(out, "exif")
+		}
+	}
+	if len(b.XMP) > 0 {
+		if fields, err := xmpFields(b.XMP); err == nil {
+			out = append(out, fields...)
+		} else {
+			out = append(out, "xmp")
+		}
+	}
+	if len(b.IPTC) > 0 {
+		if fields, err := photoshopFields(b.IPTC); err == nil {
+			out = append(out, fields...)
+		} else {
+			out = append(out, "iptc")
+		}
+	}
+	return out
+}
+
+// Removed lists, sorted and without duplicates, the fields of before that
+// after no longer has.
+func Removed(before, after Blobs) []string {
+	remaining := Fields(after)
+	var out []string
+	for _, field := range Fields(before) {
+		if !slices.Contains(remaining, field) {
+			out = append(out, field)
+		}
+	}
+	slices.Sort(out)
+	return slices.Compact(out)
+}
+
+// Filter returns data, an encoded JPEG, PNG or WebP image, with the metadata
+// the policy drops removed. keepOrientation keeps the EXIF orientation tag,
+// which must go once the pixels have been rotated upright. Other formats
+// return ErrUnsupported.
+func Filter(data []byte, p Policy, keepOrientation bool) ([]byte, error) {
+	r := rules{policy: p, keepOrientation: keepOrientation}
+	switch {
+	case isJPEG(data):
+		return filterJPEG(data, r)
+	case isPNG(data):
+		return filterPNG(data, r)
+	case isWebP(data):
+		return filterWebP(data, r)
+	default:
+		return nil, ErrUnsupported
+	}
+}
+
+// rules answers, for one policy, which fields survive.
+type rules struct {
+	policy          Policy
+	keepOrientation bool
+}
+
+func (r rules) keepProfile() bool {
+	return r.policy != StripAll
+}
+
+// keepText applies to PNG text chunks, by keyword.
+func (r rules) keepText(keyword string) bool {
+	switch r.policy {
+	case KeepCopyright:
+		return keyword == "Copyright" || keyword == "Author"
+	case KeepAllButPrivate:
+		return true
+	default:
+		return false
+	}
+}
+
+func (r rules) keepEXIF(name string) bool {
+	switch r.policy {
+	case KeepCopyright:
+		return name == "Artist" || name == "Copyright"
+	case KeepAllButPrivate:
+		return !privateEXIF[name]
+	default:
+		return false
+	}
+}
+
+func (r rules) keepXMP(p xmpProperty) bool {
+	switch r.policy {
+	case KeepCopyright:
+		switch p.space {
+		case nsDC:
+			return p.name.Local == "creator" || p.name.Local == "rights"
+		case nsPhotoshop:
+			return p.name.Local == "Credit"
+		case nsRights:
+			return true
+		}
+		return false
+	case KeepAllButPrivate:
+		if p.space == nsEXIF && strings.HasPrefix(p.name.Local, "GPS") {
+			return false
+		}
+		if p.space == nsEXIF || p.space == nsEXIFEX || p.space == nsAux {
+			return !privateEXIF[p.name.Local] && p.name.Local != "SerialNumber"
+		}
+		return true
+	default:
+		return false
+	}
+}
+
+func (r rules) keepIPTC(name string) bool {
+	switch r.policy {
+	case KeepCopyright:
+		return name == "By-line" || name == "Credit" || name == "CopyrightNotice"
+	case KeepAllButPrivate:
+		return true
+	default:
+		return false
+	}
+}
+
+// privateEXIF are the EXIF fields, beyond GPS, that identify a device or its
+// owner.
+var privateEXIF = map[string]bool{
+	"CameraSerialNumber": true,
+	"BodySerialNumber":   true,
+	"LensSerialNumber":   true,
+	"CameraOwnerName":    true,
+	"MakerNote":          true,
+}
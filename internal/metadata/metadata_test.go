@@ -0,0 +1,283 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"slices"
+	"testing"
+)
+
+func ascii(tag uint16, s string) ifdEntry {
+	return ifdEntry{tag: tag, typ: 2, count: uint32(len(s) + 1), value: append([]byte(s), 0)}
+}
+
+func short(tag uint16, v uint16) ifdEntry {
+	return ifdEntry{tag: tag, typ: 3, count: 1, value: binary.BigEndian.AppendUint16(nil, v)}
+}
+
+// cameraEXIF is what a phone writes: device, author, serials and location.
+func cameraEXIF() []byte {
+	e := &exif{
+		order: binary.BigEndian,
+		ifd0: []ifdEntry{
+			ascii(0x010f, "Camera Co"),
+			short(tagOrientation, 6),
+			ascii(0x013b, "Jane Doe"),
+			ascii(0x8298, "(c) Jane Doe"),
+		},
+		sub: []ifdEntry{
+			ascii(0x9003, "2024:01:02 03:04:05"),
+			ascii(0xa431, "SN123456"),
+		},
+		gps: []ifdEntry{
+			ascii(0x0001, "N"),
+			{tag: 0x0002, typ: 5, count: 3, value: make([]byte, 24)},
+		},
+	}
+	return e.encode()
+}
+
+const cameraXMP = `<?xpacket begin="" id="W5M0MpCehiHzreSzNTczkc9d"?>
+<x:xmpmeta xmlns:x="adobe:ns:meta/">
+<rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+<rdf:Description rdf:about="" xmlns:xmp="http://ns.adobe.com/xap/1.0/" xmlns:exif="http://ns.adobe.com/exif/1.0/" xmlns:aux="http://ns.adobe.com/exif/1.0/aux/" xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:photoshop="http://ns.adobe.com/photoshop/1.0/" xmp:CreatorTool="Editor" exif:GPSLatitude="52,31.0N" photoshop:Credit="Agency">
+<aux:SerialNumber>SN123456</aux:SerialNumber>
+<dc:creator><rdf:Seq><rdf:li>Jane Doe</rdf:li></rdf:Seq></dc:creator>
+</rdf:Description>
+</rdf:RDF>
+</x:xmpmeta>
+<?xpacket end="w"?>`
+
+func cameraIPTC() []byte {
+	iim := encodeIIM([]iimRecord{
+		{record: 1, dataset: 90, data: []byte("\x1b%G")},
+		{record: 2, dataset: 80, data: []byte("Jane Doe")},
+		{record: 2, dataset: 90, data: []byte("Berlin")},
+		{record: 2, dataset: 110, data: []byte("Agency")},
+	})
+	return encodePhotoshop([]psResource{
+		{id: resourceIPTC, name: []byte{0, 0}, data: iim},
+		{id: 0x040c, name: []byte{0, 0}, data: []byte("thumbnail")},
+	})
+}
+
+func segment(marker byte, payload []byte) []byte {
+	out := []byte{0xff, marker}
+	out = binary.BigEndian.AppendUint16(out, uint16(len(payload)+2))
+	return append(out, payload...)
+}
+
+func cameraJPEG(t *testing.T) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, 8, 8)), nil); err != nil {
+		t.Fatalf("encode fixture: %v", err)
+	}
+	plain := buf.Bytes()
+
+	var out bytes.Buffer
+	out.Write(plain[:2])
+	out.Write(segment(markerAPP1, append(bytes.Clone(exifHeader), cameraEXIF()...)))
+	out.Write(segment(markerAPP1, append(bytes.Clone(xmpHeader), cameraXMP...)))
+	out.Write(segment(markerAPP2, append(bytes.Clone(iccHeader), 1, 1, 'p', 'r', 'o', 'f')))
+	out.Write(segment(markerAPP13, cameraIPTC()))
+	out.Write(segment(markerCOM, []byte("shot on holiday")))
+	out.Write(plain[2:])
+	return out.Bytes()
+}
+
+// jpegBlobs reads the metadata blocks of data, failing the test on error.
+func jpegBlobs(t *testing.T, data []byte) Blobs {
+	t.Helper()
+
+	b, err := Read(data)
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	return b
+}
+
+func TestFilter_JPEG(t *testing.T) {
+	tests := []struct {
+		name            string
+		policy          Policy
+		keepOrientation bool
+		want            []string
+	}{
+		{
+			name:   "strip all",
+			policy: StripAll,
+		},
+		{
+			name:   "keep icc",
+			policy: KeepICC,
+			want:   []string{"icc"},
+		},
+		{
+			name:   "keep copyright",
+			policy: KeepCopyright,
+			want: []string{
+				"exif:Artist", "exif:Copyright", "icc",
+				"iptc:By-line", "iptc:CodedCharacterSet", "iptc:Credit",
+				"xmp:dc:creator", "xmp:photoshop:Credit",
+			},
+		},
+		{
+			name:   "keep all but private",
+			policy: KeepAllButPrivate,
+			want: []string{
+				"exif:Artist", "exif:Copyright", "exif:DateTimeOriginal", "exif:Make", "icc",
+				"iptc:By-line", "iptc:City", "iptc:CodedCharacterSet", "iptc:Credit",
+				"xmp:dc:creator", "xmp:photoshop:Credit", "xmp:xmp:CreatorTool",
+			},
+		},
+		{
+			name:            "keep orientation",
+			policy:          KeepAllButPrivate,
+			keepOrientation: true,
+			want: []string{
+				"exif:Artist", "exif:Copyright", "exif:DateTimeOriginal", "exif:Make", "exif:Orientation", "icc",
+				"iptc:By-line", "iptc:City", "iptc:CodedCharacterSet", "iptc:Credit",
+				"xmp:dc:creator", "xmp:photoshop:Credit", "xmp:xmp:CreatorTool",
+			},
+		},
+	}
+
+	input := cameraJPEG(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := Filter(input, tt.policy, tt.keepOrientation)
+			if err != nil {
+				t.Fatalf("Filter: %v", err)
+			}
+			if _, err := jpeg.Decode(bytes.NewReader(out)); err != nil {
+				t.Fatalf("output does not decode: %v", err)
+			}
+
+			got := Fields(jpegBlobs(t, out))
+			slices.Sort(got)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("kept %v, want %v", got, tt.want)
+			}
+
+			hasComment := bytes.Contains(out, []byte("shot on holiday"))
+			if hasComment != (tt.policy == KeepAllButPrivate) {
+				t.Errorf("comment kept: %v", hasComment)
+			}
+		})
+	}
+}
+
+func TestRemoved(t *testing.T) {
+	input := cameraJPEG(t)
+	out, err := Filter(input, KeepAllButPrivate, false)
+	if err != nil {
+		t.Fatalf("Filter: %v", err)
+	}
+
+	want := []string{
+		"exif:BodySerialNumber", "exif:GPSLatitude", "exif:GPSLatitudeRef", "exif:Orientation",
+		"photoshop:0x040C", "xmp:aux:SerialNumber", "xmp:exif:GPSLatitude",
+	}
+	if got := Removed(jpegBlobs(t, input), jpegBlobs(t, out)); !slices.Equal(got, want) {
+		t.Errorf("Removed() = %v, want %v", got, want)
+	}
+}
+
+func TestFields_BadPointer(t *testing.T) {
+	tests := map[string]ifdEntry{
+		"short gps pointer":  short(tagGPSIFD, 8),
+		"empty exif pointer": {tag: tagExifIFD, typ: 4, count: 0},
+	}
+	for name, entry := range tests {
+		t.Run(name, func(t *testing.T) {
+			// encode rewrites pointer entries, so write a stand-in tag and
+			// patch it.
+			const standIn = 0xfefe
+			pointer := entry.tag
+			entry.tag = standIn
+			e := &exif{order: binary.BigEndian, ifd0: []ifdEntry{ascii(0x010f, "Camera Co"), entry}}
+			data := bytes.Replace(e.encode(), []byte{0xfe, 0xfe}, binary.BigEndian.AppendUint16(nil, pointer), 1)
+
+			got := Fields(Blobs{EXIF: data})
+			if !slices.Equal(got, []string{"exif"}) {
+				t.Errorf("Fields() = %v, want the block listed as a whole", got)
+			}
+		})
+	}
+}
+
+func TestFilter_UnlistedGPSTag(t *testing.T) {
+	source := &exif{
+		order: binary.BigEndian,
+		ifd0:  []ifdEntry{ascii(0x010f, "Camera Co")},
+		gps:   []ifdEntry{ascii(0x0014, "N"), ascii(0x001b, "NETWORK")}, // GPSDestLatitudeRef, GPSProcessingMethod
+	}
+	e, err := parseEXIF(source.encode())
+	if err != nil {
+		t.Fatalf("parseEXIF: %v", err)
+	}
+
+	e.filter(rules{policy: KeepAllButPrivate})
+	if len(e.gps) != 0 {
+		t.Errorf("kept gps tags %v", e.fields())
+	}
+}
+
+func TestFilter_PNG(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 8, 8))); err != nil {
+		t.Fatalf("encode fixture: %v", err)
+	}
+	plain := buf.Bytes()
+
+	// Insert eXIf and text chunks right after IHDR.
+	ihdrEnd := len(pngSignature) + 12 + 13
+	var in bytes.Buffer
+	in.Write(plain[:ihdrEnd])
+	writeChunk(&in, "eXIf", cameraEXIF())
+	writeChunk(&in, "tEXt", []byte("Copyright\x00(c) Jane Doe"))
+	writeChunk(&in, "tEXt", []byte("Comment\x00shot on holiday"))
+	in.Write(plain[ihdrEnd:])
+
+	out, err := Filter(in.Bytes(), KeepCopyright, false)
+	if err != nil {
+		t.Fatalf("Filter: %v", err)
+	}
+	if _, err := png.Decode(bytes.NewReader(out)); err != nil {
+		t.Fatalf("output does not decode: %v", err)
+	}
+	if !bytes.Contains(out, []byte("(c) Jane Doe")) {
+		t.Error("copyright text dropped")
+	}
+	if bytes.Contains(out, []byte("holiday")) {
+		t.Error("comment kept")
+	}
+	if bytes.Contains(out, []byte("SN123456")) || bytes.Contains(out, []byte("Camera Co")) {
+		t.Error("exif not filtered")
+	}
+}
+
+func TestRead(t *testing.T) {
+	b := jpegBlobs(t, cameraJPEG(t))
+	if !b.ICC || len(b.EXIF) == 0 || len(b.XMP) == 0 || len(b.IPTC) == 0 {
+		t.Fatalf("Read() missed blocks: icc=%v exif=%d xmp=%d iptc=%d", b.ICC, len(b.EXIF), len(b.XMP), len(b.IPTC))
+	}
+	if got := Orientation(b.EXIF); got != 6 {
+		t.Errorf("Orientation() = %d, want 6", got)
+	}
+	if got := Orientation(nil); got != 1 {
+		t.Errorf("Orientation(nil) = %d, want 1", got)
+	}
+}
+
+func TestFilter_Unsupported(t *testing.T) {
+	if _, err := Filter([]byte("GIF89a"), StripAll, false); !errors.Is(err, ErrUnsupported) {
+		t.Fatalf("expected ErrUnsupported, got %v", err)
+	}
+}
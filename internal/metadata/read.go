@@ -0,0 +1,150 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// Read extracts the metadata blocks of an encoded JPEG, PNG or WebP image.
+// Other formats return ErrUnsupported.
+func Read(data []byte) (Blobs, error) {
+	switch {
+	case isJPEG(data):
+		return readJPEG(data)
+	case isPNG(data):
+		return readPNG(data)
+	case isWebP(data):
+		return readWebP(data)
+	default:
+		return Blobs{}, ErrUnsupported
+	}
+}
+
+// Orientation returns the EXIF orientation of an EXIF block, 1 (upright)
+// when it has none or cannot be parsed.
+func Orientation(exifBlock []byte) int {
+	e, err := parseEXIF(exifBlock)
+	if err != nil {
+		return 1
+	}
+	for _, entry := range e.ifd0 {
+		if entry.tag == tagOrientation && entry.typ == 3 && len(entry.value) == 2 {
+			if v := int(e.order.Uint16(entry.value)); v >= 1 && v <= 8 {
+				return v
+			}
+		}
+	}
+	return 1
+}
+
+// readJPEG walks the segments up to the start of scan.
+func readJPEG(data []byte) (Blobs, error) {
+	var b Blobs
+	for i := 2; i+2 <= len(data); {
+		if data[i] != 0xff {
+			return Blobs{}, errTruncated
+		}
+		marker := data[i+1]
+		switch {
+		case marker == 0xff:
+			i++
+			continue
+		case marker == markerSOS || marker == markerEOI:
+			return b, nil
+		case marker == 0x01 || (marker >= 0xd0 && marker <= 0xd7):
+			i += 2
+			continue
+		}
+
+		if i+4 > len(data) {
+			return Blobs{}, errTruncated
+		}
+		end := i + 2 + int(binary.BigEndian.Uint16(data[i+2:]))
+		if end < i+4 || end > len(data) {
+			return Blobs{}, errTruncated
+		}
+		payload := data[i+4 : end]
+		switch {
+		case marker == markerAPP1 && bytes.HasPrefix(payload, exifHeader):
+			b.EXIF = payload
+		case marker == markerAPP1 && bytes.HasPrefix(payload, xmpHeader):
+			b.XMP = payload[len(xmpHeader):]
+		case marker == markerAPP2 && bytes.HasPrefix(payload, iccHeader):
+			b.ICC = true
+		case marker == markerAPP13 && bytes.HasPrefix(payload, photoshopHeader):
+			b.IPTC = payload
+		}
+		i = end
+	}
+	return Blobs{}, errTruncated
+}
+
+// readPNG walks the chunks up to IEND. Only uncompressed XMP is read.
+func readPNG(data []byte) (Blobs, error) {
+	var b Blobs
+	for i := len(pngSignature); ; {
+		if i+12 > len(data) {
+			return Blobs{}, errTruncated
+		}
+		size := int(binary.BigEndian.Uint32(data[i:]))
+		end := i + 12 + size
+		if size < 0 || end > len(data) {
+			return Blobs{}, errTruncated
+		}
+		chunk := data[i+8 : i+8+size]
+
+		switch string(data[i+4 : i+8]) {
+		case "iCCP":
+			b.ICC = true
+		case "eXIf":
+			b.EXIF = chunk
+		case "iTXt":
+			if xmp, ok := pngXMPText(chunk); ok {
+				b.XMP = xmp
+			}
+		case "IEND":
+			return b, nil
+		}
+		i = end
+	}
+}
+
+// pngXMPText returns the packet of an uncompressed iTXt XMP chunk.
+func pngXMPText(chunk []byte) ([]byte, bool) {
+	keyword, rest, ok := bytes.Cut(chunk, []byte{0})
+	if !ok || string(keyword) != xmpKeyword || len(rest) < 2 || rest[0] != 0 {
+		return nil, false
+	}
+	_, rest, ok = bytes.Cut(rest[2:], []byte{0}) // language
+	if !ok {
+		return nil, false
+	}
+	_, text, ok := bytes.Cut(rest, []byte{0}) // translated keyword
+	return text, ok
+}
+
+// readWebP walks the RIFF chunks.
+func readWebP(data []byte) (Blobs, error) {
+	var b Blobs
+	for i := 12; i < len(data); {
+		if i+8 > len(data) {
+			return Blobs{}, errTruncated
+		}
+		size := int(binary.LittleEndian.Uint32(data[i+4:]))
+		if size < 0 || i+8+size > len(data) {
+			return Blobs{}, errTruncated
+		}
+		chunk := data[i+8 : i+8+size]
+
+		switch string(data[i : i+4]) {
+		case "ICCP":
+			b.ICC = true
+		case "EXIF":
+			b.EXIF = chunk
+		case "XMP ":
+			b.XMP = chunk
+		}
+		i += 8 + size + size%2
+	}
+	return b, nil
+}
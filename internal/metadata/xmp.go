@@ -0,0 +1,232 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"io"
+)
+
+// XMP namespaces the rules refer to.
+const (
+	nsRDF       = "http://www.w3.org/1999/02/22-rdf-syntax-ns#"
+	nsDC        = "http://purl.org/dc/elements/1.1/"
+	nsPhotoshop = "http://ns.adobe.com/photoshop/1.0/"
+	nsRights    = "http://ns.adobe.com/xap/1.0/rights/"
+	nsEXIF      = "http://ns.adobe.com/exif/1.0/"
+	nsEXIFEX    = "http://cipa.jp/exif/1.0/"
+	nsAux       = "http://ns.adobe.com/exif/1.0/aux/"
+)
+
+// xmpProperty is a top-level property of an rdf:Description, either a child
+// element or an attribute.
+type xmpProperty struct {
+	name  xml.Name // As written: Space is the prefix
+	space string   // Namespace URI
+}
+
+func (p xmpProperty) field() string {
+	return "xmp:" + qualified(p.name)
+}
+
+// xmpWalker copies an XMP packet token by token, asking keep about every
+// property. With a nil out it only visits.
+type xmpWalker struct {
+	dec    *xml.Decoder
+	out    *bytes.Buffer
+	keep   func(xmpProperty) bool
+	scopes []map[string]string // Namespace declarations of the open elements
+	names  []xml.Name          // Open elements; RawToken does not check nesting
+	desc   int                 // Depth of the open top-level rdf:Description, 0 if none
+	kept   int                 // Properties kept
+}
+
+var errBadXMP = errors.New("malformed xmp")
+
+func walkXMP(packet []byte, out *bytes.Buffer, keep func(xmpProperty) bool) (int, error) {
+	dec := xml.NewDecoder(bytes.NewReader(packet))
+	dec.Strict = true
+	w := &xmpWalker{dec: dec, out: out, keep: keep}
+	if err := w.run(); err != nil {
+		return 0, errBadXMP
+	}
+	return w.kept, nil
+}
+
+// xmpFields lists the properties of a packet.
+func xmpFields(packet []byte) ([]string, error) {
+	var out []string
+	_, err := walkXMP(packet, nil, func(p xmpProperty) bool {
+		out = append(out, p.field())
+		return true
+	})
+	return out, err
+}
+
+// filterXMP returns the packet without the properties rules drop, and how
+// many properties are left.
+func filterXMP(packet []byte, r rules) ([]byte, int, error) {
+	var out bytes.Buffer
+	kept, err := walkXMP(packet, &out, r.keepXMP)
+	if err != nil {
+		return nil, 0, err
+	}
+	return out.Bytes(), kept, nil
+}
+
+func (w *xmpWalker) run() error {
+	for {
+		tok, err := w.dec.RawToken()
+		if err == io.EOF {
+			if len(w.names) != 0 {
+				return errBadXMP
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			parent := w.resolvedTop()
+			w.push(t)
+			if w.desc != 0 && len(w.names) == w.desc+1 {
+				p := xmpProperty{name: t.Name, space: w.resolve(t.Name.Space)}
+				if !w.keep(p) {
+					w.pop()
+					if err := w.skip(t.Name); err != nil {
+						return err
+					}
+					continue
+				}
+				w.kept++
+			}
+			if w.desc == 0 && parent == (xml.Name{Space: nsRDF, Local: "RDF"}) &&
+				w.resolve(t.Name.Space) == nsRDF && t.Name.Local == "Description" {
+				w.desc = len(w.names)
+				t.Attr = w.filterAttrs(t.Attr)
+			}
+			w.writeStart(t)
+		case xml.EndElement:
+			if len(w.names) == 0 || w.names[len(w.names)-1] != t.Name {
+				return errBadXMP
+			}
+			if len(w.names) == w.desc {
+				w.desc = 0
+			}
+			w.pop()
+			w.write("</" + qualified(t.Name) + ">")
+		case xml.CharData:
+			if w.out != nil {
+				_ = xml.EscapeText(w.out, t)
+			}
+		case xml.ProcInst:
+			// The xpacket wrapper lets editors update the packet in place.
+			w.write("<?" + t.Target + " " + string(t.Inst) + "?>")
+		case xml.Comment, xml.Directive:
+		}
+	}
+}
+
+// filterAttrs applies keep to the attribute properties of an rdf:Description.
+func (w *xmpWalker) filterAttrs(attrs []xml.Attr) []xml.Attr {
+	kept := attrs[:0]
+	for _, a := range attrs {
+		space := w.resolve(a.Name.Space)
+		if a.Name.Space == "" || a.Name.Space == "xmlns" || space == nsRDF {
+			kept = append(kept, a)
+			continue
+		}
+		if w.keep(xmpProperty{name: a.Name, space: space}) {
+			kept = append(kept, a)
+			w.kept++
+		}
+	}
+	return kept
+}
+
+func (w *xmpWalker) push(t xml.StartElement) {
+	scope := map[string]string{}
+	for _, a := range t.Attr {
+		switch {
+		case a.Name.Space == "xmlns":
+			scope[a.Name.Local] = a.Value
+		case a.Name.Space == "" && a.Name.Local == "xmlns":
+			scope[""] = a.Value
+		}
+	}
+	w.scopes = append(w.scopes, scope)
+	w.names = append(w.names, t.Name)
+}
+
+func (w *xmpWalker) pop() {
+	w.scopes = w.scopes[:len(w.scopes)-1]
+	w.names = w.names[:len(w.names)-1]
+}
+
+func (w *xmpWalker) resolve(prefix string) string {
+	for i := len(w.scopes) - 1; i >= 0; i-- {
+		if ns, ok := w.scopes[i][prefix]; ok {
+			return ns
+		}
+	}
+	return ""
+}
+
+// resolvedTop is the innermost open element with its namespace resolved.
+func (w *xmpWalker) resolvedTop() xml.Name {
+	if len(w.names) == 0 {
+		return xml.Name{}
+	}
+	top := w.names[len(w.names)-1]
+	return xml.Name{Space: w.resolve(top.Space), Local: top.Local}
+}
+
+// skip discards tokens up to the end of the element named name.
+func (w *xmpWalker) skip(name xml.Name) error {
+	stack := []xml.Name{name}
+	for len(stack) > 0 {
+		tok, err := w.dec.RawToken()
+		if err != nil {
+			return err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			stack = append(stack, t.Name)
+		case xml.EndElement:
+			if stack[len(stack)-1] != t.Name {
+				return errBadXMP
+			}
+			stack = stack[:len(stack)-1]
+		}
+	}
+	return nil
+}
+
+func (w *xmpWalker) writeStart(t xml.StartElement) {
+	if w.out == nil {
+		return
+	}
+	w.out.WriteString("<" + qualified(t.Name))
+	for _, a := range t.Attr {
+		w.out.WriteString(" " + qualified(a.Name) + `="`)
+		_ = xml.EscapeText(w.out, []byte(a.Value))
+		w.out.WriteString(`"`)
+	}
+	w.out.WriteString(">")
+}
+
+func (w *xmpWalker) write(s string) {
+	if w.out != nil {
+		w.out.WriteString(s)
+	}
+}
+
+// qualified formats a raw (prefix, not namespace URL) name.
+func qualified(name xml.Name) string {
+	if name.Space == "" {
+		return name.Local
+	}
+	return name.Space + ":" + name.Local
+}
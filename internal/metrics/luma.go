@@ -0,0 +1,34 @@
+package metrics
+
+import (
+	"image"
+	"image/color"
+)
+
+// Luma returns the BT.601 luma plane of img as a row-major slice of
+// Bounds().Dx() * Bounds().Dy() bytes. Transparent pixels are taken as
+// composited over black.
+func Luma(img image.Image) []uint8 {
+	b := img.Bounds()
+	width, height := b.Dx(), b.Dy()
+	out := make([]uint8, width*height)
+
+	switch src := img.(type) {
+	case *image.YCbCr:
+		for y := 0; y < height; y++ {
+			copy(out[y*width:(y+1)*width], src.Y[src.YOffset(b.Min.X, b.Min.Y+y):])
+		}
+	case *image.Gray:
+		for y := 0; y < height; y++ {
+			copy(out[y*width:(y+1)*width], src.Pix[src.PixOffset(b.Min.X, b.Min.Y+y):])
+		}
+	default:
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				out[y*width+x] = color.GrayModel.Convert(img.At(b.Min.X+x, b.Min.Y+y)).(color.Gray).Y
+			}
+		}
+	}
+
+	return out
+}
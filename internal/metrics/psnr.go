@@ -0,0 +1,63 @@
+package metrics
+
+import (
+	"image"
+	"image/color"
+	"math"
+)
+
+// MaxPSNR is reported for identical images, whose PSNR is infinite.
+const MaxPSNR = 100.0
+
+// PSNR returns the peak signal-to-noise ratio of b against a in decibels,
+// computed over the R, G and B channels at 8 bits. Higher is better.
+func PSNR(a, b image.Image) (float64, error) {
+	if a.Bounds().Dx() != b.Bounds().Dx() || a.Bounds().Dy() != b.Bounds().Dy() {
+		return 0, ErrSizeMismatch
+	}
+
+	ra, rb := rgb(a), rgb(b)
+	if len(ra) == 0 {
+		return MaxPSNR, nil
+	}
+
+	var sum float64
+	for i := range ra {
+		d := float64(ra[i]) - float64(rb[i])
+		sum += d * d
+	}
+
+	mse := sum / float64(len(ra))
+	if mse == 0 {
+		return MaxPSNR, nil
+	}
+
+	return min(MaxPSNR, 10*math.Log10(255*255/mse)), nil
+}
+
+// rgb returns the R, G, B samples of img as a row-major interleaved slice.
+// Transparent pixels are taken as composited over black.
+func rgb(img image.Image) []uint8 {
+	b := img.Bounds()
+	width, height := b.Dx(), b.Dy()
+	out := make([]uint8, 0, width*height*3)
+
+	if src, ok := img.(*image.RGBA); ok {
+		for y := 0; y < height; y++ {
+			row := src.Pix[src.PixOffset(b.Min.X, b.Min.Y+y):]
+			for x := 0; x < width; x++ {
+				out = append(out, row[x*4], row[x*4+1], row[x*4+2])
+			}
+		}
+		return out
+	}
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			c := color.RGBAModel.Convert(img.At(b.Min.X+x, b.Min.Y+y)).(color.RGBA)
+			out = append(out, c.R, c.G, c.B)
+		}
+	}
+
+	return out
+}
@@ -0,0 +1,50 @@
+package metrics
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"math"
+	"testing"
+)
+
+func TestPSNR_Identical(t *testing.T) {
+	img := gradient(32, 32)
+
+	score, err := PSNR(img, img)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if score != MaxPSNR {
+		t.Fatalf("expected %f, got %f", MaxPSNR, score)
+	}
+}
+
+func TestPSNR_KnownError(t *testing.T) {
+	a := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	b := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 4; x++ {
+			a.SetRGBA(x, y, color.RGBA{R: 100, G: 100, B: 100, A: 255})
+			b.SetRGBA(x, y, color.RGBA{R: 110, G: 110, B: 110, A: 255})
+		}
+	}
+
+	score, err := PSNR(a, b)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// MSE is 100 on every sample.
+	want := 10 * math.Log10(255*255/100.0)
+	if math.Abs(score-want) > 1e-9 {
+		t.Fatalf("expected %f, got %f", want, score)
+	}
+}
+
+func TestPSNR_SizeMismatch(t *testing.T) {
+	_, err := PSNR(gradient(10, 10), gradient(11, 10))
+	if !errors.Is(err, ErrSizeMismatch) {
+		t.Fatalf("expected ErrSizeMismatch, got %v", err)
+	}
+}
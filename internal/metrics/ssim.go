@@ -0,0 +1,81 @@
+// Package metrics implements image quality metrics (SSIM, PSNR) in pure Go,
+// independent of any codec library. Inputs are decoded images of equal size.
+package metrics
+
+import (
+	"errors"
+	"image"
+)
+
+// ErrSizeMismatch is returned when the compared images differ in dimensions.
+var ErrSizeMismatch = errors.New("metrics: images differ in size")
+
+const (
+	// ssimWindow is the side of the square window statistics are computed on.
+	ssimWindow = 8
+	// ssimStride is the step between windows; half a window gives overlap
+	// without the cost of a full sliding window.
+	ssimStride = 4
+
+	ssimC1 = (0.01 * 255) * (0.01 * 255)
+	ssimC2 = (0.03 * 255) * (0.03 * 255)
+)
+
+// SSIM returns the mean structural similarity of a and b computed on luma over
+// 8x8 windows. The score is 1 for identical images and decreases with damage.
+func SSIM(a, b image.Image) (float64, error) {
+	if a.Bounds().Dx() != b.Bounds().Dx() || a.Bounds().Dy() != b.Bounds().Dy() {
+		return 0, ErrSizeMismatch
+	}
+
+	la, lb := Luma(a), Luma(b)
+	width, height := a.Bounds().Dx(), a.Bounds().Dy()
+
+	// Images smaller than one window are compared as a single window.
+	winW, winH := min(ssimWindow, width), min(ssimWindow, height)
+	if winW == 0 || winH == 0 {
+		return 1, nil
+	}
+
+	var (
+		sum   float64
+		count int
+	)
+	for y := 0; y+winH <= height; y += ssimStride {
+		for x := 0; x+winW <= width; x += ssimStride {
+			sum += windowSSIM(la, lb, width, x, y, winW, winH)
+			count++
+		}
+	}
+
+	return sum / float64(count), nil
+}
+
+// DSSIM converts an SSIM score to structural dissimilarity, 0 for identical images.
+func DSSIM(ssim float64) float64 {
+	return (1 - ssim) / 2
+}
+
+func windowSSIM(a, b []uint8, stride, x0, y0, w, h int) float64 {
+	var sumA, sumB, sumAA, sumBB, sumAB float64
+	for y := y0; y < y0+h; y++ {
+		row := y * stride
+		for x := x0; x < x0+w; x++ {
+			pa, pb := float64(a[row+x]), float64(b[row+x])
+			sumA += pa
+			sumB += pb
+			sumAA += pa * pa
+			sumBB += pb * pb
+			sumAB += pa * pb
+		}
+	}
+
+	n := float64(w * h)
+	muA, muB := sumA/n, sumB/n
+	varA := sumAA/n - muA*muA
+	varB := sumBB/n - muB*muB
+	covAB := sumAB/n - muA*muB
+
+	return ((2*muA*muB + ssimC1) * (2*covAB + ssimC2)) /
+		((muA*muA + muB*muB + ssimC1) * (varA + varB + ssimC2))
+}
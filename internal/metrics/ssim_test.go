@@ -0,0 +1,65 @@
+package metrics
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"math"
+	"testing"
+)
+
+func gradient(width, height int) *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.SetGray(x, y, color.Gray{Y: uint8((x*7 + y*3) % 256)})
+		}
+	}
+	return img
+}
+
+func TestSSIM_Identical(t *testing.T) {
+	img := gradient(64, 48)
+
+	score, err := SSIM(img, img)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if math.Abs(score-1) > 1e-9 {
+		t.Fatalf("expected 1, got %f", score)
+	}
+}
+
+func TestSSIM_DegradesWithNoise(t *testing.T) {
+	ref := gradient(64, 48)
+	light, heavy := gradient(64, 48), gradient(64, 48)
+	for i := range light.Pix {
+		if i%5 == 0 {
+			light.Pix[i] ^= 0x08
+			heavy.Pix[i] ^= 0x40
+		}
+	}
+
+	lightScore, err := SSIM(ref, light)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	heavyScore, err := SSIM(ref, heavy)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !(1 > lightScore && lightScore > heavyScore) {
+		t.Fatalf("expected 1 > light (%f) > heavy (%f)", lightScore, heavyScore)
+	}
+	if DSSIM(heavyScore) <= DSSIM(lightScore) {
+		t.Fatalf("expected DSSIM to grow with damage")
+	}
+}
+
+func TestSSIM_SizeMismatch(t *testing.T) {
+	_, err := SSIM(gradient(10, 10), gradient(10, 11))
+	if !errors.Is(err, ErrSizeMismatch) {
+		t.Fatalf("expected ErrSizeMismatch, got %v", err)
+	}
+}
@@ -0,0 +1,77 @@
+// Package mimetype detects the MIME type of uploaded content.
+//
+// It extends http.DetectContentType with image formats the standard library
+// does not recognise: the ISO base media formats (HEIC, HEIF, AVIF), TIFF,
+// JPEG 2000 and SVG.
+package mimetype
+
+import (
+	"bytes"
+	"net/http"
+)
+
+// SniffLen is the number of leading bytes Detect looks at.
+const SniffLen = 512
+
+// signatures maps leading bytes to MIME types.
+var signatures = []struct {
+	prefix   string
+	mimeType string
+}{
+	{"II*\x00", "image/tiff"},
+	{"MM\x00*", "image/tiff"},
+	{"\x00\x00\x00\x0cjP  \r\n\x87\n", "image/jp2"},
+}
+
+// brands maps ISO-BMFF major brands to MIME types.
+var brands = map[string]string{
+	"avif": "image/avif",
+	"avis": "image/avif",
+	"heic": "image/heic",
+	"heix": "image/heic",
+	"heim": "image/heic",
+	"heis": "image/heic",
+	"hevc": "image/heic-sequence",
+	"hevx": "image/heic-sequence",
+	"mif1": "image/heif",
+	"msf1": "image/heif-sequence",
+}
+
+// Detect returns the MIME type of data, considering at most SniffLen bytes.
+// It never fails; unknown content is "application/octet-stream".
+func Detect(data []byte) string {
+	if len(data) > SniffLen {
+		data = data[:SniffLen]
+	}
+	if mimeType, ok := isoBMFF(data); ok {
+		return mimeType
+	}
+	for _, sig := range signatures {
+		if bytes.HasPrefix(data, []byte(sig.prefix)) {
+			return sig.mimeType
+		}
+	}
+	if isSVG(data) {
+		return "image/svg+xml"
+	}
+	return http.DetectContentType(data)
+}
+
+// isSVG recognises XML documents with an <svg> element in the sniffed prefix.
+// Comments, the XML declaration and a doctype may come first.
+func isSVG(data []byte) bool {
+	data = bytes.TrimLeft(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf")), " \t\r\n")
+	if !bytes.HasPrefix(data, []byte("<")) {
+		return false
+	}
+	return bytes.Contains(bytes.ToLower(data), []byte("<svg"))
+}
+
+// isoBMFF recognises the "ftyp" box every HEIF-family file starts with.
+func isoBMFF(data []byte) (string, bool) {
+	if len(data) < 12 || !bytes.Equal(data[4:8], []byte("ftyp")) {
+		return "", false
+	}
+	mimeType, ok := brands[string(data[8:12])]
+	return mimeType, ok
+}
@@ -0,0 +1,37 @@
+package mimetype
+
+import "testing"
+
+func TestDetect(t *testing.T) {
+	ftyp := func(brand string) []byte {
+		return append([]byte{0, 0, 0, 24, 'f', 't', 'y', 'p'}, []byte(brand+"\x00\x00\x00\x00mif1heic")...)
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"heic", ftyp("heic"), "image/heic"},
+		{"heif", ftyp("mif1"), "image/heif"},
+		{"avif", ftyp("avif"), "image/avif"},
+		{"unknown brand", ftyp("isom"), "application/octet-stream"},
+		{"tiff little endian", []byte("II*\x00\x08\x00\x00\x00"), "image/tiff"},
+		{"tiff big endian", []byte("MM\x00*\x00\x00\x00\x08"), "image/tiff"},
+		{"jp2", []byte("\x00\x00\x00\x0cjP  \r\n\x87\n"), "image/jp2"},
+		{"svg", []byte(`<svg xmlns="http://www.w3.org/2000/svg"/>`), "image/svg+xml"},
+		{"svg with prolog", []byte("\n<?xml version=\"1.0\"?>\n<!-- logo -->\n<svg/>"), "image/svg+xml"},
+		{"html", []byte("<html><body></body></html>"), "text/html; charset=utf-8"},
+		{"png", []byte("\x89PNG\r\n\x1a\n"), "image/png"},
+		{"jpeg", []byte{0xff, 0xd8, 0xff, 0xe0}, "image/jpeg"},
+		{"short", []byte("ftyp"), "text/plain; charset=utf-8"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Detect(tt.data); got != tt.want {
+				t.Errorf("Detect() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+// Package palette finds the dominant colours of an image by median cut, in
+// pure Go.
+package palette
+
+import (
+	"image"
+	"image/color"
+	"slices"
+
+	"github.com/andreychano/compressor-golang/internal/thumbnail"
+)
+
+// DefaultColors is the palette size callers use unless they need another.
+const DefaultColors = 5
+
+// Swatch is one palette colour and the share of the opaque pixels it stands
+// for.
+type Swatch struct {
+	Color      color.NRGBA // Mean of its pixels, opaque
+	Proportion float64     // 0..1; the palette sums to 1
+}
+
+// Extract returns up to n colours of img, most common first. Pixels less
+// than half opaque are ignored; an image without any has no palette. img is
+// scaled down to thumbnail.MaxSide first: more pixels barely move the
+// averages.
+func Extract(img image.Image, n int) []Swatch {
+	small := thumbnail.Fit(img, thumbnail.MaxSide)
+	var pixels [][3]uint8
+	for i := 0; i < len(small.Pix); i += 4 {
+		if p := small.Pix[i : i+4]; p[3] >= 128 {
+			pixels = append(pixels, [3]uint8{p[0], p[1], p[2]})
+		}
+	}
+	if len(pixels) == 0 || n < 1 {
+		return nil
+	}
+
+	boxes := []box{newBox(pixels)}
+	for len(boxes) < n {
+		// Split the box whose pixels spread the most, weighted by how many
+		// there are, at the median of its widest channel.
+		i := 0
+		for j, b := range boxes {
+			if b.score() > boxes[i].score() {
+				i = j
+			}
+		}
+		if boxes[i].score() == 0 {
+			break
+		}
+		lower, upper := boxes[i].split()
+		boxes[i] = lower
+		boxes = append(boxes, upper)
+	}
+
+	swatches := make([]Swatch, len(boxes))
+	for i, b := range boxes {
+		swatches[i] = Swatch{Color: b.mean(), Proportion: float64(len(b.pixels)) / float64(len(pixels))}
+	}
+	slices.SortStableFunc(swatches, func(a, b Swatch) int {
+		switch {
+		case a.Proportion > b.Proportion:
+			return -1
+		case a.Proportion < b.Proportion:
+			return 1
+		}
+		return 0
+	})
+	return swatches
+}
+
+// box is a set of pixels and the channel they spread the most along.
+type box struct {
+	pixels  [][3]uint8
+	channel int
+	spread  int
+}
+
+func newBox(pixels [][3]uint8) box {
+	b := box{pixels: pixels}
+	for c := range 3 {
+		lo, hi := uint8(255), uint8(0)
+		for _, p := range pixels {
+			lo, hi = min(lo, p[c]), max(hi, p[c])
+		}
+		if spread := int(hi) - int(lo); spread > b.spread {
+			b.channel, b.spread = c, spread
+		}
+	}
+	return b
+}
+
+func (b box) score() int {
+	return b.spread * len(b.pixels)
+}
+
+// split cuts the box in two at the median of its widest channel. The pixels
+// are reordered in place.
+func (b box) split() (lower, upper box) {
+	slices.SortFunc(b.pixels, func(p, q [3]uint8) int {
+		return int(p[b.channel]) - int(q[b.channel])
+	})
+	median := len(b.pixels) / 2
+	return newBox(b.pixels[:median]), newBox(b.pixels[median:])
+}
+
+func (b box) mean() color.NRGBA {
+	var sum [3]int
+	for _, p := range b.pixels {
+		for c := range sum {
+			sum[c] += int(p[c])
+		}
+	}
+	n := len(b.pixels)
+	return color.NRGBA{
+		R: uint8((sum[0] + n/2) / n),
+		G: uint8((sum[1] + n/2) / n),
+		B: uint8((sum[2] + n/2) / n),
+		A: 255,
+	}
+}
@@ -0,0 +1,75 @@
+package palette
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"testing"
+)
+
+// stripes fills img with vertical bands of colours, each as wide as its
+// weight out of the total.
+func stripes(width, height int, colors []color.NRGBA, weights []int) *image.NRGBA {
+	total := 0
+	for _, w := range weights {
+		total += w
+	}
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for x := 0; x < width; x++ {
+		i, edge := 0, weights[0]*width/total
+		for x >= edge {
+			i++
+			edge += weights[i] * width / total
+		}
+		for y := 0; y < height; y++ {
+			img.SetNRGBA(x, y, colors[i])
+		}
+	}
+	return img
+}
+
+func TestExtract_Proportions(t *testing.T) {
+	red := color.NRGBA{R: 220, G: 30, B: 30, A: 255}
+	blue := color.NRGBA{R: 20, G: 40, B: 200, A: 255}
+	green := color.NRGBA{R: 30, G: 180, B: 60, A: 255}
+	img := stripes(40, 10, []color.NRGBA{red, blue, green}, []int{2, 1, 1})
+
+	got := Extract(img, 3)
+	if len(got) != 3 {
+		t.Fatalf("expected 3 swatches, got %+v", got)
+	}
+	if got[0].Color != red || math.Abs(got[0].Proportion-0.5) > 1e-9 {
+		t.Errorf("expected red at 0.5 first, got %+v", got[0])
+	}
+	sum := 0.0
+	for _, s := range got {
+		sum += s.Proportion
+	}
+	if math.Abs(sum-1) > 1e-9 {
+		t.Errorf("expected proportions summing to 1, got %f", sum)
+	}
+}
+
+func TestExtract_Uniform(t *testing.T) {
+	c := color.NRGBA{R: 12, G: 34, B: 56, A: 255}
+	got := Extract(stripes(300, 200, []color.NRGBA{c}, []int{1}), DefaultColors)
+
+	// A box of one colour cannot be split.
+	if len(got) != 1 || got[0].Color != c || got[0].Proportion != 1 {
+		t.Fatalf("expected one swatch of %v, got %+v", c, got)
+	}
+}
+
+func TestExtract_IgnoresTransparent(t *testing.T) {
+	clear := color.NRGBA{R: 255, G: 255, B: 255, A: 0}
+	blue := color.NRGBA{R: 20, G: 40, B: 200, A: 255}
+
+	got := Extract(stripes(20, 20, []color.NRGBA{clear, blue}, []int{3, 1}), DefaultColors)
+	if len(got) != 1 || got[0].Color != blue {
+		t.Fatalf("expected only blue, got %+v", got)
+	}
+
+	if got := Extract(image.NewNRGBA(image.Rect(0, 0, 8, 8)), DefaultColors); got != nil {
+		t.Fatalf("expected no palette for a transparent image, got %+v", got)
+	}
+}
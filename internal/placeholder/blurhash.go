@@ -0,0 +1,123 @@
+package placeholder
+
+import (
+	"image"
+	"math"
+)
+
+// base83 is the BlurHash digit alphabet.
+const base83 = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz#$%*+,-.:;=?@[]^_{|}~"
+
+// BlurHash encodes img with x by y cosine components, 1..9 each. Alpha is
+// ignored. Images are hashed at full size; see Hashes for the usual entry
+// point.
+func BlurHash(img image.Image, x, y int) string {
+	x, y = min(max(x, 1), 9), min(max(y, 1), 9)
+	return blurHash(toNRGBA(img), x, y)
+}
+
+func blurHash(img *image.NRGBA, nx, ny int) string {
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+
+	// Linear RGB of every pixel, computed once for all components.
+	linear := make([][3]float64, w*h)
+	for py := range h {
+		for px := range w {
+			i := img.PixOffset(img.Rect.Min.X+px, img.Rect.Min.Y+py)
+			p := img.Pix[i : i+3]
+			linear[py*w+px] = [3]float64{srgbToLinear(p[0]), srgbToLinear(p[1]), srgbToLinear(p[2])}
+		}
+	}
+
+	factors := make([][3]float64, 0, nx*ny)
+	for j := range ny {
+		for i := range nx {
+			norm := 2.0
+			if i == 0 && j == 0 {
+				norm = 1
+			}
+			var f [3]float64
+			for py := range h {
+				cy := math.Cos(math.Pi * float64(j) * float64(py) / float64(h))
+				for px := range w {
+					basis := math.Cos(math.Pi*float64(i)*float64(px)/float64(w)) * cy
+					for c := range f {
+						f[c] += basis * linear[py*w+px][c]
+					}
+				}
+			}
+			scale := norm / float64(w*h)
+			factors = append(factors, [3]float64{f[0] * scale, f[1] * scale, f[2] * scale})
+		}
+	}
+
+	hash := encode83((nx-1)+(ny-1)*9, 1)
+	maximum := 1.0
+	if ac := factors[1:]; len(ac) > 0 {
+		actual := 0.0
+		for _, f := range ac {
+			actual = max(actual, math.Abs(f[0]), math.Abs(f[1]), math.Abs(f[2]))
+		}
+		quantised := int(max(0, min(82, math.Floor(actual*166-0.5))))
+		maximum = float64(quantised+1) / 166
+		hash += encode83(quantised, 1)
+	} else {
+		hash += encode83(0, 1)
+	}
+
+	dc := factors[0]
+	hash += encode83(linearToSRGB(dc[0])<<16|linearToSRGB(dc[1])<<8|linearToSRGB(dc[2]), 4)
+	for _, f := range factors[1:] {
+		quant := func(v float64) int {
+			return int(max(0, min(18, math.Floor(signPow(v/maximum, 0.5)*9+9.5))))
+		}
+		hash += encode83(quant(f[0])*19*19+quant(f[1])*19+quant(f[2]), 2)
+	}
+	return hash
+}
+
+// encode83 writes value as length base 83 digits.
+func encode83(value, length int) string {
+	out := make([]byte, length)
+	for i := length - 1; i >= 0; i-- {
+		out[i] = base83[value%83]
+		value /= 83
+	}
+	return string(out)
+}
+
+func srgbToLinear(v uint8) float64 {
+	f := float64(v) / 255
+	if f <= 0.04045 {
+		return f / 12.92
+	}
+	return math.Pow((f+0.055)/1.055, 2.4)
+}
+
+func linearToSRGB(v float64) int {
+	v = max(0, min(1, v))
+	if v <= 0.0031308 {
+		return int(v*12.92*255 + 0.5)
+	}
+	return int((1.055*math.Pow(v, 1/2.4)-0.055)*255 + 0.5)
+}
+
+func signPow(v, exp float64) float64 {
+	return math.Copysign(math.Pow(math.Abs(v), exp), v)
+}
+
+// toNRGBA returns img as non-premultiplied RGBA, without copying when it
+// already is.
+func toNRGBA(img image.Image) *image.NRGBA {
+	if n, ok := img.(*image.NRGBA); ok {
+		return n
+	}
+	b := img.Bounds()
+	out := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	for y := range b.Dy() {
+		for x := range b.Dx() {
+			out.Set(x, y, img.At(b.Min.X+x, b.Min.Y+y))
+		}
+	}
+	return out
+}
@@ -0,0 +1,21 @@
+// Package placeholder computes BlurHash and ThumbHash placeholders in pure
+// Go: short strings a client decodes into a blurry preview while the image
+// loads.
+package placeholder
+
+import (
+	"image"
+
+	"github.com/andreychano/compressor-golang/internal/thumbnail"
+)
+
+// Hashes returns the BlurHash, with 4x3 components or 3x4 for portraits, and
+// the ThumbHash of img.
+func Hashes(img image.Image) (string, []byte) {
+	small := thumbnail.Fit(img, thumbnail.MaxSide)
+	x, y := 4, 3
+	if small.Rect.Dy() > small.Rect.Dx() {
+		x, y = 3, 4
+	}
+	return blurHash(small, x, y), thumbHash(small)
+}
@@ -0,0 +1,87 @@
+package placeholder
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func solid(width, height int, c color.NRGBA) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.SetNRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+func gradient(width, height int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{R: uint8(x * 255 / width), G: uint8(y * 255 / height), B: 128, A: 255})
+		}
+	}
+	return img
+}
+
+func TestBlurHash_Solid(t *testing.T) {
+	hash := BlurHash(solid(32, 24, color.NRGBA{R: 255, G: 255, B: 255, A: 255}), 4, 3)
+
+	// Size flag 3+2*9, then the white DC after the AC maximum.
+	if hash[0] != 'L' || hash[2:6] != "TSUA" {
+		t.Fatalf("expected L?TSUA..., got %s", hash)
+	}
+}
+
+func TestBlurHash_Length(t *testing.T) {
+	for _, c := range []struct{ x, y int }{{1, 1}, {4, 3}, {9, 9}} {
+		hash := BlurHash(gradient(40, 30), c.x, c.y)
+		if want := 4 + 2*c.x*c.y; len(hash) != want {
+			t.Errorf("%dx%d: expected %d characters, got %d (%s)", c.x, c.y, want, len(hash), hash)
+		}
+	}
+}
+
+func TestThumbHash_Opaque(t *testing.T) {
+	hash := ThumbHash(solid(60, 60, color.NRGBA{R: 255, G: 255, B: 255, A: 255}))
+
+	// 5 header bytes and 37 AC nibbles: 27 luminance, 5 + 5 colour.
+	if len(hash) != 24 {
+		t.Fatalf("expected 24 bytes, got %d", len(hash))
+	}
+	if l := hash[0] & 63; l != 63 {
+		t.Errorf("expected white luminance 63, got %d", l)
+	}
+	if hash[2]&0x80 != 0 {
+		t.Error("expected no alpha flag")
+	}
+}
+
+func TestThumbHash_Alpha(t *testing.T) {
+	img := gradient(80, 40)
+	for i := 3; i < len(img.Pix); i += 8 {
+		img.Pix[i] = 0
+	}
+
+	hash := ThumbHash(img)
+	if hash[2]&0x80 == 0 {
+		t.Fatal("expected the alpha flag")
+	}
+	if landscape := hash[4]&0x80 != 0; !landscape {
+		t.Error("expected the landscape flag")
+	}
+}
+
+func TestHashes_Portrait(t *testing.T) {
+	blur, thumb := Hashes(gradient(300, 600))
+
+	// 3x4 components: size flag 2+3*9.
+	if blur[0] != base83[29] || len(blur) != 4+2*12 {
+		t.Errorf("expected a 3x4 BlurHash, got %s", blur)
+	}
+	if len(thumb) < 5 {
+		t.Errorf("expected a ThumbHash, got %d bytes", len(thumb))
+	}
+}
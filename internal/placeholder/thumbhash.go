@@ -0,0 +1,137 @@
+package placeholder
+
+import (
+	"image"
+	"math"
+
+	"github.com/andreychano/compressor-golang/internal/thumbnail"
+)
+
+// ThumbHash encodes img, scaled down to thumbnail.MaxSide first, as a
+// ThumbHash. Unlike BlurHash it keeps the aspect ratio and the alpha channel.
+func ThumbHash(img image.Image) []byte {
+	return thumbHash(thumbnail.Fit(img, thumbnail.MaxSide))
+}
+
+// thumbHash follows the reference encoder at github.com/evanw/thumbhash. img
+// must fit within 100x100.
+func thumbHash(img *image.NRGBA) []byte {
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	n := w * h
+	pixel := func(i int) []uint8 {
+		o := img.PixOffset(img.Rect.Min.X+i%w, img.Rect.Min.Y+i/w)
+		return img.Pix[o : o+4]
+	}
+
+	// The average colour, weighted by alpha.
+	var avgR, avgG, avgB, avgA float64
+	for i := range n {
+		p := pixel(i)
+		alpha := float64(p[3]) / 255
+		avgR += alpha / 255 * float64(p[0])
+		avgG += alpha / 255 * float64(p[1])
+		avgB += alpha / 255 * float64(p[2])
+		avgA += alpha
+	}
+	if avgA > 0 {
+		avgR, avgG, avgB = avgR/avgA, avgG/avgA, avgB/avgA
+	}
+
+	hasAlpha := avgA < float64(n)
+	limit := 7.0
+	if hasAlpha {
+		// Fewer luminance components leave room for the alpha channel.
+		limit = 5
+	}
+	longest := float64(max(w, h))
+	lx := max(1, int(jsRound(limit*float64(w)/longest)))
+	ly := max(1, int(jsRound(limit*float64(h)/longest)))
+
+	// Luminance, yellow-blue, red-green and alpha, composited over the
+	// average colour.
+	l, p, q, a := make([]float64, n), make([]float64, n), make([]float64, n), make([]float64, n)
+	for i := range n {
+		px := pixel(i)
+		alpha := float64(px[3]) / 255
+		r := avgR*(1-alpha) + alpha/255*float64(px[0])
+		g := avgG*(1-alpha) + alpha/255*float64(px[1])
+		b := avgB*(1-alpha) + alpha/255*float64(px[2])
+		l[i] = (r + g + b) / 3
+		p[i] = (r+g)/2 - b
+		q[i] = r - g
+		a[i] = alpha
+	}
+
+	encode := func(channel []float64, nx, ny int) (dc float64, ac []float64, scale float64) {
+		fx := make([]float64, w)
+		for cy := range ny {
+			for cx := 0; cx*ny < nx*(ny-cy); cx++ {
+				for x := range w {
+					fx[x] = math.Cos(math.Pi / float64(w) * float64(cx) * (float64(x) + 0.5))
+				}
+				f := 0.0
+				for y := range h {
+					fy := math.Cos(math.Pi / float64(h) * float64(cy) * (float64(y) + 0.5))
+					for x := range w {
+						f += channel[x+y*w] * fx[x] * fy
+					}
+				}
+				f /= float64(n)
+				if cx > 0 || cy > 0 {
+					ac = append(ac, f)
+					scale = max(scale, math.Abs(f))
+				} else {
+					dc = f
+				}
+			}
+		}
+		if scale > 0 {
+			for i := range ac {
+				ac[i] = 0.5 + 0.5/scale*ac[i]
+			}
+		}
+		return dc, ac, scale
+	}
+	lDC, lAC, lScale := encode(l, max(3, lx), max(3, ly))
+	pDC, pAC, pScale := encode(p, 3, 3)
+	qDC, qAC, qScale := encode(q, 3, 3)
+
+	landscape := w > h
+	header24 := int(jsRound(63*lDC)) | int(jsRound(31.5+31.5*pDC))<<6 | int(jsRound(31.5+31.5*qDC))<<12 | int(jsRound(31*lScale))<<18
+	header16 := int(jsRound(63*pScale))<<3 | int(jsRound(63*qScale))<<9
+	if hasAlpha {
+		header24 |= 1 << 23
+	}
+	if landscape {
+		header16 |= ly | 1<<15
+	} else {
+		header16 |= lx
+	}
+	hash := []byte{byte(header24), byte(header24 >> 8), byte(header24 >> 16), byte(header16), byte(header16 >> 8)}
+
+	channels := [][]float64{lAC, pAC, qAC}
+	if hasAlpha {
+		aDC, aAC, aScale := encode(a, 5, 5)
+		hash = append(hash, byte(int(jsRound(15*aDC))|int(jsRound(15*aScale))<<4))
+		channels = append(channels, aAC)
+	}
+
+	// Two 4-bit factors per byte, low nibble first.
+	start, index := len(hash), 0
+	for _, ac := range channels {
+		for _, f := range ac {
+			if start+index/2 == len(hash) {
+				hash = append(hash, 0)
+			}
+			hash[start+index/2] |= byte(int(jsRound(15*f)) << ((index & 1) * 4))
+			index++
+		}
+	}
+	return hash
+}
+
+// jsRound rounds half up like JavaScript's Math.round, which the reference
+// encoder uses.
+func jsRound(v float64) float64 {
+	return math.Floor(v + 0.5)
+}
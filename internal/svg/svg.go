@@ -0,0 +1,406 @@
+// Package svg cleans untrusted SVG documents before they are rendered or
+// served.
+//
+// Sanitize removes everything that lets a renderer run code or load other
+// resources: scripts, event handlers, foreignObject, external references in
+// href, in animations of href and in CSS (after decoding CSS escapes), DTDs
+// and processing instructions. Entities other than the five
+// predefined XML ones are rejected, so entity expansion cannot blow up.
+// Minify additionally drops comments, metadata, editor data and
+// insignificant whitespace.
+package svg
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalid is returned for documents that are not well-formed SVG.
+var ErrInvalid = errors.New("invalid svg")
+
+// Sanitize returns data with every unsafe construct removed.
+func Sanitize(data []byte) ([]byte, error) {
+	return rewrite(data, false)
+}
+
+// Minify sanitizes data and strips everything that does not affect rendering.
+func Minify(data []byte) ([]byte, error) {
+	return rewrite(data, true)
+}
+
+// unsafeElements are dropped together with their content.
+var unsafeElements = map[string]bool{
+	"script":        true,
+	"foreignobject": true,
+	"iframe":        true,
+	"embed":         true,
+	"object":        true,
+}
+
+// editorPrefixes are namespace prefixes of editor-only data, dropped by Minify.
+var editorPrefixes = map[string]bool{
+	"sodipodi": true,
+	"inkscape": true,
+	"sketch":   true,
+	"serif":    true,
+}
+
+// safeDataURIs are the data: URIs href may keep: raster images only, which
+// cannot reference anything themselves.
+var safeDataURIs = []string{"data:image/png", "data:image/jpeg", "data:image/gif", "data:image/webp"}
+
+type rewriter struct {
+	dec    *xml.Decoder
+	out    bytes.Buffer
+	minify bool
+	root   bool // Whether the root svg element was seen
+	open   *xml.StartElement
+	stack  []xml.Name // Open elements; RawToken does not check nesting itself
+}
+
+func rewrite(data []byte, minify bool) ([]byte, error) {
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	dec.Strict = true
+	// No Entity map: any entity beyond &amp; &lt; &gt; &apos; &quot; is an error.
+
+	w := &rewriter{dec: dec, minify: minify}
+	if err := w.run(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	if !w.root {
+		return nil, fmt.Errorf("%w: no svg root element", ErrInvalid)
+	}
+	return w.out.Bytes(), nil
+}
+
+func (w *rewriter) run() error {
+	for {
+		tok, err := w.dec.RawToken()
+		if err == io.EOF {
+			if len(w.stack) != 0 {
+				return errors.New("unexpected end of document")
+			}
+			w.flushOpen(false)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if !w.root {
+				if !strings.EqualFold(t.Name.Local, "svg") {
+					return fmt.Errorf("root element is <%s>", t.Name.Local)
+				}
+				w.root = true
+			}
+			if w.dropElement(t.Name) {
+				if err := w.skip(t.Name); err != nil {
+					return err
+				}
+				continue
+			}
+			if strings.EqualFold(t.Name.Local, "style") {
+				if err := w.style(t); err != nil {
+					return err
+				}
+				continue
+			}
+			w.flushOpen(false)
+			t.Attr = w.filterAttrs(t.Name, t.Attr)
+			w.open = &t
+			w.stack = append(w.stack, t.Name)
+		case xml.EndElement:
+			if len(w.stack) == 0 || w.stack[len(w.stack)-1] != t.Name {
+				return fmt.Errorf("unexpected end element </%s>", qualified(t.Name))
+			}
+			w.stack = w.stack[:len(w.stack)-1]
+			if w.open != nil && w.open.Name == t.Name {
+				w.flushOpen(true)
+				continue
+			}
+			w.flushOpen(false)
+			w.out.WriteString("</" + qualified(t.Name) + ">")
+		case xml.CharData:
+			if w.minify && len(bytes.TrimSpace(t)) == 0 {
+				continue
+			}
+			w.flushOpen(false)
+			_ = xml.EscapeText(&w.out, t)
+		case xml.ProcInst:
+			// Only the XML declaration survives; xml-stylesheet would load CSS.
+			if t.Target == "xml" && !w.minify {
+				w.out.WriteString("<?xml " + string(t.Inst) + "?>")
+			}
+		case xml.Comment, xml.Directive:
+			// Comments carry nothing; directives are DTDs and entity declarations.
+		}
+	}
+}
+
+// dropElement reports whether an element and its content must be removed.
+func (w *rewriter) dropElement(name xml.Name) bool {
+	local := strings.ToLower(name.Local)
+	if unsafeElements[local] {
+		return true
+	}
+	if w.minify {
+		return local == "metadata" || editorPrefixes[name.Space]
+	}
+	return false
+}
+
+// skip discards tokens up to the end of the element named name.
+func (w *rewriter) skip(name xml.Name) error {
+	stack := []xml.Name{name}
+	for len(stack) > 0 {
+		tok, err := w.dec.RawToken()
+		if err != nil {
+			return err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			stack = append(stack, t.Name)
+		case xml.EndElement:
+			if stack[len(stack)-1] != t.Name {
+				return fmt.Errorf("unexpected end element </%s>", qualified(t.Name))
+			}
+			stack = stack[:len(stack)-1]
+		}
+	}
+	return nil
+}
+
+// style copies a <style> element only when its CSS cannot load resources.
+func (w *rewriter) style(start xml.StartElement) error {
+	var css bytes.Buffer
+	for {
+		tok, err := w.dec.RawToken()
+		if err != nil {
+			return err
+		}
+		if end, ok := tok.(xml.EndElement); ok && end.Name == start.Name {
+			break
+		}
+		if text, ok := tok.(xml.CharData); ok {
+			css.Write(text)
+		}
+	}
+	if !safeCSS(css.String()) {
+		return nil
+	}
+
+	w.flushOpen(false)
+	start.Attr = w.filterAttrs(start.Name, start.Attr)
+	w.writeStart(start, false)
+	_ = xml.EscapeText(&w.out, css.Bytes())
+	w.out.WriteString("</" + qualified(start.Name) + ">")
+	return nil
+}
+
+// animationElements set other attributes through to, from, by and values.
+var animationElements = map[string]bool{
+	"set":              true,
+	"animate":          true,
+	"animatecolor":     true,
+	"animatemotion":    true,
+	"animatetransform": true,
+}
+
+func (w *rewriter) filterAttrs(element xml.Name, attrs []xml.Attr) []xml.Attr {
+	// An animation of href is a reference like href itself.
+	animatesHref := false
+	if animationElements[strings.ToLower(element.Local)] {
+		for _, a := range attrs {
+			if strings.EqualFold(a.Name.Local, "attributeName") {
+				target := strings.ToLower(strings.TrimSpace(a.Value))
+				target = target[strings.LastIndex(target, ":")+1:] // xlink:href
+				animatesHref = target == "href" || target == "src"
+			}
+		}
+	}
+
+	kept := attrs[:0]
+	for _, a := range attrs {
+		if animatesHref && animationValue(a.Name.Local) && !safeHrefs(a.Value) {
+			continue
+		}
+		if w.keepAttr(a) {
+			kept = append(kept, a)
+		}
+	}
+	return kept
+}
+
+// animationValue reports whether an attribute of an animation element holds
+// values of the animated attribute.
+func animationValue(local string) bool {
+	switch strings.ToLower(local) {
+	case "to", "from", "by", "values":
+		return true
+	}
+	return false
+}
+
+// safeHrefs checks every value of a semicolon-separated animation list.
+func safeHrefs(values string) bool {
+	for _, v := range strings.Split(values, ";") {
+		if !safeHref(strings.TrimSpace(v)) {
+			return false
+		}
+	}
+	return true
+}
+
+func (w *rewriter) keepAttr(a xml.Attr) bool {
+	local := strings.ToLower(a.Name.Local)
+	value := strings.TrimSpace(a.Value)
+
+	switch {
+	case strings.HasPrefix(local, "on"):
+		return false
+	case local == "href" || local == "src":
+		return safeHref(value)
+	case strings.Contains(normalize(value), "javascript:"):
+		return false
+	case !safeCSS(value):
+		return false
+	}
+
+	if w.minify {
+		if editorPrefixes[a.Name.Space] {
+			return false
+		}
+		if a.Name.Space == "xmlns" && editorPrefixes[a.Name.Local] {
+			return false
+		}
+	}
+	return true
+}
+
+// safeHref allows fragment references inside the document and inline rasters.
+func safeHref(value string) bool {
+	if strings.HasPrefix(value, "#") {
+		return true
+	}
+	lower := strings.ToLower(value)
+	for _, prefix := range safeDataURIs {
+		if strings.HasPrefix(lower, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// safeCSS reports whether CSS (a stylesheet or an attribute value) only
+// references fragments of the document itself.
+func safeCSS(css string) bool {
+	lower := normalize(css)
+	if strings.Contains(lower, "@import") || strings.Contains(lower, "expression(") {
+		return false
+	}
+	for rest := lower; ; {
+		i := strings.Index(rest, "url(")
+		if i < 0 {
+			return true
+		}
+		rest = rest[i+len("url("):]
+		target := strings.TrimLeft(rest, "'\"")
+		if !strings.HasPrefix(target, "#") && !safeHref(target) {
+			return false
+		}
+	}
+}
+
+// normalize prepares a value for the checks the way a renderer reads it: CSS
+// escapes decoded, comments, whitespace and control characters removed,
+// lower-cased. "u\72l(", "@\69mport" and "java\tscript:" are caught so.
+func normalize(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c == '\\' && i+1 < len(s) {
+			j := i + 1
+			for j < len(s) && j-i <= 6 && isHex(s[j]) {
+				j++
+			}
+			if j > i+1 {
+				v, _ := strconv.ParseUint(s[i+1:j], 16, 32)
+				b.WriteRune(rune(v))
+				if j < len(s) && isSpace(s[j]) {
+					j++ // One whitespace ends the escape
+				}
+				i = j - 1
+				continue
+			}
+			i++
+			c = s[i]
+		}
+		b.WriteByte(c)
+	}
+
+	decoded := b.String()
+	b.Reset()
+	for rest := decoded; rest != ""; {
+		if strings.HasPrefix(rest, "/*") {
+			end := strings.Index(rest[2:], "*/")
+			if end < 0 {
+				break
+			}
+			rest = rest[2+end+2:]
+			continue
+		}
+		c := rest[0]
+		rest = rest[1:]
+		if c > ' ' && c != 0x7f {
+			b.WriteByte(c)
+		}
+	}
+	return strings.ToLower(b.String())
+}
+
+func isHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
+
+// flushOpen writes a pending start tag, self-closed when the element turned
+// out to be empty.
+func (w *rewriter) flushOpen(empty bool) {
+	if w.open == nil {
+		return
+	}
+	w.writeStart(*w.open, empty)
+	w.open = nil
+}
+
+func (w *rewriter) writeStart(t xml.StartElement, empty bool) {
+	w.out.WriteString("<" + qualified(t.Name))
+	for _, a := range t.Attr {
+		w.out.WriteString(" " + qualified(a.Name) + `="`)
+		_ = xml.EscapeText(&w.out, []byte(a.Value))
+		w.out.WriteString(`"`)
+	}
+	if empty {
+		w.out.WriteString("/>")
+	} else {
+		w.out.WriteString(">")
+	}
+}
+
+// qualified formats a raw (prefix, not namespace URL) name.
+func qualified(name xml.Name) string {
+	if name.Space == "" {
+		return name.Local
+	}
+	return name.Space + ":" + name.Local
+}
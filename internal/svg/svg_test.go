@@ -0,0 +1,135 @@
+package svg
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestSanitize(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		absent  []string
+		present []string
+	}{
+		{
+			name:    "script",
+			in:      `<svg xmlns="http://www.w3.org/2000/svg"><script>alert(1)</script><rect width="1" height="1"/></svg>`,
+			absent:  []string{"script", "alert"},
+			present: []string{`<rect width="1" height="1"/>`},
+		},
+		{
+			name:    "event handler",
+			in:      `<svg xmlns="http://www.w3.org/2000/svg" onload="alert(1)"><rect onclick="x()" width="1"/></svg>`,
+			absent:  []string{"onload", "onclick"},
+			present: []string{`<rect width="1"/>`},
+		},
+		{
+			name:    "external href",
+			in:      `<svg xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink"><image xlink:href="file:///etc/passwd"/><use href="#a"/></svg>`,
+			absent:  []string{"passwd"},
+			present: []string{`<use href="#a"/>`, `xmlns:xlink=`},
+		},
+		{
+			name:    "external css",
+			in:      `<svg xmlns="http://www.w3.org/2000/svg"><style>@import url(http://x/a.css);</style><rect style="fill:url(http://x/p)" fill="url(#g)"/></svg>`,
+			absent:  []string{"@import", "http://x"},
+			present: []string{`fill="url(#g)"`},
+		},
+		{
+			name:    "animated href",
+			in:      `<svg xmlns="http://www.w3.org/2000/svg"><a><set attributeName="href" to="http://evil.example/x.png"/><animate attributeName="xlink:href" values="#a;javascript:alert(1)"/><set attributeName="href" to="#b"/></a></svg>`,
+			absent:  []string{"evil.example", "javascript"},
+			present: []string{`<set attributeName="href"/>`, `to="#b"`},
+		},
+		{
+			name:   "escaped css url",
+			in:     `<svg xmlns="http://www.w3.org/2000/svg"><rect style="fill: u\72l(http://evil.example/p)"/><rect style="fill: u\rl(http://evil.example/q)"/></svg>`,
+			absent: []string{"evil.example"},
+		},
+		{
+			name:   "escaped import",
+			in:     `<svg xmlns="http://www.w3.org/2000/svg"><style>@\69mport "http://evil.example/a.css";</style><style>@im/**/port "http://evil.example/b.css";</style></svg>`,
+			absent: []string{"evil.example"},
+		},
+		{
+			name:   "control characters",
+			in:     `<svg xmlns="http://www.w3.org/2000/svg"><rect fill="java&#9;script:alert(1)" style="fill:url(&#10;http://evil.example/p)"/></svg>`,
+			absent: []string{"alert", "evil.example"},
+		},
+		{
+			name:   "foreign object",
+			in:     `<svg xmlns="http://www.w3.org/2000/svg"><foreignObject><iframe src="http://x"/></foreignObject></svg>`,
+			absent: []string{"foreignObject", "iframe"},
+		},
+		{
+			name:    "stylesheet instruction",
+			in:      `<?xml version="1.0"?><?xml-stylesheet href="http://x/a.css"?><svg xmlns="http://www.w3.org/2000/svg"/>`,
+			absent:  []string{"xml-stylesheet"},
+			present: []string{`<?xml version="1.0"?>`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := Sanitize([]byte(tt.in))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			for _, s := range tt.absent {
+				if strings.Contains(string(out), s) {
+					t.Errorf("output still contains %q: %s", s, out)
+				}
+			}
+			for _, s := range tt.present {
+				if !strings.Contains(string(out), s) {
+					t.Errorf("output lost %q: %s", s, out)
+				}
+			}
+		})
+	}
+}
+
+func TestSanitize_Rejects(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+	}{
+		{"entity expansion", `<!DOCTYPE svg [<!ENTITY a "aaaa">]><svg xmlns="http://www.w3.org/2000/svg"><text>&a;</text></svg>`},
+		{"external entity", `<!DOCTYPE svg [<!ENTITY x SYSTEM "file:///etc/passwd">]><svg><text>&x;</text></svg>`},
+		{"not svg", `<html><body/></html>`},
+		{"unclosed", `<svg><rect></svg>`},
+		{"mismatched", `<svg><a></b></svg>`},
+		{"mismatched in script", `<svg><script><a></b></script></svg>`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Sanitize([]byte(tt.in)); !errors.Is(err, ErrInvalid) {
+				t.Fatalf("expected ErrInvalid, got %v", err)
+			}
+		})
+	}
+}
+
+func TestMinify(t *testing.T) {
+	in := `<?xml version="1.0" encoding="UTF-8"?>
+<!-- Created with Inkscape -->
+<svg xmlns="http://www.w3.org/2000/svg" xmlns:inkscape="http://www.inkscape.org/namespaces/inkscape" inkscape:version="1.3" width="10" height="10">
+  <metadata><rdf/></metadata>
+  <inkscape:grid/>
+  <rect width="10" height="10" fill="#f00"></rect>
+  <text>a &amp; b</text>
+</svg>
+`
+	want := `<svg xmlns="http://www.w3.org/2000/svg" width="10" height="10"><rect width="10" height="10" fill="#f00"/><text>a &amp; b</text></svg>`
+
+	out, err := Minify([]byte(in))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(out) != want {
+		t.Fatalf("Minify() =\n%s\nwant\n%s", out, want)
+	}
+}
@@ -0,0 +1,31 @@
+// Package thumbnail scales images down for the computations that only need a
+// small preview: placeholders and palettes.
+package thumbnail
+
+import (
+	"image"
+
+	"golang.org/x/image/draw"
+)
+
+// MaxSide is the longest side images are scaled down to before hashing or
+// counting colours. ThumbHash accepts no more, and more pixels only cost
+// time.
+const MaxSide = 100
+
+// Fit scales img down to fit within side x side pixels, keeping the aspect
+// ratio. Smaller images are only copied.
+func Fit(img image.Image, side int) *image.NRGBA {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w > side || h > side {
+		if w >= h {
+			w, h = side, max(1, (h*side+w/2)/w)
+		} else {
+			w, h = max(1, (w*side+h/2)/h), side
+		}
+	}
+	out := image.NewNRGBA(image.Rect(0, 0, w, h))
+	draw.BiLinear.Scale(out, out.Rect, img, b, draw.Src, nil)
+	return out
+}
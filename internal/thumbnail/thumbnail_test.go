@@ -0,0 +1,24 @@
+package thumbnail
+
+import (
+	"image"
+	"testing"
+)
+
+func TestFit(t *testing.T) {
+	cases := []struct {
+		width, height int
+		want          image.Point
+	}{
+		{400, 200, image.Pt(100, 50)},
+		{30, 900, image.Pt(3, 100)},
+		{2000, 1, image.Pt(100, 1)},
+		{60, 40, image.Pt(60, 40)},
+	}
+	for _, c := range cases {
+		got := Fit(image.NewNRGBA(image.Rect(0, 0, c.width, c.height)), MaxSide).Rect.Size()
+		if got != c.want {
+			t.Errorf("%dx%d: expected %v, got %v", c.width, c.height, c.want, got)
+		}
+	}
+}